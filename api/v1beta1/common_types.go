@@ -1,5 +1,190 @@
 package v1beta1
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
 const (
 	MetalNodeLabelName = "infrastructure.cluster.x-k8s.io/metal-node-name"
+
+	// ProviderIDPrefix is the prefix of the provider id of the demo machines, followed by the metal node uid
+	ProviderIDPrefix = "demo://"
+
+	// DefaultAPIServerPort is the port the API server of the control plane machines listens on
+	DefaultAPIServerPort = 6443
+
+	// MetalNodeStatusAnnotation mirrors the status of a metal node claimed by the provider,
+	// clusterctl move does not copy the status of the objects it moves so it is restored from it
+	MetalNodeStatusAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-status"
+
+	// MetalNodeDemoMachineAnnotation holds the name of the demo machine a metal node is claimed by. It is written along
+	// with the resource version of the metal node, so that of two demo machines claiming the same metal node only one wins.
+	MetalNodeDemoMachineAnnotation = "infrastructure.cluster.x-k8s.io/demo-machine"
+
+	// ClusterctlMoveLabelName makes clusterctl move the claimed metal nodes, even if not (yet) owned by a cluster object
+	ClusterctlMoveLabelName = "clusterctl.cluster.x-k8s.io/move"
+
+	// MetalNodeNetworksAnnotation lists the networks of a metal node as a JSON array of MetalNodeNetwork,
+	// e.g. [{"name":"management","addresses":["10.0.0.1","fd00::1"],"dnsNames":["node1.example.com"]}].
+	// It is set by the administrator of the metal nodes.
+	MetalNodeNetworksAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-networks"
+
+	// MetalNodeStaticAddressAnnotation holds the static address claimed by the demo machine bound to a metal node
+	// as a JSON MetalNodeStaticAddress, e.g. {"address":"10.0.0.5","prefix":24,"gateway":"10.0.0.1"}.
+	// The agent of the metal node configures it on the node before the bootstrap.
+	MetalNodeStaticAddressAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-static-address"
+
+	// MetalNodeReprovisioningAnnotation holds the reprovisioning of a metal node released by a demo machine as a JSON
+	// MetalNodeReprovisioning, the metal node is claimed again once Available. Removing it returns a Failed metal node.
+	MetalNodeReprovisioningAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-reprovisioning"
+
+	// MetalNodeReinitializeAnnotation asks the metal node controller to initialize a released metal node again, e.g.
+	// reinstalling kubeadm once the metal node is cleaned. The metal node controller removes it once the metal node is
+	// initialized, the metal node is not claimed until then.
+	MetalNodeReinitializeAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-reinitialize"
+
+	// MetalNodeRerunBootstrapAnnotation asks the agent of a bootstrapped metal node to run the bootstrap data named in its
+	// status again, e.g. a new HAProxy configuration of the load balancer. The agent removes it once the bootstrap data ran,
+	// the metal node stays bootstrapped meanwhile. The value is the revision of the bootstrap data asked to run.
+	MetalNodeRerunBootstrapAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-rerun-bootstrap"
+
+	// MetalNodeKubernetesVersionAnnotation holds the Kubernetes version a metal node is initialized for, set by the metal
+	// node controller when it initializes the metal node, or by hand on the metal nodes pre-staged for an upgrade.
+	// The metal nodes without it are taken for initialized for any version.
+	MetalNodeKubernetesVersionAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-kubernetes-version"
+
+	// MetalNodeRequestedKubernetesVersionAnnotation asks the metal node controller to initialize a metal node for a
+	// Kubernetes version, it is set when a demo machine claims a metal node initialized for another version, along
+	// with the metal node being reported not ready.
+	MetalNodeRequestedKubernetesVersionAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-requested-kubernetes-version"
+
+	// MetalNodeBMCAnnotation holds the baseboard management controller of a metal node as a JSON MetalNodeBMC,
+	// e.g. {"address":"redfish://10.0.0.100/redfish/v1/Systems/1","credentialsName":"metalnode-1-bmc"}.
+	// It is set by the administrator of the metal nodes, the power of the metal node is managed through it.
+	MetalNodeBMCAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-bmc"
+
+	// MetalNodePowerOffAnnotation asks the provider to power off a released metal node through its BMC, it is set when the
+	// metal node is released and removed once the metal node is powered off, or claimed again before.
+	MetalNodePowerOffAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-power-off"
+
+	// MetalNodePoweredOffAnnotation marks a released metal node the provider powered off through its BMC. The metal node
+	// stays in the pool while reported not ready, the demo machine claiming it powers it on and removes the annotation.
+	MetalNodePoweredOffAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-powered-off"
+
+	// MetalNodeMaintenanceAnnotation takes a metal node out of rotation, e.g. for firmware work, its value is the
+	// MaintenanceMode. It is set by the administrator of the metal nodes and removed to return the metal node to the pool.
+	MetalNodeMaintenanceAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-maintenance"
+
+	// PreemptionProtectedAnnotation keeps the worker Machines from being preempted by the machines of higher priority
+	// clusters, it is set on a Machine, on its MachineSet or MachineDeployment, or on its Cluster.
+	PreemptionProtectedAnnotation = "infrastructure.cluster.x-k8s.io/preemption-protected"
+
+	// PreemptedByAnnotation is set on a Machine preempted for a demo machine of a higher priority cluster, its value is
+	// the name of the demo machine waiting for the metal node of the Machine.
+	PreemptedByAnnotation = "infrastructure.cluster.x-k8s.io/preempted-by"
+)
+
+// IPFamily is the family of an IP address
+type IPFamily string
+
+const (
+	// IPv4Family is the IPv4 address family
+	IPv4Family IPFamily = "IPv4"
+
+	// IPv6Family is the IPv6 address family
+	IPv6Family IPFamily = "IPv6"
+)
+
+// NetworkRole tells whether the addresses on a network are reachable from within the cluster only or from outside of it
+type NetworkRole string
+
+const (
+	// InternalNetworkRole networks give InternalIP and InternalDNS machine addresses
+	InternalNetworkRole NetworkRole = "internal"
+
+	// ExternalNetworkRole networks give ExternalIP and ExternalDNS machine addresses
+	ExternalNetworkRole NetworkRole = "external"
 )
+
+// MetalNodeNetwork is a network a metal node is attached to, listed in its MetalNodeNetworksAnnotation
+// +kubebuilder:object:generate=false
+type MetalNodeNetwork struct {
+	// Name identifies the network across the metal nodes, e.g. management or data
+	Name string `json:"name"`
+	// Role of the network, internal if not set
+	Role NetworkRole `json:"role,omitempty"`
+	// Addresses are the IPv4 and IPv6 addresses of the metal node on the network
+	Addresses []string `json:"addresses"`
+	// DNSNames are the names resolving to the addresses of the metal node on the network
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
+// MetalNodeStaticAddress is a static address handed to a metal node through its MetalNodeStaticAddressAnnotation
+// +kubebuilder:object:generate=false
+type MetalNodeStaticAddress struct {
+	// Address is the IPv4 or IPv6 address
+	Address string `json:"address"`
+	// Prefix is the length of the prefix of the network of the address
+	Prefix int32 `json:"prefix"`
+	// Gateway of the network of the address
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// MetalNodeBMC is the baseboard management controller of a metal node, set through its MetalNodeBMCAnnotation
+// +kubebuilder:object:generate=false
+type MetalNodeBMC struct {
+	// Address of the BMC, its scheme selects the interface: redfish://, redfish+http://, redfish+https:// or ipmi://
+	Address string `json:"address"`
+	// CredentialsName is the name of the secret, in the namespace of the metal node, holding the username and
+	// the password of the BMC
+	CredentialsName string `json:"credentialsName"`
+	// DisableCertificateVerification skips the verification of the certificate of a Redfish BMC
+	DisableCertificateVerification bool `json:"disableCertificateVerification,omitempty"`
+}
+
+// MaintenanceMode is how a metal node in maintenance is taken out of rotation
+type MaintenanceMode string
+
+const (
+	// CordonMaintenanceMode keeps the metal node from being claimed, the machine it hosts is left running on it.
+	// The metal nodes annotated with an unknown mode are cordoned.
+	CordonMaintenanceMode MaintenanceMode = "Cordon"
+
+	// EvacuateMaintenanceMode cordons the metal node and deletes the Machine it hosts, so that its MachineSet or
+	// control plane replaces it on another metal node
+	EvacuateMaintenanceMode MaintenanceMode = "Evacuate"
+)
+
+// ReprovisioningState is a state a metal node released by a demo machine goes through before it is claimed again
+type ReprovisioningState string
+
+const (
+	// CleaningState is a metal node running the cleanup bootstrap data, removing what the cluster left on it
+	CleaningState ReprovisioningState = "Cleaning"
+
+	// ReinitializingState is a metal node reinitialized by the metal node controller, e.g. reinstalling kubeadm,
+	// as asked by the MetalNodeReinitializeAnnotation
+	ReinitializingState ReprovisioningState = "Reinitializing"
+
+	// AvailableState is a reprovisioned metal node, free to be claimed again
+	AvailableState ReprovisioningState = "Available"
+
+	// FailedState is a metal node whose cleaning or reinitialization timed out, it is kept out of the pool
+	FailedState ReprovisioningState = "Failed"
+)
+
+// MetalNodeReprovisioning is the reprovisioning of a metal node, kept in its MetalNodeReprovisioningAnnotation
+// +kubebuilder:object:generate=false
+type MetalNodeReprovisioning struct {
+	// State of the metal node
+	State ReprovisioningState `json:"state"`
+	// LastTransitionTime is when the metal node entered the state
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	// Conditions of the steps of the last reprovisioning
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+	// Reprovisioned counts the reprovisionings the metal node completed
+	Reprovisioned int32 `json:"reprovisioned"`
+	// Failed counts the reprovisionings of the metal node that timed out
+	Failed int32 `json:"failed"`
+}
@@ -39,6 +39,134 @@ type DemoClusterSpec struct {
 	// ControlPlaneEndpoint represents the endpoint used to communicate with the control plane.
 	// +optional
 	ControlPlaneEndpoint clusterv1.APIEndpoint `json:"controlPlaneEndpoint"`
+
+	// APIServerPort is the port the API server of the control plane machines binds to, it must match the bindPort
+	// of the kubeadm configuration of the control plane. Defaults to 6443.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	APIServerPort int32 `json:"apiServerPort,omitempty"`
+
+	// BootstrapExtensions are cloud-config fragments, e.g. registry mirrors, NTP servers or CA bundles, merged in order
+	// into the bootstrap data of every machine of the cluster before it is handed to a metal node. Lists are appended to,
+	// and the values set by the bootstrap data win over the ones set by the extensions. Changing them does not affect
+	// the machines already handed their bootstrap data.
+	// +optional
+	BootstrapExtensions []DemoBootstrapExtension `json:"bootstrapExtensions,omitempty"`
+
+	// EndpointAddress selects the address of the metal nodes supplying the control plane endpoint
+	// and the backends of the load balancer.
+	// +optional
+	EndpointAddress *DemoEndpointAddressSpec `json:"endpointAddress,omitempty"`
+
+	// LoadBalancer claims a metal node in the load-balancer role to run HAProxy in front of the control plane machines,
+	// the control plane endpoint is set to that metal node.
+	// +optional
+	LoadBalancer *DemoLoadBalancerSpec `json:"loadBalancer,omitempty"`
+
+	// VirtualIP makes the control plane machines hold a virtual IP with keepalived, the control plane endpoint
+	// is set to it. It is injected into the KubeadmControlPlane of the cluster, and can't be used along with LoadBalancer.
+	// +optional
+	VirtualIP *DemoVirtualIPSpec `json:"virtualIP,omitempty"`
+
+	// Etcd claims metal nodes in the etcd role to run an external etcd cluster for the control plane,
+	// the demoCluster is ready once all of them are bootstrapped.
+	// +optional
+	Etcd *DemoEtcdSpec `json:"etcd,omitempty"`
+
+	// Priority of the machines of the cluster over the machines of the other clusters of the namespace when no metal
+	// node is free, see PreemptionPolicy. Defaults to 0.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// PreemptionPolicy tells whether the machines of the cluster waiting for a metal node scale down the workers of
+	// the lower priority clusters of the namespace to free theirs: Never, PreemptLowerPriority, or DryRun to only record
+	// the Machines that would be preempted as events. Defaults to Never.
+	// +kubebuilder:validation:Enum=Never;PreemptLowerPriority;DryRun
+	// +optional
+	PreemptionPolicy PreemptionPolicy `json:"preemptionPolicy,omitempty"`
+}
+
+// PreemptionPolicy is how the machines of a cluster preempt the machines of lower priority clusters
+type PreemptionPolicy string
+
+const (
+	// NeverPreemptionPolicy machines wait for a metal node to be freed
+	NeverPreemptionPolicy PreemptionPolicy = "Never"
+
+	// PreemptLowerPriorityPreemptionPolicy machines scale down the MachineSet or MachineDeployment of a worker Machine
+	// of a lower priority cluster, marking the Machine for deletion, to claim its metal node once released
+	PreemptLowerPriorityPreemptionPolicy PreemptionPolicy = "PreemptLowerPriority"
+
+	// DryRunPreemptionPolicy machines record the worker Machine they would preempt as an event, leaving it running
+	DryRunPreemptionPolicy PreemptionPolicy = "DryRun"
+)
+
+// DemoBootstrapExtension references a cloud-config fragment held by a ConfigMap or a Secret
+type DemoBootstrapExtension struct {
+	// Kind of the object holding the cloud-config, ConfigMap or Secret.
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+
+	// Name of the ConfigMap or Secret, in the namespace of the demoCluster.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key of the ConfigMap or Secret holding the cloud-config, defaults to value.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// DemoEndpointAddressSpec selects an address of the metal nodes
+type DemoEndpointAddressSpec struct {
+	// Network is the metal node network the address is picked from, see MetalNodeNetworksAnnotation.
+	// The address of .spec.nodeEndPoint.host of the metal nodes is used when empty.
+	// +optional
+	Network string `json:"network,omitempty"`
+
+	// IPFamily is the family of the address, IPv4 or IPv6. It defaults to the family of the first service CIDR
+	// of the cluster, i.e. the primary family of a dual-stack cluster, when the metal nodes have addresses of both.
+	// +kubebuilder:validation:Enum=IPv4;IPv6
+	// +optional
+	IPFamily IPFamily `json:"ipFamily,omitempty"`
+}
+
+// DemoLoadBalancerSpec defines the load balancer of the control plane
+type DemoLoadBalancerSpec struct {
+	// Port is the port HAProxy listens on for the control plane, defaults to 6443.
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// DemoVirtualIPSpec defines the virtual IP of the control plane
+type DemoVirtualIPSpec struct {
+	// Address is the virtual IP of the control plane endpoint, it must be free in the network of the metal nodes.
+	// It can not be changed once it is the control plane endpoint.
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+
+	// Interface is the network interface of the control plane machines holding the virtual IP.
+	// +kubebuilder:validation:MinLength=1
+	Interface string `json:"interface"`
+
+	// VirtualRouterID is the VRRP virtual router id, it must be unique in the network, defaults to 51.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=255
+	// +optional
+	VirtualRouterID int32 `json:"virtualRouterID,omitempty"`
+}
+
+// DemoEtcdSpec defines the external etcd cluster of the control plane
+type DemoEtcdSpec struct {
+	// Replicas is the number of etcd members, the members are fixed once they are claimed.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Image is the etcd container image, defaults to the etcd of kubeadm v1.23.
+	// +optional
+	Image string `json:"image,omitempty"`
 }
 
 // DemoClusterStatus defines the observed state of DemoCluster
@@ -53,6 +181,83 @@ type DemoClusterStatus struct {
 	// Conditions defines current service state of the DemoCluster.
 	// +optional
 	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+
+	// LoadBalancer is the observed state of the load balancer of the control plane.
+	// +optional
+	LoadBalancer *DemoLoadBalancerStatus `json:"loadBalancer,omitempty"`
+
+	// Etcd is the observed state of the external etcd cluster of the control plane.
+	// +optional
+	Etcd *DemoEtcdStatus `json:"etcd,omitempty"`
+
+	// Upgrade is the progress of the last Kubernetes version upgrade of the machines of the cluster.
+	// +optional
+	Upgrade *DemoUpgradeStatus `json:"upgrade,omitempty"`
+}
+
+// DemoLoadBalancerStatus defines the observed state of the load balancer of the control plane
+type DemoLoadBalancerStatus struct {
+	// MetalNodeName is the metal node running HAProxy.
+	// +optional
+	MetalNodeName string `json:"metalNodeName,omitempty"`
+
+	// Backends are the control plane addresses HAProxy balances to.
+	// +optional
+	Backends []string `json:"backends,omitempty"`
+}
+
+// DemoUpgradeStatus defines the progress of a Kubernetes version upgrade of the machines of a cluster
+type DemoUpgradeStatus struct {
+	// TargetVersion is the Kubernetes version the machines are upgraded to, the newest version of the machines.
+	TargetVersion string `json:"targetVersion"`
+
+	// UpdatedMachines is the number of machines of the target version bootstrapped on a metal node.
+	// +optional
+	UpdatedMachines int32 `json:"updatedMachines"`
+
+	// PendingMachines is the number of machines of the target version waiting for a metal node.
+	// +optional
+	PendingMachines int32 `json:"pendingMachines"`
+
+	// OutdatedMachines is the number of machines of an older version left in the cluster.
+	// +optional
+	OutdatedMachines int32 `json:"outdatedMachines"`
+
+	// ReinitializingMetalNodes is the number of metal nodes of the cluster being reinitialized for the target version.
+	// +optional
+	ReinitializingMetalNodes int32 `json:"reinitializingMetalNodes"`
+
+	// StartTime is when the target version was first seen on a machine of the cluster.
+	StartTime metav1.Time `json:"startTime"`
+
+	// CompletionTime is when all the machines of the cluster were of the target version and bootstrapped.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// DemoEtcdStatus defines the observed state of the external etcd cluster of the control plane
+type DemoEtcdStatus struct {
+	// Members are the etcd members, one per metal node.
+	// +optional
+	Members []DemoEtcdMember `json:"members,omitempty"`
+
+	// Endpoints are the client URLs of the etcd members, for the external etcd configuration of the control plane.
+	// +optional
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// DemoEtcdMember defines the observed state of an etcd member
+type DemoEtcdMember struct {
+	// MetalNodeName is the metal node running the member.
+	MetalNodeName string `json:"metalNodeName"`
+
+	// Address is the address the member listens on.
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// Ready denotes the metal node of the member is ready and bootstrapped.
+	// +optional
+	Ready bool `json:"ready"`
 }
 
 //+kubebuilder:object:root=true
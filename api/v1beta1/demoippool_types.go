@@ -0,0 +1,74 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DemoIPPoolSpec defines the addresses of a DemoIPPool
+type DemoIPPoolSpec struct {
+	// Addresses of the pool, each an IP address, a range of IP addresses like 10.0.0.10-10.0.0.20,
+	// or a CIDR whose network and broadcast addresses are left out
+	// +kubebuilder:validation:MinItems=1
+	Addresses []string `json:"addresses"`
+
+	// Prefix is the length of the prefix of the network the addresses are on
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=128
+	Prefix int32 `json:"prefix"`
+
+	// Gateway of the network the addresses are on
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// DemoIPPoolStatus defines the observed state of DemoIPPool
+type DemoIPPoolStatus struct {
+	// Used is the number of addresses allocated to IPAddressClaims
+	// +optional
+	Used int32 `json:"used"`
+
+	// Free is the number of addresses left in the pool
+	// +optional
+	Free int32 `json:"free"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// DemoIPPool is the Schema for the demoippools API, an in-cluster pool fulfilling the IPAddressClaims referencing it
+type DemoIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DemoIPPoolSpec   `json:"spec,omitempty"`
+	Status DemoIPPoolStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DemoIPPoolList contains a list of DemoIPPool
+type DemoIPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DemoIPPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DemoIPPool{}, &DemoIPPoolList{})
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	capierrors "sigs.k8s.io/cluster-api/errors"
 )
 
 const (
@@ -35,9 +37,14 @@ type DemoMachineSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// ProviderID will be the metal node recourse uid,it's unique
+	// ProviderID will be the metal node resource uid prefixed with demo://, it's unique
 	// +optional
 	ProviderID string `json:"providerID,omitempty"`
+
+	// IPAddressPool references the pool, e.g. a DemoIPPool, the static address of the machine is claimed from
+	// through an IPAddressClaim before a metal node is bound to the machine. Requires the IPAM feature gate.
+	// +optional
+	IPAddressPool *corev1.TypedLocalObjectReference `json:"ipAddressPool,omitempty"`
 }
 
 // DemoMachineStatus defines the observed state of DemoMachine
@@ -60,6 +67,33 @@ type DemoMachineStatus struct {
 	// Conditions defines current service state of the DemoMachine.
 	// +optional
 	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+
+	// FailureReason will be set in the event that there is a terminal problem
+	// reconciling the DemoMachine and will contain a succinct value suitable
+	// for machine interpretation.
+	// +optional
+	FailureReason *capierrors.MachineStatusError `json:"failureReason,omitempty"`
+
+	// FailureMessage will be set in the event that there is a terminal problem
+	// reconciling the DemoMachine and will contain a more verbose string suitable
+	// for logging and human consumption.
+	// +optional
+	FailureMessage *string `json:"failureMessage,omitempty"`
+
+	// Queue is the place of the machine in the provisioning queue of its namespace while it waits for a free metal node.
+	// +optional
+	Queue *DemoMachineQueueStatus `json:"queue,omitempty"`
+}
+
+// DemoMachineQueueStatus defines the place of a demo machine in the provisioning queue of its namespace.
+// The free metal nodes are handed out to the machines of the higher priority clusters first, then the clusters take
+// turns, their machines first in first out.
+type DemoMachineQueueStatus struct {
+	// Position of the machine in the queue, starting at 1.
+	Position int32 `json:"position"`
+
+	// QueuedAt is the time the machine started to wait for a metal node, the waiting time is its age.
+	QueuedAt metav1.Time `json:"queuedAt"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetalNodeRebootAnnotation asks the agent of a metal node to power-cycle the host, the agent removes it once the host
+// is rebooted. The value is the name of the DemoRemediation asking for the reboot.
+const MetalNodeRebootAnnotation = "infrastructure.cluster.x-k8s.io/metal-node-reboot"
+
+// DemoMachineReprovisionMetalNodeAnnotation asks a demo machine being deleted to have its metal node cleaned and
+// reinitialized once released, whether the MetalNodeReprovisioning feature gate is enabled or not. The value is the name
+// of the DemoRemediation that set it.
+const DemoMachineReprovisionMetalNodeAnnotation = "infrastructure.cluster.x-k8s.io/reprovision-metal-node"
+
+// RemediationAction is an action taken to remediate an unhealthy machine
+type RemediationAction string
+
+const (
+	// RebootRemediationAction power-cycles the metal node hosting the machine
+	RebootRemediationAction RemediationAction = "Reboot"
+
+	// RebootstrapRemediationAction has the metal node hosting the machine cleaned and reinitialized, and deletes the
+	// machine so that its owner replaces it with new bootstrap data. The bootstrap data of a machine can not run twice on
+	// a host: the host keeps what the first run left, and the bootstrap token expired.
+	RebootstrapRemediationAction RemediationAction = "Rebootstrap"
+
+	// DeleteRemediationAction deletes the machine, so that its owner replaces it
+	DeleteRemediationAction RemediationAction = "Delete"
+)
+
+// RemediationAttemptResult is the outcome of a remediation attempt
+type RemediationAttemptResult string
+
+const (
+	// RunningRemediationAttempt is an attempt waiting for the machine to turn healthy
+	RunningRemediationAttempt RemediationAttemptResult = "Running"
+
+	// SucceededRemediationAttempt is an attempt after which the machine turned healthy, or that deleted the machine
+	SucceededRemediationAttempt RemediationAttemptResult = "Succeeded"
+
+	// FailedRemediationAttempt is an attempt that could not be taken, or after which the machine did not turn healthy in time
+	FailedRemediationAttempt RemediationAttemptResult = "Failed"
+)
+
+// DemoRemediationSpec defines how an unhealthy machine is remediated
+type DemoRemediationSpec struct {
+	// RebootLimit is the number of times the metal node is rebooted before the machine is replaced and its metal node
+	// reprovisioned
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RebootLimit int32 `json:"rebootLimit"`
+
+	// RebootstrapLimit is the number of attempts to replace the machine and reprovision its metal node before the machine
+	// is only deleted. A Rebootstrap attempt deletes the machine, the next attempts are only taken when it could not be taken.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RebootstrapLimit int32 `json:"rebootstrapLimit"`
+
+	// Timeout is the time the machine is given to turn healthy after each attempt
+	// +kubebuilder:default="5m"
+	// +optional
+	Timeout metav1.Duration `json:"timeout"`
+}
+
+// RemediationAttempt records an action taken to remediate the machine
+type RemediationAttempt struct {
+	// Action taken
+	Action RemediationAction `json:"action"`
+
+	// Result of the attempt
+	Result RemediationAttemptResult `json:"result"`
+
+	// StartTime is when the action was taken
+	StartTime metav1.Time `json:"startTime"`
+
+	// CompletionTime is when the result of the attempt was known
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message explains the result of the attempt
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// DemoRemediationStatus defines the observed state of DemoRemediation
+type DemoRemediationStatus struct {
+	// Attempts taken to remediate the machine, the last one is the current one
+	// +optional
+	Attempts []RemediationAttempt `json:"attempts,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// DemoRemediation is the Schema for the demoremediations API, created by a MachineHealthCheck
+// for an unhealthy machine, named after the machine
+type DemoRemediation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DemoRemediationSpec   `json:"spec,omitempty"`
+	Status DemoRemediationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DemoRemediationList contains a list of DemoRemediation
+type DemoRemediationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DemoRemediation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DemoRemediation{}, &DemoRemediationList{})
+}
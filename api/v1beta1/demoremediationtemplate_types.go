@@ -0,0 +1,55 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DemoRemediationTemplateSpec defines the desired state of DemoRemediationTemplate
+type DemoRemediationTemplateSpec struct {
+	Template DemoRemediationTemplateResource `json:"template"`
+}
+
+//+kubebuilder:object:root=true
+
+// DemoRemediationTemplate is the Schema for the demoremediationtemplates API,
+// referenced by the remediationTemplate of a MachineHealthCheck
+type DemoRemediationTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DemoRemediationTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DemoRemediationTemplateList contains a list of DemoRemediationTemplate
+type DemoRemediationTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DemoRemediationTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DemoRemediationTemplate{}, &DemoRemediationTemplateList{})
+}
+
+type DemoRemediationTemplateResource struct {
+	// Spec is the specification of the remediations created from the template.
+	Spec DemoRemediationSpec `json:"spec"`
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetalNodeQuotaSpec defines how many metal nodes of the namespace some of its clusters may claim, and how many
+// are reserved for them
+type MetalNodeQuotaSpec struct {
+	// ClusterName is the name of the Cluster the quota applies to
+	// +optional
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// ClusterSelector selects the Clusters the quota applies to by their labels, e.g. the label of a team.
+	// The quota applies to all the clusters of the namespace when neither clusterName nor clusterSelector is set.
+	// +optional
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+
+	// MetalNodeSelector selects the metal nodes the quota counts by their labels, all of them when not set
+	// +optional
+	MetalNodeSelector *metav1.LabelSelector `json:"metalNodeSelector,omitempty"`
+
+	// Max is the most metal nodes the clusters may claim altogether, unlimited when not set
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Max *int32 `json:"max,omitempty"`
+
+	// Reserved is the number of metal nodes kept for the clusters: the other clusters can not claim the free metal
+	// nodes the clusters still need to reach it
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Reserved int32 `json:"reserved,omitempty"`
+}
+
+// MetalNodeQuotaStatus defines the observed state of MetalNodeQuota
+type MetalNodeQuotaStatus struct {
+	// Clusters are the names of the Clusters the quota applies to
+	// +optional
+	Clusters []string `json:"clusters,omitempty"`
+
+	// Used is the number of metal nodes claimed by the clusters
+	// +optional
+	Used int32 `json:"used"`
+
+	// Free is the number of metal nodes counted by the quota free to be claimed
+	// +optional
+	Free int32 `json:"free"`
+
+	// Reserved is the number of free metal nodes held back for the clusters
+	// +optional
+	Reserved int32 `json:"reserved"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// MetalNodeQuota is the Schema for the metalnodequotas API, capping the metal nodes some clusters of a namespace
+// may claim and reserving metal nodes for them
+type MetalNodeQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MetalNodeQuotaSpec   `json:"spec,omitempty"`
+	Status MetalNodeQuotaStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MetalNodeQuotaList contains a list of MetalNodeQuota
+type MetalNodeQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MetalNodeQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MetalNodeQuota{}, &MetalNodeQuotaList{})
+}
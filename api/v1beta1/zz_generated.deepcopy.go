@@ -22,16 +22,34 @@ limitations under the License.
 package v1beta1
 
 import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	apiv1beta1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/errors"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoBootstrapExtension) DeepCopyInto(out *DemoBootstrapExtension) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoBootstrapExtension.
+func (in *DemoBootstrapExtension) DeepCopy() *DemoBootstrapExtension {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoBootstrapExtension)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DemoCluster) DeepCopyInto(out *DemoCluster) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -89,6 +107,31 @@ func (in *DemoClusterList) DeepCopyObject() runtime.Object {
 func (in *DemoClusterSpec) DeepCopyInto(out *DemoClusterSpec) {
 	*out = *in
 	out.ControlPlaneEndpoint = in.ControlPlaneEndpoint
+	if in.BootstrapExtensions != nil {
+		in, out := &in.BootstrapExtensions, &out.BootstrapExtensions
+		*out = make([]DemoBootstrapExtension, len(*in))
+		copy(*out, *in)
+	}
+	if in.EndpointAddress != nil {
+		in, out := &in.EndpointAddress, &out.EndpointAddress
+		*out = new(DemoEndpointAddressSpec)
+		**out = **in
+	}
+	if in.LoadBalancer != nil {
+		in, out := &in.LoadBalancer, &out.LoadBalancer
+		*out = new(DemoLoadBalancerSpec)
+		**out = **in
+	}
+	if in.VirtualIP != nil {
+		in, out := &in.VirtualIP, &out.VirtualIP
+		*out = new(DemoVirtualIPSpec)
+		**out = **in
+	}
+	if in.Etcd != nil {
+		in, out := &in.Etcd, &out.Etcd
+		*out = new(DemoEtcdSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoClusterSpec.
@@ -111,6 +154,21 @@ func (in *DemoClusterStatus) DeepCopyInto(out *DemoClusterStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LoadBalancer != nil {
+		in, out := &in.LoadBalancer, &out.LoadBalancer
+		*out = new(DemoLoadBalancerStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Etcd != nil {
+		in, out := &in.Etcd, &out.Etcd
+		*out = new(DemoEtcdStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Upgrade != nil {
+		in, out := &in.Upgrade, &out.Upgrade
+		*out = new(DemoUpgradeStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoClusterStatus.
@@ -185,7 +243,7 @@ func (in *DemoClusterTemplateList) DeepCopyObject() runtime.Object {
 func (in *DemoClusterTemplateResource) DeepCopyInto(out *DemoClusterTemplateResource) {
 	*out = *in
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoClusterTemplateResource.
@@ -214,12 +272,211 @@ func (in *DemoClusterTemplateSpec) DeepCopy() *DemoClusterTemplateSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoEndpointAddressSpec) DeepCopyInto(out *DemoEndpointAddressSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoEndpointAddressSpec.
+func (in *DemoEndpointAddressSpec) DeepCopy() *DemoEndpointAddressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoEndpointAddressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoEtcdMember) DeepCopyInto(out *DemoEtcdMember) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoEtcdMember.
+func (in *DemoEtcdMember) DeepCopy() *DemoEtcdMember {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoEtcdMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoEtcdSpec) DeepCopyInto(out *DemoEtcdSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoEtcdSpec.
+func (in *DemoEtcdSpec) DeepCopy() *DemoEtcdSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoEtcdSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoEtcdStatus) DeepCopyInto(out *DemoEtcdStatus) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]DemoEtcdMember, len(*in))
+		copy(*out, *in)
+	}
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoEtcdStatus.
+func (in *DemoEtcdStatus) DeepCopy() *DemoEtcdStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoEtcdStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoIPPool) DeepCopyInto(out *DemoIPPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoIPPool.
+func (in *DemoIPPool) DeepCopy() *DemoIPPool {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoIPPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DemoIPPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoIPPoolList) DeepCopyInto(out *DemoIPPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DemoIPPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoIPPoolList.
+func (in *DemoIPPoolList) DeepCopy() *DemoIPPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoIPPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DemoIPPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoIPPoolSpec) DeepCopyInto(out *DemoIPPoolSpec) {
+	*out = *in
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoIPPoolSpec.
+func (in *DemoIPPoolSpec) DeepCopy() *DemoIPPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoIPPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoIPPoolStatus) DeepCopyInto(out *DemoIPPoolStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoIPPoolStatus.
+func (in *DemoIPPoolStatus) DeepCopy() *DemoIPPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoIPPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoLoadBalancerSpec) DeepCopyInto(out *DemoLoadBalancerSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoLoadBalancerSpec.
+func (in *DemoLoadBalancerSpec) DeepCopy() *DemoLoadBalancerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoLoadBalancerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoLoadBalancerStatus) DeepCopyInto(out *DemoLoadBalancerStatus) {
+	*out = *in
+	if in.Backends != nil {
+		in, out := &in.Backends, &out.Backends
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoLoadBalancerStatus.
+func (in *DemoLoadBalancerStatus) DeepCopy() *DemoLoadBalancerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoLoadBalancerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DemoMachine) DeepCopyInto(out *DemoMachine) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -273,9 +530,30 @@ func (in *DemoMachineList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoMachineQueueStatus) DeepCopyInto(out *DemoMachineQueueStatus) {
+	*out = *in
+	in.QueuedAt.DeepCopyInto(&out.QueuedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoMachineQueueStatus.
+func (in *DemoMachineQueueStatus) DeepCopy() *DemoMachineQueueStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoMachineQueueStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DemoMachineSpec) DeepCopyInto(out *DemoMachineSpec) {
 	*out = *in
+	if in.IPAddressPool != nil {
+		in, out := &in.IPAddressPool, &out.IPAddressPool
+		*out = new(v1.TypedLocalObjectReference)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoMachineSpec.
@@ -303,6 +581,21 @@ func (in *DemoMachineStatus) DeepCopyInto(out *DemoMachineStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.FailureReason != nil {
+		in, out := &in.FailureReason, &out.FailureReason
+		*out = new(errors.MachineStatusError)
+		**out = **in
+	}
+	if in.FailureMessage != nil {
+		in, out := &in.FailureMessage, &out.FailureMessage
+		*out = new(string)
+		**out = **in
+	}
+	if in.Queue != nil {
+		in, out := &in.Queue, &out.Queue
+		*out = new(DemoMachineQueueStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoMachineStatus.
@@ -377,7 +670,7 @@ func (in *DemoMachineTemplateList) DeepCopyObject() runtime.Object {
 func (in *DemoMachineTemplateResource) DeepCopyInto(out *DemoMachineTemplateResource) {
 	*out = *in
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoMachineTemplateResource.
@@ -405,3 +698,354 @@ func (in *DemoMachineTemplateSpec) DeepCopy() *DemoMachineTemplateSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediation) DeepCopyInto(out *DemoRemediation) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediation.
+func (in *DemoRemediation) DeepCopy() *DemoRemediation {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DemoRemediation) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediationList) DeepCopyInto(out *DemoRemediationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DemoRemediation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediationList.
+func (in *DemoRemediationList) DeepCopy() *DemoRemediationList {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DemoRemediationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediationSpec) DeepCopyInto(out *DemoRemediationSpec) {
+	*out = *in
+	out.Timeout = in.Timeout
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediationSpec.
+func (in *DemoRemediationSpec) DeepCopy() *DemoRemediationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediationStatus) DeepCopyInto(out *DemoRemediationStatus) {
+	*out = *in
+	if in.Attempts != nil {
+		in, out := &in.Attempts, &out.Attempts
+		*out = make([]RemediationAttempt, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediationStatus.
+func (in *DemoRemediationStatus) DeepCopy() *DemoRemediationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediationTemplate) DeepCopyInto(out *DemoRemediationTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediationTemplate.
+func (in *DemoRemediationTemplate) DeepCopy() *DemoRemediationTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediationTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DemoRemediationTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediationTemplateList) DeepCopyInto(out *DemoRemediationTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DemoRemediationTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediationTemplateList.
+func (in *DemoRemediationTemplateList) DeepCopy() *DemoRemediationTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediationTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DemoRemediationTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediationTemplateResource) DeepCopyInto(out *DemoRemediationTemplateResource) {
+	*out = *in
+	out.Spec = in.Spec
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediationTemplateResource.
+func (in *DemoRemediationTemplateResource) DeepCopy() *DemoRemediationTemplateResource {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediationTemplateResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoRemediationTemplateSpec) DeepCopyInto(out *DemoRemediationTemplateSpec) {
+	*out = *in
+	out.Template = in.Template
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoRemediationTemplateSpec.
+func (in *DemoRemediationTemplateSpec) DeepCopy() *DemoRemediationTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoRemediationTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoUpgradeStatus) DeepCopyInto(out *DemoUpgradeStatus) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoUpgradeStatus.
+func (in *DemoUpgradeStatus) DeepCopy() *DemoUpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoUpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DemoVirtualIPSpec) DeepCopyInto(out *DemoVirtualIPSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DemoVirtualIPSpec.
+func (in *DemoVirtualIPSpec) DeepCopy() *DemoVirtualIPSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DemoVirtualIPSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetalNodeQuota) DeepCopyInto(out *MetalNodeQuota) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetalNodeQuota.
+func (in *MetalNodeQuota) DeepCopy() *MetalNodeQuota {
+	if in == nil {
+		return nil
+	}
+	out := new(MetalNodeQuota)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MetalNodeQuota) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetalNodeQuotaList) DeepCopyInto(out *MetalNodeQuotaList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]MetalNodeQuota, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetalNodeQuotaList.
+func (in *MetalNodeQuotaList) DeepCopy() *MetalNodeQuotaList {
+	if in == nil {
+		return nil
+	}
+	out := new(MetalNodeQuotaList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MetalNodeQuotaList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetalNodeQuotaSpec) DeepCopyInto(out *MetalNodeQuotaSpec) {
+	*out = *in
+	if in.ClusterSelector != nil {
+		in, out := &in.ClusterSelector, &out.ClusterSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MetalNodeSelector != nil {
+		in, out := &in.MetalNodeSelector, &out.MetalNodeSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Max != nil {
+		in, out := &in.Max, &out.Max
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetalNodeQuotaSpec.
+func (in *MetalNodeQuotaSpec) DeepCopy() *MetalNodeQuotaSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetalNodeQuotaSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetalNodeQuotaStatus) DeepCopyInto(out *MetalNodeQuotaStatus) {
+	*out = *in
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetalNodeQuotaStatus.
+func (in *MetalNodeQuotaStatus) DeepCopy() *MetalNodeQuotaStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MetalNodeQuotaStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemediationAttempt) DeepCopyInto(out *RemediationAttempt) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemediationAttempt.
+func (in *RemediationAttempt) DeepCopy() *RemediationAttempt {
+	if in == nil {
+		return nil
+	}
+	out := new(RemediationAttempt)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bootstrap validates the bootstrap data written by the bootstrap providers, and translates the shell
+// scripts to the cloud-config the metal node agent runs.
+package bootstrap
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Format is the format of the bootstrap data, read from the format key of the bootstrap data secret
+type Format string
+
+const (
+	// CloudConfig is the cloud-init cloud-config written by kubeadm, the metal node agent runs it as is
+	CloudConfig Format = "cloud-config"
+
+	// Ignition is an Ignition config, e.g. for the Flatcar hosts, the metal node agent applies it as is
+	Ignition Format = "ignition"
+
+	// Shell is a raw shell script, starting with a shebang
+	Shell Format = "shell"
+)
+
+// keys of the bootstrap data secret
+const (
+	ValueKey  = "value"
+	FormatKey = "format"
+)
+
+// cloudConfigHeader starts a cloud-config
+const cloudConfigHeader = "#cloud-config"
+
+// jinjaHeader starts the cloud-config templated by cloud-init, as written by kubeadm
+const jinjaHeader = "## template: jinja"
+
+// ParseFormat returns the format of the bootstrap data, cloud-config when the bootstrap provider did not set one
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case "", CloudConfig:
+		return CloudConfig, nil
+	case Ignition, Shell:
+		return Format(format), nil
+	default:
+		return "", errors.Errorf("unsupported bootstrap data format %q, the format must be one of %s, %s or %s", format, CloudConfig, Ignition, Shell)
+	}
+}
+
+// AgentData validates the bootstrap data of the given format and returns the data the metal node agent runs, along
+// with its format. The Ignition configs are handed as is to the agent of the Ignition hosts, e.g. Flatcar, whose
+// cloud-config runner has no runcmd, the other formats are run as cloud-config.
+func AgentData(format Format, value []byte) (Format, []byte, error) {
+	if len(strings.TrimSpace(string(value))) == 0 {
+		return "", nil, errors.New("the bootstrap data is empty")
+	}
+	switch format {
+	case CloudConfig:
+		if err := validateCloudConfig(value); err != nil {
+			return "", nil, err
+		}
+		return CloudConfig, value, nil
+	case Ignition:
+		if err := validateIgnition(value); err != nil {
+			return "", nil, err
+		}
+		return Ignition, value, nil
+	case Shell:
+		data, err := translateShell(value)
+		return CloudConfig, data, err
+	default:
+		return "", nil, errors.Errorf("unsupported bootstrap data format %q", format)
+	}
+}
+
+// validateCloudConfig checks the cloud-config starts with its header and is a YAML mapping
+func validateCloudConfig(value []byte) error {
+	lines := strings.SplitN(strings.TrimLeft(string(value), "\n"), "\n", 3)
+	if strings.TrimSpace(lines[0]) == jinjaHeader {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != cloudConfigHeader {
+		return errors.Errorf("invalid cloud-config: it must start with %q", cloudConfigHeader)
+	}
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(value, &config); err != nil {
+		return errors.Wrap(err, "invalid cloud-config")
+	}
+	return nil
+}
+
+// cloudConfig is the part of cloud-config the translated bootstrap data uses
+type cloudConfig struct {
+	WriteFiles []cloudConfigFile `json:"write_files,omitempty"`
+	RunCmd     []string          `json:"runcmd,omitempty"`
+}
+
+type cloudConfigFile struct {
+	Path        string `json:"path"`
+	Owner       string `json:"owner"`
+	Permissions string `json:"permissions"`
+	Encoding    string `json:"encoding,omitempty"`
+	Content     string `json:"content"`
+}
+
+func (c *cloudConfig) marshal() ([]byte, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(cloudConfigHeader+"\n"), data...), nil
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseFormat(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(ParseFormat("")).To(Equal(CloudConfig))
+	g.Expect(ParseFormat("cloud-config")).To(Equal(CloudConfig))
+	g.Expect(ParseFormat("ignition")).To(Equal(Ignition))
+	g.Expect(ParseFormat("shell")).To(Equal(Shell))
+
+	_, err := ParseFormat("cloud-init")
+	g.Expect(err).To(MatchError(`unsupported bootstrap data format "cloud-init", the format must be one of cloud-config, ignition or shell`))
+}
+
+func TestAgentData(t *testing.T) {
+	g := NewWithT(t)
+
+	kubeadm := []byte("## template: jinja\n#cloud-config\nwrite_files:\n- path: /etc/kubeadm.yml\n  content: |\n    name: '{{ ds.meta_data.local_hostname }}'\nruncmd:\n- kubeadm init --config /etc/kubeadm.yml\n")
+	format, data, err := AgentData(CloudConfig, kubeadm)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(format).To(Equal(CloudConfig))
+	g.Expect(data).To(Equal(kubeadm))
+
+	_, _, err = AgentData(CloudConfig, []byte("runcmd:\n- kubeadm init\n"))
+	g.Expect(err).To(MatchError(`invalid cloud-config: it must start with "#cloud-config"`))
+
+	_, _, err = AgentData(CloudConfig, []byte("#cloud-config\nruncmd: [\n"))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(HavePrefix("invalid cloud-config: "))
+
+	_, _, err = AgentData(Ignition, []byte(" \n"))
+	g.Expect(err).To(MatchError("the bootstrap data is empty"))
+}
+
+func TestShell(t *testing.T) {
+	g := NewWithT(t)
+
+	script := "#!/bin/bash\nkubeadm join 10.0.0.1:6443 --token abcdef.0123456789abcdef\n"
+	config := parseCloudConfig(g, Shell, script)
+	g.Expect(config.WriteFiles).To(Equal([]cloudConfigFile{{
+		Path:        ShellScriptPath,
+		Owner:       "root:root",
+		Permissions: "0700",
+		Encoding:    "b64",
+		Content:     base64.StdEncoding.EncodeToString([]byte(script)),
+	}}))
+	g.Expect(config.RunCmd).To(Equal([]string{ShellScriptPath}))
+
+	_, _, err := AgentData(Shell, []byte("kubeadm join 10.0.0.1:6443\n"))
+	g.Expect(err).To(MatchError("invalid shell script: it must start with a shebang, e.g. #!/bin/bash"))
+}
+
+func TestIgnition(t *testing.T) {
+	g := NewWithT(t)
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	_, err := w.Write([]byte("compressed\n"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(w.Close()).To(Succeed())
+
+	// the Ignition config written by kubeadm for Flatcar is handed as is to the metal node agent
+	ignition := `{
+  "ignition": {"version": "2.3.0"},
+  "passwd": {"users": [{"name": "core", "sshAuthorizedKeys": ["ssh-ed25519 AAAA core"], "groups": ["sudo", "docker"]}]},
+  "storage": {
+    "directories": [{"path": "/etc/kubernetes/manifests", "mode": 448}],
+    "files": [
+      {"path": "/etc/kubeadm.sh", "filesystem": "root", "mode": 448, "contents": {"source": "data:,%23!%2Fbin%2Fbash%0Akubeadm%20init%0A"}},
+      {"path": "/etc/kubernetes/pki/ca.crt", "filesystem": "root", "mode": 416, "overwrite": true, "user": {"name": "core"}, "contents": {"source": "data:text/plain;base64,Y2VydA=="}},
+      {"path": "/etc/compressed", "contents": {"source": "data:;base64,` + base64.StdEncoding.EncodeToString(compressed.Bytes()) + `", "compression": "gzip"}}
+    ],
+    "links": [{"path": "/opt/bin/kubeadm", "target": "/usr/bin/kubeadm"}]
+  },
+  "systemd": {"units": [
+    {"name": "kubeadm.service", "enabled": true, "contents": "[Service]\nExecStart=/etc/kubeadm.sh\n"},
+    {"name": "containerd.service", "dropins": [{"name": "10-limits.conf", "contents": "[Service]\nLimitNOFILE=1048576\n"}]},
+    {"name": "update-engine.service", "mask": true}
+  ]}
+}`
+	format, data, err := AgentData(Ignition, []byte(ignition))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(format).To(Equal(Ignition))
+	g.Expect(string(data)).To(Equal(ignition))
+}
+
+func TestIgnitionUnsupported(t *testing.T) {
+	g := NewWithT(t)
+
+	tests := []struct {
+		ignition string
+		err      string
+	}{
+		{
+			ignition: `#cloud-config`,
+			err:      "invalid Ignition config: invalid character '#' looking for beginning of value",
+		},
+		{
+			ignition: `{"storage": {}}`,
+			err:      "invalid Ignition config: it has no ignition.version",
+		},
+		{
+			ignition: `{"ignition": {"version": "1.0.0"}}`,
+			err:      "unsupported Ignition config version 1.0.0, the version must be 2.x or 3.x",
+		},
+		{
+			ignition: `{"ignition": {"version": "3.3.0", "config": {"merge": [{"source": "https://example.com/config.ign"}]}}}`,
+			err:      "unsupported Ignition config: merging or replacing remote configs is not supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "3.3.0"}, "storage": {"files": [{"path": "/etc/hosts", "append": [{"source": "data:,10.0.0.1"}]}]}}`,
+			err:      "unsupported Ignition config: appending to file /etc/hosts is not supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "3.3.0"}, "storage": {"files": [{"path": "/etc/kubeadm.sh", "contents": {"source": "https://example.com/kubeadm.sh"}}]}}`,
+			err:      "unsupported Ignition config: file /etc/kubeadm.sh: the contents are fetched from a https source, only data URLs are supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "3.3.0"}, "storage": {"disks": [{"device": "/dev/sdb", "wipeTable": true}]}}`,
+			err:      "unsupported Ignition config: storage.disks is not supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "3.3.0"}, "storage": {"raid": [{"name": "data", "level": "raid1", "devices": ["/dev/sdb", "/dev/sdc"]}]}}`,
+			err:      "unsupported Ignition config: storage.raid is not supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "3.3.0"}, "storage": {"filesystems": [{"device": "/dev/sdb", "format": "xfs", "path": "/var/lib/etcd"}]}}`,
+			err:      "unsupported Ignition config: storage.filesystems is not supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "2.3.0"}, "passwd": {"groups": [{"name": "kube"}]}}`,
+			err:      "unsupported Ignition config: passwd.groups is not supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "2.3.0"}, "storage": {"files": [{"path": "/var/lib/etcd/env", "filesystem": "etcd", "contents": {"source": "data:,"}}]}}`,
+			err:      "unsupported Ignition config: file /var/lib/etcd/env is written to filesystem etcd, only the root filesystem is supported",
+		},
+		{
+			ignition: `{"ignition": {"version": "3.3.0"}, "storage": {"files": [{"path": "/etc/hosts", "overwrite": false, "contents": {"source": "data:,10.0.0.1"}}]}}`,
+			err:      "unsupported Ignition config: file /etc/hosts is not to be overwritten, the existing files are always overwritten",
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := AgentData(Ignition, []byte(tt.ignition))
+		g.Expect(err).To(MatchError(tt.err))
+	}
+}
+
+// parseCloudConfig translates the bootstrap data and parses the resulting cloud-config
+func parseCloudConfig(g *WithT, format Format, value string) *cloudConfig {
+	agentFormat, data, err := AgentData(format, []byte(value))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(agentFormat).To(Equal(CloudConfig))
+	g.Expect(strings.HasPrefix(string(data), "#cloud-config\n")).To(BeTrue())
+	config := &cloudConfig{}
+	g.Expect(yaml.UnmarshalStrict(data, config)).To(Succeed())
+	return config
+}
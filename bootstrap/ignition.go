@@ -0,0 +1,155 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ignitionConfig is the part of an Ignition config the validation reads, it is the same in the 2.x
+// specifications written by kubeadm for Flatcar and in the 3.x ones
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+		Config  struct {
+			Append  []json.RawMessage `json:"append"`
+			Merge   []json.RawMessage `json:"merge"`
+			Replace *json.RawMessage  `json:"replace"`
+		} `json:"config"`
+	} `json:"ignition"`
+	Passwd struct {
+		Groups []json.RawMessage `json:"groups"`
+	} `json:"passwd"`
+	Storage struct {
+		Disks       []json.RawMessage `json:"disks"`
+		Raid        []json.RawMessage `json:"raid"`
+		Filesystems []json.RawMessage `json:"filesystems"`
+		Files       []struct {
+			Path       string          `json:"path"`
+			Filesystem string          `json:"filesystem"`
+			Overwrite  *bool           `json:"overwrite"`
+			Append     json.RawMessage `json:"append"`
+			Contents   struct {
+				Source      string `json:"source"`
+				Compression string `json:"compression"`
+			} `json:"contents"`
+		} `json:"files"`
+	} `json:"storage"`
+}
+
+// validateIgnition checks the Ignition config only applies what the metal node agent applies on a host already
+// installed: the users, directories, files, links and systemd units, whose contents are embedded in the config
+func validateIgnition(value []byte) error {
+	ignition := &ignitionConfig{}
+	if err := json.Unmarshal(value, ignition); err != nil {
+		return errors.Wrap(err, "invalid Ignition config")
+	}
+	version := ignition.Ignition.Version
+	if version == "" {
+		return errors.New("invalid Ignition config: it has no ignition.version")
+	}
+	if !strings.HasPrefix(version, "2.") && !strings.HasPrefix(version, "3.") {
+		return errors.Errorf("unsupported Ignition config version %s, the version must be 2.x or 3.x", version)
+	}
+	if len(ignition.Ignition.Config.Append) > 0 || len(ignition.Ignition.Config.Merge) > 0 || ignition.Ignition.Config.Replace != nil {
+		return errors.New("unsupported Ignition config: merging or replacing remote configs is not supported")
+	}
+
+	// the metal node is partitioned when it is installed, and its groups are those of its image
+	for _, section := range []struct {
+		field   string
+		entries []json.RawMessage
+	}{
+		{"storage.disks", ignition.Storage.Disks},
+		{"storage.raid", ignition.Storage.Raid},
+		{"storage.filesystems", ignition.Storage.Filesystems},
+		{"passwd.groups", ignition.Passwd.Groups},
+	} {
+		if len(section.entries) > 0 {
+			return errors.Errorf("unsupported Ignition config: %s is not supported", section.field)
+		}
+	}
+
+	for _, file := range ignition.Storage.Files {
+		if file.Filesystem != "" && file.Filesystem != "root" {
+			return errors.Errorf("unsupported Ignition config: file %s is written to filesystem %s, only the root filesystem is supported", file.Path, file.Filesystem)
+		}
+		// the agent replaces the existing files
+		if file.Overwrite != nil && !*file.Overwrite {
+			return errors.Errorf("unsupported Ignition config: file %s is not to be overwritten, the existing files are always overwritten", file.Path)
+		}
+		if appending := strings.TrimSpace(string(file.Append)); appending != "" && appending != "false" && appending != "null" && appending != "[]" {
+			return errors.Errorf("unsupported Ignition config: appending to file %s is not supported", file.Path)
+		}
+		content, err := decodeDataURL(file.Contents.Source)
+		if err != nil {
+			return errors.Wrapf(err, "unsupported Ignition config: file %s", file.Path)
+		}
+		switch file.Contents.Compression {
+		case "":
+		case "gzip":
+			if _, err := gunzip(content); err != nil {
+				return errors.Wrapf(err, "invalid Ignition config: file %s", file.Path)
+			}
+		default:
+			return errors.Errorf("unsupported Ignition config: file %s is compressed with %s", file.Path, file.Contents.Compression)
+		}
+	}
+	return nil
+}
+
+// decodeDataURL returns the contents of a data URL, the metal node agent does not fetch remote contents
+func decodeDataURL(source string) ([]byte, error) {
+	if source == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(source, "data:") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid source %q", source)
+		}
+		return nil, errors.Errorf("the contents are fetched from a %s source, only data URLs are supported", u.Scheme)
+	}
+	comma := strings.Index(source, ",")
+	if comma < 0 {
+		return nil, errors.Errorf("invalid data URL %q", source)
+	}
+	mediaType, data := source[len("data:"):comma], source[comma+1:]
+	if strings.HasSuffix(mediaType, ";base64") {
+		content, err := base64.StdEncoding.DecodeString(data)
+		return content, errors.Wrap(err, "invalid base64 data URL")
+	}
+	content, err := url.PathUnescape(data)
+	return []byte(content), errors.Wrap(err, "invalid data URL")
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// MergeCloudConfigs merges the cloud-configs in order: the lists are appended to, the mappings are merged and the
+// other values of the later cloud-configs override the ones of the earlier. The result is templated by cloud-init
+// when any of the cloud-configs is, as the kubeadm ones are.
+func MergeCloudConfigs(configs ...[]byte) ([]byte, error) {
+	merged := map[string]interface{}{}
+	jinja := false
+	for i, config := range configs {
+		if err := validateCloudConfig(config); err != nil {
+			return nil, errors.Wrapf(err, "cloud-config %d", i)
+		}
+		jinja = jinja || strings.TrimSpace(strings.SplitN(strings.TrimLeft(string(config), "\n"), "\n", 2)[0]) == jinjaHeader
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal(config, &values); err != nil {
+			return nil, errors.Wrapf(err, "cloud-config %d", i)
+		}
+		merged = mergeValues(merged, values).(map[string]interface{})
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	header := cloudConfigHeader + "\n"
+	if jinja {
+		header = jinjaHeader + "\n" + header
+	}
+	return append([]byte(header), data...), nil
+}
+
+// mergeValues merges src into dst, the lists are appended to and the mappings are merged
+func mergeValues(dst, src interface{}) interface{} {
+	switch s := src.(type) {
+	case map[string]interface{}:
+		if d, ok := dst.(map[string]interface{}); ok {
+			for k, v := range s {
+				d[k] = mergeValues(d[k], v)
+			}
+			return d
+		}
+	case []interface{}:
+		if d, ok := dst.([]interface{}); ok {
+			return append(d, s...)
+		}
+	}
+	return src
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMergeCloudConfigs(t *testing.T) {
+	g := NewWithT(t)
+
+	ntp := "#cloud-config\nntp:\n  enabled: true\n  servers:\n  - ntp1.example.com\nruncmd:\n- systemctl restart chronyd\n"
+	registry := "#cloud-config\nwrite_files:\n- path: /etc/containerd/certs.d/docker.io/hosts.toml\n  content: |\n    server = \"https://registry.example.com\"\nntp:\n  servers:\n  - ntp2.example.com\n"
+	kubeadm := "## template: jinja\n#cloud-config\nwrite_files:\n- path: /run/kubeadm/kubeadm.yaml\n  content: |\n    name: '{{ ds.meta_data.local_hostname }}'\nruncmd:\n- kubeadm init --config /run/kubeadm/kubeadm.yaml\nntp:\n  enabled: false\n"
+
+	merged, err := MergeCloudConfigs([]byte(ntp), []byte(registry), []byte(kubeadm))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(merged)).To(Equal(`## template: jinja
+#cloud-config
+ntp:
+  enabled: false
+  servers:
+  - ntp1.example.com
+  - ntp2.example.com
+runcmd:
+- systemctl restart chronyd
+- kubeadm init --config /run/kubeadm/kubeadm.yaml
+write_files:
+- content: |
+    server = "https://registry.example.com"
+  path: /etc/containerd/certs.d/docker.io/hosts.toml
+- content: |
+    name: '{{ ds.meta_data.local_hostname }}'
+  path: /run/kubeadm/kubeadm.yaml
+`))
+
+	merged, err = MergeCloudConfigs([]byte(ntp))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(merged)).To(HavePrefix("#cloud-config\n"))
+
+	_, err = MergeCloudConfigs([]byte(ntp), []byte("ntp:\n  enabled: true\n"))
+	g.Expect(err).To(MatchError(`cloud-config 1: invalid cloud-config: it must start with "#cloud-config"`))
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bootstrap
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ShellScriptPath is where the cloud-config writes a shell script bootstrap data before running it
+const ShellScriptPath = "/var/lib/cluster-api-provider-demo/bootstrap.sh"
+
+// translateShell returns the cloud-config writing the script on the metal node and running it
+func translateShell(value []byte) ([]byte, error) {
+	if !strings.HasPrefix(string(value), "#!") {
+		return nil, errors.New("invalid shell script: it must start with a shebang, e.g. #!/bin/bash")
+	}
+	config := &cloudConfig{
+		WriteFiles: []cloudConfigFile{{
+			Path:        ShellScriptPath,
+			Owner:       "root:root",
+			Permissions: "0700",
+			Encoding:    "b64",
+			Content:     base64.StdEncoding.EncodeToString(value),
+		}},
+		RunCmd: []string{ShellScriptPath},
+	}
+	return config.marshal()
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+)
+
+func explainCommand() *command {
+	return &command{
+		name:  "explain",
+		use:   "explain MACHINE",
+		short: "Explain why a Machine or DemoMachine is not placed on a metal node.",
+		flags: pflag.NewFlagSet("explain", pflag.ContinueOnError),
+		run: func(ctx context.Context, o *options, args []string) error {
+			if len(args) != 1 {
+				return errors.New("explain takes the name of a Machine or DemoMachine")
+			}
+			return explain(ctx, o, args[0], time.Now())
+		},
+	}
+}
+
+// explain prints why a machine is not placed on a metal node, along with the state of the pool it waits on
+func explain(ctx context.Context, o *options, name string, now time.Time) error {
+	chain, err := getMachineChain(ctx, o.client, o.namespace, name)
+	if err != nil {
+		return err
+	}
+	if chain.demoMachine == nil {
+		fmt.Fprintf(o.out, "Machine %s has no DemoMachine yet, it is created from the infrastructure template of the Machine\n", chain.machine.Name)
+		return nil
+	}
+	demoMachine := chain.demoMachine
+	if metalNodeName := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]; metalNodeName != "" {
+		if chain.metalNode == nil {
+			fmt.Fprintf(o.out, "DemoMachine %s is placed on metal node %s, which is not found\n", demoMachine.Name, metalNodeName)
+			return nil
+		}
+		fmt.Fprintf(o.out, "DemoMachine %s is placed on metal node %s (host %s, %s)\n", demoMachine.Name, metalNodeName,
+			chain.metalNode.Spec.NodeEndPoint.Host, metalNodeState(chain.metalNode))
+		if !demoMachine.Status.Bootstrapped {
+			writeCondition(o, demoMachine, constants.BootstrapSucceededCondition)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(o.out, "DemoMachine %s is not placed on a metal node:\n", demoMachine.Name)
+	cluster := chain.cluster
+	switch {
+	case !demoMachine.DeletionTimestamp.IsZero():
+		fmt.Fprintln(o.out, "- the DemoMachine is being deleted")
+		return nil
+	case chain.machine == nil:
+		fmt.Fprintln(o.out, "- the DemoMachine has no owner Machine yet")
+		return nil
+	case cluster == nil:
+		fmt.Fprintf(o.out, "- Cluster %s is not found\n", chain.machine.Spec.ClusterName)
+		return nil
+	case cluster.Spec.Paused || annotations.HasPaused(cluster):
+		fmt.Fprintf(o.out, "- Cluster %s is paused, its machines are not reconciled\n", cluster.Name)
+		return nil
+	case !cluster.Status.InfrastructureReady:
+		fmt.Fprintf(o.out, "- the DemoCluster of Cluster %s is not ready\n", cluster.Name)
+	case chain.machine.Spec.Bootstrap.DataSecretName == nil:
+		fmt.Fprintln(o.out, "- the bootstrap data of the Machine is not available yet")
+	}
+	writeCondition(o, demoMachine, constants.MetalNodeReadyCondition)
+	if queue := demoMachine.Status.Queue; queue != nil {
+		fmt.Fprintf(o.out, "- waiting at position %d of the provisioning queue of namespace %s, for %s\n",
+			queue.Position, demoMachine.Namespace, now.Sub(queue.QueuedAt.Time).Round(time.Second))
+	}
+
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := o.client.List(ctx, metalNodeList, client.InNamespace(o.namespace)); err != nil {
+		return errors.Wrap(err, "failed to list the metal nodes")
+	}
+	states := map[string]int{}
+	for i := range metalNodeList.Items {
+		states[metalNodeState(&metalNodeList.Items[i])]++
+	}
+	fmt.Fprintf(o.out, "- %d metal nodes of namespace %s are free, %d in maintenance, %d reprovisioning, %d not ready, out of %d\n",
+		states[freeState], o.namespace, states[maintenanceState], states[reprovisioningState], states[notReadyState], len(metalNodeList.Items))
+
+	quotaList := &infrav1.MetalNodeQuotaList{}
+	if err := o.client.List(ctx, quotaList, client.InNamespace(o.namespace)); err != nil {
+		return errors.Wrap(err, "failed to list the metal node quotas")
+	}
+	for _, quota := range quotaList.Items {
+		applies := false
+		for _, name := range quota.Status.Clusters {
+			applies = applies || name == cluster.Name
+		}
+		max := "unlimited"
+		if quota.Spec.Max != nil {
+			max = fmt.Sprint(*quota.Spec.Max)
+		}
+		switch {
+		case applies:
+			fmt.Fprintf(o.out, "- MetalNodeQuota %s applies to the cluster: %d metal nodes claimed out of %s\n", quota.Name, quota.Status.Used, max)
+		case quota.Status.Reserved > 0:
+			fmt.Fprintf(o.out, "- MetalNodeQuota %s reserves %d free metal nodes for other clusters\n", quota.Name, quota.Status.Reserved)
+		}
+	}
+
+	if demoCluster := chain.demoCluster; demoCluster != nil {
+		policy := demoCluster.Spec.PreemptionPolicy
+		if policy == "" {
+			policy = infrav1.NeverPreemptionPolicy
+		}
+		fmt.Fprintf(o.out, "- the cluster has priority %d and preemption policy %s\n", demoCluster.Spec.Priority, policy)
+	}
+	return nil
+}
+
+// writeCondition prints the reason and message of a condition of the demo machine which is not true
+func writeCondition(o *options, demoMachine *infrav1.DemoMachine, t clusterv1.ConditionType) {
+	condition := conditions.Get(demoMachine, t)
+	if condition == nil || condition.Status == corev1.ConditionTrue {
+		return
+	}
+	fmt.Fprintf(o.out, "- %s is %s, %s", t, condition.Status, condition.Reason)
+	if condition.Message != "" {
+		fmt.Fprintf(o.out, ": %s", condition.Message)
+	}
+	fmt.Fprintln(o.out)
+}
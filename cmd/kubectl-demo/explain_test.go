@@ -0,0 +1,93 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+)
+
+func TestExplain(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("explains a machine waiting in the provisioning queue", func(t *testing.T) {
+		g := NewWithT(t)
+		cluster, demoCluster := newCluster("demo")
+		demoCluster.Spec.Priority = 10
+		machine, demoMachine := newMachine("demo", "demo-0", "")
+		demoMachine.Status.Queue = &infrav1.DemoMachineQueueStatus{Position: 2, QueuedAt: metav1.NewTime(now.Add(-90 * time.Second))}
+		demoMachine.Status.Conditions = clusterv1.Conditions{{
+			Type:     constants.MetalNodeReadyCondition,
+			Status:   corev1.ConditionFalse,
+			Severity: clusterv1.ConditionSeverityInfo,
+			Reason:   constants.WaitingInQueueReason,
+			Message:  "1 metal nodes free for 2 queued machines",
+		}}
+		max := int32(3)
+		quota := &infrav1.MetalNodeQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: namespace},
+			Spec:       infrav1.MetalNodeQuotaSpec{ClusterName: "demo", Max: &max},
+			Status:     infrav1.MetalNodeQuotaStatus{Clusters: []string{"demo"}, Used: 1, Free: 1},
+		}
+		o, out := newOptions(cluster, demoCluster, machine, demoMachine, quota,
+			newMetalNode("node-1", "10.0.0.1", nil),
+			newMetalNode("node-2", "10.0.0.2", claim("demo", "demo-1")),
+			newMetalNode("node-3", "10.0.0.3", func(metalNode *metav1beta1.MetalNode) {
+				metalNode.Annotations = map[string]string{infrav1.MetalNodeMaintenanceAnnotation: "firmware"}
+			}),
+		)
+		g.Expect(explain(ctx, o, "demo-0", now)).To(Succeed())
+		g.Expect(out.String()).To(Equal("" +
+			"DemoMachine demo-0 is not placed on a metal node:\n" +
+			"- MetalNodeReady is False, WaitingInQueue: 1 metal nodes free for 2 queued machines\n" +
+			"- waiting at position 2 of the provisioning queue of namespace default, for 1m30s\n" +
+			"- 1 metal nodes of namespace default are free, 1 in maintenance, 0 reprovisioning, 0 not ready, out of 3\n" +
+			"- MetalNodeQuota quota applies to the cluster: 1 metal nodes claimed out of 3\n" +
+			"- the cluster has priority 10 and preemption policy Never\n"))
+	})
+
+	t.Run("explains a machine of a paused cluster", func(t *testing.T) {
+		g := NewWithT(t)
+		cluster, demoCluster := newCluster("demo")
+		cluster.Spec.Paused = true
+		machine, demoMachine := newMachine("demo", "demo-0", "")
+		o, out := newOptions(cluster, demoCluster, machine, demoMachine)
+		g.Expect(explain(ctx, o, "demo-0", now)).To(Succeed())
+		g.Expect(out.String()).To(Equal("DemoMachine demo-0 is not placed on a metal node:\n- Cluster demo is paused, its machines are not reconciled\n"))
+	})
+
+	t.Run("reports the metal node of a placed machine", func(t *testing.T) {
+		g := NewWithT(t)
+		cluster, demoCluster := newCluster("demo")
+		machine, demoMachine := newMachine("demo", "demo-0", "node")
+		demoMachine.Status.Bootstrapped = true
+		o, out := newOptions(cluster, demoCluster, machine, demoMachine, newMetalNode("node", "10.0.0.1", claim("demo", "demo-0")))
+		g.Expect(explain(ctx, o, "demo-0", now)).To(Succeed())
+		g.Expect(out.String()).To(Equal("DemoMachine demo-0 is placed on metal node node (host 10.0.0.1, bootstrapped)\n"))
+	})
+}
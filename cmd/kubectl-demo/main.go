@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-demo is a kubectl plugin inspecting and operating the metal nodes of the demo provider,
+// run as kubectl demo <command>.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = clusterv1.AddToScheme(scheme)
+	_ = infrav1.AddToScheme(scheme)
+	_ = metav1beta1.AddToScheme(scheme)
+}
+
+// options are the options shared by the commands
+type options struct {
+	client        client.Client
+	namespace     string
+	allNamespaces bool
+	out           io.Writer
+}
+
+// command is a command of the plugin
+type command struct {
+	name  string
+	use   string
+	short string
+	flags *pflag.FlagSet
+	run   func(ctx context.Context, o *options, args []string) error
+}
+
+func commands() []*command {
+	return []*command{poolCommand(), traceCommand(), releaseCommand(), explainCommand()}
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by the first argument
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(out)
+		return nil
+	}
+	var cmd *command
+	for _, c := range commands() {
+		if c.name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		usage(out)
+		return errors.Errorf("unknown command %q", args[0])
+	}
+
+	var kubeconfig, kubeContext, namespace string
+	var allNamespaces bool
+	flags := cmd.flags
+	flags.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	flags.StringVar(&kubeContext, "context", "", "The kubeconfig context to use.")
+	flags.StringVarP(&namespace, "namespace", "n", "", "The namespace of the objects, the namespace of the context by default.")
+	flags.BoolVarP(&allNamespaces, "all-namespaces", "A", false, "List the objects across all namespaces, where supported.")
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprintf(out, "%s\n\nUsage:\n  kubectl demo %s\n\nFlags:\n%s", cmd.short, cmd.use, flags.FlagUsages())
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: kubeContext,
+		Context:        clientcmdapi.Context{Namespace: namespace},
+	})
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to load the kubeconfig")
+	}
+	if namespace, _, err = config.Namespace(); err != nil {
+		return errors.Wrap(err, "failed to get the namespace of the kubeconfig context")
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return errors.Wrap(err, "failed to create the client")
+	}
+
+	return cmd.run(ctx, &options{client: c, namespace: namespace, allNamespaces: allNamespaces, out: out}, flags.Args())
+}
+
+// usage prints the commands of the plugin
+func usage(out io.Writer) {
+	fmt.Fprint(out, "kubectl-demo inspects and operates the metal nodes of the demo provider.\n\nCommands:\n")
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %s\t%s\n", c.use, c.short)
+	}
+	_ = w.Flush()
+	fmt.Fprintln(out, "\nRun kubectl demo <command> --help for the flags of a command.")
+}
+
+// newTabWriter returns a writer aligning the columns of a table
+func newTabWriter(out io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+}
+
+// orNone returns the value, or <none> if it is empty
+func orNone(values ...string) string {
+	value := strings.Join(values, ",")
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
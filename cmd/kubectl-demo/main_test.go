@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+const namespace = "default"
+
+// newOptions returns the options of a command run against the objects, and the output of the command
+func newOptions(objs ...client.Object) (*options, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return &options{client: c, namespace: namespace, out: out}, out
+}
+
+// newMetalNode returns a ready metal node on host, changed by mutate
+func newMetalNode(name, host string, mutate func(*metav1beta1.MetalNode)) *metav1beta1.MetalNode {
+	metalNode := &metav1beta1.MetalNode{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       metav1beta1.MetalNodeSpec{NodeEndPoint: metav1beta1.NodeEndPoint{Host: host}},
+		Status:     metav1beta1.MetalNodeStatus{Ready: true},
+	}
+	if mutate != nil {
+		mutate(metalNode)
+	}
+	return metalNode
+}
+
+// claim has a metal node claimed by a demo machine of cluster, bootstrapped as a worker
+func claim(cluster, demoMachine string) func(*metav1beta1.MetalNode) {
+	return func(metalNode *metav1beta1.MetalNode) {
+		metalNode.Status.RefCluster = cluster
+		metalNode.Status.Bootstrapped = true
+		metalNode.Status.Role = []string{"worker"}
+		metalNode.Annotations = map[string]string{infrav1.MetalNodeStatusAnnotation: "{}", infrav1.MetalNodeDemoMachineAnnotation: demoMachine}
+		metalNode.Labels = map[string]string{infrav1.ClusterctlMoveLabelName: ""}
+	}
+}
+
+// newCluster returns a Cluster whose infrastructure is the DemoCluster of the same name
+func newCluster(name string) (*clusterv1.Cluster, *infrav1.DemoCluster) {
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: clusterv1.ClusterSpec{
+			InfrastructureRef: &corev1.ObjectReference{APIVersion: infrav1.GroupVersion.String(), Kind: "DemoCluster", Name: name},
+		},
+		Status: clusterv1.ClusterStatus{InfrastructureReady: true},
+	}
+	demoCluster := &infrav1.DemoCluster{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	return cluster, demoCluster
+}
+
+// newMachine returns a Machine of cluster with bootstrap data and its DemoMachine of the same name, placed on the
+// metal node if one is given
+func newMachine(cluster, name, metalNode string) (*clusterv1.Machine, *infrav1.DemoMachine) {
+	dataSecretName := name
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: clusterv1.MachineSpec{
+			ClusterName:       cluster,
+			Bootstrap:         clusterv1.Bootstrap{DataSecretName: &dataSecretName},
+			InfrastructureRef: corev1.ObjectReference{APIVersion: infrav1.GroupVersion.String(), Kind: "DemoMachine", Name: name},
+		},
+	}
+	demoMachine := &infrav1.DemoMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			Labels:          map[string]string{clusterv1.ClusterLabelName: cluster},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: clusterv1.GroupVersion.String(), Kind: "Machine", Name: name}},
+		},
+	}
+	if metalNode != "" {
+		demoMachine.Labels[infrav1.MetalNodeLabelName] = metalNode
+	}
+	return machine, demoMachine
+}
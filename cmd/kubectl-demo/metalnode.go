@@ -0,0 +1,75 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// The states of the metal nodes in the pool
+const (
+	freeState           = "free"
+	claimedState        = "claimed"
+	bootstrappedState   = "bootstrapped"
+	notReadyState       = "not-ready"
+	maintenanceState    = "maintenance"
+	reprovisioningState = "reprovisioning"
+)
+
+// metalNodeState returns the state of a metal node in the pool, the way the provider sees it
+func metalNodeState(metalNode *metav1beta1.MetalNode) string {
+	annotations := metalNode.GetAnnotations()
+	if data, ok := annotations[infrav1.MetalNodeReprovisioningAnnotation]; ok {
+		reprovisioning := &infrav1.MetalNodeReprovisioning{}
+		if err := json.Unmarshal([]byte(data), reprovisioning); err != nil || reprovisioning.State != infrav1.AvailableState {
+			return reprovisioningState
+		}
+	}
+	_, statusLost := annotations[infrav1.MetalNodeStatusAnnotation]
+	switch {
+	case metalNode.Status.Bootstrapped:
+		return bootstrappedState
+	case metalNode.GetRefCluster() != "" || statusLost:
+		return claimedState
+	}
+	if _, ok := annotations[infrav1.MetalNodeMaintenanceAnnotation]; ok {
+		return maintenanceState
+	}
+	if !metalNode.IsReady() {
+		return notReadyState
+	}
+	return freeState
+}
+
+// demoMachineName returns the name of the demo machine a metal node is claimed by, empty if none is
+func demoMachineName(metalNode *metav1beta1.MetalNode) string {
+	return metalNode.GetAnnotations()[infrav1.MetalNodeDemoMachineAnnotation]
+}
+
+// clusterName returns the name of the Cluster owning a metal node, for the metal nodes claimed by a DemoCluster,
+// empty if none does
+func clusterName(metalNode *metav1beta1.MetalNode) string {
+	for _, ref := range metalNode.GetOwnerReferences() {
+		if ref.Kind == "Cluster" {
+			return ref.Name
+		}
+	}
+	return ""
+}
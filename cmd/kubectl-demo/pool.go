@@ -0,0 +1,131 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+)
+
+// poolGroup is a group of metal nodes of the pool sharing a state, a role and a cluster
+type poolGroup struct {
+	namespace string
+	state     string
+	role      string
+	cluster   string
+	nodes     int
+}
+
+func poolCommand() *command {
+	flags := pflag.NewFlagSet("pool", pflag.ContinueOnError)
+	nodes := flags.Bool("nodes", false, "List the metal nodes instead of counting them.")
+	return &command{
+		name:  "pool",
+		use:   "pool [--nodes]",
+		short: "Show the free, claimed and bootstrapped metal nodes by role and cluster.",
+		flags: flags,
+		run: func(ctx context.Context, o *options, args []string) error {
+			if len(args) != 0 {
+				return errors.New("pool takes no arguments")
+			}
+			return pool(ctx, o, *nodes)
+		},
+	}
+}
+
+// pool prints the metal nodes of the pool, counted by state, role and cluster or one by one
+func pool(ctx context.Context, o *options, nodes bool) error {
+	var listOptions []client.ListOption
+	if !o.allNamespaces {
+		listOptions = append(listOptions, client.InNamespace(o.namespace))
+	}
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := o.client.List(ctx, metalNodeList, listOptions...); err != nil {
+		return errors.Wrap(err, "failed to list the metal nodes")
+	}
+	metalNodes := metalNodeList.Items
+	sort.SliceStable(metalNodes, func(i, j int) bool {
+		if metalNodes[i].Namespace != metalNodes[j].Namespace {
+			return metalNodes[i].Namespace < metalNodes[j].Namespace
+		}
+		return metalNodes[i].Name < metalNodes[j].Name
+	})
+
+	w := newTabWriter(o.out)
+	namespaceColumn := func(namespace string) string {
+		if o.allNamespaces {
+			return namespace + "\t"
+		}
+		return ""
+	}
+	if nodes {
+		fmt.Fprintf(w, "%sNAME\tHOST\tSTATE\tROLE\tCLUSTER\tDEMOMACHINE\n", namespaceColumn("NAMESPACE"))
+		for i := range metalNodes {
+			metalNode := &metalNodes[i]
+			fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\t%s\n", namespaceColumn(metalNode.Namespace), metalNode.Name,
+				metalNode.Spec.NodeEndPoint.Host, metalNodeState(metalNode), orNone(metalNode.Status.Role...),
+				orNone(metalNode.GetRefCluster()), orNone(demoMachineName(metalNode)))
+		}
+		return w.Flush()
+	}
+
+	var groups []*poolGroup
+	index := map[poolGroup]*poolGroup{}
+	for i := range metalNodes {
+		metalNode := &metalNodes[i]
+		key := poolGroup{
+			namespace: metalNode.Namespace,
+			state:     metalNodeState(metalNode),
+			role:      strings.Join(metalNode.Status.Role, ","),
+			cluster:   metalNode.GetRefCluster(),
+		}
+		group, ok := index[key]
+		if !ok {
+			group = &poolGroup{namespace: key.namespace, state: key.state, role: key.role, cluster: key.cluster}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		group.nodes++
+	}
+	states := map[string]int{freeState: 0, claimedState: 1, bootstrappedState: 2, notReadyState: 3, reprovisioningState: 4, maintenanceState: 5}
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		switch {
+		case a.namespace != b.namespace:
+			return a.namespace < b.namespace
+		case a.state != b.state:
+			return states[a.state] < states[b.state]
+		case a.cluster != b.cluster:
+			return a.cluster < b.cluster
+		}
+		return a.role < b.role
+	})
+
+	fmt.Fprintf(w, "%sSTATE\tROLE\tCLUSTER\tNODES\n", namespaceColumn("NAMESPACE"))
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%d\n", namespaceColumn(group.namespace), group.state, orNone(group.role), orNone(group.cluster), group.nodes)
+	}
+	return w.Flush()
+}
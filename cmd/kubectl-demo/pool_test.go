@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+func TestMetalNodeState(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(metalNodeState(newMetalNode("free", "10.0.0.1", nil))).To(Equal(freeState))
+	g.Expect(metalNodeState(newMetalNode("not-ready", "10.0.0.2", func(metalNode *metav1beta1.MetalNode) {
+		metalNode.Status.Ready = false
+	}))).To(Equal(notReadyState))
+	g.Expect(metalNodeState(newMetalNode("claimed", "10.0.0.3", func(metalNode *metav1beta1.MetalNode) {
+		metalNode.Status.RefCluster = "demo"
+	}))).To(Equal(claimedState))
+	g.Expect(metalNodeState(newMetalNode("bootstrapped", "10.0.0.4", claim("demo", "demo-0")))).To(Equal(bootstrappedState))
+	g.Expect(metalNodeState(newMetalNode("maintenance", "10.0.0.5", func(metalNode *metav1beta1.MetalNode) {
+		metalNode.Annotations = map[string]string{infrav1.MetalNodeMaintenanceAnnotation: "firmware"}
+	}))).To(Equal(maintenanceState))
+	g.Expect(metalNodeState(newMetalNode("cleaning", "10.0.0.6", func(metalNode *metav1beta1.MetalNode) {
+		metalNode.Annotations = map[string]string{infrav1.MetalNodeReprovisioningAnnotation: `{"state":"Cleaning"}`}
+	}))).To(Equal(reprovisioningState))
+	g.Expect(metalNodeState(newMetalNode("available", "10.0.0.7", func(metalNode *metav1beta1.MetalNode) {
+		metalNode.Annotations = map[string]string{infrav1.MetalNodeReprovisioningAnnotation: `{"state":"Available"}`}
+	}))).To(Equal(freeState))
+}
+
+func TestPool(t *testing.T) {
+	g := NewWithT(t)
+
+	o, out := newOptions(
+		newMetalNode("node-1", "10.0.0.1", nil),
+		newMetalNode("node-2", "10.0.0.2", nil),
+		newMetalNode("node-3", "10.0.0.3", claim("demo", "demo-0")),
+		newMetalNode("node-4", "10.0.0.4", claim("demo", "demo-1")),
+		newMetalNode("node-5", "10.0.0.5", func(metalNode *metav1beta1.MetalNode) {
+			metalNode.Annotations = map[string]string{infrav1.MetalNodeMaintenanceAnnotation: "firmware"}
+		}),
+	)
+	g.Expect(pool(context.Background(), o, false)).To(Succeed())
+	g.Expect(out.String()).To(Equal("" +
+		"STATE         ROLE    CLUSTER  NODES\n" +
+		"free          <none>  <none>   2\n" +
+		"bootstrapped  worker  demo     2\n" +
+		"maintenance   <none>  <none>   1\n"))
+
+	out.Reset()
+	g.Expect(pool(context.Background(), o, true)).To(Succeed())
+	g.Expect(out.String()).To(ContainSubstring("node-3  10.0.0.3  bootstrapped  worker  demo     demo-0\n"))
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+)
+
+func releaseCommand() *command {
+	flags := pflag.NewFlagSet("release", pflag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Only print what releasing the metal node would do.")
+	force := flags.Bool("force", false, "Release a bootstrapped metal node, or one whose DemoMachine or Cluster is being deleted.")
+	return &command{
+		name:  "release",
+		use:   "release METALNODE [--dry-run] [--force]",
+		short: "Force release a metal node stuck claimed, handing it back to the pool.",
+		flags: flags,
+		run: func(ctx context.Context, o *options, args []string) error {
+			if len(args) != 1 {
+				return errors.New("release takes the name of a metal node")
+			}
+			return release(ctx, o, args[0], *dryRun, *force)
+		},
+	}
+}
+
+// release hands a claimed metal node back to the pool, through the release the DemoMachine releasing it runs. The power-off
+// of a released metal node is left to the controllers.
+// It refuses to release the metal nodes still in use: those bound to a DemoMachine or Cluster not being deleted,
+// and the bootstrapped ones unless forced.
+func release(ctx context.Context, o *options, name string, dryRun, force bool) error {
+	metalNode := &metav1beta1.MetalNode{}
+	if err := o.client.Get(ctx, client.ObjectKey{Namespace: o.namespace, Name: name}, metalNode); err != nil {
+		return errors.Wrapf(err, "failed to get metal node %s", name)
+	}
+	_, statusLost := metalNode.GetAnnotations()[infrav1.MetalNodeStatusAnnotation]
+	if metalNode.GetRefCluster() == "" && !statusLost && demoMachineName(metalNode) == "" && clusterName(metalNode) == "" {
+		return errors.Errorf("metal node %s is not claimed", name)
+	}
+
+	// the DemoMachines bound to the metal node, through their label or the owner reference of the metal node
+	demoMachineList := &infrav1.DemoMachineList{}
+	if err := o.client.List(ctx, demoMachineList, client.InNamespace(o.namespace), client.MatchingLabels{infrav1.MetalNodeLabelName: name}); err != nil {
+		return errors.Wrap(err, "failed to list the demo machines")
+	}
+	demoMachines := demoMachineList.Items
+	if owner := demoMachineName(metalNode); owner != "" {
+		bound := false
+		for _, demoMachine := range demoMachines {
+			bound = bound || demoMachine.Name == owner
+		}
+		if !bound {
+			demoMachine := &infrav1.DemoMachine{}
+			if err := o.client.Get(ctx, client.ObjectKey{Namespace: o.namespace, Name: owner}, demoMachine); err != nil {
+				if !apierrors.IsNotFound(err) {
+					return errors.Wrapf(err, "failed to get demo machine %s", owner)
+				}
+			} else {
+				demoMachines = append(demoMachines, *demoMachine)
+			}
+		}
+	}
+	for _, demoMachine := range demoMachines {
+		if demoMachine.DeletionTimestamp.IsZero() {
+			return errors.Errorf("metal node %s is bound to DemoMachine %s, delete its Machine to release the metal node", name, demoMachine.Name)
+		}
+		if !force {
+			return errors.Errorf("metal node %s is bound to DemoMachine %s being deleted, pass --force to release the metal node from under it", name, demoMachine.Name)
+		}
+	}
+
+	// the control plane endpoint, load balancer and etcd metal nodes of a DemoCluster are owned by its Cluster
+	if owner := clusterName(metalNode); owner != "" {
+		cluster := &clusterv1.Cluster{}
+		if err := o.client.Get(ctx, client.ObjectKey{Namespace: o.namespace, Name: owner}, cluster); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return errors.Wrapf(err, "failed to get cluster %s", owner)
+			}
+		} else if cluster.DeletionTimestamp.IsZero() {
+			return errors.Errorf("metal node %s is claimed by the DemoCluster of Cluster %s, it is released when the cluster is deleted", name, owner)
+		} else if !force {
+			return errors.Errorf("metal node %s is claimed by Cluster %s being deleted, pass --force to release the metal node from under it", name, owner)
+		}
+	}
+
+	if metalNode.Status.Bootstrapped && !force {
+		return errors.Errorf("metal node %s is bootstrapped and may still run Kubernetes, pass --force to release it anyway", name)
+	}
+
+	// a metal node that ran bootstrap data is cleaned and reinitialized before it is claimed again, as the controllers do
+	reprovision := metalNode.Status.Bootstrapped || metalNode.Status.DataSecretName != ""
+	_, bmc := metalNode.GetAnnotations()[infrav1.MetalNodeBMCAnnotation]
+	if dryRun {
+		for _, demoMachine := range demoMachines {
+			if _, ok := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]; ok {
+				fmt.Fprintf(o.out, "DemoMachine %s would be unbound from metal node %s\n", demoMachine.Name, name)
+			}
+		}
+		fmt.Fprintf(o.out, "metal node %s would be released\n", name)
+		if reprovision {
+			fmt.Fprintf(o.out, "metal node %s would be cleaned and reinitialized before it is claimed again\n", name)
+		} else if bmc {
+			fmt.Fprintf(o.out, "metal node %s would be powered off through its BMC by the controller until it is claimed again\n", name)
+		}
+		printMaintenance(o, metalNode)
+		return nil
+	}
+
+	// without the label the DemoMachines being deleted leave the released metal node alone, they are bound again
+	// if the metal node fails to be released
+	var unbound []*infrav1.DemoMachine
+	for i := range demoMachines {
+		demoMachine := &demoMachines[i]
+		if _, ok := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]; !ok {
+			continue
+		}
+		if err := setMetalNodeLabel(ctx, o.client, demoMachine, false, name); err != nil {
+			return kerrors.NewAggregate(append([]error{errors.Wrapf(err, "failed to unbind demo machine %s", demoMachine.Name)}, rebind(ctx, o.client, unbound, name)...))
+		}
+		unbound = append(unbound, demoMachine)
+	}
+	var owners []metav1.OwnerReference
+	for _, ref := range metalNode.GetOwnerReferences() {
+		if ref.Kind == "DemoMachine" || ref.Kind == "Cluster" {
+			owners = append(owners, ref)
+		}
+	}
+	if err := metalnode.Release(ctx, o.client, metalNode, reprovision, owners...); err != nil {
+		return kerrors.NewAggregate(append([]error{errors.Wrapf(err, "failed to release metal node %s", name)}, rebind(ctx, o.client, unbound, name)...))
+	}
+	for _, demoMachine := range unbound {
+		fmt.Fprintf(o.out, "DemoMachine %s unbound from metal node %s\n", demoMachine.Name, name)
+	}
+	fmt.Fprintf(o.out, "metal node %s released\n", name)
+	if reprovision {
+		fmt.Fprintf(o.out, "metal node %s is cleaned and reinitialized before it is claimed again\n", name)
+	} else if metalnode.PowerOffRequested(metalNode) {
+		fmt.Fprintf(o.out, "metal node %s is powered off through its BMC by the controller until it is claimed again\n", name)
+	}
+	printMaintenance(o, metalNode)
+	return nil
+}
+
+// printMaintenance tells when a released metal node in maintenance is claimed again
+func printMaintenance(o *options, metalNode *metav1beta1.MetalNode) {
+	if _, ok := metalNode.GetAnnotations()[infrav1.MetalNodeMaintenanceAnnotation]; ok {
+		fmt.Fprintf(o.out, "metal node %s is in maintenance, it is claimed again once the %s annotation is removed\n", metalNode.Name, infrav1.MetalNodeMaintenanceAnnotation)
+	}
+}
+
+// setMetalNodeLabel binds a DemoMachine to a metal node through its label, or unbinds it
+func setMetalNodeLabel(ctx context.Context, c client.Client, demoMachine *infrav1.DemoMachine, bind bool, name string) error {
+	patch := client.MergeFrom(demoMachine.DeepCopy())
+	labels := demoMachine.GetLabels()
+	if bind {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[infrav1.MetalNodeLabelName] = name
+	} else {
+		delete(labels, infrav1.MetalNodeLabelName)
+	}
+	demoMachine.SetLabels(labels)
+	return c.Patch(ctx, demoMachine, patch)
+}
+
+// rebind binds the DemoMachines unbound from a metal node that failed to be released again
+func rebind(ctx context.Context, c client.Client, demoMachines []*infrav1.DemoMachine, name string) []error {
+	var errs []error
+	for _, demoMachine := range demoMachines {
+		if err := setMetalNodeLabel(ctx, c, demoMachine, true, name); err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to bind demo machine %s again, set its %s label to %s", demoMachine.Name, infrav1.MetalNodeLabelName, name))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("refuses a metal node which is not claimed", func(t *testing.T) {
+		g := NewWithT(t)
+		o, _ := newOptions(newMetalNode("node", "10.0.0.1", nil))
+		g.Expect(release(ctx, o, "node", false, true)).To(MatchError("metal node node is not claimed"))
+	})
+
+	t.Run("refuses a metal node bound to a DemoMachine", func(t *testing.T) {
+		g := NewWithT(t)
+		_, demoMachine := newMachine("demo", "demo-0", "node")
+		o, _ := newOptions(newMetalNode("node", "10.0.0.1", claim("demo", "demo-0")), demoMachine)
+		g.Expect(release(ctx, o, "node", false, true)).To(MatchError("metal node node is bound to DemoMachine demo-0, delete its Machine to release the metal node"))
+	})
+
+	t.Run("refuses a metal node of a Cluster", func(t *testing.T) {
+		g := NewWithT(t)
+		cluster, _ := newCluster("demo")
+		metalNode := newMetalNode("node", "10.0.0.1", func(metalNode *metav1beta1.MetalNode) {
+			metalNode.Status.RefCluster = "demo"
+			metalNode.OwnerReferences = []metav1.OwnerReference{{APIVersion: "cluster.x-k8s.io/v1beta1", Kind: "Cluster", Name: "demo"}}
+		})
+		o, _ := newOptions(metalNode, cluster)
+		g.Expect(release(ctx, o, "node", false, true)).To(MatchError("metal node node is claimed by the DemoCluster of Cluster demo, it is released when the cluster is deleted"))
+	})
+
+	t.Run("releases a bootstrapped metal node only when forced", func(t *testing.T) {
+		g := NewWithT(t)
+		o, out := newOptions(newMetalNode("node", "10.0.0.1", claim("demo", "demo-0")))
+		g.Expect(release(ctx, o, "node", false, false)).To(MatchError("metal node node is bootstrapped and may still run Kubernetes, pass --force to release it anyway"))
+
+		g.Expect(release(ctx, o, "node", true, true)).To(Succeed())
+		g.Expect(out.String()).To(HavePrefix("metal node node would be released\n"))
+		metalNode := &metav1beta1.MetalNode{}
+		g.Expect(o.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "node"}, metalNode)).To(Succeed())
+		g.Expect(metalNodeState(metalNode)).To(Equal(bootstrappedState))
+
+		out.Reset()
+		g.Expect(release(ctx, o, "node", false, true)).To(Succeed())
+		g.Expect(out.String()).To(Equal("metal node node released\nmetal node node is cleaned and reinitialized before it is claimed again\n"))
+		g.Expect(o.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "node"}, metalNode)).To(Succeed())
+		g.Expect(metalNodeState(metalNode)).To(Equal(reprovisioningState))
+		g.Expect(metalNode.GetAnnotations()[infrav1.MetalNodeReprovisioningAnnotation]).To(ContainSubstring(string(infrav1.CleaningState)))
+		g.Expect(metalNode.Status).To(Equal(metav1beta1.MetalNodeStatus{Ready: true}))
+		g.Expect(metalNode.GetOwnerReferences()).To(BeEmpty())
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeStatusAnnotation))
+		g.Expect(metalNode.GetLabels()).NotTo(HaveKey(infrav1.ClusterctlMoveLabelName))
+	})
+
+	t.Run("unbinds the DemoMachine being deleted when forced", func(t *testing.T) {
+		g := NewWithT(t)
+		_, demoMachine := newMachine("demo", "demo-0", "node")
+		demoMachine.DeletionTimestamp = &metav1.Time{Time: metav1.Now().Time}
+		demoMachine.Finalizers = []string{infrav1.MachineFinalizer}
+		metalNode := newMetalNode("node", "10.0.0.1", claim("demo", "demo-0"))
+		metalNode.Status.Bootstrapped = false
+		o, out := newOptions(metalNode, demoMachine)
+		g.Expect(release(ctx, o, "node", false, false)).To(MatchError("metal node node is bound to DemoMachine demo-0 being deleted, pass --force to release the metal node from under it"))
+
+		g.Expect(release(ctx, o, "node", false, true)).To(Succeed())
+		g.Expect(out.String()).To(Equal("DemoMachine demo-0 unbound from metal node node\nmetal node node released\n"))
+		g.Expect(o.client.Get(ctx, client.ObjectKeyFromObject(demoMachine), demoMachine)).To(Succeed())
+		g.Expect(demoMachine.GetLabels()).NotTo(HaveKey(infrav1.MetalNodeLabelName))
+		metalNode = &metav1beta1.MetalNode{}
+		g.Expect(o.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "node"}, metalNode)).To(Succeed())
+		g.Expect(metalNodeState(metalNode)).To(Equal(freeState))
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeDemoMachineAnnotation))
+	})
+
+	t.Run("keeps a released metal node in maintenance", func(t *testing.T) {
+		g := NewWithT(t)
+		metalNode := newMetalNode("node", "10.0.0.1", claim("demo", "demo-0"))
+		metalNode.Status.Bootstrapped = false
+		metalNode.Annotations[infrav1.MetalNodeMaintenanceAnnotation] = string(infrav1.EvacuateMaintenanceMode)
+		metalNode.Annotations[infrav1.MetalNodeRerunBootstrapAnnotation] = "1"
+		o, out := newOptions(metalNode)
+
+		g.Expect(release(ctx, o, "node", false, false)).To(Succeed())
+		g.Expect(out.String()).To(Equal(fmt.Sprintf("metal node node released\nmetal node node is in maintenance, it is claimed again once the %s annotation is removed\n",
+			infrav1.MetalNodeMaintenanceAnnotation)))
+		g.Expect(o.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "node"}, metalNode)).To(Succeed())
+		g.Expect(metalNodeState(metalNode)).To(Equal(maintenanceState))
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeRerunBootstrapAnnotation))
+	})
+
+	t.Run("leaves the power-off of a released metal node with a BMC to the controller", func(t *testing.T) {
+		g := NewWithT(t)
+		metalNode := newMetalNode("node", "10.0.0.1", claim("demo", "demo-0"))
+		metalNode.Status.Bootstrapped = false
+		metalNode.Annotations[infrav1.MetalNodeBMCAnnotation] = `{"address":"redfish://10.0.1.1","credentialsName":"bmc"}`
+		o, out := newOptions(metalNode)
+
+		g.Expect(release(ctx, o, "node", true, false)).To(Succeed())
+		g.Expect(out.String()).To(Equal("metal node node would be released\nmetal node node would be powered off through its BMC by the controller until it is claimed again\n"))
+
+		out.Reset()
+		g.Expect(release(ctx, o, "node", false, false)).To(Succeed())
+		g.Expect(out.String()).To(Equal("metal node node released\nmetal node node is powered off through its BMC by the controller until it is claimed again\n"))
+		g.Expect(o.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "node"}, metalNode)).To(Succeed())
+		g.Expect(metalNode.GetAnnotations()).To(HaveKey(infrav1.MetalNodePowerOffAnnotation))
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodePoweredOffAnnotation))
+	})
+
+	t.Run("binds the DemoMachine again when the metal node fails to be released", func(t *testing.T) {
+		g := NewWithT(t)
+		_, demoMachine := newMachine("demo", "demo-0", "node")
+		demoMachine.DeletionTimestamp = &metav1.Time{Time: metav1.Now().Time}
+		demoMachine.Finalizers = []string{infrav1.MachineFinalizer}
+		metalNode := newMetalNode("node", "10.0.0.1", claim("demo", "demo-0"))
+		metalNode.Status.Bootstrapped = false
+		o, out := newOptions(metalNode, demoMachine)
+		o.client = failingStatusClient{o.client}
+
+		g.Expect(release(ctx, o, "node", false, true)).To(MatchError(ContainSubstring("failed to release metal node node")))
+		g.Expect(out.String()).To(BeEmpty())
+		g.Expect(o.client.Get(ctx, client.ObjectKeyFromObject(demoMachine), demoMachine)).To(Succeed())
+		g.Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, "node"))
+	})
+}
+
+// failingStatusClient fails to update the status of the objects
+type failingStatusClient struct {
+	client.Client
+}
+
+func (c failingStatusClient) Status() client.StatusWriter {
+	return failingStatusWriter{c.Client.Status()}
+}
+
+type failingStatusWriter struct {
+	client.StatusWriter
+}
+
+func (failingStatusWriter) Update(context.Context, client.Object, ...client.UpdateOption) error {
+	return errors.New("status update failed")
+}
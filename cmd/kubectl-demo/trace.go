@@ -0,0 +1,251 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// machineChain is a DemoMachine along with the objects it is chained to, nil for those not found
+type machineChain struct {
+	cluster     *clusterv1.Cluster
+	demoCluster *infrav1.DemoCluster
+	machine     *clusterv1.Machine
+	demoMachine *infrav1.DemoMachine
+	metalNode   *metav1beta1.MetalNode
+}
+
+// getMachineChain returns the chain of the Machine or DemoMachine named name
+func getMachineChain(ctx context.Context, c client.Client, namespace, name string) (*machineChain, error) {
+	chain := &machineChain{}
+	// get returns false if the object is not found
+	get := func(name string, obj client.Object) (bool, error) {
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	machine := &clusterv1.Machine{}
+	found, err := get(name, machine)
+	if err != nil {
+		return nil, err
+	}
+	demoMachineName := name
+	if found {
+		chain.machine = machine
+		if ref := machine.Spec.InfrastructureRef; ref.Kind != "DemoMachine" {
+			return nil, errors.Errorf("Machine %s is not a machine of the demo provider, its infrastructure is %s %s", name, ref.Kind, ref.Name)
+		}
+		demoMachineName = machine.Spec.InfrastructureRef.Name
+	}
+	demoMachine := &infrav1.DemoMachine{}
+	if found, err = get(demoMachineName, demoMachine); err != nil {
+		return nil, err
+	}
+	if found {
+		chain.demoMachine = demoMachine
+	} else if chain.machine == nil {
+		return nil, errors.Errorf("neither a Machine nor a DemoMachine named %s is found in namespace %s", name, namespace)
+	}
+
+	if chain.machine == nil {
+		for _, ref := range demoMachine.GetOwnerReferences() {
+			if ref.Kind == "Machine" {
+				machine := &clusterv1.Machine{}
+				if found, err = get(ref.Name, machine); err != nil {
+					return nil, err
+				}
+				if found {
+					chain.machine = machine
+				}
+			}
+		}
+	}
+
+	var clusterName string
+	if chain.machine != nil {
+		clusterName = chain.machine.Spec.ClusterName
+	} else {
+		clusterName = chain.demoMachine.GetLabels()[clusterv1.ClusterLabelName]
+	}
+	if clusterName != "" {
+		cluster := &clusterv1.Cluster{}
+		if found, err = get(clusterName, cluster); err != nil {
+			return nil, err
+		}
+		if found {
+			chain.cluster = cluster
+		}
+	}
+	if chain.cluster != nil && chain.cluster.Spec.InfrastructureRef != nil && chain.cluster.Spec.InfrastructureRef.Kind == "DemoCluster" {
+		demoCluster := &infrav1.DemoCluster{}
+		if found, err = get(chain.cluster.Spec.InfrastructureRef.Name, demoCluster); err != nil {
+			return nil, err
+		}
+		if found {
+			chain.demoCluster = demoCluster
+		}
+	}
+
+	if chain.demoMachine != nil {
+		if metalNodeName := chain.demoMachine.GetLabels()[infrav1.MetalNodeLabelName]; metalNodeName != "" {
+			metalNode := &metav1beta1.MetalNode{}
+			if found, err = get(metalNodeName, metalNode); err != nil {
+				return nil, err
+			}
+			if found {
+				chain.metalNode = metalNode
+			}
+		}
+	}
+	return chain, nil
+}
+
+func traceCommand() *command {
+	return &command{
+		name:  "trace",
+		use:   "trace machine NAME",
+		short: "Show the chain of a Machine or DemoMachine down to its MetalNode, along with their conditions.",
+		flags: pflag.NewFlagSet("trace", pflag.ContinueOnError),
+		run: func(ctx context.Context, o *options, args []string) error {
+			if len(args) != 2 || args[0] != "machine" {
+				return errors.New("trace takes the name of a Machine or DemoMachine, as trace machine NAME")
+			}
+			return trace(ctx, o, args[1])
+		},
+	}
+}
+
+// trace prints the chain of a Machine or DemoMachine and the conditions along it
+func trace(ctx context.Context, o *options, name string) error {
+	chain, err := getMachineChain(ctx, o.client, o.namespace, name)
+	if err != nil {
+		return err
+	}
+
+	w := newTabWriter(o.out)
+	fmt.Fprintln(w, "OBJECT\tDETAILS")
+	if cluster := chain.cluster; cluster != nil {
+		details := []string{
+			fmt.Sprintf("phase=%s", cluster.Status.Phase),
+			fmt.Sprintf("paused=%t", cluster.Spec.Paused),
+			fmt.Sprintf("infrastructureReady=%t", cluster.Status.InfrastructureReady),
+		}
+		if demoCluster := chain.demoCluster; demoCluster != nil {
+			details = append(details, fmt.Sprintf("priority=%d", demoCluster.Spec.Priority))
+			if demoCluster.Spec.PreemptionPolicy != "" {
+				details = append(details, fmt.Sprintf("preemptionPolicy=%s", demoCluster.Spec.PreemptionPolicy))
+			}
+		}
+		fmt.Fprintf(w, "Cluster/%s\t%s\n", cluster.Name, strings.Join(details, ", "))
+	}
+	if machine := chain.machine; machine != nil {
+		details := []string{fmt.Sprintf("phase=%s", machine.Status.Phase)}
+		if machine.Spec.Version != nil {
+			details = append(details, fmt.Sprintf("version=%s", *machine.Spec.Version))
+		}
+		for _, ref := range machine.GetOwnerReferences() {
+			if ref.Controller != nil && *ref.Controller {
+				details = append(details, fmt.Sprintf("owner=%s/%s", ref.Kind, ref.Name))
+			}
+		}
+		if preemptedBy, ok := machine.GetAnnotations()[infrav1.PreemptedByAnnotation]; ok {
+			details = append(details, fmt.Sprintf("preemptedBy=%s", preemptedBy))
+		}
+		if !machine.DeletionTimestamp.IsZero() {
+			details = append(details, "deleting")
+		}
+		fmt.Fprintf(w, "└─Machine/%s\t%s\n", machine.Name, strings.Join(details, ", "))
+	}
+	if demoMachine := chain.demoMachine; demoMachine != nil {
+		details := []string{
+			fmt.Sprintf("ready=%t", demoMachine.Status.Ready),
+			fmt.Sprintf("bootstrapped=%t", demoMachine.Status.Bootstrapped),
+		}
+		if queue := demoMachine.Status.Queue; queue != nil {
+			details = append(details, fmt.Sprintf("queuePosition=%d", queue.Position))
+		}
+		if !demoMachine.DeletionTimestamp.IsZero() {
+			details = append(details, "deleting")
+		}
+		fmt.Fprintf(w, "  └─DemoMachine/%s\t%s\n", demoMachine.Name, strings.Join(details, ", "))
+		if metalNodeName := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]; metalNodeName != "" && chain.metalNode == nil {
+			fmt.Fprintf(w, "    └─MetalNode/%s\tnot found\n", metalNodeName)
+		}
+	}
+	if metalNode := chain.metalNode; metalNode != nil {
+		details := []string{
+			fmt.Sprintf("host=%s", metalNode.Spec.NodeEndPoint.Host),
+			fmt.Sprintf("state=%s", metalNodeState(metalNode)),
+			fmt.Sprintf("ready=%t", metalNode.IsReady()),
+			fmt.Sprintf("bootstrapped=%t", metalNode.Status.Bootstrapped),
+			fmt.Sprintf("role=%s", orNone(metalNode.Status.Role...)),
+			fmt.Sprintf("refCluster=%s", orNone(metalNode.GetRefCluster())),
+		}
+		annotations := metalNode.GetAnnotations()
+		if version, ok := annotations[infrav1.MetalNodeKubernetesVersionAnnotation]; ok {
+			details = append(details, fmt.Sprintf("kubernetesVersion=%s", version))
+		}
+		if reason, ok := annotations[infrav1.MetalNodeMaintenanceAnnotation]; ok {
+			details = append(details, fmt.Sprintf("maintenance=%q", reason))
+		}
+		fmt.Fprintf(w, "    └─MetalNode/%s\t%s\n", metalNode.Name, strings.Join(details, ", "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(o.out)
+	w = newTabWriter(o.out)
+	fmt.Fprintln(w, "OBJECT\tCONDITION\tSTATUS\tSEVERITY\tREASON\tMESSAGE")
+	if chain.cluster != nil {
+		writeConditions(w, "Cluster/"+chain.cluster.Name, chain.cluster.Status.Conditions)
+	}
+	if chain.demoCluster != nil {
+		writeConditions(w, "DemoCluster/"+chain.demoCluster.Name, chain.demoCluster.Status.Conditions)
+	}
+	if chain.machine != nil {
+		writeConditions(w, "Machine/"+chain.machine.Name, chain.machine.Status.Conditions)
+	}
+	if chain.demoMachine != nil {
+		writeConditions(w, "DemoMachine/"+chain.demoMachine.Name, chain.demoMachine.Status.Conditions)
+	}
+	return w.Flush()
+}
+
+// writeConditions writes the conditions of an object as rows of a table
+func writeConditions(w io.Writer, object string, conditions clusterv1.Conditions) {
+	for _, condition := range conditions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", object, condition.Type, condition.Status, condition.Severity, condition.Reason, condition.Message)
+	}
+}
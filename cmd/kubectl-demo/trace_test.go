@@ -0,0 +1,51 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+)
+
+func TestTrace(t *testing.T) {
+	g := NewWithT(t)
+
+	cluster, demoCluster := newCluster("demo")
+	machine, demoMachine := newMachine("demo", "demo-0", "node")
+	demoMachine.Name = "demo-0-infra"
+	machine.Spec.InfrastructureRef.Name = "demo-0-infra"
+	demoMachine.Status.Conditions = clusterv1.Conditions{{Type: constants.MetalNodeReadyCondition, Status: corev1.ConditionTrue}}
+	o, out := newOptions(cluster, demoCluster, machine, demoMachine, newMetalNode("node", "10.0.0.1", claim("demo", "demo-0-infra")))
+
+	// the chain is found from the Machine as well as from the DemoMachine
+	for _, name := range []string{"demo-0", "demo-0-infra"} {
+		out.Reset()
+		g.Expect(trace(context.Background(), o, name)).To(Succeed())
+		g.Expect(out.String()).To(ContainSubstring("Cluster/demo "))
+		g.Expect(out.String()).To(ContainSubstring("└─Machine/demo-0 "))
+		g.Expect(out.String()).To(ContainSubstring("└─DemoMachine/demo-0-infra "))
+		g.Expect(out.String()).To(ContainSubstring("└─MetalNode/node "))
+		g.Expect(out.String()).To(ContainSubstring("host=10.0.0.1, state=bootstrapped"))
+		g.Expect(out.String()).To(MatchRegexp("DemoMachine/demo-0-infra +MetalNodeReady +True"))
+	}
+}
@@ -21,6 +21,27 @@ const (
 
 	// BootstrapSucceededCondition denotes the bootstrap succeeded
 	BootstrapSucceededCondition = "BootstrapSucceeded"
+
+	// LoadBalancerAvailableCondition denotes the metal node running the load balancer of the control plane is bootstrapped
+	LoadBalancerAvailableCondition = "LoadBalancerAvailable"
+
+	// VirtualIPInjectedCondition denotes keepalived holding the virtual IP of the control plane is injected into the control plane
+	VirtualIPInjectedCondition = "VirtualIPInjected"
+
+	// EtcdAvailableCondition denotes the metal nodes running the external etcd cluster of the control plane are bootstrapped
+	EtcdAvailableCondition = "EtcdAvailable"
+
+	// ControlPlaneEndpointHealthyCondition denotes the API server answers on the control plane endpoint, its message carries the probe latency
+	ControlPlaneEndpointHealthyCondition = "ControlPlaneEndpointHealthy"
+
+	// IPAddressClaimedCondition denotes the static address of a DemoMachine is allocated from its pool
+	IPAddressClaimedCondition = "IPAddressClaimed"
+
+	// MetalNodeCleanedCondition denotes a released metal node ran the cleanup bootstrap data
+	MetalNodeCleanedCondition = "MetalNodeCleaned"
+
+	// MetalNodeReinitializedCondition denotes a cleaned metal node is initialized again
+	MetalNodeReinitializedCondition = "MetalNodeReinitialized"
 )
 
 // condition reason constants
@@ -32,7 +53,8 @@ const (
 	// script to be ready before starting to create the container that provides the DockerMachine infrastructure.
 	WaitingForBootstrapDataReason = "WaitingForBootstrapData"
 
-	// BootstrapDataNotAvailableReason (Severity=Info) documents a DemoMachine waiting for the control plane
+	// BootstrapDataNotAvailableReason (Severity=Info or Error) documents a DemoMachine whose bootstrap data secret is missing,
+	// or holds bootstrap data of an unsupported format or failing validation
 	BootstrapDataNotAvailableReason = "BootstrapDataNotAvailable"
 
 	// DeletingReason (Severity=Info) documents a condition not in Status=True because the underlying object it is currently being deleted.
@@ -43,4 +65,69 @@ const (
 
 	//WaitingForMetalNodeBootstrapReason (Severity=Info) documents a DemoMachine waiting for the metal node bootstrap
 	WaitingForMetalNodeBootstrapReason = "WaitingForMetalNodeBootstrap"
+
+	// UnsupportedControlPlaneReason (Severity=Warning) documents a DemoCluster with a virtual IP whose control plane is not a KubeadmControlPlane
+	UnsupportedControlPlaneReason = "UnsupportedControlPlane"
+
+	// WaitingForControlPlaneReason (Severity=Info) documents a DemoCluster waiting for its KubeadmControlPlane to be created,
+	// or for its control plane to be initialized before probing the control plane endpoint
+	WaitingForControlPlaneReason = "WaitingForControlPlane"
+
+	// InvalidConfigurationReason (Severity=Error) documents a DemoCluster with conflicting control plane endpoint options
+	InvalidConfigurationReason = "InvalidConfiguration"
+
+	// EndpointUnreachableReason (Severity=Warning) documents a control plane endpoint refusing or timing out connections
+	EndpointUnreachableReason = "EndpointUnreachable"
+
+	// APIServerNotReadyReason (Severity=Warning) documents an API server behind the control plane endpoint failing its /readyz check
+	APIServerNotReadyReason = "APIServerNotReady"
+
+	// EndpointMetalNodeNotReadyReason (Severity=Warning) documents the metal node hosting the control plane endpoint no longer ready
+	EndpointMetalNodeNotReadyReason = "EndpointMetalNodeNotReady"
+
+	// WaitingForIPAddressReason (Severity=Info) documents a DemoMachine waiting for the pool to allocate its static address
+	WaitingForIPAddressReason = "WaitingForIPAddress"
+
+	// WaitingForMetalNodeCleanupReason (Severity=Info) documents a released metal node running the cleanup bootstrap data
+	WaitingForMetalNodeCleanupReason = "WaitingForMetalNodeCleanup"
+
+	// ReprovisioningTimedOutReason (Severity=Error) documents a released metal node whose reprovisioning step timed out
+	ReprovisioningTimedOutReason = "ReprovisioningTimedOut"
+
+	// MetalNodePowerCycledReason (Severity=Warning) documents a DemoMachine whose metal node did not run the bootstrap data in time,
+	// and was power-cycled through its BMC
+	MetalNodePowerCycledReason = "MetalNodePowerCycled"
+
+	// MetalNodeEvacuatedReason (Severity=Warning) documents a DemoMachine whose metal node is evacuated for maintenance
+	MetalNodeEvacuatedReason = "MetalNodeEvacuated"
+
+	// QuotaExceededReason (Severity=Warning) documents a DemoMachine that can not claim a free metal node within the
+	// MetalNodeQuotas of its namespace
+	QuotaExceededReason = "QuotaExceeded"
+
+	// PreemptingReason (Severity=Info) documents a DemoMachine waiting for the metal node of the Machine of a lower
+	// priority cluster it preempted
+	PreemptingReason = "Preempting"
+
+	// MetalNodeClaimedReason (Severity=Error) documents a bootstrapped DemoMachine whose metal node is claimed by another DemoMachine
+	MetalNodeClaimedReason = "MetalNodeClaimed"
+
+	// WaitingInQueueReason (Severity=Info) documents a DemoMachine leaving the free metal nodes to the machines ahead of it
+	// in the provisioning queue of its namespace
+	WaitingInQueueReason = "WaitingInQueue"
+)
+
+// The reasons of the events recorded for the preemption decisions of the DemoMachines, along with PreemptingReason
+const (
+	// PreemptedReason is recorded on a Machine preempted by a DemoMachine of a higher priority cluster
+	PreemptedReason = "Preempted"
+
+	// PreemptionDryRunReason is recorded on a DemoMachine of a DryRun cluster with the Machine it would preempt
+	PreemptionDryRunReason = "PreemptionDryRun"
+
+	// NoPreemptionCandidateReason is recorded on a DemoMachine finding no Machine of a lower priority cluster to preempt
+	NoPreemptionCandidateReason = "NoPreemptionCandidate"
+
+	// PreemptionFailedReason is recorded on a DemoMachine failing to preempt a Machine
+	PreemptionFailedReason = "PreemptionFailed"
 )
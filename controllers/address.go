@@ -0,0 +1,165 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"net"
+
+	"github.com/pkg/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// apiServerPort returns the port the API server of the control plane machines of the demoCluster binds to
+func apiServerPort(demoCluster *infrav1.DemoCluster) int32 {
+	if demoCluster.Spec.APIServerPort != 0 {
+		return demoCluster.Spec.APIServerPort
+	}
+	return infrav1.DefaultAPIServerPort
+}
+
+// endpointAddress returns the address of a metal node supplying the control plane endpoint, picked from the network
+// selected by the demoCluster, in the IP family it selects or else preferably in the primary IP family of the cluster
+func endpointAddress(metalNode *metav1beta1.MetalNode, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (string, error) {
+	selector := demoCluster.Spec.EndpointAddress
+	if selector == nil {
+		selector = &infrav1.DemoEndpointAddressSpec{}
+	}
+
+	candidates := []string{metalNode.Spec.NodeEndPoint.Host}
+	if selector.Network != "" {
+		networks, err := getMetalNodeNetworks(metalNode)
+		if err != nil {
+			return "", err
+		}
+		candidates = nil
+		for _, network := range networks {
+			if network.Name == selector.Network {
+				candidates = network.Addresses
+			}
+		}
+		if len(candidates) == 0 {
+			return "", errors.Errorf("metal node %s has no address on network %s", metalNode.Name, selector.Network)
+		}
+	}
+
+	if selector.IPFamily != "" {
+		for _, address := range candidates {
+			if ipFamily(address) == selector.IPFamily {
+				return address, nil
+			}
+		}
+		return "", errors.Errorf("metal node %s has no %s address", metalNode.Name, selector.IPFamily)
+	}
+	if family := primaryIPFamily(cluster); family != "" {
+		for _, address := range candidates {
+			if ipFamily(address) == family {
+				return address, nil
+			}
+		}
+	}
+	return candidates[0], nil
+}
+
+// metalNodeAddresses returns the machine addresses of a metal node: its name as the host name, then the addresses and
+// DNS names on each of its networks typed after the role of the network, and its static address as an InternalIP.
+// The host the metal node is reached at is an ExternalIP unless listed before, as for metal nodes without networks.
+func metalNodeAddresses(metalNode *metav1beta1.MetalNode) ([]clusterv1.MachineAddress, error) {
+	addresses := []clusterv1.MachineAddress{{Type: clusterv1.MachineHostName, Address: metalNode.Name}}
+	networks, err := getMetalNodeNetworks(metalNode)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	add := func(addressType clusterv1.MachineAddressType, address string) {
+		if seen[address] {
+			return
+		}
+		seen[address] = true
+		addresses = append(addresses, clusterv1.MachineAddress{Type: addressType, Address: address})
+	}
+	for _, network := range networks {
+		ipType, dnsType := clusterv1.MachineInternalIP, clusterv1.MachineInternalDNS
+		if network.Role == infrav1.ExternalNetworkRole {
+			ipType, dnsType = clusterv1.MachineExternalIP, clusterv1.MachineExternalDNS
+		}
+		for _, address := range network.Addresses {
+			add(ipType, address)
+		}
+		for _, name := range network.DNSNames {
+			add(dnsType, name)
+		}
+	}
+	if data, ok := metalNode.GetAnnotations()[infrav1.MetalNodeStaticAddressAnnotation]; ok {
+		staticAddress := infrav1.MetalNodeStaticAddress{}
+		if err := json.Unmarshal([]byte(data), &staticAddress); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the static address of metal node %s", metalNode.Name)
+		}
+		add(clusterv1.MachineInternalIP, staticAddress.Address)
+	}
+	add(clusterv1.MachineExternalIP, metalNode.Spec.NodeEndPoint.Host)
+	return addresses, nil
+}
+
+// getMetalNodeNetworks returns the networks listed in the annotation of a metal node
+func getMetalNodeNetworks(metalNode *metav1beta1.MetalNode) ([]infrav1.MetalNodeNetwork, error) {
+	data, ok := metalNode.GetAnnotations()[infrav1.MetalNodeNetworksAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var networks []infrav1.MetalNodeNetwork
+	if err := json.Unmarshal([]byte(data), &networks); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the networks of metal node %s", metalNode.Name)
+	}
+	return networks, nil
+}
+
+// ipFamily returns the family of an IP address, or an empty family if it is a host name
+func ipFamily(address string) infrav1.IPFamily {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return infrav1.IPv4Family
+	default:
+		return infrav1.IPv6Family
+	}
+}
+
+// primaryIPFamily returns the family of the first service CIDR of the cluster, or else of its first pod CIDR
+func primaryIPFamily(cluster *clusterv1.Cluster) infrav1.IPFamily {
+	clusterNetwork := cluster.Spec.ClusterNetwork
+	if clusterNetwork == nil {
+		return ""
+	}
+	for _, blocks := range []*clusterv1.NetworkRanges{clusterNetwork.Services, clusterNetwork.Pods} {
+		if blocks == nil || len(blocks.CIDRBlocks) == 0 {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(blocks.CIDRBlocks[0])
+		if err != nil {
+			return ""
+		}
+		return ipFamily(ip.String())
+	}
+	return ""
+}
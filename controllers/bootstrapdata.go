@@ -0,0 +1,187 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/bootstrap"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+)
+
+// reconcileBootstrapData validates the bootstrap data of the machine and returns the name of the secret the metal node
+// runs: a short-lived secret owned by the demoMachine, holding the bootstrap data run as cloud-config and merged with
+// the bootstrap extensions of the demoCluster, or the Ignition config as is, so that the metal node agent never reads the secret of the
+// bootstrap provider. The name is empty when the bootstrap data is not available, the BootstrapDataAvailable condition
+// tells why.
+func (r *DemoMachineReconciler) reconcileBootstrapData(ctx context.Context, machine *clusterv1.Machine, cluster *clusterv1.Cluster, demoMachine *infrav1.DemoMachine, demoCluster *infrav1.DemoCluster) (string, error) {
+	if machine.Namespace != cluster.Namespace {
+		conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+			"the Machine is not in the namespace %s of its cluster", cluster.Namespace)
+		return "", nil
+	}
+	key := client.ObjectKey{Namespace: cluster.Namespace, Name: *machine.Spec.Bootstrap.DataSecretName}
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityInfo,
+				"bootstrap data secret %s is not found", key.Name)
+			return "", nil
+		}
+		return "", err
+	}
+	// the bootstrap data of a machine without a bootstrap config is provided by the user, otherwise it must be written by the bootstrap config
+	if configRef := machine.Spec.Bootstrap.ConfigRef; configRef != nil &&
+		!util.HasOwnerRef(secret.GetOwnerReferences(), metav1.OwnerReference{APIVersion: configRef.APIVersion, Kind: configRef.Kind, Name: configRef.Name}) {
+		conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+			"bootstrap data secret %s is not owned by the bootstrap config %s %s of the Machine", key.Name, configRef.Kind, configRef.Name)
+		return "", nil
+	}
+
+	value, ok := secret.Data[bootstrap.ValueKey]
+	if !ok {
+		conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+			"bootstrap data secret %s has no %s key", key.Name, bootstrap.ValueKey)
+		return "", nil
+	}
+	format, err := bootstrap.ParseFormat(string(secret.Data[bootstrap.FormatKey]))
+	if err != nil {
+		conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+			"bootstrap data secret %s: %v", key.Name, err)
+		return "", nil
+	}
+	agentFormat, data, err := bootstrap.AgentData(format, value)
+	if err != nil {
+		conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+			"bootstrap data secret %s: %v", key.Name, err)
+		return "", nil
+	}
+	// the bootstrap extensions are cloud-config, they can not be merged into an Ignition config
+	if agentFormat != bootstrap.CloudConfig && len(demoCluster.Spec.BootstrapExtensions) > 0 {
+		conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+			"bootstrap data secret %s: the bootstrap extensions of the cluster can not be merged into %s bootstrap data", key.Name, format)
+		return "", nil
+	}
+	// the extensions are merged first so that the bootstrap data wins
+	configs := make([][]byte, 0, len(demoCluster.Spec.BootstrapExtensions)+1)
+	for _, extension := range demoCluster.Spec.BootstrapExtensions {
+		extensionKey := extension.Key
+		if extensionKey == "" {
+			extensionKey = bootstrap.ValueKey
+		}
+		values, err := r.getBootstrapExtension(ctx, demoCluster.Namespace, extension)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityInfo,
+					"bootstrap extension %s %s is not found", extension.Kind, extension.Name)
+				return "", nil
+			}
+			return "", err
+		}
+		config, ok := values[extensionKey]
+		if !ok {
+			conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+				"bootstrap extension %s %s has no %s key", extension.Kind, extension.Name, extensionKey)
+			return "", nil
+		}
+		if _, _, err := bootstrap.AgentData(bootstrap.CloudConfig, config); err != nil {
+			conditions.MarkFalse(demoMachine, constants.BootstrapDataAvailableCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityError,
+				"bootstrap extension %s %s: %v", extension.Kind, extension.Name, err)
+			return "", nil
+		}
+		configs = append(configs, config)
+	}
+	if len(configs) > 0 {
+		if data, err = bootstrap.MergeCloudConfigs(append(configs, data)...); err != nil {
+			return "", errors.Wrap(err, "failed to merge the bootstrap extensions")
+		}
+	}
+
+	derived := &corev1.Secret{}
+	derived.Name = cloudConfigSecretName(demoMachine)
+	derived.Namespace = demoMachine.Namespace
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, derived, func() error {
+		derived.Labels = map[string]string{clusterv1.ClusterLabelName: cluster.Name}
+		derived.Type = clusterv1.ClusterSecretType
+		derived.Data = map[string][]byte{
+			bootstrap.ValueKey:  data,
+			bootstrap.FormatKey: []byte(agentFormat),
+		}
+		return controllerutil.SetControllerReference(demoMachine, derived, r.Client.Scheme())
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to write the metal node bootstrap data of the %s bootstrap data", format)
+	}
+	conditions.MarkTrue(demoMachine, constants.BootstrapDataAvailableCondition)
+	return derived.Name, nil
+}
+
+// getBootstrapExtension returns the data of the ConfigMap or Secret holding a bootstrap extension
+func (r *DemoMachineReconciler) getBootstrapExtension(ctx context.Context, namespace string, extension infrav1.DemoBootstrapExtension) (map[string][]byte, error) {
+	key := client.ObjectKey{Namespace: namespace, Name: extension.Name}
+	switch extension.Kind {
+	case "ConfigMap":
+		configMap := &corev1.ConfigMap{}
+		if err := r.Client.Get(ctx, key, configMap); err != nil {
+			return nil, err
+		}
+		values := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+		for k, v := range configMap.BinaryData {
+			values[k] = v
+		}
+		for k, v := range configMap.Data {
+			values[k] = []byte(v)
+		}
+		return values, nil
+	case "Secret":
+		secret := &corev1.Secret{}
+		if err := r.Client.Get(ctx, key, secret); err != nil {
+			return nil, err
+		}
+		return secret.Data, nil
+	default:
+		return nil, errors.Errorf("unsupported bootstrap extension kind %s", extension.Kind)
+	}
+}
+
+// deleteCloudConfigSecret deletes the cloud-config of a demoMachine once its metal node is bootstrapped, it holds the join token
+// of the machine
+func (r *DemoMachineReconciler) deleteCloudConfigSecret(ctx context.Context, demoMachine *infrav1.DemoMachine) error {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: demoMachine.Namespace, Name: cloudConfigSecretName(demoMachine)}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return client.IgnoreNotFound(r.Client.Delete(ctx, secret))
+}
+
+// cloudConfigSecretName returns the name of the secret holding the cloud-config the metal node of a demoMachine runs
+func cloudConfigSecretName(demoMachine *infrav1.DemoMachine) string {
+	return fmt.Sprintf("%s-cloud-config", demoMachine.Name)
+}
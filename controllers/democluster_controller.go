@@ -18,21 +18,32 @@ package controllers
 
 import (
 	"context"
-	"sigs.k8s.io/cluster-api/util/conditions"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
 	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/cluster-api/util/predicates"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
 	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
 	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/feature"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
 	"github.com/git-czy/cluster-api-provider-demo/utils/log"
 )
 
@@ -40,13 +51,26 @@ import (
 type DemoClusterReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// WatchFilterValue is the label value used to filter events and metal nodes prior to reconciliation.
+	WatchFilterValue string
+
+	// EndpointProbeInterval is the interval the control plane endpoint of a ready DemoCluster is probed at,
+	// defaults to DefaultEndpointProbeInterval.
+	EndpointProbeInterval time.Duration
+
+	endpointProber *endpointProber
 }
 
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=democlusters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=democlusters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=democlusters/finalizers,verbs=get;update;patch
-//+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes,verbs=get;list
+//+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;machines,verbs=get;list;watch
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demomachines,verbs=get;list;watch
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=kubeadmcontrolplanes,verbs=get;list;watch;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -106,7 +130,7 @@ func (r *DemoClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	// todo 5 Handle deleted clusters
 	if !demoCluster.DeletionTimestamp.IsZero() {
-		return r.reconcileDelete(ctx, demoCluster)
+		return r.reconcileDelete(ctx, demoCluster, cluster)
 	}
 
 	// todo 6 Handle non-deleted clusters
@@ -114,14 +138,119 @@ func (r *DemoClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *DemoClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+func (r *DemoClusterReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	r.endpointProber = newEndpointProber()
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&infrav1.DemoCluster{}).
+		WithOptions(options).
+		WithEventFilter(predicates.ResourceNotPausedAndHasFilterLabel(mgr.GetLogger(), r.WatchFilterValue)).
+		Watches(
+			&source.Kind{Type: &metav1beta1.MetalNode{}},
+			handler.EnqueueRequestsFromMapFunc(r.MetalNodeToDemoClusters),
+		).
+		// resume the demoCluster when its cluster is unpaused, e.g. at the end of a clusterctl move
+		Watches(
+			&source.Kind{Type: &clusterv1.Cluster{}},
+			handler.EnqueueRequestsFromMapFunc(util.ClusterToInfrastructureMapFunc(infrav1.GroupVersion.WithKind("DemoCluster"))),
+			builder.WithPredicates(predicates.ClusterUnpaused(mgr.GetLogger())),
+		).
+		// report the progress of the upgrades as the machines are replaced
+		Watches(
+			&source.Kind{Type: &clusterv1.Machine{}},
+			handler.EnqueueRequestsFromMapFunc(r.MachineToDemoCluster),
+		).
+		Watches(
+			&source.Kind{Type: &infrav1.DemoMachine{}},
+			handler.EnqueueRequestsFromMapFunc(r.MachineToDemoCluster),
+		).
+		// record the probes of the control plane endpoints run in the background
+		Watches(
+			&source.Channel{Source: r.endpointProber.events},
+			&handler.EnqueueRequestForObject{},
+		).
 		Complete(r)
 }
 
+// MetalNodeToDemoClusters is a handler.MapFunc to be used to enqueue requests for reconciliation
+// of the DemoClusters still waiting for a control plane endpoint when a free metal node shows up,
+// and of the DemoClusters with a load balancer or an external etcd cluster when their metal nodes change.
+func (r *DemoClusterReconciler) MetalNodeToDemoClusters(o client.Object) []reconcile.Request {
+	metalNode, ok := o.(*metav1beta1.MetalNode)
+	if !ok {
+		log.Errorf("expected a MetalNode but got a %T", o)
+		return nil
+	}
+	if metalNodeStatusLost(metalNode) {
+		return nil
+	}
+	free := metalNode.GetRefCluster() == "" && metalNodeClaimedBy(metalNode) == "" && !metalNodeCordoned(metalNode)
+
+	demoClusterList := &infrav1.DemoClusterList{}
+	if err := r.Client.List(context.TODO(), demoClusterList, watchFilterListOptions(metalNode.Namespace, r.WatchFilterValue)...); err != nil {
+		log.WithError(err).Errorf("failed to list demoClusters in namespace %s", metalNode.Namespace)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, demoCluster := range demoClusterList.Items {
+		waiting := free && !demoCluster.Status.Ready
+		followed := (demoCluster.Spec.LoadBalancer != nil || demoCluster.Spec.Etcd != nil) &&
+			(free || metalNodeRefersTo(metalNode, ownerClusterName(&demoCluster), demoCluster.Name))
+		if waiting || followed {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&demoCluster)})
+		}
+	}
+	return requests
+}
+
+// ownerClusterName returns the name of the Cluster owning a demoCluster, empty until the Cluster sets its owner reference
+func ownerClusterName(demoCluster *infrav1.DemoCluster) string {
+	for _, ref := range demoCluster.GetOwnerReferences() {
+		if ref.Kind == "Cluster" && strings.HasPrefix(ref.APIVersion, clusterv1.GroupVersion.Group+"/") {
+			return ref.Name
+		}
+	}
+	return ""
+}
+
 // reconcileDelete reconcile demoCluster delete
-func (r *DemoClusterReconciler) reconcileDelete(ctx context.Context, demoCluster *infrav1.DemoCluster) (ctrl.Result, error) {
+func (r *DemoClusterReconciler) reconcileDelete(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (ctrl.Result, error) {
+	r.endpointProber.forget(demoCluster)
+
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := r.Client.List(ctx, metalNodeList, watchFilterListOptions(demoCluster.Namespace, r.WatchFilterValue)...); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// release the metal nodes of the control plane endpoint, of the load balancer and of etcd, the machines already released theirs,
+	// and drop the cluster owner reference before the garbage collector deletes the metal node along with the cluster.
+	// The ones that ran the bootstrap data of the load balancer or of etcd are reprovisioned, so that the etcd member or
+	// HAProxy is torn down before the metal node is claimed again.
+	ownerRef := clusterOwnerRef(cluster)
+	for i := range metalNodeList.Items {
+		metalNode := &metalNodeList.Items[i]
+		if !util.HasOwnerRef(metalNode.GetOwnerReferences(), ownerRef) {
+			continue
+		}
+		if _, err := restoreMetalNodeStatus(metalNode); err != nil {
+			return ctrl.Result{}, err
+		}
+		metalNode.SetOwnerReferences(util.RemoveOwnerRef(metalNode.GetOwnerReferences(), ownerRef))
+		if metalNodeRefersTo(metalNode, cluster.Name, demoCluster.Name) {
+			if metalNode.Status.DataSecretName != "" {
+				if err := metalnode.StartReprovisioning(metalNode); err != nil {
+					return ctrl.Result{}, err
+				}
+			} else {
+				metalNode.ResetMetalNode()
+			}
+		}
+		if err := metalnode.Update(ctx, r.Client, metalNode); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Infof("released metal node %s of cluster %s", metalNode.Name, cluster.Name)
+	}
+
 	// Cluster is deleted so remove the finalizer.
 	controllerutil.RemoveFinalizer(demoCluster, infrav1.ClusterFinalizer)
 
@@ -130,23 +259,65 @@ func (r *DemoClusterReconciler) reconcileDelete(ctx context.Context, demoCluster
 
 // reconcileNormal reconcile demoCluster normal
 func (r *DemoClusterReconciler) reconcileNormal(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (ctrl.Result, error) {
-	// todo reconcile normal logic here
+	result, err := r.reconcileControlPlaneEndpoint(ctx, demoCluster, cluster)
+	if err != nil || !demoCluster.Status.Ready {
+		return result, err
+	}
 
-	if demoCluster.Status.Ready {
+	if err := r.reconcileUpgrade(ctx, demoCluster, cluster); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to report the progress of the upgrade")
+	}
+
+	// keep an eye on the endpoint once it serves the control plane
+	healthResult, err := r.reconcileEndpointHealth(ctx, demoCluster, cluster)
+	return util.LowestNonZeroResult(result, healthResult), err
+}
+
+// reconcileControlPlaneEndpoint sets the control plane endpoint along the topology of the demoCluster,
+// and marks the demoCluster ready once it is
+func (r *DemoClusterReconciler) reconcileControlPlaneEndpoint(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (ctrl.Result, error) {
+
+	if demoCluster.Spec.LoadBalancer != nil && demoCluster.Spec.VirtualIP != nil {
+		conditions.MarkFalse(demoCluster, constants.ControlPlaneEndPointSetCondition, constants.InvalidConfigurationReason, clusterv1.ConditionSeverityError,
+			"loadBalancer and virtualIP can't be used together")
+		log.Errorf("demoCluster %s sets both loadBalancer and virtualIP", demoCluster.Name)
 		return ctrl.Result{}, nil
 	}
 
-	log.Info("preparing load balancer")
-	// todo 这里一般回来检查 demoCluster是否配置好,按照docker provider的做法 应该创建一个load_balancer
-	// todo 事实上从官方搭建高可用文档的案列来看 也是这么做的
-	// todo 如果不是高可用部署，那么无需安装负载均衡器，还未找到查看是否是高可用部署的方法，搁置
+	// the control plane machines initialize against the external etcd cluster, so it comes first
+	if demoCluster.Spec.Etcd != nil {
+		available, err := r.reconcileEtcd(ctx, demoCluster, cluster)
+		if err != nil || (!available && !demoCluster.Status.Ready) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// the load balancer follows the control plane machines, even once the demoCluster is ready
+	if demoCluster.Spec.LoadBalancer != nil {
+		return r.reconcileLoadBalancer(ctx, demoCluster, cluster)
+	}
+
+	// keepalived follows the virtual IP settings, even once the demoCluster is ready
+	if demoCluster.Spec.VirtualIP != nil {
+		return r.reconcileVirtualIP(ctx, demoCluster, cluster)
+	}
+
+	if demoCluster.Status.Ready {
+		return ctrl.Result{}, nil
+	}
 
-	// todo 应该在此处为demoCluster设置ControlPlaneEndpoint，在docker-provider中设置的是负载均衡器的 地址
-	// todo 如果不使用 docker 容器安装负载均衡器或者不使用高可用安装集群，那么应该直接使用裸机的IP，port为6443
+	// a highly available control plane uses the endpoint provided by the user (e.g. an external load balancer),
+	// so there is no need to pick a metal node for it
+	if feature.Gates.Enabled(feature.HAControlPlaneEndpoint) && demoCluster.Spec.ControlPlaneEndpoint.IsValid() {
+		log.Infof("using the provided control plane endpoint %s", demoCluster.Spec.ControlPlaneEndpoint.String())
+		demoCluster.Status.Ready = true
+		conditions.MarkTrue(demoCluster, constants.ControlPlaneEndPointSetCondition)
+		return ctrl.Result{}, nil
+	}
 
-	// 目前先不考虑高可用部署，从所有metalnode中选择一个作为controlplane使用其 ip：6443设置为controlplane endpoint
+	// 未开启负载均衡器（spec.loadBalancer）时，从所有metalnode中选择一个作为controlplane使用其 ip：6443设置为controlplane endpoint
 	metalNodeList := &metav1beta1.MetalNodeList{}
-	if err := r.Client.List(ctx, metalNodeList, client.InNamespace(demoCluster.Namespace)); err != nil {
+	if err := r.Client.List(ctx, metalNodeList, watchFilterListOptions(demoCluster.Namespace, r.WatchFilterValue)...); err != nil {
 		conditions.MarkFalse(demoCluster, constants.ControlPlaneEndPointSetCondition, constants.NoMetalNodeFoundReason, clusterv1.ConditionSeverityWarning, err.Error())
 		if apierrors.IsNotFound(err) {
 			log.Info("no metalnode found")
@@ -155,11 +326,45 @@ func (r *DemoClusterReconciler) reconcileNormal(ctx context.Context, demoCluster
 		return ctrl.Result{}, err
 	}
 
+	// a demoCluster resumed after a clusterctl move lost its status but kept its endpoint,
+	// which is set on the metal node owned by the cluster
+	if demoCluster.Spec.ControlPlaneEndpoint.IsValid() {
+		for i := range metalNodeList.Items {
+			metalNode := &metalNodeList.Items[i]
+			if address, err := endpointAddress(metalNode, demoCluster, cluster); err != nil || address != demoCluster.Spec.ControlPlaneEndpoint.Host ||
+				!util.HasOwnerRef(metalNode.GetOwnerReferences(), clusterOwnerRef(cluster)) {
+				continue
+			}
+			restored, err := restoreMetalNodeStatus(metalNode)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if restored {
+				if err := metalnode.Update(ctx, r.Client, metalNode); err != nil {
+					return ctrl.Result{}, err
+				}
+				log.Infof("restored the status of metal node %s", metalNode.Name)
+			}
+			demoCluster.Status.Ready = true
+			conditions.MarkTrue(demoCluster, constants.ControlPlaneEndPointSetCondition)
+			return ctrl.Result{}, nil
+		}
+	}
+
 	var controlPlaneNode *metav1beta1.MetalNode
-	for _, metalNode := range metalNodeList.Items {
-		if !metalNode.HasRole(constants.ControlPlaneNodeRoleValue) && !metalNode.HasRole(constants.WorkerNodeRoleValue) {
-			controlPlaneNode = &metalNode
+	var controlPlaneAddress string
+	for i := range metalNodeList.Items {
+		metalNode := &metalNodeList.Items[i]
+		if metalNode.HasRole(constants.ControlPlaneNodeRoleValue) || metalNode.HasRole(constants.WorkerNodeRoleValue) ||
+			metalNode.GetRefCluster() != "" || metalNodeClaimedBy(metalNode) != "" || metalNodeStatusLost(metalNode) || metalNodeReprovisioning(metalNode) || metalNodeCordoned(metalNode) {
+			continue
+		}
+		address, err := endpointAddress(metalNode, demoCluster, cluster)
+		if err != nil {
+			log.Infof("metal node %s can not supply the control plane endpoint: %v", metalNode.Name, err)
+			continue
 		}
+		controlPlaneNode, controlPlaneAddress = metalNode, address
 	}
 
 	// todo 测试先直接指定metalNode了，当前目的是部署一个单master单worker的集群
@@ -183,24 +388,21 @@ func (r *DemoClusterReconciler) reconcileNormal(ctx context.Context, demoCluster
 
 	// set demoCluster controlPlaneEndpoint
 	demoCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{
-		Host: controlPlaneNode.Spec.NodeEndPoint.Host,
-		Port: 6443,
+		Host: controlPlaneAddress,
+		Port: apiServerPort(demoCluster),
 	}
 
-	// set controlPlaneNode Role and RefCluster
+	// set controlPlaneNode Role and RefCluster, and the cluster owner reference clusterctl moves it with
 	controlPlaneNode.SetRole(constants.ControlPlaneNodeRoleValue)
 	controlPlaneNode.Status.RefCluster = cluster.Name
-	if err := r.Status().Update(ctx, controlPlaneNode); err != nil {
+	controlPlaneNode.SetOwnerReferences(util.EnsureOwnerRef(controlPlaneNode.GetOwnerReferences(), clusterOwnerRef(cluster)))
+	if err := metalnode.Update(ctx, r.Client, controlPlaneNode); err != nil {
 		return ctrl.Result{}, err
 	}
 
 	// Mark the demoCluster ready
 	demoCluster.Status.Ready = true
 
-	if err := controllerutil.SetOwnerReference(cluster, controlPlaneNode, r.Client.Scheme()); err != nil {
-		return ctrl.Result{}, err
-	}
-
 	conditions.MarkTrue(demoCluster, constants.ControlPlaneEndPointSetCondition)
 
 	return ctrl.Result{}, nil
@@ -214,5 +416,9 @@ func patchDemoCluster(ctx context.Context, patchHelper *patch.Helper, demoCluste
 		patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
 			clusterv1.ReadyCondition,
 			constants.ControlPlaneEndPointSetCondition,
+			constants.LoadBalancerAvailableCondition,
+			constants.VirtualIPInjectedCondition,
+			constants.EtcdAvailableCondition,
+			constants.ControlPlaneEndpointHealthyCondition,
 		}})
 }
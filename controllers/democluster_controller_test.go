@@ -0,0 +1,502 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/etcd"
+	"github.com/git-czy/cluster-api-provider-demo/loadbalancer"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+)
+
+var _ = Describe("DemoClusterReconciler", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+	})
+
+	It("claims a free metal node as the control plane endpoint", func() {
+		metalNode := createMetalNode(ctx, namespace, "10.0.0.1", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace)
+
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoCluster.Spec.ControlPlaneEndpoint).To(Equal(clusterv1.APIEndpoint{Host: "10.0.0.1", Port: 6443}))
+		Expect(conditions.IsTrue(demoCluster, constants.ControlPlaneEndPointSetCondition)).To(BeTrue())
+
+		Expect(get(ctx, metalNode)()).To(Succeed())
+		Expect(metalNode.ContainRole(constants.ControlPlaneNodeRoleValue)).To(BeTrue())
+		Expect(metalNode.GetRefCluster()).To(Equal(cluster.Name))
+	})
+
+	It("waits for a metal node to show up", func() {
+		_, demoCluster := createCluster(ctx, namespace)
+
+		Eventually(func() string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoCluster, constants.ControlPlaneEndPointSetCondition)
+		}, timeout, interval).Should(Equal(constants.NoMetalNodeFoundReason))
+		Expect(demoCluster.Status.Ready).To(BeFalse())
+
+		createMetalNode(ctx, namespace, "10.0.0.2", metalNodeProfile{})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("does not claim a metal node in maintenance as the control plane endpoint", func() {
+		cordoned := createMetalNode(ctx, namespace, "10.0.0.30", metalNodeProfile{})
+		annotateMetalNode(ctx, cordoned, infrav1.MetalNodeMaintenanceAnnotation, string(infrav1.CordonMaintenanceMode))
+		_, demoCluster := createCluster(ctx, namespace)
+		Eventually(func() string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoCluster, constants.ControlPlaneEndPointSetCondition)
+		}, timeout, interval).Should(Equal(constants.NoMetalNodeFoundReason))
+
+		createMetalNode(ctx, namespace, "10.0.0.31", metalNodeProfile{})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoCluster.Spec.ControlPlaneEndpoint.Host).To(Equal("10.0.0.31"))
+		Expect(getMetalNode(ctx, namespace, cordoned.Name).GetRefCluster()).To(BeEmpty())
+	})
+
+	It("picks the endpoint address on the selected network and family, with the configured port", func() {
+		createMetalNode(ctx, namespace, "10.0.0.3", metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.0.4", metalNodeProfile{})
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeNetworksAnnotation,
+			`[{"name":"management","addresses":["10.0.0.4"]},{"name":"data","addresses":["192.168.0.4","fd00::4"]}]`)
+		_, demoCluster := createCluster(ctx, namespace, func(_ *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.APIServerPort = 443
+			demoCluster.Spec.EndpointAddress = &infrav1.DemoEndpointAddressSpec{Network: "data", IPFamily: infrav1.IPv6Family}
+		})
+
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoCluster.Spec.ControlPlaneEndpoint).To(Equal(clusterv1.APIEndpoint{Host: "fd00::4", Port: 443}))
+		Expect(getMetalNode(ctx, namespace, metalNode.Name).ContainRole(constants.ControlPlaneNodeRoleValue)).To(BeTrue())
+	})
+
+	It("releases the metal node of the control plane endpoint and removes its finalizer when deleted", func() {
+		metalNode := createMetalNode(ctx, namespace, "10.0.0.3", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace)
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+		Expect(hasOwnerRef(metalNode, clusterv1.GroupVersion.String(), "Cluster", cluster.Name)).To(BeTrue())
+
+		Expect(k8sClient.Delete(ctx, demoCluster)).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, demoCluster)())
+		}, timeout, interval).Should(BeTrue())
+
+		metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+		Expect(hasOwnerRef(metalNode, clusterv1.GroupVersion.String(), "Cluster", cluster.Name)).To(BeFalse())
+		Expect(metalNode.GetRefCluster()).To(BeEmpty())
+		Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeStatusAnnotation))
+	})
+
+	It("runs a load balancer in front of the control plane machines", func() {
+		lbNode := createMetalNode(ctx, namespace, "10.0.0.6", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace, func(_ *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.LoadBalancer = &infrav1.DemoLoadBalancerSpec{Port: 8443}
+		})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoCluster.Spec.ControlPlaneEndpoint).To(Equal(clusterv1.APIEndpoint{Host: "10.0.0.6", Port: 8443}))
+		Expect(conditions.IsTrue(demoCluster, constants.LoadBalancerAvailableCondition)).To(BeTrue())
+		Expect(demoCluster.Status.LoadBalancer.MetalNodeName).To(Equal(lbNode.Name))
+
+		lbNode = getMetalNode(ctx, namespace, lbNode.Name)
+		Expect(lbNode.ContainRole(constants.LoadBalancerRoleValue)).To(BeTrue())
+		Expect(lbNode.GetRefCluster()).To(Equal(cluster.Name))
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: lbNode.Status.DataSecretName}, secret)).To(Succeed())
+		Expect(string(secret.Data["value"])).To(ContainSubstring("bind :::8443 v4v6"))
+
+		By("adding the control plane machines to the backends")
+		cpNode := createMetalNode(ctx, namespace, "10.0.0.7", metalNodeProfile{})
+		_, demoMachine := createMachine(ctx, cluster, true)
+		Eventually(func() []string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return nil
+			}
+			return demoCluster.Status.LoadBalancer.Backends
+		}, timeout, interval).Should(ConsistOf("10.0.0.7"))
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, cpNode.Name))
+		Eventually(func() string {
+			if err := get(ctx, secret)(); err != nil {
+				return ""
+			}
+			return string(secret.Data["value"])
+		}, timeout, interval).Should(ContainSubstring("10.0.0.7:6443"))
+
+		By("asking the bootstrapped load balancer to run the new configuration")
+		Eventually(func() string {
+			return metalNodeAgent.BootstrapData(lbNode.Name)
+		}, timeout, interval).Should(ContainSubstring("10.0.0.7:6443"))
+		Eventually(func() map[string]string {
+			return getMetalNode(ctx, namespace, lbNode.Name).GetAnnotations()
+		}, timeout, interval).ShouldNot(HaveKey(infrav1.MetalNodeRerunBootstrapAnnotation))
+		Expect(getMetalNode(ctx, namespace, lbNode.Name).Status.Bootstrapped).To(BeTrue())
+
+		By("leaving the control plane machines not bootstrapped yet out of the backends")
+		joiningNode := createMetalNode(ctx, namespace, "10.0.0.8", metalNodeProfile{})
+		metalNodeAgent.SetProfile(joiningNode.Name, metalNodeProfile{FailBootstrap: true})
+		_, joiningMachine := createMachine(ctx, cluster, true)
+		Eventually(func() string {
+			return getMetalNode(ctx, namespace, joiningNode.Name).Status.DataSecretName
+		}, timeout, interval).ShouldNot(BeEmpty())
+		Consistently(func() []string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return nil
+			}
+			return demoCluster.Status.LoadBalancer.Backends
+		}, 2*time.Second, interval).Should(ConsistOf("10.0.0.7"))
+		Expect(k8sClient.Delete(ctx, joiningMachine)).To(Succeed())
+
+		By("removing the deleted control plane machines from the backends")
+		Expect(k8sClient.Delete(ctx, demoMachine)).To(Succeed())
+		Eventually(func() []string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return nil
+			}
+			return demoCluster.Status.LoadBalancer.Backends
+		}, timeout, interval).Should(BeEmpty())
+		Expect(demoCluster.Status.Ready).To(BeTrue())
+	})
+
+	It("injects keepalived holding the virtual IP into the KubeadmControlPlane", func() {
+		kcp := &unstructured.Unstructured{}
+		cluster, demoCluster := createCluster(ctx, namespace, func(cluster *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.VirtualIP = &infrav1.DemoVirtualIPSpec{Address: "10.0.0.100", Interface: "eth0"}
+			kcp = createKubeadmControlPlane(ctx, namespace, cluster.Name+"-control-plane")
+			cluster.Spec.ControlPlaneRef = &corev1.ObjectReference{
+				APIVersion: kcp.GetAPIVersion(),
+				Kind:       kcp.GetKind(),
+				Name:       kcp.GetName(),
+				Namespace:  namespace,
+			}
+		})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoCluster.Spec.ControlPlaneEndpoint).To(Equal(clusterv1.APIEndpoint{Host: "10.0.0.100", Port: infrav1.DefaultAPIServerPort}))
+		Expect(conditions.IsTrue(demoCluster, constants.VirtualIPInjectedCondition)).To(BeTrue())
+
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: demoCluster.Name + "-keepalived"}, secret)).To(Succeed())
+		Expect(secret.GetLabels()).To(HaveKeyWithValue(clusterv1.ClusterLabelName, cluster.Name))
+		Expect(string(secret.Data["keepalived.conf"])).To(ContainSubstring("virtual_router_id 51"))
+		authPass := string(secret.Data["auth-pass"])
+		Expect(authPass).To(HaveLen(8))
+		Expect(string(secret.Data["keepalived.conf"])).To(ContainSubstring("auth_pass " + authPass))
+
+		Expect(get(ctx, kcp)()).To(Succeed())
+		files, _, err := unstructured.NestedSlice(kcp.Object, "spec", "kubeadmConfigSpec", "files")
+		Expect(err).NotTo(HaveOccurred())
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.(map[string]interface{})["path"].(string))
+		}
+		Expect(paths).To(Equal([]string{
+			"/etc/motd",
+			loadbalancer.KeepalivedConfigPath,
+			loadbalancer.KeepalivedCheckScriptPath,
+			loadbalancer.KeepalivedManifestPath,
+		}))
+		secretName, _, _ := unstructured.NestedString(files[1].(map[string]interface{}), "contentFrom", "secret", "name")
+		Expect(secretName).To(Equal(secret.Name))
+
+		By("keeping the VRRP password and the files when the virtual IP changes")
+		demoCluster.Spec.VirtualIP.VirtualRouterID = 52
+		Expect(k8sClient.Update(ctx, demoCluster)).To(Succeed())
+		Eventually(func() string {
+			if err := get(ctx, secret)(); err != nil {
+				return ""
+			}
+			return string(secret.Data["keepalived.conf"])
+		}, timeout, interval).Should(ContainSubstring("virtual_router_id 52"))
+		Expect(string(secret.Data["auth-pass"])).To(Equal(authPass))
+		Expect(get(ctx, kcp)()).To(Succeed())
+		files, _, err = unstructured.NestedSlice(kcp.Object, "spec", "kubeadmConfigSpec", "files")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(4))
+
+		By("refusing to move the virtual IP away from the control plane endpoint")
+		Expect(get(ctx, demoCluster)()).To(Succeed())
+		demoCluster.Spec.VirtualIP.Address = "10.0.0.101"
+		Expect(k8sClient.Update(ctx, demoCluster)).To(Succeed())
+		Eventually(func() string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoCluster, constants.VirtualIPInjectedCondition)
+		}, timeout, interval).Should(Equal(constants.InvalidConfigurationReason))
+		Expect(demoCluster.Spec.ControlPlaneEndpoint.Host).To(Equal("10.0.0.100"))
+		Expect(get(ctx, secret)()).To(Succeed())
+		Expect(string(secret.Data["keepalived.conf"])).To(ContainSubstring("10.0.0.100"))
+		Expect(string(secret.Data["keepalived.conf"])).NotTo(ContainSubstring("10.0.0.101"))
+	})
+
+	It("refuses a load balancer along with a virtual IP", func() {
+		_, demoCluster := createCluster(ctx, namespace, func(_ *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.LoadBalancer = &infrav1.DemoLoadBalancerSpec{}
+			demoCluster.Spec.VirtualIP = &infrav1.DemoVirtualIPSpec{Address: "10.0.0.101", Interface: "eth0"}
+		})
+		Eventually(func() string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoCluster, constants.ControlPlaneEndPointSetCondition)
+		}, timeout, interval).Should(Equal(constants.InvalidConfigurationReason))
+		Expect(demoCluster.Status.Ready).To(BeFalse())
+	})
+
+	It("bootstraps an external etcd cluster before the control plane endpoint", func() {
+		first := createMetalNode(ctx, namespace, "10.0.0.11", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace, func(_ *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.Etcd = &infrav1.DemoEtcdSpec{Replicas: 2}
+		})
+		Eventually(func() string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoCluster, constants.EtcdAvailableCondition)
+		}, timeout, interval).Should(Equal(constants.NoMetalNodeFoundReason))
+		Expect(demoCluster.Status.Ready).To(BeFalse())
+		Expect(getMetalNode(ctx, namespace, first.Name).GetRefCluster()).To(BeEmpty())
+
+		By("claiming the etcd members all together")
+		second := createMetalNode(ctx, namespace, "10.0.0.12", metalNodeProfile{})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && conditions.IsTrue(demoCluster, constants.EtcdAvailableCondition)
+		}, timeout, interval).Should(BeTrue())
+
+		By("claiming the control plane endpoint once etcd is available")
+		endpointNode := createMetalNode(ctx, namespace, "10.0.0.13", metalNodeProfile{})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoCluster.Status.Etcd.Endpoints).To(ConsistOf("https://10.0.0.11:2379", "https://10.0.0.12:2379"))
+		Expect(demoCluster.Status.Etcd.Members).To(ConsistOf(
+			infrav1.DemoEtcdMember{MetalNodeName: first.Name, Address: "10.0.0.11", Ready: true},
+			infrav1.DemoEtcdMember{MetalNodeName: second.Name, Address: "10.0.0.12", Ready: true},
+		))
+		Expect(demoCluster.Spec.ControlPlaneEndpoint.Host).To(Equal("10.0.0.13"))
+		Expect(getMetalNode(ctx, namespace, endpointNode.Name).ContainRole(constants.ControlPlaneNodeRoleValue)).To(BeTrue())
+
+		for _, name := range []string{first.Name, second.Name} {
+			metalNode := getMetalNode(ctx, namespace, name)
+			Expect(metalNode.ContainRole(constants.EtcdRoleValue)).To(BeTrue())
+			Expect(metalNode.GetRefCluster()).To(Equal(cluster.Name))
+			Expect(hasOwnerRef(metalNode, clusterv1.GroupVersion.String(), "Cluster", cluster.Name)).To(BeTrue())
+			bootstrap := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: metalNode.Status.DataSecretName}, bootstrap)).To(Succeed())
+			Expect(string(bootstrap.Data["value"])).To(ContainSubstring("--name=" + name + " "))
+		}
+
+		By("publishing the certificates where Cluster API looks them up")
+		caSecret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cluster.Name + "-etcd"}, caSecret)).To(Succeed())
+		Expect(caSecret.Data).NotTo(HaveKey("tls.key"))
+		caKeySecret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cluster.Name + "-etcd-ca-key"}, caKeySecret)).To(Succeed())
+		ca, err := etcd.ParseCertificateAuthority(&certs.KeyPair{Cert: caSecret.Data["tls.crt"], Key: caKeySecret.Data["tls.key"]})
+		Expect(err).NotTo(HaveOccurred())
+		clientSecret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cluster.Name + "-apiserver-etcd-client"}, clientSecret)).To(Succeed())
+		Expect(strings.Split(string(clientSecret.Data["endpoints"]), ",")).To(Equal(demoCluster.Status.Etcd.Endpoints))
+		clientCert, err := certs.DecodeCertPEM(clientSecret.Data["tls.crt"])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clientCert.CheckSignatureFrom(ca.Cert)).To(Succeed())
+
+		By("reprovisioning the etcd members when deleted")
+		Expect(k8sClient.Delete(ctx, demoCluster)).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, demoCluster)())
+		}, timeout, interval).Should(BeTrue())
+		for _, name := range []string{first.Name, second.Name} {
+			metalNode := getMetalNode(ctx, namespace, name)
+			Expect(metalNode.GetRefCluster()).To(BeEmpty())
+			Expect(metalNode.GetAnnotations()).To(HaveKey(infrav1.MetalNodeReprovisioningAnnotation))
+			Eventually(func() (infrav1.ReprovisioningState, error) {
+				reprovisioning, err := metalnode.GetReprovisioning(getMetalNode(ctx, namespace, name))
+				if err != nil || reprovisioning == nil {
+					return "", err
+				}
+				return reprovisioning.State, nil
+			}, timeout, interval).Should(Equal(infrav1.AvailableState))
+			Expect(metalNodeAgent.BootstrapData(name)).To(ContainSubstring("docker rm -f etcd"))
+		}
+		Expect(getMetalNode(ctx, namespace, endpointNode.Name).GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeReprovisioningAnnotation))
+	})
+
+	It("moves the private key of an etcd certificate authority out of the secret Cluster API copies to the machines", func() {
+		createMetalNode(ctx, namespace, "10.0.0.14", metalNodeProfile{})
+		ca, err := etcd.NewCertificateAuthority()
+		Expect(err).NotTo(HaveOccurred())
+		cluster, _ := createCluster(ctx, namespace, func(cluster *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.Etcd = &infrav1.DemoEtcdSpec{Replicas: 1}
+			Expect(k8sClient.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: cluster.Name + "-etcd", Namespace: namespace},
+				Data:       map[string][]byte{"tls.crt": ca.KeyPair().Cert, "tls.key": ca.KeyPair().Key},
+			})).To(Succeed())
+		})
+		caKeySecret := &corev1.Secret{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cluster.Name + "-etcd-ca-key"}, caKeySecret)
+		}, timeout, interval).Should(Succeed())
+		Expect(caKeySecret.Data).To(HaveKeyWithValue("tls.key", ca.KeyPair().Key))
+		caSecret := &corev1.Secret{}
+		Eventually(func() map[string][]byte {
+			if err := k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cluster.Name + "-etcd"}, caSecret); err != nil {
+				return nil
+			}
+			return caSecret.Data
+		}, timeout, interval).ShouldNot(HaveKey("tls.key"))
+		Expect(caSecret.Data).To(HaveKeyWithValue("tls.crt", ca.KeyPair().Cert))
+	})
+
+	It("probes the control plane endpoint and warns when its metal node is no longer ready", func() {
+		metalNode := createMetalNode(ctx, namespace, "127.0.0.1", metalNodeProfile{})
+		_, demoCluster := createCluster(ctx, namespace)
+		// nothing serves the control plane in the test environment
+		Eventually(func() string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoCluster, constants.ControlPlaneEndpointHealthyCondition)
+		}, timeout, interval).Should(Equal(constants.EndpointUnreachableReason))
+		Expect(conditions.GetSeverity(demoCluster, constants.ControlPlaneEndpointHealthyCondition)).To(HaveValue(Equal(clusterv1.ConditionSeverityWarning)))
+		Expect(demoCluster.Status.Ready).To(BeTrue())
+
+		By("losing the metal node of the endpoint")
+		metalNodeAgent.SetProfile(metalNode.Name, metalNodeProfile{FailInitialization: true})
+		metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+		metalNode.Status.Ready = false
+		Expect(k8sClient.Status().Update(ctx, metalNode)).To(Succeed())
+		Eventually(func() string {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoCluster, constants.ControlPlaneEndpointHealthyCondition)
+		}, timeout, interval).Should(Equal(constants.EndpointMetalNodeNotReadyReason))
+		Expect(conditions.GetMessage(demoCluster, constants.ControlPlaneEndpointHealthyCondition)).To(ContainSubstring(metalNode.Name))
+		Expect(demoCluster.Status.Ready).To(BeTrue())
+	})
+
+	It("reports the progress of the upgrade of its machines", func() {
+		createMetalNode(ctx, namespace, "10.0.0.20", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace)
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		createMetalNode(ctx, namespace, "10.0.0.21", metalNodeProfile{})
+		oldMachine, oldDemoMachine := createMachineWithVersion(ctx, cluster, false, "v1.23.6")
+		Eventually(func() bool {
+			return get(ctx, oldDemoMachine)() == nil && oldDemoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		// the machines of a new cluster are not upgraded
+		Expect(get(ctx, demoCluster)()).To(Succeed())
+		Expect(demoCluster.Status.Upgrade).To(BeNil())
+
+		metalNode := createMetalNode(ctx, namespace, "10.0.0.22", metalNodeProfile{})
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeKubernetesVersionAnnotation, "v1.23.6")
+		_, newDemoMachine := createMachineWithVersion(ctx, cluster, false, "v1.24.1")
+		Eventually(func() bool {
+			return get(ctx, newDemoMachine)() == nil && newDemoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() *infrav1.DemoUpgradeStatus {
+			if err := get(ctx, demoCluster)(); err != nil {
+				return nil
+			}
+			return demoCluster.Status.Upgrade
+		}, timeout, interval).Should(And(
+			HaveField("TargetVersion", "v1.24.1"),
+			HaveField("UpdatedMachines", int32(1)),
+			HaveField("PendingMachines", int32(0)),
+			HaveField("OutdatedMachines", int32(1)),
+			HaveField("ReinitializingMetalNodes", int32(0)),
+			HaveField("CompletionTime", BeNil()),
+		))
+		startTime := demoCluster.Status.Upgrade.StartTime
+
+		Expect(k8sClient.Delete(ctx, oldMachine)).To(Succeed())
+		Eventually(func() *metav1.Time {
+			if err := get(ctx, demoCluster)(); err != nil || demoCluster.Status.Upgrade == nil {
+				return nil
+			}
+			return demoCluster.Status.Upgrade.CompletionTime
+		}, timeout, interval).ShouldNot(BeNil())
+		Expect(demoCluster.Status.Upgrade.OutdatedMachines).To(BeZero())
+		Expect(demoCluster.Status.Upgrade.StartTime).To(Equal(startTime))
+	})
+
+	It("keeps its control plane endpoint when resumed after a clusterctl move", func() {
+		metalNode := createMetalNode(ctx, namespace, "10.0.0.4", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace)
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		// a free metal node the demoCluster must not claim on resume
+		createMetalNode(ctx, namespace, "10.0.0.5", metalNodeProfile{})
+
+		simulateMove(ctx, cluster, demoCluster, metalNode)
+
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoCluster.Spec.ControlPlaneEndpoint).To(Equal(clusterv1.APIEndpoint{Host: "10.0.0.4", Port: 6443}))
+		Eventually(func() string {
+			return getMetalNode(ctx, namespace, metalNode.Name).GetRefCluster()
+		}, timeout, interval).Should(Equal(cluster.Name))
+		Expect(getMetalNode(ctx, namespace, metalNode.Name).ContainRole(constants.ControlPlaneNodeRoleValue)).To(BeTrue())
+	})
+})
@@ -0,0 +1,225 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/cluster-api/util/predicates"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/ipam"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// DemoIPPoolReconciler reconciles a DemoIPPool object, allocating its addresses to the IPAddressClaims referencing it
+type DemoIPPoolReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// WatchFilterValue is the label value used to filter events prior to reconciliation.
+	WatchFilterValue string
+}
+
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demoippools,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demoippools/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=ipaddressclaims,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=ipaddressclaims/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=ipaddresses,verbs=get;list;watch;create;delete
+
+// Reconcile allocates an IPAddress to each IPAddressClaim of the pool without one, and deletes the IPAddresses
+// of the deleted IPAddressClaims so that their addresses are allocated again.
+func (r *DemoIPPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	demoIPPool := &infrav1.DemoIPPool{}
+	if err := r.Client.Get(ctx, req.NamespacedName, demoIPPool); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	l := log.With("demoIPPool", demoIPPool.Name)
+
+	pool, err := ipam.NewPool(demoIPPool.Spec.Addresses)
+	if err != nil {
+		l.WithError(err).Error("invalid addresses")
+		return ctrl.Result{}, nil
+	}
+
+	claimList := ipam.NewClaimList()
+	if err := r.Client.List(ctx, claimList, client.InNamespace(demoIPPool.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	claims := map[string]*unstructured.Unstructured{}
+	for i := range claimList.Items {
+		claim := &claimList.Items[i]
+		if r.isPoolRef(ipam.GetPoolRef(claim), demoIPPool) && claim.GetDeletionTimestamp().IsZero() {
+			claims[claim.GetName()] = claim
+		}
+	}
+
+	addressList := ipam.NewAddressList()
+	if err := r.Client.List(ctx, addressList, client.InNamespace(demoIPPool.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	used := map[string]bool{}
+	allocated := map[string]string{}
+	for i := range addressList.Items {
+		address := &addressList.Items[i]
+		if !r.isPoolRef(ipam.GetPoolRef(address), demoIPPool) {
+			continue
+		}
+		claimName := ipam.GetClaimRef(address)
+		if _, ok := claims[claimName]; !ok {
+			if err := r.Client.Delete(ctx, address); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			l.Infof("released the address of IPAddressClaim %s", claimName)
+			continue
+		}
+		spec, err := ipam.GetAddress(address)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		used[spec.Address] = true
+		allocated[claimName] = address.GetName()
+	}
+
+	// allocate in the order of the claims so that the addresses follow the creation of the machines
+	var pending []*unstructured.Unstructured
+	for _, claim := range claims {
+		pending = append(pending, claim)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		ti, tj := pending[i].GetCreationTimestamp(), pending[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return pending[i].GetName() < pending[j].GetName()
+	})
+	for _, claim := range pending {
+		name, ok := allocated[claim.GetName()]
+		if !ok {
+			address, free := pool.Allocate(used)
+			if !free {
+				l.Warnf("no address left for IPAddressClaim %s", claim.GetName())
+				break
+			}
+			if err := r.createAddress(ctx, demoIPPool, claim, address); err != nil {
+				return ctrl.Result{}, err
+			}
+			used[address] = true
+			name = claim.GetName()
+			l.Infof("allocated address %s to IPAddressClaim %s", address, claim.GetName())
+		}
+		if ipam.GetAddressRef(claim) != name {
+			if err := ipam.SetAddressRef(claim, name); err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.Client.Status().Update(ctx, claim); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	usedInPool := int32(0)
+	for address := range used {
+		if pool.Contains(address) {
+			usedInPool++
+		}
+	}
+	status := infrav1.DemoIPPoolStatus{Used: usedInPool, Free: pool.Size() - usedInPool}
+	if status.Free < 0 {
+		status.Free = 0
+	}
+	if status == demoIPPool.Status {
+		return ctrl.Result{}, nil
+	}
+	demoIPPool.Status = status
+	return ctrl.Result{}, r.Client.Status().Update(ctx, demoIPPool)
+}
+
+// createAddress creates the IPAddress allocating address to the claim, owned by the claim and the pool
+func (r *DemoIPPoolReconciler) createAddress(ctx context.Context, demoIPPool *infrav1.DemoIPPool, claim *unstructured.Unstructured, address string) error {
+	ipAddress := ipam.NewAddress()
+	ipAddress.SetName(claim.GetName())
+	ipAddress.SetNamespace(claim.GetNamespace())
+	if err := controllerutil.SetControllerReference(claim, ipAddress, r.Scheme); err != nil {
+		return err
+	}
+	if err := controllerutil.SetOwnerReference(demoIPPool, ipAddress, r.Scheme); err != nil {
+		return err
+	}
+	if err := ipam.SetPoolRef(ipAddress, ipam.GetPoolRef(claim)); err != nil {
+		return err
+	}
+	if err := ipam.SetAddress(ipAddress, claim.GetName(), ipam.Address{
+		Address: address,
+		Prefix:  demoIPPool.Spec.Prefix,
+		Gateway: demoIPPool.Spec.Gateway,
+	}); err != nil {
+		return err
+	}
+	if err := r.Client.Create(ctx, ipAddress); err != nil {
+		return errors.Wrapf(err, "failed to allocate address %s to IPAddressClaim %s", address, claim.GetName())
+	}
+	return nil
+}
+
+func (r *DemoIPPoolReconciler) isPoolRef(ref corev1.TypedLocalObjectReference, demoIPPool *infrav1.DemoIPPool) bool {
+	return ipam.IsPoolRef(ref, infrav1.GroupVersion.Group, "DemoIPPool", demoIPPool.Name)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DemoIPPoolReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&infrav1.DemoIPPool{}, builder.WithPredicates(predicates.ResourceHasFilterLabel(mgr.GetLogger(), r.WatchFilterValue))).
+		WithOptions(options).
+		Watches(
+			&source.Kind{Type: ipam.NewClaim()},
+			handler.EnqueueRequestsFromMapFunc(IPAddressClaimToDemoIPPool),
+		).
+		Complete(r)
+}
+
+// IPAddressClaimToDemoIPPool is a handler.MapFunc to be used to enqueue requests for reconciliation
+// of the DemoIPPool an IPAddressClaim claims from.
+func IPAddressClaimToDemoIPPool(o client.Object) []reconcile.Request {
+	claim, ok := o.(*unstructured.Unstructured)
+	if !ok {
+		log.Errorf("expected an IPAddressClaim but got a %T", o)
+		return nil
+	}
+	ref := ipam.GetPoolRef(claim)
+	if !ipam.IsPoolRef(ref, infrav1.GroupVersion.Group, "DemoIPPool", ref.Name) {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: claim.GetNamespace(), Name: ref.Name}}}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/ipam"
+)
+
+var _ = Describe("DemoIPPoolReconciler", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+	})
+
+	// staticAddress returns the static address handed to the metal node bound to the demoMachine
+	staticAddress := func(demoMachine *infrav1.DemoMachine) string {
+		metalNode := getMetalNode(ctx, namespace, demoMachine.GetLabels()[infrav1.MetalNodeLabelName])
+		address := infrav1.MetalNodeStaticAddress{}
+		Expect(json.Unmarshal([]byte(metalNode.GetAnnotations()[infrav1.MetalNodeStaticAddressAnnotation]), &address)).To(Succeed())
+		Expect(address.Prefix).To(BeEquivalentTo(24))
+		Expect(address.Gateway).To(Equal("10.0.2.1"))
+		return address.Address
+	}
+
+	It("claims the static addresses of the machines before binding their metal nodes, and releases them", func() {
+		createMetalNode(ctx, namespace, "10.0.2.100", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace)
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		for _, host := range []string{"10.0.2.101", "10.0.2.102", "10.0.2.103"} {
+			createMetalNode(ctx, namespace, host, metalNodeProfile{})
+		}
+
+		pool := &infrav1.DemoIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: namespace},
+			Spec: infrav1.DemoIPPoolSpec{
+				Addresses: []string{"10.0.2.10-10.0.2.11"},
+				Prefix:    24,
+				Gateway:   "10.0.2.1",
+			},
+		}
+		Expect(k8sClient.Create(ctx, pool)).To(Succeed())
+		apiGroup := infrav1.GroupVersion.Group
+		fromPool := func(demoMachine *infrav1.DemoMachine) {
+			demoMachine.Spec.IPAddressPool = &corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "DemoIPPool", Name: pool.Name}
+		}
+
+		_, first := createMachine(ctx, cluster, false, fromPool)
+		_, second := createMachine(ctx, cluster, false, fromPool)
+		for _, demoMachine := range []*infrav1.DemoMachine{first, second} {
+			demoMachine := demoMachine
+			Eventually(func() bool {
+				return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+			}, timeout, interval).Should(BeTrue())
+			Expect(conditions.IsTrue(demoMachine, constants.IPAddressClaimedCondition)).To(BeTrue())
+		}
+		firstAddress, secondAddress := staticAddress(first), staticAddress(second)
+		Expect([]string{firstAddress, secondAddress}).To(ConsistOf("10.0.2.10", "10.0.2.11"))
+		Expect(first.Status.Addresses).To(ContainElement(clusterv1.MachineAddress{Type: clusterv1.MachineInternalIP, Address: firstAddress}))
+		Eventually(func() infrav1.DemoIPPoolStatus {
+			_ = get(ctx, pool)()
+			return pool.Status
+		}, timeout, interval).Should(Equal(infrav1.DemoIPPoolStatus{Used: 2, Free: 0}))
+
+		By("waiting for an address when the pool is exhausted")
+		_, third := createMachine(ctx, cluster, false, fromPool)
+		Eventually(func() string {
+			if err := get(ctx, third)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(third, constants.IPAddressClaimedCondition)
+		}, timeout, interval).Should(Equal(constants.WaitingForIPAddressReason))
+		Expect(third.GetLabels()).NotTo(HaveKey(infrav1.MetalNodeLabelName))
+
+		By("releasing the address of a deleted machine")
+		firstNode := first.GetLabels()[infrav1.MetalNodeLabelName]
+		Expect(k8sClient.Delete(ctx, first)).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, first)())
+		}, timeout, interval).Should(BeTrue())
+		Expect(getMetalNode(ctx, namespace, firstNode).GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeStaticAddressAnnotation))
+		claim := ipam.NewClaim()
+		claim.SetNamespace(namespace)
+		claim.SetName(first.Name)
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, claim)())
+		}, timeout, interval).Should(BeTrue())
+
+		Eventually(func() bool {
+			return get(ctx, third)() == nil && third.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(staticAddress(third)).To(Equal(firstAddress))
+	})
+})
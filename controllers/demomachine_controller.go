@@ -20,22 +20,37 @@ import (
 	"context"
 	"fmt"
 	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/feature"
+	"github.com/git-czy/cluster-api-provider-demo/ipam"
 	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	capierrors "sigs.k8s.io/cluster-api/errors"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/cluster-api/util/conditions"
 	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/cluster-api/util/predicates"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+	"sync"
 	"time"
 
 	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
 	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
 	"github.com/git-czy/cluster-api-provider-demo/utils/log"
 )
 
@@ -43,14 +58,43 @@ import (
 type DemoMachineReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// WatchFilterValue is the label value used to filter events and metal nodes prior to reconciliation.
+	WatchFilterValue string
+
+	// ReprovisioningTimeout is the time a released metal node is given to be cleaned, and then to be reinitialized,
+	// defaults to DefaultReprovisioningTimeout.
+	ReprovisioningTimeout time.Duration
+
+	// BootstrapTimeout is the time a metal node with a BMC is given to run the bootstrap data before it is power-cycled,
+	// defaults to DefaultBootstrapTimeout.
+	BootstrapTimeout time.Duration
+
+	// Recorder records the preemption decisions of the demo machines as events.
+	Recorder record.EventRecorder
+
+	// APIReader reads the metal nodes and the quotas live, when a claim is checked against the metal node quotas.
+	// It defaults to the API reader of the manager.
+	APIReader client.Reader
+
+	// quotaClaims serializes the claims checked against the metal node quotas
+	quotaClaims sync.Mutex
 }
 
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demomachines,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demomachines/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demomachines/finalizers,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;machines,verbs=get;list;watch
-//+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=delete;patch
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinedeployments;machinesets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes/status,verbs=get;update
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=metalnodequotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=ipaddressclaims,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=ipam.cluster.x-k8s.io,resources=ipaddresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -151,10 +195,136 @@ func (r *DemoMachineReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *DemoMachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+func (r *DemoMachineReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+	if err := registerProvisioningQueueCollector(mgr.GetClient(), r.WatchFilterValue); err != nil {
+		return err
+	}
+	clusterToDemoMachines, err := util.ClusterToObjectsMapper(mgr.GetClient(), &infrav1.DemoMachineList{}, mgr.GetScheme())
+	if err != nil {
+		return err
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&infrav1.DemoMachine{}).
-		Complete(r)
+		WithOptions(options).
+		WithEventFilter(predicates.ResourceNotPausedAndHasFilterLabel(mgr.GetLogger(), r.WatchFilterValue)).
+		Watches(
+			&source.Kind{Type: &metav1beta1.MetalNode{}},
+			handler.EnqueueRequestsFromMapFunc(r.MetalNodeToDemoMachines),
+		).
+		// resume the machines when their cluster is unpaused, e.g. at the end of a clusterctl move
+		Watches(
+			&source.Kind{Type: &clusterv1.Cluster{}},
+			handler.EnqueueRequestsFromMapFunc(clusterToDemoMachines),
+			builder.WithPredicates(predicates.ClusterUnpausedAndInfrastructureReady(mgr.GetLogger())),
+		).
+		Watches(
+			&source.Kind{Type: &infrav1.MetalNodeQuota{}},
+			handler.EnqueueRequestsFromMapFunc(r.MetalNodeQuotaToDemoMachines),
+		).
+		// the machines left in the provisioning queue move up when a machine leaves it
+		Watches(
+			&source.Kind{Type: &infrav1.DemoMachine{}},
+			handler.EnqueueRequestsFromMapFunc(r.DemoMachineToQueuedDemoMachines),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc: func(event.CreateEvent) bool { return false },
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					oldDemoMachine, okOld := e.ObjectOld.(*infrav1.DemoMachine)
+					newDemoMachine, okNew := e.ObjectNew.(*infrav1.DemoMachine)
+					return okOld && okNew && queued(oldDemoMachine) && !queued(newDemoMachine)
+				},
+				DeleteFunc:  func(event.DeleteEvent) bool { return false },
+				GenericFunc: func(event.GenericEvent) bool { return false },
+			}),
+		)
+	// the IPAddressClaims are only served when the IPAM contract is installed
+	if feature.Gates.Enabled(feature.IPAM) {
+		b = b.Owns(ipam.NewClaim())
+	}
+	if err := b.Complete(r); err != nil {
+		return err
+	}
+
+	// the metal nodes of the load balancer and of etcd are always reprovisioned once released, the feature gate only
+	// decides for the ones released by the DemoMachines, the others are powered off through their BMC. The reprovisioning
+	// controller has a queue and a rate limiter of its own, a rate limiter keeps track of the items of a single queue,
+	// and only cleans or powers off the metal nodes of its watch filter.
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("metalnode-reprovisioning").
+		For(&metav1beta1.MetalNode{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(o client.Object) bool {
+			_, reprovisioning := o.GetAnnotations()[infrav1.MetalNodeReprovisioningAnnotation]
+			_, powerOff := o.GetAnnotations()[infrav1.MetalNodePowerOffAnnotation]
+			return reprovisioning || powerOff
+		}))).
+		WithOptions(controller.Options{MaxConcurrentReconciles: options.MaxConcurrentReconciles}).
+		WithEventFilter(predicates.ResourceHasFilterLabel(mgr.GetLogger(), r.WatchFilterValue)).
+		Complete(reconcile.Func(r.reconcileReprovisioning))
+}
+
+// MetalNodeToDemoMachines is a handler.MapFunc to be used to enqueue requests for reconciliation
+// of the DemoMachine bound to a metal node, or of the DemoMachines still waiting for one when the metal node is free.
+func (r *DemoMachineReconciler) MetalNodeToDemoMachines(o client.Object) []reconcile.Request {
+	metalNode, ok := o.(*metav1beta1.MetalNode)
+	if !ok {
+		log.Errorf("expected a MetalNode but got a %T", o)
+		return nil
+	}
+
+	demoMachineList := &infrav1.DemoMachineList{}
+	if err := r.Client.List(context.TODO(), demoMachineList, watchFilterListOptions(metalNode.Namespace, r.WatchFilterValue)...); err != nil {
+		log.WithError(err).Errorf("failed to list demoMachines in namespace %s", metalNode.Namespace)
+		return nil
+	}
+
+	free := metalNodeFree(metalNode)
+	var requests []reconcile.Request
+	for _, demoMachine := range demoMachineList.Items {
+		bound := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]
+		if bound == metalNode.Name || metalNodeClaimedBy(metalNode) == demoMachine.Name || (free && bound == "") {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&demoMachine)})
+		}
+	}
+	return requests
+}
+
+// MetalNodeQuotaToDemoMachines is a handler.MapFunc to be used to enqueue requests for reconciliation
+// of the DemoMachines waiting for a metal node in the namespace of a MetalNodeQuota, when the quota changes.
+func (r *DemoMachineReconciler) MetalNodeQuotaToDemoMachines(o client.Object) []reconcile.Request {
+	demoMachineList := &infrav1.DemoMachineList{}
+	if err := r.Client.List(context.TODO(), demoMachineList, watchFilterListOptions(o.GetNamespace(), r.WatchFilterValue)...); err != nil {
+		log.WithError(err).Errorf("failed to list demoMachines in namespace %s", o.GetNamespace())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, demoMachine := range demoMachineList.Items {
+		if demoMachine.GetLabels()[infrav1.MetalNodeLabelName] == "" {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&demoMachine)})
+		}
+	}
+	return requests
+}
+
+// DemoMachineToQueuedDemoMachines is a handler.MapFunc to be used to enqueue requests for reconciliation
+// of the DemoMachines waiting in the provisioning queue of the namespace of a DemoMachine, when it leaves the queue.
+func (r *DemoMachineReconciler) DemoMachineToQueuedDemoMachines(o client.Object) []reconcile.Request {
+	demoMachineList := &infrav1.DemoMachineList{}
+	if err := r.Client.List(context.TODO(), demoMachineList, watchFilterListOptions(o.GetNamespace(), r.WatchFilterValue)...); err != nil {
+		log.WithError(err).Errorf("failed to list demoMachines in namespace %s", o.GetNamespace())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range demoMachineList.Items {
+		demoMachine := &demoMachineList.Items[i]
+		if demoMachine.Name != o.GetName() && queued(demoMachine) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(demoMachine)})
+		}
+	}
+	return requests
 }
 
 // reconcileDelete reconcile demoMachine delete
@@ -169,27 +339,37 @@ func (r *DemoMachineReconciler) reconcileDelete(ctx context.Context, machine *cl
 		return ctrl.Result{}, errors.Wrap(err, "failed to patch demoMachine")
 	}
 
-	metalNode := &metav1beta1.MetalNode{}
-	metalNodeList, err := r.getMetalNodes(ctx, demoCluster)
-	if err != nil {
-		conditions.MarkFalse(demoCluster, constants.MetalNodeReadyCondition, constants.DeletingReason, clusterv1.ConditionSeverityInfo, err.Error())
-		return ctrl.Result{}, err
-	}
-
-	for _, node := range metalNodeList.Items {
-		if string(node.UID) == demoMachine.Spec.ProviderID {
-			metalNode = &node
-			break
+	// the metal node hosting the machine is the one set in the demoMachine label,
+	// the machine may have been deleted before claiming any metal node
+	var metalNode *metav1beta1.MetalNode
+	if name := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]; name != "" {
+		metalNode = &metav1beta1.MetalNode{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: demoMachine.Namespace, Name: name}, metalNode); err != nil {
+			if !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			log.Infof("metal node %s hosting the machine is already gone", name)
+			metalNode = nil
 		}
 	}
 
 	// todo operate control plane machine
 
-	// reset metalNode
-	metalNode.ResetMetalNode()
-	if err := r.Client.Status().Update(ctx, metalNode); err != nil {
-		conditions.MarkFalse(demoCluster, constants.MetalNodeReadyCondition, constants.DeletingReason, clusterv1.ConditionSeverityWarning, err.Error())
-		return ctrl.Result{}, err
+	// reset metalNode, or have it cleaned and reinitialized before it is claimed again, as a remediation may ask for
+	if metalNode != nil {
+		_, reprovision := demoMachine.GetAnnotations()[infrav1.DemoMachineReprovisionMetalNodeAnnotation]
+		reprovision = reprovision || feature.Gates.Enabled(feature.MetalNodeReprovisioning)
+		if err := metalnode.Release(ctx, r.Client, metalNode, reprovision, demoMachineOwnerRef(demoMachine)); err != nil {
+			conditions.MarkFalse(demoCluster, constants.MetalNodeReadyCondition, constants.DeletingReason, clusterv1.ConditionSeverityWarning, err.Error())
+			return ctrl.Result{}, err
+		}
+	}
+
+	// release the static address once the metal node no longer uses it
+	if demoMachine.Spec.IPAddressPool != nil {
+		if err := r.deleteIPAddressClaim(ctx, demoMachine); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
 	controllerutil.RemoveFinalizer(demoMachine, infrav1.MachineFinalizer)
@@ -197,14 +377,17 @@ func (r *DemoMachineReconciler) reconcileDelete(ctx context.Context, machine *cl
 }
 
 // reconcileNormal reconcile demoMachine normal
-func (r *DemoMachineReconciler) reconcileNormal(ctx context.Context, machine *clusterv1.Machine, cluster *clusterv1.Cluster, demoMachine *infrav1.DemoMachine, demoCluster *infrav1.DemoCluster, l log.Logger) (ctrl.Result, error) {
+func (r *DemoMachineReconciler) reconcileNormal(ctx context.Context, machine *clusterv1.Machine, cluster *clusterv1.Cluster, demoMachine *infrav1.DemoMachine, demoCluster *infrav1.DemoCluster, l log.Logger) (_ ctrl.Result, rerr error) {
 	var metalNode *metav1beta1.MetalNode
 
-	// always update the metalNode status
+	// always update the metalNode, a failed update is retried
 	defer func() {
 		if metalNode != nil {
-			if err := r.Client.Status().Update(ctx, metalNode); err != nil {
-				log.Errorf("failed to update metalNode status")
+			if err := metalnode.Update(ctx, r.Client, metalNode); err != nil {
+				log.WithError(err).Errorf("failed to update metalNode %s", metalNode.Name)
+				if rerr == nil {
+					rerr = errors.Wrapf(err, "failed to update metal node %s", metalNode.Name)
+				}
 			}
 		}
 	}()
@@ -230,23 +413,110 @@ func (r *DemoMachineReconciler) reconcileNormal(ctx context.Context, machine *cl
 		}
 		metalNode = &metav1beta1.MetalNode{}
 		if err := r.Client.Get(ctx, key, metalNode); err != nil {
+			if apierrors.IsNotFound(err) && demoMachine.Status.Bootstrapped && feature.Gates.Enabled(feature.AutoRemediation) {
+				metalNode = nil
+				setMachineFailure(demoMachine, fmt.Sprintf("metal node %s hosting the machine is not found", key.Name))
+				l.Errorf("metal node %s hosting the machine is not found, marking the machine as failed", key.Name)
+				return ctrl.Result{}, nil
+			}
 			conditions.MarkFalse(demoCluster, constants.MetalNodeReadyCondition, constants.NoMetalNodeFoundReason, clusterv1.ConditionSeverityWarning, err.Error())
 			l.Errorln("no metal node found, please check the status and number of metal node")
 			return ctrl.Result{}, err
 		}
+
+		// the metal node was moved by clusterctl, which does not copy the status
+		restored, err := restoreMetalNodeStatus(metalNode)
+		if err != nil {
+			metalNode = nil
+			return ctrl.Result{}, err
+		}
+		if restored {
+			l.Infof("restored the status of metal node %s", metalNode.Name)
+		}
+
+		// the metal node stays bound to the machine as long as it is claimed by it, the ones claimed before the claim
+		// was recorded on the metal node are adopted
+		switch claimedBy := metalNodeClaimedBy(metalNode); {
+		case claimedBy == demoMachine.Name:
+		case claimedBy == "" && metalNodeRefersTo(metalNode, cluster.Name, demoCluster.Name):
+			claimMetalNode(metalNode, demoMachine)
+		case demoMachine.Status.Bootstrapped:
+			conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.MetalNodeClaimedReason, clusterv1.ConditionSeverityError,
+				"metal node %s is claimed by %q", metalNode.Name, claimedBy)
+			l.Errorf("metal node %s hosting the machine is claimed by %q", metalNode.Name, claimedBy)
+			metalNode = nil
+			return ctrl.Result{}, nil
+		default:
+			l.Warnf("metal node %s is not claimed by the machine, claiming another one", metalNode.Name)
+			delete(labels, infrav1.MetalNodeLabelName)
+			demoMachine.SetLabels(labels)
+			metalNode = nil
+		}
+		if metalNode != nil {
+			metalNode.SetOwnerReferences(util.RemoveOwnerRef(metalNode.GetOwnerReferences(), demoMachineOwnerRef(demoMachine)))
+			// the metal nodes claimed before the name of the cluster was normalized refer to the demoCluster
+			if metalNode.GetRefCluster() != cluster.Name && metalNodeRefersTo(metalNode, cluster.Name, demoCluster.Name) {
+				metalNode.Status.RefCluster = cluster.Name
+			}
+		}
+	}
+
+	// a machine whose metal node is lost after bootstrap is reported as failed so that it can be remediated
+	if metalNode != nil && !metalNode.IsReady() && demoMachine.Status.Bootstrapped && feature.Gates.Enabled(feature.AutoRemediation) {
+		setMachineFailure(demoMachine, fmt.Sprintf("metal node %s hosting the machine is no longer ready", metalNode.Name))
+		l.Errorf("metal node %s hosting the machine is no longer ready, marking the machine as failed", metalNode.Name)
+		return ctrl.Result{}, nil
+	}
+
+	// the machine is replaced on another metal node when its metal node is evacuated for maintenance
+	if metalNode != nil && metalNodeEvacuated(metalNode) {
+		return ctrl.Result{}, r.evacuateMachine(ctx, machine, demoMachine, metalNode, l)
+	}
+
+	// the metal node powered off when released is powered on once claimed again
+	if metalNode != nil && metalNodePoweredOff(metalNode) {
+		if err := powerOnMetalNode(ctx, r.Client, metalNode); err != nil {
+			return ctrl.Result{}, err
+		}
+		conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.WaitingForMetalNodeReadyReason, clusterv1.ConditionSeverityInfo,
+			"metal node %s is powering on", metalNode.Name)
+		l.Infof("powered on metal node %s, waiting for it to be ready...", metalNode.Name)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 	}
 
 	if metalNode != nil && metalNode.IsReady() && metalNode.Status.Bootstrapped {
-		setMachineAddress(demoMachine, metalNode)
+		// the metal node no longer needs its bootstrap data
+		if err := r.deleteCloudConfigSecret(ctx, demoMachine); err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "failed to delete the bootstrap data of the bootstrapped metal node")
+		}
+		if err := setMachineAddress(demoMachine, metalNode); err != nil {
+			l.WithError(err).Warn("failed to read the networks of the metal node, only its host is reported")
+		}
 		demoMachine.Status.Ready = true
 		demoMachine.Status.Bootstrapped = true
-		demoMachine.Spec.ProviderID = string(metalNode.UID)
+		// the provider id is immutable, the uid of the metal node changes when it is moved by clusterctl
+		if demoMachine.Spec.ProviderID == "" {
+			demoMachine.Spec.ProviderID = metalNodeProviderID(metalNode)
+		}
+		if providerID, migrated := migrateProviderID(demoMachine.Spec.ProviderID); migrated {
+			l.Infof("migrating the provider id %s to %s", demoMachine.Spec.ProviderID, providerID)
+			demoMachine.Spec.ProviderID = providerID
+		}
 		// set condition mark bootstrap success
 		conditions.MarkTrue(demoMachine, constants.BootstrapSucceededCondition)
 		l.Info("MetalNode bootstrap success!")
 		return ctrl.Result{}, nil
 	}
 
+	// a machine bootstrapped before, e.g. whose metal node lost its status in a clusterctl move, waits for the agent to report
+	// the metal node bootstrapped again rather than bootstrapping it twice
+	if metalNode != nil && demoMachine.Spec.ProviderID != "" {
+		conditions.MarkFalse(demoMachine, constants.BootstrapSucceededCondition, constants.WaitingForMetalNodeBootstrapReason, clusterv1.ConditionSeverityInfo,
+			"waiting for metal node %s to be reported bootstrapped again", metalNode.Name)
+		l.Infof("waiting for metal node %s to be reported bootstrapped again...", metalNode.Name)
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
 	// Make sure bootstrap data is available and populated.
 	if machine.Spec.Bootstrap.DataSecretName == nil {
 		if !util.IsControlPlaneMachine(machine) && !conditions.IsTrue(cluster, clusterv1.ControlPlaneInitializedCondition) {
@@ -260,12 +530,63 @@ func (r *DemoMachineReconciler) reconcileNormal(ctx context.Context, machine *cl
 		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 	}
 
-	if metalNode != nil && metalNode.IsReady() && !metalNode.Status.Bootstrapped {
-		metalNode.Status.DataSecretName = *machine.Spec.Bootstrap.DataSecretName
+	// the bootstrap data is validated, translated to the cloud-config the metal node agent runs and merged with the bootstrap extensions,
+	// before a metal node is bound to the machine
+	dataSecretName, err := r.reconcileBootstrapData(ctx, machine, cluster, demoMachine, demoCluster)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if dataSecretName == "" {
+		l.Warnf("the bootstrap data is not available: %s", conditions.GetMessage(demoMachine, constants.BootstrapDataAvailableCondition))
+		conditions.MarkFalse(demoMachine, constants.BootstrapSucceededCondition, constants.BootstrapDataNotAvailableReason, clusterv1.ConditionSeverityInfo, "")
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	// the static address of the machine is claimed before a metal node is bound to it, and handed to the metal node
+	var staticAddress *ipam.Address
+	if demoMachine.Spec.IPAddressPool != nil && !feature.Gates.Enabled(feature.IPAM) {
+		conditions.MarkFalse(demoMachine, constants.IPAddressClaimedCondition, constants.InvalidConfigurationReason, clusterv1.ConditionSeverityError,
+			"the IPAM feature gate is disabled")
+		l.Errorf("the machine has an IP address pool but the IPAM feature gate is disabled")
+		return ctrl.Result{}, nil
+	}
+	if demoMachine.Spec.IPAddressPool != nil {
+		address, err := r.reconcileIPAddressClaim(ctx, demoMachine, cluster)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if address == nil {
+			conditions.MarkFalse(demoMachine, constants.IPAddressClaimedCondition, constants.WaitingForIPAddressReason, clusterv1.ConditionSeverityInfo, "")
+			l.Infof("waiting for an address from %s %s", demoMachine.Spec.IPAddressPool.Kind, demoMachine.Spec.IPAddressPool.Name)
+			return ctrl.Result{}, nil
+		}
+		conditions.MarkTrue(demoMachine, constants.IPAddressClaimedCondition)
+		staticAddress = address
+	}
+
+	kubernetesVersion := machineKubernetesVersion(machine)
+	if metalNode != nil && metalNode.IsReady() && !metalNode.Status.Bootstrapped && metalNodeInitializedFor(metalNode, kubernetesVersion) {
+		if err := setMetalNodeStaticAddress(metalNode, staticAddress); err != nil {
+			return ctrl.Result{}, err
+		}
+		metalNode.Status.DataSecretName = dataSecretName
+		if err := r.reconcileBootstrapPower(ctx, demoMachine, metalNode, l); err != nil {
+			return ctrl.Result{}, err
+		}
 		// set condition mark initialized successbootstrapped
 		l.Info("MetalNode initialized success! Waiting for metalNode bootstrap...")
 		conditions.MarkTrue(demoMachine, constants.MetalNodeReadyCondition)
-		conditions.MarkFalse(demoMachine, constants.BootstrapSucceededCondition, constants.WaitingForMetalNodeBootstrapReason, clusterv1.ConditionSeverityInfo, "")
+		if conditions.GetReason(demoMachine, constants.BootstrapSucceededCondition) != constants.MetalNodePowerCycledReason {
+			conditions.MarkFalse(demoMachine, constants.BootstrapSucceededCondition, constants.WaitingForMetalNodeBootstrapReason, clusterv1.ConditionSeverityInfo, "")
+		}
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	// the metal node bound to the machine is being reinitialized for its Kubernetes version
+	if metalNode != nil && !metalNode.Status.Bootstrapped && metalNodeReinitializing(metalNode) {
+		conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.WaitingForMetalNodeReadyReason, clusterv1.ConditionSeverityInfo,
+			"metal node %s is being reinitialized for Kubernetes %s", metalNode.Name, metalNode.GetAnnotations()[infrav1.MetalNodeRequestedKubernetesVersionAnnotation])
+		l.Infof("waiting for metal node %s to be reinitialized...", metalNode.Name)
 		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 	}
 
@@ -282,60 +603,206 @@ func (r *DemoMachineReconciler) reconcileNormal(ctx context.Context, machine *cl
 		return ctrl.Result{}, err
 	}
 
-	// todo
-	// at this time the metalNode is initialized(kubeadm docker ...) ,version 1.23.6
-	// but it needs to be initialized according to the specified version(machine.Spec.Version) in the future
-	// then set owner-reference and  re-enqueue
-	for _, node := range metalNodeList.Items {
-		// filter metalNode exclude not ready and already bootstrapped
-		if node.Status.Bootstrapped || !node.IsReady() {
+	quotas, err := getQuotaUsages(ctx, r.Client, demoCluster.Namespace, metalNodeList.Items)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to get the metal node quotas")
+	}
+
+	// the free metal nodes are handed out along the provisioning queue of the namespace, a machine claims one only if
+	// there are enough of them left for the machines ahead of it. The control plane machines claiming their reserved
+	// metal nodes are not queued.
+	claimsFreeNodes := role == constants.WorkerNodeRoleValue || feature.Gates.Enabled(feature.HAControlPlaneEndpoint) ||
+		demoCluster.Spec.LoadBalancer != nil || demoCluster.Spec.VirtualIP != nil
+	var position, free int32
+	queuedAt := metav1.Now()
+	if demoMachine.Status.Queue != nil {
+		queuedAt = demoMachine.Status.Queue.QueuedAt
+	}
+	if claimsFreeNodes {
+		queue, err := getProvisioningQueue(ctx, r.Client, demoMachine.Namespace, r.WatchFilterValue)
+		if err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "failed to get the provisioning queue")
+		}
+		queue = queue.add(&queueEntry{name: demoMachine.Name, cluster: cluster.Name, priority: demoCluster.Spec.Priority, queuedAt: queuedAt})
+		position = queue.position(demoMachine.Name)
+		for i := range metalNodeList.Items {
+			if metalNodeFree(&metalNodeList.Items[i]) {
+				free++
+			}
+		}
+	}
+
+	// the metal nodes pre-staged for the Kubernetes version of the machine are claimed first, then the ones that can be taken
+	// for any version, the others are reinitialized once claimed
+	sortMetalNodesForVersion(metalNodeList.Items, kubernetesVersion)
+	var freeNode *metav1beta1.MetalNode
+	var quotaExceeded string
+	var waitingInQueue bool
+	// claimsFreeNode is set when the metal node picked is a free one, which the quotas count
+	var claimsFreeNode bool
+	// a previous claim of the machine got its metal node updated but not its label
+	if metalNode = getClaimedMetalNode(metalNodeList.Items, demoMachine); metalNode != nil && metalNode.GetRefCluster() == "" {
+		metalNode.SetRole(role)
+		metalNode.Status.RefCluster = cluster.Name
+		claimsFreeNode = true
+	}
+	for i := range metalNodeList.Items {
+		if metalNode != nil {
+			break
+		}
+		node := &metalNodeList.Items[i]
+		// filter metalNode exclude not ready unless powered off when released, already bootstrapped and claimed by another demoMachine
+		// and waiting for its status to be restored after a clusterctl move, or in maintenance
+		if node.Status.Bootstrapped || (!node.IsReady() && !metalNodePoweredOff(node)) || metalNodeClaimedBy(node) != "" || metalNodeStatusLost(node) || metalNodeReprovisioning(node) ||
+			metalNodeCordoned(node) {
 			continue
 		}
+		// the free metal nodes are claimed within the quotas of the namespace
+		if node.GetRefCluster() == "" {
+			if admitted, message := admitMetalNode(quotas, cluster.Name, node); !admitted {
+				quotaExceeded = message
+				continue
+			}
+			if position > free {
+				waitingInQueue = true
+				continue
+			}
+		}
 		// First find the node that has been set to the control-plane role when demoCluster reconcile
-		if role == constants.ControlPlaneNodeRoleValue && node.ContainRole(role) && node.GetRefCluster() == demoCluster.Name {
-			metalNode = &node
-			// todo in the future, we need to init this node
+		if role == constants.ControlPlaneNodeRoleValue && node.ContainRole(role) && metalNodeRefersTo(node, cluster.Name, demoCluster.Name) {
+			metalNode = node
 			break
 		}
 		// Then find a node which is not set to any role
 		if role == constants.WorkerNodeRoleValue && !node.ContainRole(role) && node.GetRefCluster() == "" {
-			metalNode = &node
+			metalNode = node
 			// only set role once
 			metalNode.SetRole(role)
-			metalNode.Status.RefCluster = demoCluster.Name
-			// todo in the future, we need to init this node
+			metalNode.Status.RefCluster = cluster.Name
+			claimsFreeNode = true
 			break
 		}
+		// a highly available control plane, or one behind the load balancer or a virtual IP, has more control plane machines than reserved nodes,
+		// remember a free node for them
+		if role == constants.ControlPlaneNodeRoleValue && freeNode == nil &&
+			(feature.Gates.Enabled(feature.HAControlPlaneEndpoint) || demoCluster.Spec.LoadBalancer != nil || demoCluster.Spec.VirtualIP != nil) &&
+			!node.ContainRole(constants.WorkerNodeRoleValue) && node.GetRefCluster() == "" {
+			freeNode = node
+		}
+	}
+	if metalNode == nil && freeNode != nil {
+		metalNode = freeNode
+		metalNode.SetRole(role)
+		metalNode.Status.RefCluster = cluster.Name
+		claimsFreeNode = true
+	}
+	if metalNode == nil && waitingInQueue {
+		demoMachine.Status.Queue = &infrav1.DemoMachineQueueStatus{Position: position, QueuedAt: queuedAt}
+		conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.WaitingInQueueReason, clusterv1.ConditionSeverityInfo,
+			"position %d in the provisioning queue, %d free metal nodes are left for the machines ahead", position, free)
+		l.Infof("waiting at position %d in the provisioning queue of namespace %s", position, demoMachine.Namespace)
+		return ctrl.Result{RequeueAfter: provisioningQueueRequeueInterval}, nil
+	}
+	// the machines that can not claim a metal node within the quotas leave the queue, the pool is not what they wait for
+	demoMachine.Status.Queue = nil
+	if metalNode == nil && quotaExceeded != "" {
+		conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.QuotaExceededReason, clusterv1.ConditionSeverityWarning, quotaExceeded)
+		l.Warnf("no metal node can be claimed for cluster %s within the metal node quotas: %s", cluster.Name, quotaExceeded)
+		return ctrl.Result{}, nil
+	}
+	// if no metalNode found, wait in the queue and preempt a machine of a lower priority cluster when enabled, or return
+	if metalNode == nil && claimsFreeNodes {
+		demoMachine.Status.Queue = &infrav1.DemoMachineQueueStatus{Position: position, QueuedAt: queuedAt}
+	}
+	if metalNode == nil && preemptionEnabled(demoCluster) {
+		return ctrl.Result{RequeueAfter: provisioningQueueRequeueInterval}, r.preemptMachine(ctx, cluster, demoMachine, demoCluster, metalNodeList.Items, l)
 	}
-	// if no metalNode found, return
 	if metalNode == nil {
 		conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.NoMetalNodeFoundReason, clusterv1.ConditionSeverityWarning, "no metal node found")
 		l.Errorf("no metal node eligible for cluster %s, please check the status and number of metal node", demoCluster.Name)
+		if demoMachine.Status.Queue != nil {
+			return ctrl.Result{RequeueAfter: provisioningQueueRequeueInterval}, nil
+		}
 		return ctrl.Result{}, nil
 	}
 
-	// Set the demoMachine label.
-	labels[infrav1.MetalNodeLabelName] = metalNode.Name
+	// the quotas were checked against the cached metal nodes, the claims of free metal nodes are checked again against the
+	// live ones one at a time, so that concurrent claims can not exceed the quotas
+	if claimsFreeNode && len(quotas) > 0 {
+		r.quotaClaims.Lock()
+		defer r.quotaClaims.Unlock()
+		admitted, message, err := r.admitMetalNodeLive(ctx, cluster, metalNode)
+		if err != nil {
+			metalNode = nil
+			return ctrl.Result{}, errors.Wrap(err, "failed to check the metal node quotas")
+		}
+		if !admitted {
+			metalNode = nil
+			conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.QuotaExceededReason, clusterv1.ConditionSeverityWarning, message)
+			l.Warnf("no metal node can be claimed for cluster %s within the metal node quotas: %s", cluster.Name, message)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// Claim the metal node, the update is made with the resource version the metal node was picked at,
+	// it fails if another demo machine claimed the metal node meanwhile.
+	claimMetalNode(metalNode, demoMachine)
+	if err := setMetalNodeStaticAddress(metalNode, staticAddress); err != nil {
+		metalNode = nil
+		return ctrl.Result{}, err
+	}
+	if !metalNodeInitializedFor(metalNode, kubernetesVersion) {
+		requestKubernetesVersion(metalNode, kubernetesVersion)
+		l.Infof("metal node %s is initialized for Kubernetes %s, reinitializing it for %s", metalNode.Name,
+			metalNode.GetAnnotations()[infrav1.MetalNodeKubernetesVersionAnnotation], kubernetesVersion)
+	}
+	claimed := metalNode
+	metalNode = nil
+	if err := metalnode.Update(ctx, r.Client, claimed); err != nil {
+		if apierrors.IsConflict(err) {
+			l.Infof("metal node %s changed while the machine claimed it, retrying", claimed.Name)
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, errors.Wrapf(err, "failed to claim metal node %s", claimed.Name)
+	}
+
+	// Set the demoMachine label once the metal node is claimed
+	labels[infrav1.MetalNodeLabelName] = claimed.Name
 	demoMachine.SetLabels(labels)
 
 	conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.WaitingForMetalNodeReadyReason, clusterv1.ConditionSeverityInfo, "")
-	l.With("metalNode", metalNode.Name).With("metalNodeRole", metalNode.Status.Role).Info("waiting for the metalNode to be initialized...")
+	l.With("metalNode", claimed.Name).With("metalNodeRole", claimed.Status.Role).Info("waiting for the metalNode to be initialized...")
 	// Always requeue after 10 seconds,when it's a new metalNode
 	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 }
 
-// setMachineAddress gets the address from the metal node .spec.NodeEndPoint.Host and sets it on the Machine object.
-func setMachineAddress(demoMachine *infrav1.DemoMachine, metalNode *metav1beta1.MetalNode) {
-	demoMachine.Status.Addresses = []clusterv1.MachineAddress{
-		{
-			Type:    clusterv1.MachineHostName,
-			Address: metalNode.Name,
-		},
-		{
-			Type:    clusterv1.MachineExternalIP,
-			Address: metalNode.Spec.NodeEndPoint.Host,
-		},
+// setMachineAddress sets the addresses of the metal node on the Machine object, falling back to the
+// metal node .spec.NodeEndPoint.Host when its networks can not be read.
+func setMachineAddress(demoMachine *infrav1.DemoMachine, metalNode *metav1beta1.MetalNode) error {
+	addresses, err := metalNodeAddresses(metalNode)
+	if err != nil {
+		demoMachine.Status.Addresses = []clusterv1.MachineAddress{
+			{
+				Type:    clusterv1.MachineHostName,
+				Address: metalNode.Name,
+			},
+			{
+				Type:    clusterv1.MachineExternalIP,
+				Address: metalNode.Spec.NodeEndPoint.Host,
+			},
+		}
+		return err
 	}
+	demoMachine.Status.Addresses = addresses
+	return nil
+}
+
+// setMachineFailure reports a terminal problem of the demoMachine, the Machine controller bubbles it up to the Machine.
+func setMachineFailure(demoMachine *infrav1.DemoMachine, message string) {
+	reason := capierrors.UpdateMachineError
+	demoMachine.Status.FailureReason = &reason
+	demoMachine.Status.FailureMessage = &message
+	demoMachine.Status.Ready = false
 }
 
 // patchDemoCluster will patch the DemoCluster
@@ -346,7 +813,7 @@ func patchDemoMachine(ctx context.Context, patchHelper *patch.Helper, demoMachin
 // getMetalNodes returns a list of metal nodes
 func (r *DemoMachineReconciler) getMetalNodes(ctx context.Context, demoCluster *infrav1.DemoCluster) (*metav1beta1.MetalNodeList, error) {
 	metalNodes := &metav1beta1.MetalNodeList{}
-	if err := r.Client.List(ctx, metalNodes, client.InNamespace(demoCluster.Namespace)); err != nil {
+	if err := r.Client.List(ctx, metalNodes, watchFilterListOptions(demoCluster.Namespace, r.WatchFilterValue)...); err != nil {
 		return nil, err
 	}
 	if len(metalNodes.Items) == 0 {
@@ -355,6 +822,56 @@ func (r *DemoMachineReconciler) getMetalNodes(ctx context.Context, demoCluster *
 	return metalNodes, nil
 }
 
+// admitMetalNodeLive tells whether the quotas of the namespace let a cluster claim a free metal node, reading the quotas,
+// the clusters and the metal nodes from the API server rather than from the cache. The message tells why not.
+func (r *DemoMachineReconciler) admitMetalNodeLive(ctx context.Context, cluster *clusterv1.Cluster, metalNode *metav1beta1.MetalNode) (bool, string, error) {
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := r.APIReader.List(ctx, metalNodeList, watchFilterListOptions(cluster.Namespace, r.WatchFilterValue)...); err != nil {
+		return false, "", err
+	}
+	quotas, err := getQuotaUsages(ctx, r.APIReader, cluster.Namespace, metalNodeList.Items)
+	if err != nil {
+		return false, "", err
+	}
+	admitted, message := admitMetalNode(quotas, cluster.Name, metalNode)
+	return admitted, message, nil
+}
+
+// getClaimedMetalNode returns the metal node claimed by the demo machine, nil if none is
+func getClaimedMetalNode(metalNodes []metav1beta1.MetalNode, demoMachine *infrav1.DemoMachine) *metav1beta1.MetalNode {
+	for i := range metalNodes {
+		if metalNodeClaimedBy(&metalNodes[i]) == demoMachine.Name {
+			return &metalNodes[i]
+		}
+	}
+	return nil
+}
+
+// watchFilterListOptions returns the list options selecting the objects of a namespace,
+// restricted to the objects carrying the watch filter label when a watch filter value is set
+func watchFilterListOptions(namespace, watchFilterValue string) []client.ListOption {
+	opts := []client.ListOption{client.InNamespace(namespace)}
+	if watchFilterValue != "" {
+		opts = append(opts, client.MatchingLabels{clusterv1.WatchLabel: watchFilterValue})
+	}
+	return opts
+}
+
+// metalNodeProviderID returns the provider id of the machine hosted by the metal node
+func metalNodeProviderID(metalNode *metav1beta1.MetalNode) string {
+	return infrav1.ProviderIDPrefix + string(metalNode.UID)
+}
+
+// migrateProviderID returns the provider id of a machine in the current form, and whether it was in an older one.
+// The demo machines bootstrapped before the provider ids were prefixed hold the bare metal node uid, which Cluster API
+// does not accept to match the Node of the machine.
+func migrateProviderID(providerID string) (string, bool) {
+	if providerID == "" || strings.Contains(providerID, "://") {
+		return providerID, false
+	}
+	return infrav1.ProviderIDPrefix + providerID, true
+}
+
 // getMetalNodeReference returns the metal node reference of the demo machine
 func getMetalNodeReference(metalNode metav1beta1.MetalNode) metav1.OwnerReference {
 	return metav1.OwnerReference{
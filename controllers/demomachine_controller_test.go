@@ -0,0 +1,937 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/feature"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+)
+
+// machineFlow describes a DemoMachine provisioning flow
+type machineFlow struct {
+	// controlPlane provisions a control plane machine on the metal node of the control plane endpoint
+	controlPlane bool
+	// profile is the timeline of the metal node hosting the machine
+	profile metalNodeProfile
+	// bootstrapped expects the machine to be ready and bootstrapped
+	bootstrapped bool
+	// condition and reason are expected on the machine when it is not bootstrapped
+	condition clusterv1.ConditionType
+	reason    string
+}
+
+var _ = Describe("DemoMachineReconciler", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+	})
+
+	// setupCluster creates a cluster whose control plane endpoint is set on a metal node with the given profile
+	setupCluster := func(profile metalNodeProfile) (*clusterv1.Cluster, *metav1beta1.MetalNode) {
+		endpointNode := createMetalNode(ctx, namespace, "10.0.1.1", profile)
+		cluster, demoCluster := createCluster(ctx, namespace)
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		return cluster, endpointNode
+	}
+
+	table.DescribeTable("provisioning flows",
+		func(flow machineFlow) {
+			endpointProfile := metalNodeProfile{}
+			if flow.controlPlane {
+				endpointProfile = flow.profile
+			}
+			cluster, metalNode := setupCluster(endpointProfile)
+			if !flow.controlPlane {
+				// created once the endpoint is set so that the DemoCluster does not pick it
+				metalNode = createMetalNode(ctx, namespace, "10.0.1.2", flow.profile)
+			}
+
+			_, demoMachine := createMachine(ctx, cluster, flow.controlPlane)
+
+			if flow.bootstrapped {
+				Eventually(func() bool {
+					return get(ctx, demoMachine)() == nil && demoMachine.Status.Ready && demoMachine.Status.Bootstrapped
+				}, timeout, interval).Should(BeTrue())
+
+				metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+				Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, metalNode.Name))
+				Expect(demoMachine.Spec.ProviderID).To(Equal(infrav1.ProviderIDPrefix + string(metalNode.UID)))
+				Expect(demoMachine.Status.Addresses).To(ContainElement(clusterv1.MachineAddress{
+					Type:    clusterv1.MachineExternalIP,
+					Address: metalNode.Spec.NodeEndPoint.Host,
+				}))
+				Expect(conditions.IsTrue(demoMachine, constants.BootstrapSucceededCondition)).To(BeTrue())
+				Expect(metalNode.Status.DataSecretName).NotTo(BeEmpty())
+				Expect(metalNode.GetRefCluster()).To(Equal(cluster.Name))
+				Expect(metalNode.GetAnnotations()).To(HaveKeyWithValue(infrav1.MetalNodeDemoMachineAnnotation, demoMachine.Name))
+				Expect(hasOwnerRef(metalNode, infrav1.GroupVersion.String(), "DemoMachine", demoMachine.Name)).To(BeFalse())
+				Expect(metalNode.GetLabels()).To(HaveKey(infrav1.ClusterctlMoveLabelName))
+				return
+			}
+
+			Eventually(func() string {
+				if err := get(ctx, demoMachine)(); err != nil {
+					return ""
+				}
+				return conditions.GetReason(demoMachine, flow.condition)
+			}, timeout, interval).Should(Equal(flow.reason))
+			Consistently(func() bool {
+				return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+			}, 3*time.Second, interval).Should(BeFalse())
+		},
+		table.Entry("worker machine claims a free metal node and bootstraps", machineFlow{
+			profile:      metalNodeProfile{ReadyAfter: time.Second, BootstrapAfter: time.Second},
+			bootstrapped: true,
+		}),
+		table.Entry("control plane machine bootstraps on the control plane endpoint metal node", machineFlow{
+			controlPlane: true,
+			profile:      metalNodeProfile{ReadyAfter: time.Second, BootstrapAfter: time.Second},
+			bootstrapped: true,
+		}),
+		table.Entry("worker machine waits when no metal node gets initialized", machineFlow{
+			profile:   metalNodeProfile{FailInitialization: true},
+			condition: constants.MetalNodeReadyCondition,
+			reason:    constants.NoMetalNodeFoundReason,
+		}),
+		table.Entry("worker machine waits when the metal node bootstrap times out", machineFlow{
+			profile:   metalNodeProfile{FailBootstrap: true},
+			condition: constants.BootstrapSucceededCondition,
+			reason:    constants.WaitingForMetalNodeBootstrapReason,
+		}),
+		table.Entry("control plane machine waits when the metal node bootstrap times out", machineFlow{
+			controlPlane: true,
+			profile:      metalNodeProfile{FailBootstrap: true},
+			condition:    constants.BootstrapSucceededCondition,
+			reason:       constants.WaitingForMetalNodeBootstrapReason,
+		}),
+	)
+
+	It("reports the addresses of the metal node networks", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.4", metalNodeProfile{})
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeNetworksAnnotation, `[
+			{"name":"management","addresses":["10.0.1.4","fd00:1::4"],"dnsNames":["node4.cluster.local"]},
+			{"name":"public","role":"external","addresses":["203.0.113.4"],"dnsNames":["node4.example.com"]}
+		]`)
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoMachine.Status.Addresses).To(Equal([]clusterv1.MachineAddress{
+			{Type: clusterv1.MachineHostName, Address: metalNode.Name},
+			{Type: clusterv1.MachineInternalIP, Address: "10.0.1.4"},
+			{Type: clusterv1.MachineInternalIP, Address: "fd00:1::4"},
+			{Type: clusterv1.MachineInternalDNS, Address: "node4.cluster.local"},
+			{Type: clusterv1.MachineExternalIP, Address: "203.0.113.4"},
+			{Type: clusterv1.MachineExternalDNS, Address: "node4.example.com"},
+		}))
+	})
+
+	It("releases the metal node when deleted", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.3", metalNodeProfile{})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		Expect(k8sClient.Delete(ctx, demoMachine)).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, demoMachine)())
+		}, timeout, interval).Should(BeTrue())
+
+		// the released metal node is cleaned and reinitialized before it can be claimed again
+		var reprovisioning *infrav1.MetalNodeReprovisioning
+		Eventually(func() (infrav1.ReprovisioningState, error) {
+			metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+			var err error
+			reprovisioning, err = metalnode.GetReprovisioning(metalNode)
+			if err != nil || reprovisioning == nil {
+				return "", err
+			}
+			return reprovisioning.State, nil
+		}, timeout, interval).Should(Equal(infrav1.AvailableState))
+		Expect(reprovisioning.Reprovisioned).To(BeEquivalentTo(1))
+		Expect(reprovisioning.Conditions).To(HaveLen(2))
+		for _, condition := range reprovisioning.Conditions {
+			Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+		}
+		Expect(metalNode.IsReady()).To(BeTrue())
+		Expect(metalNode.GetRefCluster()).To(BeEmpty())
+		Expect(metalNode.Status.Bootstrapped).To(BeFalse())
+		Expect(metalNode.Status.DataSecretName).To(BeEmpty())
+		Expect(hasOwnerRef(metalNode, infrav1.GroupVersion.String(), "DemoMachine", demoMachine.Name)).To(BeFalse())
+		Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeStatusAnnotation))
+		// the metal node controller was asked to initialize the cleaned metal node again
+		Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeReinitializeAnnotation))
+		Expect(metalNodeAgent.Reinitializations(metalNode.Name)).To(Equal(1))
+	})
+
+	It("waits for the metal node controller to initialize a cleaned metal node again", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.61", metalNodeProfile{})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		// the metal node stays ready, only the reinitialization the provider asked for completes the reprovisioning
+		metalNodeAgent.SetProfile(metalNode.Name, metalNodeProfile{FailInitialization: true})
+		Expect(k8sClient.Delete(ctx, demoMachine)).To(Succeed())
+		Eventually(func() map[string]string {
+			return getMetalNode(ctx, namespace, metalNode.Name).GetAnnotations()
+		}, timeout, interval).Should(HaveKey(infrav1.MetalNodeReinitializeAnnotation))
+		Expect(getMetalNode(ctx, namespace, metalNode.Name).IsReady()).To(BeTrue())
+		Consistently(func() (infrav1.ReprovisioningState, error) {
+			reprovisioning, err := metalnode.GetReprovisioning(getMetalNode(ctx, namespace, metalNode.Name))
+			if err != nil || reprovisioning == nil {
+				return "", err
+			}
+			return reprovisioning.State, nil
+		}, 2*time.Second, interval).Should(Equal(infrav1.ReinitializingState))
+
+		metalNodeAgent.SetProfile(metalNode.Name, metalNodeProfile{})
+		annotateMetalNode(ctx, metalNode, "test", "resync")
+		Eventually(func() (infrav1.ReprovisioningState, error) {
+			reprovisioning, err := metalnode.GetReprovisioning(getMetalNode(ctx, namespace, metalNode.Name))
+			if err != nil || reprovisioning == nil {
+				return "", err
+			}
+			return reprovisioning.State, nil
+		}, timeout, interval).Should(Equal(infrav1.AvailableState))
+		Expect(metalNodeAgent.Reinitializations(metalNode.Name)).To(Equal(1))
+	})
+
+	It("keeps a metal node that fails to be cleaned out of the pool", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.60", metalNodeProfile{})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		metalNodeAgent.SetProfile(metalNode.Name, metalNodeProfile{FailBootstrap: true})
+		Expect(k8sClient.Delete(ctx, demoMachine)).To(Succeed())
+		Eventually(func() (infrav1.ReprovisioningState, error) {
+			reprovisioning, err := metalnode.GetReprovisioning(getMetalNode(ctx, namespace, metalNode.Name))
+			if err != nil || reprovisioning == nil {
+				return "", err
+			}
+			return reprovisioning.State, nil
+		}, timeout, interval).Should(Equal(infrav1.FailedState))
+
+		// a new machine does not claim the failed metal node
+		_, demoMachine = createMachine(ctx, cluster, false)
+		Consistently(func() string {
+			Expect(get(ctx, demoMachine)()).To(Succeed())
+			return demoMachine.Spec.ProviderID
+		}, 2*time.Second, interval).Should(BeEmpty())
+	})
+
+	table.DescribeTable("bootstrap data formats",
+		func(format, value string, translated bool) {
+			cluster, _ := setupCluster(metalNodeProfile{})
+			metalNode := createMetalNode(ctx, namespace, "10.0.1.80", metalNodeProfile{})
+
+			_, demoMachine := createMachineWithBootstrapData(ctx, cluster, false, format, value)
+			Eventually(func() bool {
+				return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+			}, timeout, interval).Should(BeTrue())
+			Expect(conditions.IsTrue(demoMachine, constants.BootstrapDataAvailableCondition)).To(BeTrue())
+
+			// the metal node runs the cloud-config translated from a shell script, and an Ignition config as is
+			if translated {
+				Expect(metalNodeAgent.BootstrapData(metalNode.Name)).To(HavePrefix("#cloud-config\n"))
+			} else {
+				Expect(metalNodeAgent.BootstrapData(metalNode.Name)).To(Equal(value))
+			}
+		},
+		table.Entry("raw shell", "shell", "#!/bin/bash\nkubeadm join --config /run/kubeadm/kubeadm-join-config.yaml\n", true),
+		table.Entry("Ignition", "ignition", `{"ignition": {"version": "2.3.0"}, "systemd": {"units": [{"name": "kubeadm.service", "enabled": true, "contents": "[Service]\nExecStart=/usr/bin/kubeadm join\n"}]}}`, false),
+	)
+
+	table.DescribeTable("invalid bootstrap data",
+		func(format, value, message string) {
+			cluster, _ := setupCluster(metalNodeProfile{})
+			createMetalNode(ctx, namespace, "10.0.1.81", metalNodeProfile{})
+
+			_, demoMachine := createMachineWithBootstrapData(ctx, cluster, false, format, value)
+			Eventually(func() string {
+				if err := get(ctx, demoMachine)(); err != nil {
+					return ""
+				}
+				return conditions.GetReason(demoMachine, constants.BootstrapDataAvailableCondition)
+			}, timeout, interval).Should(Equal(constants.BootstrapDataNotAvailableReason))
+			Expect(conditions.GetMessage(demoMachine, constants.BootstrapDataAvailableCondition)).To(Equal(
+				fmt.Sprintf("bootstrap data secret %s-bootstrap: %s", demoMachine.Name, message)))
+			Expect(conditions.GetSeverity(demoMachine, constants.BootstrapDataAvailableCondition)).To(HaveValue(Equal(clusterv1.ConditionSeverityError)))
+			// no metal node is claimed for bootstrap data it can not run
+			Expect(demoMachine.GetLabels()).NotTo(HaveKey(infrav1.MetalNodeLabelName))
+		},
+		table.Entry("unsupported format", "cloud-init", "#cloud-config\n",
+			`unsupported bootstrap data format "cloud-init", the format must be one of cloud-config, ignition or shell`),
+		table.Entry("cloud-config without its header", "cloud-config", "runcmd:\n- kubeadm init\n",
+			`invalid cloud-config: it must start with "#cloud-config"`),
+		table.Entry("shell script without a shebang", "shell", "kubeadm init\n",
+			"invalid shell script: it must start with a shebang, e.g. #!/bin/bash"),
+		table.Entry("Ignition fetching remote contents", "ignition",
+			`{"ignition": {"version": "3.3.0"}, "storage": {"files": [{"path": "/etc/kubeadm.sh", "contents": {"source": "https://example.com/kubeadm.sh"}}]}}`,
+			"unsupported Ignition config: file /etc/kubeadm.sh: the contents are fetched from a https source, only data URLs are supported"),
+		table.Entry("Ignition partitioning a disk", "ignition",
+			`{"ignition": {"version": "3.3.0"}, "storage": {"disks": [{"device": "/dev/sdb", "wipeTable": true}]}}`,
+			"unsupported Ignition config: storage.disks is not supported"),
+	)
+
+	It("merges the bootstrap extensions of the cluster into the bootstrap data", func() {
+		Expect(k8sClient.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "ntp", Namespace: namespace},
+			Data:       map[string]string{"value": "#cloud-config\nntp:\n  servers:\n  - ntp.example.com\nruncmd:\n- systemctl restart chronyd\n"},
+		})).To(Succeed())
+		Expect(k8sClient.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubelet", Namespace: namespace},
+			Data:       map[string][]byte{"cloud-config": []byte("#cloud-config\nwrite_files:\n- path: /etc/default/kubelet\n  content: KUBELET_EXTRA_ARGS=--cgroup-driver=systemd\n")},
+		})).To(Succeed())
+
+		createMetalNode(ctx, namespace, "10.0.1.1", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace, func(_ *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.BootstrapExtensions = []infrav1.DemoBootstrapExtension{
+				{Kind: "ConfigMap", Name: "ntp"},
+				{Kind: "Secret", Name: "kubelet", Key: "cloud-config"},
+			}
+		})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.82", metalNodeProfile{})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		Expect(metalNodeAgent.BootstrapData(metalNode.Name)).To(Equal(`#cloud-config
+ntp:
+  servers:
+  - ntp.example.com
+runcmd:
+- systemctl restart chronyd
+- kubeadm join --config /run/kubeadm/kubeadm-join-config.yaml
+write_files:
+- content: KUBELET_EXTRA_ARGS=--cgroup-driver=systemd
+  path: /etc/default/kubelet
+`))
+	})
+
+	It("hands a short-lived copy of the bootstrap data to the metal node", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.84", metalNodeProfile{BootstrapAfter: 3 * time.Second})
+
+		machine, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() string {
+			return getMetalNode(ctx, namespace, metalNode.Name).Status.DataSecretName
+		}, timeout, interval).Should(Equal(cloudConfigSecretName(demoMachine)))
+		Expect(cloudConfigSecretName(demoMachine)).NotTo(Equal(*machine.Spec.Bootstrap.DataSecretName))
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cloudConfigSecretName(demoMachine)}, secret)).To(Succeed())
+		Expect(hasOwnerRef(secret, infrav1.GroupVersion.String(), "DemoMachine", demoMachine.Name)).To(BeTrue())
+		Expect(string(secret.Data["value"])).To(Equal(defaultBootstrapData))
+
+		// the copy is deleted once the metal node is bootstrapped
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(metalNodeAgent.BootstrapData(metalNode.Name)).To(Equal(defaultBootstrapData))
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cloudConfigSecretName(demoMachine)}, secret))
+		}, timeout, interval).Should(BeTrue())
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: *machine.Spec.Bootstrap.DataSecretName}, &corev1.Secret{})).To(Succeed())
+	})
+
+	It("refuses bootstrap data not written by the bootstrap config of the machine", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		createMetalNode(ctx, namespace, "10.0.1.85", metalNodeProfile{})
+
+		_, demoMachine := createMachineWithBootstrapSecret(ctx, cluster, false, func(_ *clusterv1.Machine, secret *corev1.Secret) {
+			secret.OwnerReferences = nil
+			secret.Data = map[string][]byte{"value": []byte(defaultBootstrapData)}
+		})
+		Eventually(func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return conditions.GetMessage(demoMachine, constants.BootstrapDataAvailableCondition)
+		}, timeout, interval).Should(Equal(fmt.Sprintf("bootstrap data secret %s-bootstrap is not owned by the bootstrap config KubeadmConfig %s of the Machine", demoMachine.Name, demoMachine.Name)))
+		Expect(conditions.GetSeverity(demoMachine, constants.BootstrapDataAvailableCondition)).To(HaveValue(Equal(clusterv1.ConditionSeverityError)))
+		Expect(demoMachine.GetLabels()).NotTo(HaveKey(infrav1.MetalNodeLabelName))
+	})
+
+	It("waits for the bootstrap extensions of the cluster", func() {
+		createMetalNode(ctx, namespace, "10.0.1.1", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace, func(_ *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.BootstrapExtensions = []infrav1.DemoBootstrapExtension{{Kind: "ConfigMap", Name: "registry-mirrors"}}
+		})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		createMetalNode(ctx, namespace, "10.0.1.83", metalNodeProfile{})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return conditions.GetMessage(demoMachine, constants.BootstrapDataAvailableCondition)
+		}, timeout, interval).Should(Equal("bootstrap extension ConfigMap registry-mirrors is not found"))
+		Expect(conditions.GetReason(demoMachine, constants.BootstrapSucceededCondition)).To(Equal(constants.BootstrapDataNotAvailableReason))
+
+		Expect(k8sClient.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry-mirrors", Namespace: namespace},
+			Data:       map[string]string{"value": "#cloud-config\nwrite_files:\n- path: /etc/containerd/certs.d/docker.io/hosts.toml\n  content: server = \"https://registry.example.com\"\n"},
+		})).To(Succeed())
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("powers on the metal node handed the bootstrap data, and power-cycles it once when the bootstrap is stuck", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.70", metalNodeProfile{FailBootstrap: true})
+		bmc := createBMC(ctx, metalNode)
+		defer bmc.Close()
+		bmc.SetPowerState("Off")
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(bmc.Resets, timeout, interval).Should(Equal([]string{"On", "ForceRestart"}))
+		Eventually(func() string {
+			Expect(get(ctx, demoMachine)()).To(Succeed())
+			return conditions.GetReason(demoMachine, constants.BootstrapSucceededCondition)
+		}, timeout, interval).Should(Equal(constants.MetalNodePowerCycledReason))
+		Consistently(bmc.Resets, 4*time.Second, interval).Should(HaveLen(2))
+	})
+
+	It("powers off the metal node when deleted", func() {
+		Expect(feature.MutableGates.Set(fmt.Sprintf("%s=false", feature.MetalNodeReprovisioning))).To(Succeed())
+		defer func() {
+			Expect(feature.MutableGates.Set(fmt.Sprintf("%s=true", feature.MetalNodeReprovisioning))).To(Succeed())
+		}()
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.71", metalNodeProfile{})
+		bmc := createBMC(ctx, metalNode)
+		defer bmc.Close()
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(bmc.Resets()).To(BeEmpty())
+
+		Expect(k8sClient.Delete(ctx, demoMachine)).To(Succeed())
+		Eventually(bmc.PowerState, timeout, interval).Should(Equal("Off"))
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, demoMachine)())
+		}, timeout, interval).Should(BeTrue())
+		Expect(getMetalNode(ctx, namespace, metalNode.Name).GetRefCluster()).To(BeEmpty())
+	})
+
+	It("powers on the metal node powered off when released once another machine claims it", func() {
+		Expect(feature.MutableGates.Set(fmt.Sprintf("%s=false", feature.MetalNodeReprovisioning))).To(Succeed())
+		defer func() {
+			Expect(feature.MutableGates.Set(fmt.Sprintf("%s=true", feature.MetalNodeReprovisioning))).To(Succeed())
+		}()
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.72", metalNodeProfile{})
+		bmc := createBMC(ctx, metalNode)
+		defer bmc.Close()
+		metalNodeAgent.SetProfile(metalNode.Name, metalNodeProfile{PowerState: bmc.PowerState})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(k8sClient.Delete(ctx, demoMachine)).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, demoMachine)())
+		}, timeout, interval).Should(BeTrue())
+
+		// the released metal node is powered off by the controller, drops ready along with its host, and stays in the pool
+		Eventually(func() bool {
+			return getMetalNode(ctx, namespace, metalNode.Name).IsReady()
+		}, timeout, interval).Should(BeFalse())
+		Expect(getMetalNode(ctx, namespace, metalNode.Name).GetAnnotations()).To(HaveKey(infrav1.MetalNodePoweredOffAnnotation))
+		Expect(getMetalNode(ctx, namespace, metalNode.Name).GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodePowerOffAnnotation))
+
+		_, demoMachine = createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, metalNode.Name))
+		Expect(bmc.PowerState()).To(Equal("On"))
+		Expect(getMetalNode(ctx, namespace, metalNode.Name).GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodePoweredOffAnnotation))
+	})
+
+	It("claims the metal node pre-staged for the Kubernetes version of the machine first", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		outdated := createMetalNode(ctx, namespace, "10.0.1.90", metalNodeProfile{})
+		annotateMetalNode(ctx, outdated, infrav1.MetalNodeKubernetesVersionAnnotation, "v1.23.6")
+		createMetalNode(ctx, namespace, "10.0.1.91", metalNodeProfile{})
+		preStaged := createMetalNode(ctx, namespace, "10.0.1.92", metalNodeProfile{})
+		annotateMetalNode(ctx, preStaged, infrav1.MetalNodeKubernetesVersionAnnotation, "v1.24.1")
+
+		_, demoMachine := createMachineWithVersion(ctx, cluster, false, "v1.24.1")
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, preStaged.Name))
+		Expect(getMetalNode(ctx, namespace, preStaged.Name).GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeRequestedKubernetesVersionAnnotation))
+	})
+
+	It("reinitializes a metal node initialized for another Kubernetes version before bootstrapping it", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.93", metalNodeProfile{})
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeKubernetesVersionAnnotation, "v1.23.6")
+
+		_, demoMachine := createMachineWithVersion(ctx, cluster, false, "v1.24.1")
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, metalNode.Name))
+		metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+		Expect(metalNode.GetAnnotations()).To(HaveKeyWithValue(infrav1.MetalNodeRequestedKubernetesVersionAnnotation, "v1.24.1"))
+		Expect(metalNode.GetAnnotations()).To(HaveKeyWithValue(infrav1.MetalNodeKubernetesVersionAnnotation, "v1.24.1"))
+	})
+
+	It("waits for a metal node being reinitialized", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.94", metalNodeProfile{})
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeKubernetesVersionAnnotation, "v1.23.6")
+		Eventually(func() bool {
+			return getMetalNode(ctx, namespace, metalNode.Name).IsReady()
+		}, timeout, interval).Should(BeTrue())
+		// the metal node fails to come back once reinitialized
+		metalNodeAgent.SetProfile(metalNode.Name, metalNodeProfile{FailInitialization: true})
+
+		_, demoMachine := createMachineWithVersion(ctx, cluster, false, "v1.24.1")
+		Eventually(func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return conditions.GetMessage(demoMachine, constants.MetalNodeReadyCondition)
+		}, timeout, interval).Should(Equal(fmt.Sprintf("metal node %s is being reinitialized for Kubernetes v1.24.1", metalNode.Name)))
+		// a free metal node the machine must not claim meanwhile
+		createMetalNode(ctx, namespace, "10.0.1.95", metalNodeProfile{})
+		Consistently(func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return demoMachine.GetLabels()[infrav1.MetalNodeLabelName]
+		}, 2*time.Second, interval).Should(Equal(metalNode.Name))
+		Expect(demoMachine.Status.Bootstrapped).To(BeFalse())
+	})
+
+	It("binds a metal node to a single machine when machines claim it at once", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.102", metalNodeProfile{FailBootstrap: true})
+
+		var demoMachines []*infrav1.DemoMachine
+		for i := 0; i < 3; i++ {
+			_, demoMachine := createMachine(ctx, cluster, false)
+			demoMachines = append(demoMachines, demoMachine)
+		}
+		Eventually(func() string {
+			return getMetalNode(ctx, namespace, metalNode.Name).GetAnnotations()[infrav1.MetalNodeDemoMachineAnnotation]
+		}, timeout, interval).ShouldNot(BeEmpty())
+		claimedBy := getMetalNode(ctx, namespace, metalNode.Name).GetAnnotations()[infrav1.MetalNodeDemoMachineAnnotation]
+
+		Consistently(func() []string {
+			var bound []string
+			for _, demoMachine := range demoMachines {
+				if get(ctx, demoMachine)() == nil && demoMachine.GetLabels()[infrav1.MetalNodeLabelName] == metalNode.Name {
+					bound = append(bound, demoMachine.Name)
+				}
+			}
+			return bound
+		}, 3*time.Second, interval).Should(Or(BeEmpty(), Equal([]string{claimedBy})))
+		Eventually(func() string {
+			for _, demoMachine := range demoMachines {
+				if demoMachine.Name == claimedBy && get(ctx, demoMachine)() == nil {
+					return demoMachine.GetLabels()[infrav1.MetalNodeLabelName]
+				}
+			}
+			return ""
+		}, timeout, interval).Should(Equal(metalNode.Name))
+	})
+
+	It("does not claim a metal node in maintenance", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.100", metalNodeProfile{})
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeMaintenanceAnnotation, string(infrav1.CordonMaintenanceMode))
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoMachine, constants.MetalNodeReadyCondition)
+		}, timeout, interval).Should(Equal(constants.NoMetalNodeFoundReason))
+
+		// the metal node returns to the pool once out of maintenance
+		Eventually(func() error {
+			if err := get(ctx, metalNode)(); err != nil {
+				return err
+			}
+			delete(metalNode.Annotations, infrav1.MetalNodeMaintenanceAnnotation)
+			return k8sClient.Update(ctx, metalNode)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, metalNode.Name))
+	})
+
+	It("deletes the machine hosted on an evacuated metal node so that its controller replaces it", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.101", metalNodeProfile{})
+
+		machine, demoMachine := createMachineWithBootstrapSecret(ctx, cluster, false, func(machine *clusterv1.Machine, secret *corev1.Secret) {
+			controller := true
+			machine.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: clusterv1.GroupVersion.String(),
+				Kind:       "MachineSet",
+				Name:       "workers",
+				UID:        "workers",
+				Controller: &controller,
+			}}
+			secret.Data = map[string][]byte{"value": []byte(defaultBootstrapData)}
+		})
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeMaintenanceAnnotation, string(infrav1.EvacuateMaintenanceMode))
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, machine)())
+		}, timeout, interval).Should(BeTrue())
+		Expect(get(ctx, demoMachine)()).To(Succeed())
+		Expect(conditions.GetReason(demoMachine, constants.MetalNodeReadyCondition)).To(Equal(constants.MetalNodeEvacuatedReason))
+	})
+
+	It("marks the control plane machine hosted on an evacuated metal node to be deleted first instead of deleting it", func() {
+		cluster, metalNode := setupCluster(metalNodeProfile{})
+
+		machine, demoMachine := createMachineWithBootstrapSecret(ctx, cluster, true, func(machine *clusterv1.Machine, secret *corev1.Secret) {
+			controller := true
+			machine.OwnerReferences = []metav1.OwnerReference{{
+				APIVersion: "controlplane.cluster.x-k8s.io/v1beta1",
+				Kind:       "KubeadmControlPlane",
+				Name:       "control-plane",
+				UID:        "control-plane",
+				Controller: &controller,
+			}}
+			secret.Data = map[string][]byte{"value": []byte(defaultBootstrapData)}
+		})
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeMaintenanceAnnotation, string(infrav1.EvacuateMaintenanceMode))
+		Eventually(func() map[string]string {
+			Expect(get(ctx, machine)()).To(Succeed())
+			return machine.GetAnnotations()
+		}, timeout, interval).Should(HaveKey(clusterv1.DeleteMachineAnnotation))
+		Consistently(func() bool {
+			return get(ctx, machine)() == nil && machine.DeletionTimestamp.IsZero()
+		}, 2*time.Second, interval).Should(BeTrue())
+		Expect(get(ctx, demoMachine)()).To(Succeed())
+		Expect(conditions.GetReason(demoMachine, constants.MetalNodeReadyCondition)).To(Equal(constants.MetalNodeEvacuatedReason))
+		Expect(conditions.GetMessage(demoMachine, constants.MetalNodeReadyCondition)).To(ContainSubstring("not deleted from under its KubeadmControlPlane control-plane"))
+	})
+
+	It("keeps the machine without a controller hosted on an evacuated metal node", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.102", metalNodeProfile{})
+
+		machine, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		annotateMetalNode(ctx, metalNode, infrav1.MetalNodeMaintenanceAnnotation, string(infrav1.EvacuateMaintenanceMode))
+		Eventually(func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoMachine, constants.MetalNodeReadyCondition)
+		}, timeout, interval).Should(Equal(constants.MetalNodeEvacuatedReason))
+		Expect(conditions.GetSeverity(demoMachine, constants.MetalNodeReadyCondition)).To(HaveValue(Equal(clusterv1.ConditionSeverityWarning)))
+		Expect(get(ctx, machine)()).To(Succeed())
+	})
+
+	// setupPriorityClusters creates a lower priority cluster running a worker of a MachineSet, and a higher priority cluster
+	// with the given preemption policy, no metal node is left free
+	setupPriorityClusters := func(policy infrav1.PreemptionPolicy) (*clusterv1.Cluster, *clusterv1.Machine, *infrav1.DemoMachine, *clusterv1.MachineSet) {
+		createMetalNode(ctx, namespace, "10.0.4.1", metalNodeProfile{})
+		low, lowDemoCluster := createCluster(ctx, namespace)
+		Eventually(func() bool {
+			return get(ctx, lowDemoCluster)() == nil && lowDemoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		createMetalNode(ctx, namespace, "10.0.4.2", metalNodeProfile{})
+		high, highDemoCluster := createCluster(ctx, namespace, func(_ *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Spec.Priority = 100
+			demoCluster.Spec.PreemptionPolicy = policy
+		})
+		Eventually(func() bool {
+			return get(ctx, highDemoCluster)() == nil && highDemoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+
+		createMetalNode(ctx, namespace, "10.0.4.3", metalNodeProfile{})
+		machineSet := createMachineSet(ctx, low, 1)
+		victim, victimDemoMachine := createMachineOfMachineSet(ctx, low, machineSet)
+		Eventually(func() bool {
+			return get(ctx, victimDemoMachine)() == nil && victimDemoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		return high, victim, victimDemoMachine, machineSet
+	}
+
+	It("preempts a worker of a lower priority cluster when no metal node is free", func() {
+		high, victim, victimDemoMachine, machineSet := setupPriorityClusters(infrav1.PreemptLowerPriorityPreemptionPolicy)
+		// the Machine controller keeps the deleted machine until its demo machine is deleted
+		Eventually(func() error {
+			if err := get(ctx, victim)(); err != nil {
+				return err
+			}
+			victim.Finalizers = append(victim.Finalizers, clusterv1.MachineFinalizer)
+			return k8sClient.Update(ctx, victim)
+		}, timeout, interval).Should(Succeed())
+
+		_, demoMachine := createMachine(ctx, high, false)
+		Eventually(func() bool {
+			Expect(get(ctx, victim)()).To(Succeed())
+			return !victim.DeletionTimestamp.IsZero()
+		}, timeout, interval).Should(BeTrue())
+		Expect(victim.GetAnnotations()).To(HaveKeyWithValue(infrav1.PreemptedByAnnotation, demoMachine.Name))
+		Expect(get(ctx, machineSet)()).To(Succeed())
+		Expect(machineSet.Spec.Replicas).To(HaveValue(BeEquivalentTo(1)))
+		Eventually(findEvent(ctx, victim, constants.PreemptedReason), timeout, interval).Should(ContainSubstring(demoMachine.Name))
+		Eventually(findEvent(ctx, demoMachine, constants.PreemptingReason), timeout, interval).Should(ContainSubstring(victim.Name))
+		Expect(get(ctx, demoMachine)()).To(Succeed())
+		Expect(conditions.GetReason(demoMachine, constants.MetalNodeReadyCondition)).To(Equal(constants.PreemptingReason))
+
+		// the preempted machine is gone once its demo machine releases its metal node, which the waiting machine then claims
+		Expect(k8sClient.Delete(ctx, victimDemoMachine)).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, victimDemoMachine)())
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() error {
+			if err := get(ctx, victim)(); err != nil {
+				return err
+			}
+			victim.Finalizers = nil
+			return k8sClient.Update(ctx, victim)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("records the machine it would preempt in dry run", func() {
+		high, victim, victimDemoMachine, machineSet := setupPriorityClusters(infrav1.DryRunPreemptionPolicy)
+
+		_, demoMachine := createMachine(ctx, high, false)
+		Eventually(findEvent(ctx, demoMachine, constants.PreemptionDryRunReason), timeout, interval).Should(
+			Equal(fmt.Sprintf("Would preempt Machine %s of cluster %s with priority 0 on metal node %s, replaced by MachineSet %s once a metal node is free",
+				victim.Name, victim.Spec.ClusterName, victimDemoMachine.GetLabels()[infrav1.MetalNodeLabelName], machineSet.Name)))
+		Expect(get(ctx, victim)()).To(Succeed())
+		Expect(victim.GetAnnotations()).NotTo(HaveKey(infrav1.PreemptedByAnnotation))
+		Expect(get(ctx, machineSet)()).To(Succeed())
+		Expect(machineSet.Spec.Replicas).To(HaveValue(BeEquivalentTo(1)))
+
+		// the same decision made again when the machine is reconciled is not recorded again
+		Eventually(func() string {
+			Expect(get(ctx, demoMachine)()).To(Succeed())
+			return conditions.GetMessage(demoMachine, constants.MetalNodeReadyCondition)
+		}, timeout, interval).Should(ContainSubstring("would be preempted"))
+		Eventually(func() error {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return err
+			}
+			demoMachine.Annotations = map[string]string{"test": "requeue"}
+			return k8sClient.Update(ctx, demoMachine)
+		}, timeout, interval).Should(Succeed())
+		Consistently(func() int32 {
+			eventList := &corev1.EventList{}
+			Expect(k8sClient.List(ctx, eventList, client.InNamespace(namespace))).To(Succeed())
+			var count int32
+			for _, event := range eventList.Items {
+				if event.InvolvedObject.UID == demoMachine.UID && event.Reason == constants.PreemptionDryRunReason {
+					count += event.Count
+				}
+			}
+			return count
+		}, 3*time.Second, interval).Should(BeEquivalentTo(1))
+	})
+
+	It("does not preempt the protected workers", func() {
+		high, victim, _, machineSet := setupPriorityClusters(infrav1.PreemptLowerPriorityPreemptionPolicy)
+		Eventually(func() error {
+			if err := get(ctx, machineSet)(); err != nil {
+				return err
+			}
+			machineSet.Annotations = map[string]string{infrav1.PreemptionProtectedAnnotation: ""}
+			return k8sClient.Update(ctx, machineSet)
+		}, timeout, interval).Should(Succeed())
+
+		_, demoMachine := createMachine(ctx, high, false)
+		Eventually(findEvent(ctx, demoMachine, constants.NoPreemptionCandidateReason), timeout, interval).Should(
+			Equal("No Machine of a cluster of priority lower than 100 can be preempted"))
+		Expect(get(ctx, victim)()).To(Succeed())
+		Expect(victim.GetAnnotations()).NotTo(HaveKey(infrav1.PreemptedByAnnotation))
+	})
+
+	It("orders the provisioning queue by priority, then the clusters take turns", func() {
+		at := func(minute int) metav1.Time {
+			return metav1.NewTime(time.Date(2022, 1, 1, 0, minute, 0, 0, time.UTC))
+		}
+		queue := provisioningQueue{
+			{name: "a1", cluster: "a", queuedAt: at(0)},
+			{name: "a3", cluster: "a", queuedAt: at(2)},
+			{name: "a2", cluster: "a", queuedAt: at(1)},
+			{name: "b1", cluster: "b", queuedAt: at(3)},
+			{name: "b2", cluster: "b", queuedAt: at(4)},
+			{name: "c1", cluster: "c", priority: 10, queuedAt: at(5)},
+		}
+		queue.sort()
+		var names []string
+		for _, entry := range queue {
+			names = append(names, entry.name)
+		}
+		Expect(names).To(Equal([]string{"c1", "a1", "b1", "a2", "b2", "a3"}))
+
+		// the first machine of another cluster takes its turn along the first machines of the other clusters
+		queue = queue.add(&queueEntry{name: "d1", cluster: "d", queuedAt: at(6)})
+		Expect(queue.position("d1")).To(BeEquivalentTo(4))
+		Expect(queue.position("a3")).To(BeEquivalentTo(7))
+	})
+
+	It("queues the machines waiting for a metal node", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+
+		_, first := createMachine(ctx, cluster, false)
+		Eventually(func() *infrav1.DemoMachineQueueStatus {
+			Expect(get(ctx, first)()).To(Succeed())
+			return first.Status.Queue
+		}, timeout, interval).Should(HaveField("Position", BeEquivalentTo(1)))
+		_, second := createMachine(ctx, cluster, false)
+		Eventually(func() *infrav1.DemoMachineQueueStatus {
+			Expect(get(ctx, second)()).To(Succeed())
+			return second.Status.Queue
+		}, timeout, interval).Should(HaveField("Position", BeEquivalentTo(2)))
+		queuedAt := second.Status.Queue.QueuedAt
+
+		// the first machine in the queue claims the freed metal node, the second one moves up
+		createMetalNode(ctx, namespace, "10.0.5.1", metalNodeProfile{})
+		Eventually(func() bool {
+			return get(ctx, first)() == nil && first.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(first.Status.Queue).To(BeNil())
+		Eventually(func() *infrav1.DemoMachineQueueStatus {
+			Expect(get(ctx, second)()).To(Succeed())
+			return second.Status.Queue
+		}, timeout, interval).Should(HaveField("Position", BeEquivalentTo(1)))
+		Expect(second.Status.Queue.QueuedAt.Equal(&queuedAt)).To(BeTrue())
+		Expect(second.GetLabels()).NotTo(HaveKey(infrav1.MetalNodeLabelName))
+	})
+
+	It("migrates the bare metal node uid provider id of a bootstrapped machine", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.6", metalNodeProfile{})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+
+		Eventually(func() error {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return err
+			}
+			demoMachine.Spec.ProviderID = string(metalNode.UID)
+			return k8sClient.Update(ctx, demoMachine)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return demoMachine.Spec.ProviderID
+		}, timeout, interval).Should(Equal(infrav1.ProviderIDPrefix + string(metalNode.UID)))
+	})
+
+	It("resumes a bootstrapped machine after a clusterctl move", func() {
+		cluster, _ := setupCluster(metalNodeProfile{})
+		metalNode := createMetalNode(ctx, namespace, "10.0.1.4", metalNodeProfile{})
+
+		_, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		providerID := demoMachine.Spec.ProviderID
+		// a free metal node the machine must not claim on resume
+		createMetalNode(ctx, namespace, "10.0.1.5", metalNodeProfile{})
+
+		simulateMove(ctx, cluster, demoMachine, metalNode)
+
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Ready && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(demoMachine.Spec.ProviderID).To(Equal(providerID))
+		Expect(demoMachine.GetLabels()).To(HaveKeyWithValue(infrav1.MetalNodeLabelName, metalNode.Name))
+
+		Eventually(func() bool {
+			metalNode = getMetalNode(ctx, namespace, metalNode.Name)
+			return metalNode.GetRefCluster() == cluster.Name && metalNode.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Expect(metalNode.Status.DataSecretName).NotTo(BeEmpty())
+	})
+})
@@ -0,0 +1,341 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/cluster-api/util/predicates"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// DefaultRemediationTimeout is the time the machine is given to turn healthy after each remediation attempt
+// when the DemoRemediation does not set one
+const DefaultRemediationTimeout = 5 * time.Minute
+
+// DemoRemediationReconciler reconciles a DemoRemediation object, remediating the unhealthy machine it is named after
+// by rebooting its metal node, then replacing it and reprovisioning its metal node, and only then deleting it
+type DemoRemediationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// WatchFilterValue is the label value used to filter events prior to reconciliation.
+	WatchFilterValue string
+}
+
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demoremediations,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demoremediations/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demoremediationtemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=demomachines,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes/status,verbs=get;update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile takes the next remediation action once the previous one did not turn the machine healthy in time.
+// The MachineHealthCheck deletes the DemoRemediation when the machine turns healthy.
+func (r *DemoRemediationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, rerr error) {
+	remediation := &infrav1.DemoRemediation{}
+	if err := r.Client.Get(ctx, req.NamespacedName, remediation); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if !remediation.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	machine, err := util.GetOwnerMachine(ctx, r.Client, remediation.ObjectMeta)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if machine == nil {
+		log.Info("Waiting for MachineHealthCheck Controller to set OwnerRef on DemoRemediation")
+		return ctrl.Result{}, nil
+	}
+	l := log.With("demoRemediation", remediation.Name).With("machine", machine.Name)
+
+	cluster, err := util.GetClusterFromMetadata(ctx, r.Client, machine.ObjectMeta)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if annotations.IsPaused(cluster, remediation) {
+		l.Info("Reconciliation is paused for this object")
+		return ctrl.Result{}, nil
+	}
+
+	patchHelper, err := patch.NewHelper(remediation, r.Client)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer func() {
+		if err := patchHelper.Patch(ctx, remediation); err != nil {
+			log.WithError(err).Errorf("failed to patch demoRemediation %s", remediation.Name)
+			if rerr == nil {
+				rerr = err
+			}
+		}
+	}()
+
+	timeout := remediation.Spec.Timeout.Duration
+	if timeout == 0 {
+		timeout = DefaultRemediationTimeout
+	}
+
+	attempt := lastRemediationAttempt(remediation)
+	if attempt != nil && attempt.Result == infrav1.RunningRemediationAttempt {
+		if conditions.IsTrue(machine, clusterv1.MachineHealthCheckSucceededCondition) {
+			completeRemediationAttempt(attempt, infrav1.SucceededRemediationAttempt, "the machine turned healthy")
+			l.Infof("the machine turned healthy after the %s attempt", attempt.Action)
+			return ctrl.Result{}, nil
+		}
+		if remaining := time.Until(attempt.StartTime.Add(timeout)); remaining > 0 {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+		message := fmt.Sprintf("the machine did not turn healthy in %s", timeout)
+		if attempt.Action == infrav1.RebootRemediationAction {
+			rebooted, err := r.clearRebootRequest(ctx, machine)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if !rebooted {
+				message = fmt.Sprintf("the metal node was not rebooted in %s", timeout)
+			}
+		}
+		completeRemediationAttempt(attempt, infrav1.FailedRemediationAttempt, message)
+		l.Warnf("the %s attempt failed: %s", attempt.Action, message)
+	}
+	if attempt != nil && attempt.Result == infrav1.SucceededRemediationAttempt {
+		return ctrl.Result{}, nil
+	}
+
+	// take the next action, skipping the ones that can not be taken
+	for {
+		action := nextRemediationAction(remediation)
+		remediation.Status.Attempts = append(remediation.Status.Attempts, infrav1.RemediationAttempt{
+			Action:    action,
+			Result:    infrav1.RunningRemediationAttempt,
+			StartTime: metav1.Now(),
+		})
+		attempt = lastRemediationAttempt(remediation)
+
+		var err error
+		switch action {
+		case infrav1.RebootRemediationAction:
+			err = r.rebootMetalNode(ctx, remediation, machine)
+		case infrav1.RebootstrapRemediationAction:
+			if err = r.rebootstrapMachine(ctx, remediation, machine); err == nil {
+				completeRemediationAttempt(attempt, infrav1.SucceededRemediationAttempt, "deleted the machine, its metal node is reprovisioned")
+				l.Info("deleted the machine, its metal node is reprovisioned")
+				return ctrl.Result{}, nil
+			}
+		case infrav1.DeleteRemediationAction:
+			if err := r.Client.Delete(ctx, machine); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, errors.Wrapf(err, "failed to delete machine %s", machine.Name)
+			}
+			completeRemediationAttempt(attempt, infrav1.SucceededRemediationAttempt, "deleted the machine")
+			l.Info("deleted the machine")
+			return ctrl.Result{}, nil
+		}
+		if err != nil {
+			completeRemediationAttempt(attempt, infrav1.FailedRemediationAttempt, err.Error())
+			l.WithError(err).Warnf("the %s attempt failed", action)
+			continue
+		}
+		l.Infof("took the %s action, waiting for the machine to turn healthy", action)
+		return ctrl.Result{RequeueAfter: timeout}, nil
+	}
+}
+
+// rebootMetalNode power-cycles the metal node hosting the machine through its BMC,
+// or asks its agent to when it has none
+func (r *DemoRemediationReconciler) rebootMetalNode(ctx context.Context, remediation *infrav1.DemoRemediation, machine *clusterv1.Machine) error {
+	metalNode, err := r.getMachineMetalNode(ctx, machine)
+	if err != nil {
+		return err
+	}
+	pm, err := getPowerManager(ctx, r.Client, metalNode)
+	if err != nil {
+		return err
+	}
+	if pm != nil {
+		return errors.Wrapf(pm.Reboot(ctx), "failed to power-cycle metal node %s", metalNode.Name)
+	}
+	metalNodeAnnotations := metalNode.GetAnnotations()
+	if metalNodeAnnotations == nil {
+		metalNodeAnnotations = map[string]string{}
+	}
+	metalNodeAnnotations[infrav1.MetalNodeRebootAnnotation] = remediation.Name
+	metalNode.SetAnnotations(metalNodeAnnotations)
+	return metalnode.Update(ctx, r.Client, metalNode)
+}
+
+// clearRebootRequest withdraws the reboot the agent did not get to, it returns true if there was none left
+func (r *DemoRemediationReconciler) clearRebootRequest(ctx context.Context, machine *clusterv1.Machine) (bool, error) {
+	metalNode, err := r.getMachineMetalNode(ctx, machine)
+	if err != nil {
+		return true, nil
+	}
+	if _, ok := metalNode.GetAnnotations()[infrav1.MetalNodeRebootAnnotation]; !ok {
+		return true, nil
+	}
+	delete(metalNode.Annotations, infrav1.MetalNodeRebootAnnotation)
+	return false, metalnode.Update(ctx, r.Client, metalNode)
+}
+
+// rebootstrapMachine has the metal node hosting the machine reprovisioned once released, and deletes the machine so that
+// its owner replaces it with new bootstrap data, which the bootstrap provider only generates for new machines
+func (r *DemoRemediationReconciler) rebootstrapMachine(ctx context.Context, remediation *infrav1.DemoRemediation, machine *clusterv1.Machine) error {
+	demoMachine, err := r.getMachineDemoMachine(ctx, machine)
+	if err != nil {
+		return err
+	}
+	if _, err := r.getMachineMetalNode(ctx, machine); err != nil {
+		return err
+	}
+	patchBase := client.MergeFrom(demoMachine.DeepCopy())
+	demoMachineAnnotations := demoMachine.GetAnnotations()
+	if demoMachineAnnotations == nil {
+		demoMachineAnnotations = map[string]string{}
+	}
+	demoMachineAnnotations[infrav1.DemoMachineReprovisionMetalNodeAnnotation] = remediation.Name
+	demoMachine.SetAnnotations(demoMachineAnnotations)
+	if err := r.Client.Patch(ctx, demoMachine, patchBase); err != nil {
+		return errors.Wrapf(err, "failed to ask DemoMachine %s to reprovision its metal node", demoMachine.Name)
+	}
+	if err := r.Client.Delete(ctx, machine); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete machine %s", machine.Name)
+	}
+	return nil
+}
+
+// getMachineDemoMachine returns the DemoMachine of the machine
+func (r *DemoRemediationReconciler) getMachineDemoMachine(ctx context.Context, machine *clusterv1.Machine) (*infrav1.DemoMachine, error) {
+	ref := machine.Spec.InfrastructureRef
+	if ref.Kind != "DemoMachine" {
+		return nil, errors.Errorf("the machine is not a DemoMachine but a %s", ref.Kind)
+	}
+	demoMachine := &infrav1.DemoMachine{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: ref.Name}, demoMachine); err != nil {
+		return nil, errors.Wrapf(err, "failed to get DemoMachine %s", ref.Name)
+	}
+	return demoMachine, nil
+}
+
+// getMachineMetalNode returns the metal node bound to the DemoMachine of the machine
+func (r *DemoRemediationReconciler) getMachineMetalNode(ctx context.Context, machine *clusterv1.Machine) (*metav1beta1.MetalNode, error) {
+	demoMachine, err := r.getMachineDemoMachine(ctx, machine)
+	if err != nil {
+		return nil, err
+	}
+	name := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]
+	if name == "" {
+		return nil, errors.Errorf("no metal node is bound to DemoMachine %s", demoMachine.Name)
+	}
+	metalNode := &metav1beta1.MetalNode{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: name}, metalNode); err != nil {
+		return nil, errors.Wrapf(err, "failed to get metal node %s", name)
+	}
+	if _, err := restoreMetalNodeStatus(metalNode); err != nil {
+		return nil, err
+	}
+	return metalNode, nil
+}
+
+// nextRemediationAction returns the action following the attempts taken so far
+func nextRemediationAction(remediation *infrav1.DemoRemediation) infrav1.RemediationAction {
+	var reboots, rebootstraps int32
+	for _, attempt := range remediation.Status.Attempts {
+		switch attempt.Action {
+		case infrav1.RebootRemediationAction:
+			reboots++
+		case infrav1.RebootstrapRemediationAction:
+			rebootstraps++
+		}
+	}
+	switch {
+	case reboots < remediation.Spec.RebootLimit:
+		return infrav1.RebootRemediationAction
+	case rebootstraps < remediation.Spec.RebootstrapLimit:
+		return infrav1.RebootstrapRemediationAction
+	default:
+		return infrav1.DeleteRemediationAction
+	}
+}
+
+func lastRemediationAttempt(remediation *infrav1.DemoRemediation) *infrav1.RemediationAttempt {
+	if len(remediation.Status.Attempts) == 0 {
+		return nil
+	}
+	return &remediation.Status.Attempts[len(remediation.Status.Attempts)-1]
+}
+
+func completeRemediationAttempt(attempt *infrav1.RemediationAttempt, result infrav1.RemediationAttemptResult, message string) {
+	now := metav1.Now()
+	attempt.Result = result
+	attempt.CompletionTime = &now
+	attempt.Message = message
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DemoRemediationReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&infrav1.DemoRemediation{}, builder.WithPredicates(predicates.ResourceHasFilterLabel(mgr.GetLogger(), r.WatchFilterValue))).
+		WithOptions(options).
+		Watches(
+			&source.Kind{Type: &clusterv1.Machine{}},
+			handler.EnqueueRequestsFromMapFunc(MachineToDemoRemediation),
+		).
+		Complete(r)
+}
+
+// MachineToDemoRemediation is a handler.MapFunc to be used to enqueue requests for reconciliation
+// of the DemoRemediation of a Machine, named after the Machine.
+func MachineToDemoRemediation(o client.Object) []reconcile.Request {
+	machine, ok := o.(*clusterv1.Machine)
+	if !ok {
+		log.Errorf("expected a Machine but got a %T", o)
+		return nil
+	}
+	if !conditions.Has(machine, clusterv1.MachineHealthCheckSucceededCondition) {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: machine.Namespace, Name: machine.Name}}}
+}
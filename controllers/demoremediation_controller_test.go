@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+)
+
+var _ = Describe("DemoRemediationReconciler", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+	})
+
+	// setupUnhealthyMachine bootstraps a machine on a metal node following profile, then reports it unhealthy
+	// the way the MachineHealthCheck does
+	setupUnhealthyMachine := func(profile metalNodeProfile) (*clusterv1.Machine, *infrav1.DemoMachine) {
+		createMetalNode(ctx, namespace, "10.0.3.1", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace)
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		createMetalNode(ctx, namespace, "10.0.3.2", profile)
+
+		machine, demoMachine := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+
+		Expect(get(ctx, machine)()).To(Succeed())
+		conditions.MarkFalse(machine, clusterv1.MachineHealthCheckSucceededCondition, clusterv1.NodeConditionsFailedReason, clusterv1.ConditionSeverityWarning, "")
+		Expect(k8sClient.Status().Update(ctx, machine)).To(Succeed())
+		return machine, demoMachine
+	}
+
+	// createRemediation creates the DemoRemediation of the machine the way the MachineHealthCheck does
+	createRemediation := func(machine *clusterv1.Machine, spec infrav1.DemoRemediationSpec) *infrav1.DemoRemediation {
+		remediation := &infrav1.DemoRemediation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      machine.Name,
+				Namespace: machine.Namespace,
+				Labels:    map[string]string{clusterv1.ClusterLabelName: machine.Spec.ClusterName},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: clusterv1.GroupVersion.String(),
+					Kind:       "Machine",
+					Name:       machine.Name,
+					UID:        machine.UID,
+				}},
+			},
+			Spec: spec,
+		}
+		Expect(k8sClient.Create(ctx, remediation)).To(Succeed())
+		return remediation
+	}
+
+	// attempts returns the actions and results of the remediation attempts
+	attempts := func(remediation *infrav1.DemoRemediation) func() []string {
+		return func() []string {
+			if err := get(ctx, remediation)(); err != nil {
+				return nil
+			}
+			var attempts []string
+			for _, attempt := range remediation.Status.Attempts {
+				attempts = append(attempts, string(attempt.Action)+"/"+string(attempt.Result))
+			}
+			return attempts
+		}
+	}
+
+	It("reboots the metal node, then replaces the machine and reprovisions its metal node", func() {
+		machine, demoMachine := setupUnhealthyMachine(metalNodeProfile{})
+		metalNodeName := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]
+
+		remediation := createRemediation(machine, infrav1.DemoRemediationSpec{
+			RebootLimit:      1,
+			RebootstrapLimit: 1,
+			Timeout:          metav1.Duration{Duration: 2 * time.Second},
+		})
+		Eventually(attempts(remediation), timeout, interval).Should(Equal([]string{
+			"Reboot/Failed", "Rebootstrap/Succeeded",
+		}))
+		Expect(remediation.Status.Attempts[0].Message).To(ContainSubstring("did not turn healthy"))
+		Expect(metalNodeAgent.Reboots(metalNodeName)).To(Equal(1))
+		Eventually(func() bool {
+			return apierrors.IsNotFound(get(ctx, machine)())
+		}, timeout, interval).Should(BeTrue())
+
+		// the bootstrap data of the machine did not run again on the dirty host
+		metalNode := getMetalNode(ctx, namespace, metalNodeName)
+		Expect(metalNode.Status.Bootstrapped).To(BeTrue())
+		Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeRebootAnnotation))
+
+		// the garbage collector deletes the DemoMachine of the deleted machine, which has its metal node reprovisioned
+		Expect(get(ctx, demoMachine)()).To(Succeed())
+		Expect(demoMachine.GetAnnotations()).To(HaveKeyWithValue(infrav1.DemoMachineReprovisionMetalNodeAnnotation, remediation.Name))
+		Expect(k8sClient.Delete(ctx, demoMachine)).To(Succeed())
+		Eventually(func() (infrav1.ReprovisioningState, error) {
+			reprovisioning, err := metalnode.GetReprovisioning(getMetalNode(ctx, namespace, metalNodeName))
+			if err != nil || reprovisioning == nil {
+				return "", err
+			}
+			return reprovisioning.State, nil
+		}, timeout, interval).Should(Equal(infrav1.AvailableState))
+		Expect(metalNodeAgent.BootstrapData(metalNodeName)).To(ContainSubstring("kubeadm reset --force"))
+	})
+
+	It("deletes the machine when its metal node can not be reprovisioned", func() {
+		machine, demoMachine := setupUnhealthyMachine(metalNodeProfile{})
+
+		// the metal node hosting the machine is gone
+		Expect(k8sClient.Delete(ctx, getMetalNode(ctx, namespace, demoMachine.GetLabels()[infrav1.MetalNodeLabelName]))).To(Succeed())
+
+		remediation := createRemediation(machine, infrav1.DemoRemediationSpec{
+			RebootstrapLimit: 1,
+			Timeout:          metav1.Duration{Duration: 2 * time.Second},
+		})
+		Eventually(attempts(remediation), timeout, interval).Should(Equal([]string{"Rebootstrap/Failed", "Delete/Succeeded"}))
+		Expect(remediation.Status.Attempts[0].Message).To(ContainSubstring("failed to get metal node"))
+	})
+
+	It("withdraws the reboot the metal node did not get to", func() {
+		machine, demoMachine := setupUnhealthyMachine(metalNodeProfile{FailReboot: true})
+		metalNodeName := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]
+
+		remediation := createRemediation(machine, infrav1.DemoRemediationSpec{
+			RebootLimit: 1,
+			Timeout:     metav1.Duration{Duration: 2 * time.Second},
+		})
+		Eventually(attempts(remediation), timeout, interval).Should(Equal([]string{"Reboot/Failed", "Delete/Succeeded"}))
+		Expect(remediation.Status.Attempts[0].Message).To(ContainSubstring("was not rebooted"))
+		Expect(getMetalNode(ctx, namespace, metalNodeName).GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeRebootAnnotation))
+	})
+
+	It("stops once the machine turns healthy", func() {
+		machine, demoMachine := setupUnhealthyMachine(metalNodeProfile{})
+		metalNodeName := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]
+
+		remediation := createRemediation(machine, infrav1.DemoRemediationSpec{
+			RebootLimit:      1,
+			RebootstrapLimit: 1,
+			Timeout:          metav1.Duration{Duration: time.Minute},
+		})
+		Eventually(func() int {
+			return metalNodeAgent.Reboots(metalNodeName)
+		}, timeout, interval).Should(Equal(1))
+
+		Expect(get(ctx, machine)()).To(Succeed())
+		conditions.MarkTrue(machine, clusterv1.MachineHealthCheckSucceededCondition)
+		Expect(k8sClient.Status().Update(ctx, machine)).To(Succeed())
+
+		Eventually(attempts(remediation), timeout, interval).Should(Equal([]string{"Reboot/Succeeded"}))
+		Consistently(attempts(remediation), 2*time.Second, interval).Should(Equal([]string{"Reboot/Succeeded"}))
+		Expect(get(ctx, machine)()).To(Succeed())
+	})
+})
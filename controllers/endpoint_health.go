@@ -0,0 +1,243 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+const (
+	// DefaultEndpointProbeInterval is the interval the control plane endpoint is probed at
+	DefaultEndpointProbeInterval = 30 * time.Second
+
+	// endpointProbeTimeout bounds each step of a probe of the control plane endpoint
+	endpointProbeTimeout = 2 * time.Second
+)
+
+// endpointProbe is the outcome of probing the API server behind the control plane endpoint
+type endpointProbe struct {
+	// tcpLatency is the time it took to connect to the endpoint
+	tcpLatency time.Duration
+	// readyzLatency is the time it took the API server to answer /readyz
+	readyzLatency time.Duration
+	// reason explains a failed probe, it is empty when the API server is ready
+	reason string
+	// err is the error of a failed probe
+	err error
+}
+
+func (p *endpointProbe) String() string {
+	if p.err != nil {
+		return p.err.Error()
+	}
+	return fmt.Sprintf("tcp %s, /readyz %s", p.tcpLatency.Round(time.Millisecond), p.readyzLatency.Round(time.Millisecond))
+}
+
+// endpointProber probes the control plane endpoints in the background, so that a slow or unreachable endpoint does not
+// hold up the DemoClusters reconciled after it. The reconciler reads the outcome of the last probe of an endpoint, and the
+// DemoCluster is reconciled again once a new one is in.
+type endpointProber struct {
+	mu     sync.Mutex
+	probes map[types.NamespacedName]*endpointProbeState
+	// events triggers the reconciliation of the DemoClusters whose endpoint was probed
+	events chan event.GenericEvent
+}
+
+// endpointProbeState is the last probe of the control plane endpoint of a DemoCluster
+type endpointProbeState struct {
+	endpoint clusterv1.APIEndpoint
+	probe    *endpointProbe
+	probedAt time.Time
+	inFlight bool
+}
+
+func newEndpointProber() *endpointProber {
+	return &endpointProber{
+		probes: map[types.NamespacedName]*endpointProbeState{},
+		events: make(chan event.GenericEvent),
+	}
+}
+
+// lastProbe returns the last probe of the control plane endpoint of a demoCluster, nil until the first one is in. It starts
+// a new probe in the background when the last one is older than the interval or probed another endpoint.
+func (p *endpointProber) lastProbe(demoCluster *infrav1.DemoCluster, interval time.Duration) *endpointProbe {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := client.ObjectKeyFromObject(demoCluster)
+	state, ok := p.probes[key]
+	if !ok || state.endpoint != demoCluster.Spec.ControlPlaneEndpoint {
+		state = &endpointProbeState{endpoint: demoCluster.Spec.ControlPlaneEndpoint}
+		p.probes[key] = state
+	}
+	if !state.inFlight && time.Since(state.probedAt) >= interval {
+		state.inFlight = true
+		go p.run(key, demoCluster.DeepCopy(), state)
+	}
+	return state.probe
+}
+
+// run probes the endpoint and triggers the reconciliation of the demoCluster
+func (p *endpointProber) run(key types.NamespacedName, demoCluster *infrav1.DemoCluster, state *endpointProbeState) {
+	probe := probeEndpoint(context.Background(), state.endpoint)
+	p.mu.Lock()
+	state.probe, state.probedAt, state.inFlight = probe, time.Now(), false
+	current := p.probes[key] == state
+	p.mu.Unlock()
+	if current {
+		p.events <- event.GenericEvent{Object: demoCluster}
+	}
+}
+
+// forget drops the probes of a deleted demoCluster
+func (p *endpointProber) forget(demoCluster *infrav1.DemoCluster) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.probes, client.ObjectKeyFromObject(demoCluster))
+}
+
+// reconcileEndpointHealth records the last probe of the control plane endpoint of a ready demoCluster in the
+// ControlPlaneEndpointHealthy condition, it returns when to look at the endpoint again
+func (r *DemoClusterReconciler) reconcileEndpointHealth(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (ctrl.Result, error) {
+	interval := r.EndpointProbeInterval
+	if interval == 0 {
+		interval = DefaultEndpointProbeInterval
+	}
+	// nothing listens on the endpoint before the first control plane machine initializes
+	if !conditions.IsTrue(cluster, clusterv1.ControlPlaneInitializedCondition) {
+		conditions.MarkFalse(demoCluster, constants.ControlPlaneEndpointHealthyCondition, constants.WaitingForControlPlaneReason, clusterv1.ConditionSeverityInfo,
+			"the control plane is not initialized yet")
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	probe := r.endpointProber.lastProbe(demoCluster, interval)
+
+	// a single control plane machine hosts the endpoint, it is down along with its metal node
+	metalNode, err := r.getEndpointMetalNode(ctx, demoCluster, cluster)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if metalNode != nil && !metalNode.IsReady() {
+		conditions.MarkFalse(demoCluster, constants.ControlPlaneEndpointHealthyCondition, constants.EndpointMetalNodeNotReadyReason, clusterv1.ConditionSeverityWarning,
+			"metal node %s of the control plane endpoint is not ready", metalNode.Name)
+		log.Warnf("metal node %s of the control plane endpoint of demoCluster %s is not ready", metalNode.Name, demoCluster.Name)
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	// the demoCluster is reconciled again once the first probe is in
+	if probe == nil {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	if probe.err != nil {
+		conditions.MarkFalse(demoCluster, constants.ControlPlaneEndpointHealthyCondition, probe.reason, clusterv1.ConditionSeverityWarning, "%s", probe)
+		log.Warnf("control plane endpoint %s of demoCluster %s is unhealthy: %s", demoCluster.Spec.ControlPlaneEndpoint.String(), demoCluster.Name, probe)
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	conditions.Set(demoCluster, &clusterv1.Condition{
+		Type:    constants.ControlPlaneEndpointHealthyCondition,
+		Status:  corev1.ConditionTrue,
+		Message: probe.String(),
+	})
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// getEndpointMetalNode returns the metal node hosting the control plane endpoint in the single control plane mode,
+// i.e. the metal node claimed by the cluster whose address is the endpoint
+func (r *DemoClusterReconciler) getEndpointMetalNode(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (*metav1beta1.MetalNode, error) {
+	if demoCluster.Spec.LoadBalancer != nil || demoCluster.Spec.VirtualIP != nil {
+		return nil, nil
+	}
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := r.Client.List(ctx, metalNodeList, watchFilterListOptions(demoCluster.Namespace, r.WatchFilterValue)...); err != nil {
+		return nil, err
+	}
+	for i := range metalNodeList.Items {
+		metalNode := &metalNodeList.Items[i]
+		if address, err := endpointAddress(metalNode, demoCluster, cluster); err == nil && address == demoCluster.Spec.ControlPlaneEndpoint.Host &&
+			metalNode.HasRole(constants.ControlPlaneNodeRoleValue) &&
+			util.HasOwnerRef(metalNode.GetOwnerReferences(), clusterOwnerRef(cluster)) {
+			return metalNode, nil
+		}
+	}
+	return nil, nil
+}
+
+// probeEndpoint connects to the control plane endpoint and asks the API server behind it whether it is ready,
+// /readyz is open to anonymous requests and the probe sends no credentials, so the serving certificate is not verified
+func probeEndpoint(ctx context.Context, endpoint clusterv1.APIEndpoint) *endpointProbe {
+	probe := &endpointProbe{}
+	url := fmt.Sprintf("https://%s/readyz", net.JoinHostPort(endpoint.Host, strconv.Itoa(int(endpoint.Port))))
+
+	// time the connection within the request, a bare connection would show up as a failed TLS handshake in the API server logs
+	var connectStart time.Time
+	var tcpLatency time.Duration
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) { connectStart = time.Now() },
+		ConnectDone:  func(_, _ string, _ error) { tcpLatency = time.Since(connectStart) },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, url, nil)
+	if err != nil {
+		probe.reason, probe.err = constants.EndpointUnreachableReason, err
+		return probe
+	}
+	httpClient := &http.Client{
+		Timeout: endpointProbeTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+			DisableKeepAlives: true,
+		},
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		probe.reason = constants.APIServerNotReadyReason
+		if opErr := (&net.OpError{}); errors.As(err, &opErr) && opErr.Op == "dial" {
+			probe.reason = constants.EndpointUnreachableReason
+		}
+		probe.err = errors.Wrapf(err, "failed to get %s", url)
+		return probe
+	}
+	_ = resp.Body.Close()
+	probe.tcpLatency, probe.readyzLatency = tcpLatency, time.Since(start)
+	if resp.StatusCode != http.StatusOK {
+		probe.reason, probe.err = constants.APIServerNotReadyReason, errors.Errorf("%s returned %s", url, resp.Status)
+	}
+	return probe
+}
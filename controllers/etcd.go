@@ -0,0 +1,322 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/etcd"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+const (
+	// defaultEtcdReplicas is the number of etcd members used when the DemoCluster does not set one
+	defaultEtcdReplicas = 3
+
+	// etcdEndpointsKey is the key of the etcd client URLs in the client certificate secret of the API servers
+	etcdEndpointsKey = "endpoints"
+)
+
+// reconcileEtcd claims the metal nodes of the external etcd cluster, issues its certificates and bootstraps its members,
+// it returns true once all of them are ready. The certificates are published in the secrets Cluster API looks up
+// for an external etcd, <cluster>-etcd and <cluster>-apiserver-etcd-client. The private key of the certificate authority
+// is kept out of them, Cluster API copies those onto the control plane machines.
+func (r *DemoClusterReconciler) reconcileEtcd(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (bool, error) {
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := r.Client.List(ctx, metalNodeList, watchFilterListOptions(demoCluster.Namespace, r.WatchFilterValue)...); err != nil {
+		return false, err
+	}
+	replicas := int(demoCluster.Spec.Etcd.Replicas)
+	if replicas == 0 {
+		replicas = defaultEtcdReplicas
+	}
+
+	etcdNodes, err := getEtcdNodes(metalNodeList, cluster)
+	if err != nil {
+		return false, err
+	}
+	// the members of a new etcd cluster know each other when they bootstrap, so they are claimed
+	// all together and fixed once the first one got its bootstrap data
+	if len(etcdNodes) < replicas && !etcdBootstrapStarted(etcdNodes) {
+		var candidates []*metav1beta1.MetalNode
+		for i := range metalNodeList.Items {
+			metalNode := &metalNodeList.Items[i]
+			if metalNode.IsReady() && metalNode.GetRefCluster() == "" && metalNodeClaimedBy(metalNode) == "" && !metalNodeStatusLost(metalNode) && !metalNodeReprovisioning(metalNode) && !metalNodeCordoned(metalNode) &&
+				!metalNode.HasRole(constants.ControlPlaneNodeRoleValue) && !metalNode.HasRole(constants.WorkerNodeRoleValue) &&
+				!metalNode.HasRole(constants.LoadBalancerRoleValue) {
+				candidates = append(candidates, metalNode)
+			}
+		}
+		if len(etcdNodes)+len(candidates) < replicas {
+			conditions.MarkFalse(demoCluster, constants.EtcdAvailableCondition, constants.NoMetalNodeFoundReason, clusterv1.ConditionSeverityWarning,
+				"%d of %d metal nodes found for etcd", len(etcdNodes)+len(candidates), replicas)
+			log.Infof("waiting for %d metal nodes for etcd, %d found", replicas, len(etcdNodes)+len(candidates))
+			return false, nil
+		}
+		for _, metalNode := range candidates[:replicas-len(etcdNodes)] {
+			metalNode.SetRole(constants.EtcdRoleValue)
+			metalNode.Status.RefCluster = cluster.Name
+			metalNode.SetOwnerReferences(util.EnsureOwnerRef(metalNode.GetOwnerReferences(), clusterOwnerRef(cluster)))
+			if err := metalnode.Update(ctx, r.Client, metalNode); err != nil {
+				return false, err
+			}
+			log.Infof("claimed metal node %s for etcd", metalNode.Name)
+			etcdNodes = append(etcdNodes, metalNode)
+		}
+	}
+
+	var members []etcd.Member
+	for _, metalNode := range etcdNodes {
+		members = append(members, etcd.Member{Name: metalNode.Name, Address: metalNode.Spec.NodeEndPoint.Host})
+	}
+	ca, err := r.reconcileEtcdCA(ctx, demoCluster, cluster)
+	if err != nil {
+		return false, err
+	}
+	if err := r.reconcileEtcdClientSecret(ctx, demoCluster, cluster, ca, etcd.Endpoints(members)); err != nil {
+		return false, err
+	}
+
+	demoCluster.Status.Etcd = &infrav1.DemoEtcdStatus{Endpoints: etcd.Endpoints(members)}
+	ready := 0
+	for i, metalNode := range etcdNodes {
+		secretName, err := r.reconcileEtcdMemberSecret(ctx, demoCluster, cluster, ca, &etcd.Config{
+			Member:       members[i],
+			Members:      members,
+			ClusterToken: fmt.Sprintf("%s-%s", cluster.Namespace, cluster.Name),
+			Image:        demoCluster.Spec.Etcd.Image,
+		})
+		if err != nil {
+			return false, err
+		}
+		if metalNode.Status.DataSecretName != secretName {
+			metalNode.Status.DataSecretName = secretName
+			metalNode.Status.Bootstrapped = false
+		}
+		if err := metalnode.Update(ctx, r.Client, metalNode); err != nil {
+			return false, err
+		}
+
+		member := infrav1.DemoEtcdMember{
+			MetalNodeName: metalNode.Name,
+			Address:       members[i].Address,
+			Ready:         metalNode.IsReady() && metalNode.Status.Bootstrapped,
+		}
+		if member.Ready {
+			ready++
+		}
+		demoCluster.Status.Etcd.Members = append(demoCluster.Status.Etcd.Members, member)
+	}
+
+	if ready < replicas {
+		conditions.MarkFalse(demoCluster, constants.EtcdAvailableCondition, constants.WaitingForMetalNodeBootstrapReason, clusterv1.ConditionSeverityInfo,
+			"%d of %d etcd members ready", ready, replicas)
+		return false, nil
+	}
+	conditions.MarkTrue(demoCluster, constants.EtcdAvailableCondition)
+	return true, nil
+}
+
+// reconcileEtcdCA returns the etcd certificate authority, creating it the first time. Its certificate is published in
+// <cluster>-etcd, its private key is kept in <cluster>-etcd-ca-key which only the provider reads.
+func (r *DemoClusterReconciler) reconcileEtcdCA(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (*etcd.CertificateAuthority, error) {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: cluster.Namespace, Name: etcdCASecretName(cluster)}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+
+		ca, err := etcd.NewCertificateAuthority()
+		if err != nil {
+			return nil, err
+		}
+		keyPair := ca.KeyPair()
+		// the key comes first, a certificate authority is never published without it
+		if err := r.writeEtcdCAKeySecret(ctx, demoCluster, cluster, keyPair.Key); err != nil {
+			return nil, err
+		}
+		if err := r.createEtcdSecret(ctx, demoCluster, cluster, key.Name, map[string][]byte{corev1.TLSCertKey: keyPair.Cert}); err != nil {
+			return nil, err
+		}
+		log.Infof("created the etcd certificate authority of cluster %s", cluster.Name)
+		return ca, nil
+	}
+
+	// the certificate authorities created before the key had a secret of its own hold it along with the certificate
+	if caKey, ok := secret.Data[corev1.TLSPrivateKeyKey]; ok {
+		if err := r.writeEtcdCAKeySecret(ctx, demoCluster, cluster, caKey); err != nil {
+			return nil, err
+		}
+		delete(secret.Data, corev1.TLSPrivateKeyKey)
+		if err := r.Client.Update(ctx, secret); err != nil {
+			return nil, errors.Wrapf(err, "failed to remove the private key from secret %s", secret.Name)
+		}
+		log.Infof("moved the private key of the etcd certificate authority of cluster %s to secret %s", cluster.Name, etcdCAKeySecretName(cluster))
+	}
+
+	keySecret := &corev1.Secret{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: etcdCAKeySecretName(cluster)}, keySecret); err != nil {
+		return nil, errors.Wrapf(err, "failed to get the private key of the etcd certificate authority of cluster %s", cluster.Name)
+	}
+	return etcd.ParseCertificateAuthority(&certs.KeyPair{Cert: secret.Data[corev1.TLSCertKey], Key: keySecret.Data[corev1.TLSPrivateKeyKey]})
+}
+
+// writeEtcdCAKeySecret writes the private key of the etcd certificate authority, owned by the demoCluster so that
+// clusterctl moves it along
+func (r *DemoClusterReconciler) writeEtcdCAKeySecret(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster, caKey []byte) error {
+	secret := &corev1.Secret{}
+	secret.Name = etcdCAKeySecretName(cluster)
+	secret.Namespace = demoCluster.Namespace
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = map[string]string{clusterv1.ClusterLabelName: cluster.Name}
+		secret.Type = clusterv1.ClusterSecretType
+		secret.Data = map[string][]byte{corev1.TLSPrivateKeyKey: caKey}
+		return controllerutil.SetControllerReference(demoCluster, secret, r.Client.Scheme())
+	})
+	return err
+}
+
+// reconcileEtcdClientSecret issues the client certificate of the API servers the first time,
+// and keeps the etcd endpoints along with it
+func (r *DemoClusterReconciler) reconcileEtcdClientSecret(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster, ca *etcd.CertificateAuthority, endpoints []string) error {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: cluster.Namespace, Name: etcdClientSecretName(cluster)}
+	if err := r.Client.Get(ctx, key, secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		keyPair, err := ca.NewAPIServerClientKeyPair()
+		if err != nil {
+			return err
+		}
+		return r.createEtcdSecret(ctx, demoCluster, cluster, key.Name, map[string][]byte{
+			corev1.TLSCertKey:       keyPair.Cert,
+			corev1.TLSPrivateKeyKey: keyPair.Key,
+			etcdEndpointsKey:        []byte(strings.Join(endpoints, ",")),
+		})
+	}
+
+	if string(secret.Data[etcdEndpointsKey]) == strings.Join(endpoints, ",") {
+		return nil
+	}
+	secret.Data[etcdEndpointsKey] = []byte(strings.Join(endpoints, ","))
+	return r.Client.Update(ctx, secret)
+}
+
+// reconcileEtcdMemberSecret writes the bootstrap data of an etcd member the first time, it returns the name of its secret.
+// The member keeps its certificate and its initial cluster, which only matter to its first start.
+func (r *DemoClusterReconciler) reconcileEtcdMemberSecret(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster, ca *etcd.CertificateAuthority, config *etcd.Config) (string, error) {
+	name := fmt.Sprintf("%s-etcd-%s", demoCluster.Name, config.Member.Name)
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: demoCluster.Namespace, Name: name}, &corev1.Secret{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return name, err
+	}
+
+	ip := net.ParseIP(config.Member.Address)
+	if ip == nil {
+		return "", errors.Errorf("metal node %s has an invalid address %q for etcd", config.Member.Name, config.Member.Address)
+	}
+	config.CACert = ca.KeyPair().Cert
+	if config.KeyPair, err = ca.NewMemberKeyPair(config.Member.Name, ip); err != nil {
+		return "", err
+	}
+	data, err := etcd.BootstrapData(config)
+	if err != nil {
+		return "", err
+	}
+	return name, r.createEtcdSecret(ctx, demoCluster, cluster, name, map[string][]byte{
+		"value":  data,
+		"format": []byte("cloud-config"),
+	})
+}
+
+// createEtcdSecret creates a secret of the etcd cluster, owned by the demoCluster so that clusterctl moves it along
+func (r *DemoClusterReconciler) createEtcdSecret(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster, name string, data map[string][]byte) error {
+	secret := &corev1.Secret{}
+	secret.Name = name
+	secret.Namespace = demoCluster.Namespace
+	secret.Labels = map[string]string{clusterv1.ClusterLabelName: cluster.Name}
+	secret.Type = clusterv1.ClusterSecretType
+	secret.Data = data
+	if err := controllerutil.SetControllerReference(demoCluster, secret, r.Client.Scheme()); err != nil {
+		return err
+	}
+	return r.Client.Create(ctx, secret)
+}
+
+// getEtcdNodes returns the metal nodes running the etcd members of the cluster,
+// restoring their status if they were moved by clusterctl
+func getEtcdNodes(metalNodeList *metav1beta1.MetalNodeList, cluster *clusterv1.Cluster) ([]*metav1beta1.MetalNode, error) {
+	var etcdNodes []*metav1beta1.MetalNode
+	for i := range metalNodeList.Items {
+		metalNode := &metalNodeList.Items[i]
+		if !util.HasOwnerRef(metalNode.GetOwnerReferences(), clusterOwnerRef(cluster)) {
+			continue
+		}
+		if _, err := restoreMetalNodeStatus(metalNode); err != nil {
+			return nil, err
+		}
+		if metalNode.HasRole(constants.EtcdRoleValue) {
+			etcdNodes = append(etcdNodes, metalNode)
+		}
+	}
+	return etcdNodes, nil
+}
+
+// etcdBootstrapStarted returns true if a metal node got the bootstrap data of its etcd member
+func etcdBootstrapStarted(etcdNodes []*metav1beta1.MetalNode) bool {
+	for _, metalNode := range etcdNodes {
+		if metalNode.Status.DataSecretName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// etcdCASecretName returns the name of the secret Cluster API looks up the etcd certificate authority in
+func etcdCASecretName(cluster *clusterv1.Cluster) string {
+	return fmt.Sprintf("%s-etcd", cluster.Name)
+}
+
+// etcdCAKeySecretName returns the name of the secret holding the private key of the etcd certificate authority,
+// which Cluster API does not look up
+func etcdCAKeySecretName(cluster *clusterv1.Cluster) string {
+	return fmt.Sprintf("%s-etcd-ca-key", cluster.Name)
+}
+
+// etcdClientSecretName returns the name of the secret Cluster API looks up the etcd client certificate of the API servers in
+func etcdClientSecretName(cluster *clusterv1.Cluster) string {
+	return fmt.Sprintf("%s-apiserver-etcd-client", cluster.Name)
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/power/fakeredfish"
+)
+
+const (
+	timeout  = 30 * time.Second
+	interval = 250 * time.Millisecond
+)
+
+// defaultBootstrapData is the cloud-config the bootstrap data secrets of the Machines hold by default
+const defaultBootstrapData = "#cloud-config\nruncmd:\n- kubeadm join --config /run/kubeadm/kubeadm-join-config.yaml\n"
+
+// createNamespace creates a namespace isolating the objects of a spec
+func createNamespace(ctx context.Context) string {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-" + strings.ToLower(util.RandomString(6))}}
+	Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+	return ns.Name
+}
+
+// createMetalNode creates a metal node driven by the fake agent along the given profile
+func createMetalNode(ctx context.Context, namespace, host string, profile metalNodeProfile) *metav1beta1.MetalNode {
+	metalNode := &metav1beta1.MetalNode{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "metalnode-" + strings.ToLower(util.RandomString(6)),
+			Namespace: namespace,
+		},
+	}
+	metalNode.Spec.NodeEndPoint.Host = host
+	metalNodeAgent.SetProfile(metalNode.Name, profile)
+	Expect(k8sClient.Create(ctx, metalNode)).To(Succeed())
+	return metalNode
+}
+
+// annotateMetalNode sets an annotation on a metal node
+func annotateMetalNode(ctx context.Context, metalNode *metav1beta1.MetalNode, key, value string) {
+	Eventually(func() error {
+		if err := get(ctx, metalNode)(); err != nil {
+			return err
+		}
+		annotations := metalNode.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+		metalNode.SetAnnotations(annotations)
+		return k8sClient.Update(ctx, metalNode)
+	}, timeout, interval).Should(Succeed())
+}
+
+// createBMC serves the BMC of a metal node from a fake Redfish server, the server must be closed once done with
+func createBMC(ctx context.Context, metalNode *metav1beta1.MetalNode) *fakeredfish.Server {
+	bmc := fakeredfish.NewServer("admin", "password")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: metalNode.Name + "-bmc", Namespace: metalNode.Namespace},
+		Data: map[string][]byte{
+			"username": []byte("admin"),
+			"password": []byte("password"),
+		},
+	}
+	Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+	data, err := json.Marshal(infrav1.MetalNodeBMC{Address: bmc.Address(), CredentialsName: secret.Name})
+	Expect(err).NotTo(HaveOccurred())
+	annotateMetalNode(ctx, metalNode, infrav1.MetalNodeBMCAnnotation, string(data))
+	return bmc
+}
+
+// createCluster creates a Cluster with its control plane initialized and the DemoCluster it owns,
+// both mutated by opts before their creation
+func createCluster(ctx context.Context, namespace string, opts ...func(*clusterv1.Cluster, *infrav1.DemoCluster)) (*clusterv1.Cluster, *infrav1.DemoCluster) {
+	name := "cluster-" + strings.ToLower(util.RandomString(6))
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: clusterv1.ClusterSpec{
+			InfrastructureRef: &corev1.ObjectReference{
+				APIVersion: infrav1.GroupVersion.String(),
+				Kind:       "DemoCluster",
+				Name:       name,
+				Namespace:  namespace,
+			},
+		},
+	}
+	demoCluster := &infrav1.DemoCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+	for _, opt := range opts {
+		opt(cluster, demoCluster)
+	}
+	Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+
+	cluster.Status.InfrastructureReady = true
+	conditions.MarkTrue(cluster, clusterv1.ControlPlaneInitializedCondition)
+	Expect(k8sClient.Status().Update(ctx, cluster)).To(Succeed())
+
+	demoCluster.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: clusterv1.GroupVersion.String(),
+		Kind:       "Cluster",
+		Name:       cluster.Name,
+		UID:        cluster.UID,
+	}}
+	Expect(k8sClient.Create(ctx, demoCluster)).To(Succeed())
+	return cluster, demoCluster
+}
+
+// createKubeadmControlPlane creates a KubeadmControlPlane with a file of its own, as unstructured
+// since the provider does not depend on the kubeadm control plane API
+func createKubeadmControlPlane(ctx context.Context, namespace, name string) *unstructured.Unstructured {
+	kcp := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "controlplane.cluster.x-k8s.io/v1beta1",
+		"kind":       "KubeadmControlPlane",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"spec": map[string]interface{}{
+			"version": "v1.23.6",
+			"machineTemplate": map[string]interface{}{
+				"infrastructureRef": map[string]interface{}{
+					"apiVersion": infrav1.GroupVersion.String(),
+					"kind":       "DemoMachineTemplate",
+					"name":       name,
+				},
+			},
+			"kubeadmConfigSpec": map[string]interface{}{
+				"files": []interface{}{
+					map[string]interface{}{"path": "/etc/motd", "content": "demo"},
+				},
+			},
+		},
+	}}
+	Expect(k8sClient.Create(ctx, kcp)).To(Succeed())
+	return kcp
+}
+
+// createMachine creates a Machine with its bootstrap data set and the DemoMachine it owns, mutated by opts before its creation
+func createMachine(ctx context.Context, cluster *clusterv1.Cluster, controlPlane bool, opts ...func(*infrav1.DemoMachine)) (*clusterv1.Machine, *infrav1.DemoMachine) {
+	return createMachineWithBootstrapData(ctx, cluster, controlPlane, "cloud-config", defaultBootstrapData, opts...)
+}
+
+// createMachineWithBootstrapData creates a Machine and its DemoMachine, the bootstrap data secret of the Machine
+// holds the given bootstrap data
+func createMachineWithBootstrapData(ctx context.Context, cluster *clusterv1.Cluster, controlPlane bool, format, value string, opts ...func(*infrav1.DemoMachine)) (*clusterv1.Machine, *infrav1.DemoMachine) {
+	return createMachineWithBootstrapSecret(ctx, cluster, controlPlane, func(_ *clusterv1.Machine, secret *corev1.Secret) {
+		secret.Data = map[string][]byte{"value": []byte(value), "format": []byte(format)}
+	}, opts...)
+}
+
+// createMachineWithVersion creates a Machine of the given Kubernetes version and its DemoMachine
+func createMachineWithVersion(ctx context.Context, cluster *clusterv1.Cluster, controlPlane bool, version string, opts ...func(*infrav1.DemoMachine)) (*clusterv1.Machine, *infrav1.DemoMachine) {
+	return createMachineWithBootstrapSecret(ctx, cluster, controlPlane, func(machine *clusterv1.Machine, secret *corev1.Secret) {
+		machine.Spec.Version = &version
+		secret.Data = map[string][]byte{"value": []byte(defaultBootstrapData)}
+	}, opts...)
+}
+
+// createMachineWithBootstrapSecret creates a Machine and its DemoMachine, the bootstrap data secret of the Machine is owned
+// by the KubeadmConfig of the Machine, both are mutated by mutate before their creation
+func createMachineWithBootstrapSecret(ctx context.Context, cluster *clusterv1.Cluster, controlPlane bool, mutate func(*clusterv1.Machine, *corev1.Secret), opts ...func(*infrav1.DemoMachine)) (*clusterv1.Machine, *infrav1.DemoMachine) {
+	name := "machine-" + strings.ToLower(util.RandomString(6))
+	labels := map[string]string{clusterv1.ClusterLabelName: cluster.Name}
+	if controlPlane {
+		labels[clusterv1.MachineControlPlaneLabelName] = ""
+	}
+	// the KubeadmConfig itself is not created, the bootstrap data secret only refers to it
+	configRef := &corev1.ObjectReference{
+		APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1",
+		Kind:       "KubeadmConfig",
+		Name:       name,
+		Namespace:  cluster.Namespace,
+	}
+	dataSecretName := fmt.Sprintf("%s-bootstrap", name)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dataSecretName,
+			Namespace: cluster.Namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: configRef.APIVersion,
+				Kind:       configRef.Kind,
+				Name:       configRef.Name,
+				UID:        types.UID(util.RandomString(16)),
+			}},
+		},
+		Type: clusterv1.ClusterSecretType,
+	}
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cluster.Namespace, Labels: labels},
+		Spec: clusterv1.MachineSpec{
+			ClusterName: cluster.Name,
+			Bootstrap:   clusterv1.Bootstrap{ConfigRef: configRef, DataSecretName: &dataSecretName},
+			InfrastructureRef: corev1.ObjectReference{
+				APIVersion: infrav1.GroupVersion.String(),
+				Kind:       "DemoMachine",
+				Name:       name,
+				Namespace:  cluster.Namespace,
+			},
+		},
+	}
+	mutate(machine, secret)
+	Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+	Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+	demoMachine := &infrav1.DemoMachine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cluster.Namespace,
+			Labels:    map[string]string{clusterv1.ClusterLabelName: cluster.Name},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: clusterv1.GroupVersion.String(),
+				Kind:       "Machine",
+				Name:       machine.Name,
+				UID:        machine.UID,
+			}},
+		},
+	}
+	for _, opt := range opts {
+		opt(demoMachine)
+	}
+	Expect(k8sClient.Create(ctx, demoMachine)).To(Succeed())
+	return machine, demoMachine
+}
+
+// createMachineSet creates a MachineSet of workers of a cluster with the given replicas, its Machines are not created
+func createMachineSet(ctx context.Context, cluster *clusterv1.Cluster, replicas int32) *clusterv1.MachineSet {
+	name := "workers-" + strings.ToLower(util.RandomString(6))
+	dataSecretName := name
+	machineSet := &clusterv1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cluster.Namespace},
+		Spec: clusterv1.MachineSetSpec{
+			ClusterName: cluster.Name,
+			Replicas:    &replicas,
+			Selector:    metav1.LabelSelector{MatchLabels: map[string]string{"machine-set": name}},
+			Template: clusterv1.MachineTemplateSpec{
+				ObjectMeta: clusterv1.ObjectMeta{Labels: map[string]string{"machine-set": name}},
+				Spec: clusterv1.MachineSpec{
+					ClusterName: cluster.Name,
+					Bootstrap:   clusterv1.Bootstrap{DataSecretName: &dataSecretName},
+					InfrastructureRef: corev1.ObjectReference{
+						APIVersion: infrav1.GroupVersion.String(),
+						Kind:       "DemoMachineTemplate",
+						Name:       name,
+					},
+				},
+			},
+		},
+	}
+	Expect(k8sClient.Create(ctx, machineSet)).To(Succeed())
+	return machineSet
+}
+
+// createMachineOfMachineSet creates a worker Machine controlled by a MachineSet and its DemoMachine
+func createMachineOfMachineSet(ctx context.Context, cluster *clusterv1.Cluster, machineSet *clusterv1.MachineSet) (*clusterv1.Machine, *infrav1.DemoMachine) {
+	return createMachineWithBootstrapSecret(ctx, cluster, false, func(machine *clusterv1.Machine, secret *corev1.Secret) {
+		controller := true
+		machine.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: clusterv1.GroupVersion.String(),
+			Kind:       "MachineSet",
+			Name:       machineSet.Name,
+			UID:        machineSet.UID,
+			Controller: &controller,
+		}}
+		secret.Data = map[string][]byte{"value": []byte(defaultBootstrapData)}
+	})
+}
+
+// findEvent returns a function returning the message of the last event of the given reason recorded on obj,
+// to be used with Eventually
+func findEvent(ctx context.Context, obj client.Object, reason string) func() string {
+	return func() string {
+		eventList := &corev1.EventList{}
+		Expect(k8sClient.List(ctx, eventList, client.InNamespace(obj.GetNamespace()))).To(Succeed())
+		message := ""
+		for _, event := range eventList.Items {
+			if event.InvolvedObject.UID == obj.GetUID() && event.Reason == reason {
+				message = event.Message
+			}
+		}
+		return message
+	}
+}
+
+// get returns a function fetching the latest version of obj, to be used with Eventually
+func get(ctx context.Context, obj client.Object) func() error {
+	return func() error {
+		return k8sClient.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	}
+}
+
+// getMetalNode returns the latest version of a metal node
+func getMetalNode(ctx context.Context, namespace, name string) *metav1beta1.MetalNode {
+	metalNode := &metav1beta1.MetalNode{}
+	metalNode.Namespace = namespace
+	metalNode.Name = name
+	Expect(get(ctx, metalNode)()).To(Succeed())
+	return metalNode
+}
+
+// simulateMove pauses the cluster and wipes the status of objs the way clusterctl move does, then resumes the cluster
+func simulateMove(ctx context.Context, cluster *clusterv1.Cluster, objs ...client.Object) {
+	setPaused(ctx, cluster, true)
+	for _, obj := range objs {
+		Eventually(func() error {
+			if err := get(ctx, obj)(); err != nil {
+				return err
+			}
+			switch o := obj.(type) {
+			case *infrav1.DemoCluster:
+				o.Status = infrav1.DemoClusterStatus{}
+			case *infrav1.DemoMachine:
+				o.Status = infrav1.DemoMachineStatus{}
+			case *metav1beta1.MetalNode:
+				o.Status = metav1beta1.MetalNodeStatus{}
+			}
+			return k8sClient.Status().Update(ctx, obj)
+		}, timeout, interval).Should(Succeed())
+	}
+	setPaused(ctx, cluster, false)
+}
+
+// setPaused pauses or resumes the reconciliation of a cluster
+func setPaused(ctx context.Context, cluster *clusterv1.Cluster, paused bool) {
+	Eventually(func() error {
+		if err := get(ctx, cluster)(); err != nil {
+			return err
+		}
+		cluster.Spec.Paused = paused
+		return k8sClient.Update(ctx, cluster)
+	}, timeout, interval).Should(Succeed())
+}
+
+// hasOwnerRef returns true if obj is owned by the object of the given kind and name
+func hasOwnerRef(obj client.Object, apiVersion, kind, name string) bool {
+	return util.HasOwnerRef(obj.GetOwnerReferences(), metav1.OwnerReference{APIVersion: apiVersion, Kind: kind, Name: name})
+}
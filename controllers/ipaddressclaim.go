@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/ipam"
+)
+
+// reconcileIPAddressClaim claims the static address of the demoMachine from its pool through an IPAddressClaim
+// named after the demoMachine, it returns nil until the pool allocates the address
+func (r *DemoMachineReconciler) reconcileIPAddressClaim(ctx context.Context, demoMachine *infrav1.DemoMachine, cluster *clusterv1.Cluster) (*ipam.Address, error) {
+	claim := ipam.NewClaim()
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(demoMachine), claim); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		claim.SetName(demoMachine.Name)
+		claim.SetNamespace(demoMachine.Namespace)
+		claim.SetLabels(map[string]string{clusterv1.ClusterLabelName: cluster.Name})
+		if err := controllerutil.SetControllerReference(demoMachine, claim, r.Scheme); err != nil {
+			return nil, err
+		}
+		if err := ipam.SetPoolRef(claim, *demoMachine.Spec.IPAddressPool); err != nil {
+			return nil, err
+		}
+		if err := r.Client.Create(ctx, claim); err != nil {
+			return nil, errors.Wrapf(err, "failed to create IPAddressClaim %s", demoMachine.Name)
+		}
+		return nil, nil
+	}
+
+	name := ipam.GetAddressRef(claim)
+	if name == "" {
+		return nil, nil
+	}
+	ipAddress := ipam.NewAddress()
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: demoMachine.Namespace, Name: name}, ipAddress); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	address, err := ipam.GetAddress(ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// deleteIPAddressClaim deletes the IPAddressClaim of the demoMachine, releasing its static address to the pool
+func (r *DemoMachineReconciler) deleteIPAddressClaim(ctx context.Context, demoMachine *infrav1.DemoMachine) error {
+	claim := ipam.NewClaim()
+	claim.SetName(demoMachine.Name)
+	claim.SetNamespace(demoMachine.Namespace)
+	if err := r.Client.Delete(ctx, claim); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete IPAddressClaim %s", demoMachine.Name)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/loadbalancer"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// reconcileLoadBalancer claims a metal node in the load-balancer role, points the control plane endpoint to it
+// and delivers the HAProxy configuration of the control plane machines through its bootstrap data
+func (r *DemoClusterReconciler) reconcileLoadBalancer(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (ctrl.Result, error) {
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := r.Client.List(ctx, metalNodeList, watchFilterListOptions(demoCluster.Namespace, r.WatchFilterValue)...); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	lbNode, err := getLoadBalancerNode(metalNodeList, cluster)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if lbNode == nil {
+		for i := range metalNodeList.Items {
+			metalNode := &metalNodeList.Items[i]
+			if metalNode.IsReady() && metalNode.GetRefCluster() == "" && metalNodeClaimedBy(metalNode) == "" && !metalNodeStatusLost(metalNode) && !metalNodeReprovisioning(metalNode) && !metalNodeCordoned(metalNode) &&
+				!metalNode.HasRole(constants.ControlPlaneNodeRoleValue) && !metalNode.HasRole(constants.WorkerNodeRoleValue) {
+				if _, err := endpointAddress(metalNode, demoCluster, cluster); err != nil {
+					log.Infof("metal node %s can not supply the control plane endpoint: %v", metalNode.Name, err)
+					continue
+				}
+				lbNode = metalNode
+				break
+			}
+		}
+		if lbNode == nil {
+			conditions.MarkFalse(demoCluster, constants.ControlPlaneEndPointSetCondition, constants.NoMetalNodeFoundReason, clusterv1.ConditionSeverityWarning, "no metal node found for the load balancer")
+			log.Info("no metalnode found for the load balancer")
+			return ctrl.Result{}, nil
+		}
+		lbNode.SetRole(constants.LoadBalancerRoleValue)
+		lbNode.Status.RefCluster = cluster.Name
+		lbNode.SetOwnerReferences(util.EnsureOwnerRef(lbNode.GetOwnerReferences(), clusterOwnerRef(cluster)))
+		log.Infof("claimed metal node %s for the load balancer", lbNode.Name)
+	}
+
+	port := demoCluster.Spec.LoadBalancer.Port
+	if port == 0 {
+		port = infrav1.DefaultAPIServerPort
+	}
+	if !demoCluster.Spec.ControlPlaneEndpoint.IsValid() {
+		address, err := endpointAddress(lbNode, demoCluster, cluster)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		demoCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{Host: address, Port: port}
+	}
+	conditions.MarkTrue(demoCluster, constants.ControlPlaneEndPointSetCondition)
+
+	backends := getLoadBalancerBackends(metalNodeList, demoCluster, cluster)
+	config, err := loadbalancer.Config(port, backends)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	changed, err := r.reconcileLoadBalancerSecret(ctx, demoCluster, cluster, config)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// the configuration is delivered as the bootstrap data of the metal node, a bootstrapped metal node is asked to run it again
+	// once it changes
+	if lbNode.Status.DataSecretName != loadBalancerSecretName(demoCluster) {
+		lbNode.Status.DataSecretName = loadBalancerSecretName(demoCluster)
+	} else if changed && lbNode.Status.Bootstrapped {
+		requestBootstrapRerun(lbNode, loadBalancerConfigRevision(config))
+		log.Infof("asked metal node %s to run the new load balancer configuration", lbNode.Name)
+	}
+	if err := metalnode.Update(ctx, r.Client, lbNode); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	demoCluster.Status.LoadBalancer = &infrav1.DemoLoadBalancerStatus{MetalNodeName: lbNode.Name}
+	for _, backend := range backends {
+		demoCluster.Status.LoadBalancer.Backends = append(demoCluster.Status.LoadBalancer.Backends, backend.Address)
+	}
+
+	if !lbNode.IsReady() || !lbNode.Status.Bootstrapped || bootstrapRerunRequested(lbNode) {
+		conditions.MarkFalse(demoCluster, constants.LoadBalancerAvailableCondition, constants.WaitingForMetalNodeBootstrapReason, clusterv1.ConditionSeverityInfo, "")
+		return ctrl.Result{}, nil
+	}
+	conditions.MarkTrue(demoCluster, constants.LoadBalancerAvailableCondition)
+	demoCluster.Status.Ready = true
+	return ctrl.Result{}, nil
+}
+
+// reconcileLoadBalancerSecret writes the bootstrap data of the load balancer, it returns true if it changed
+func (r *DemoClusterReconciler) reconcileLoadBalancerSecret(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster, config string) (bool, error) {
+	data, err := loadbalancer.BootstrapData(config)
+	if err != nil {
+		return false, err
+	}
+
+	secret := &corev1.Secret{}
+	secret.Name = loadBalancerSecretName(demoCluster)
+	secret.Namespace = demoCluster.Namespace
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = map[string]string{clusterv1.ClusterLabelName: cluster.Name}
+		secret.Type = clusterv1.ClusterSecretType
+		secret.Data = map[string][]byte{
+			"value":  data,
+			"format": []byte("cloud-config"),
+		}
+		return controllerutil.SetControllerReference(demoCluster, secret, r.Client.Scheme())
+	})
+	if err != nil {
+		return false, err
+	}
+	return result == controllerutil.OperationResultUpdated, nil
+}
+
+// getLoadBalancerNode returns the metal node running the load balancer of the cluster,
+// restoring its status if it was moved by clusterctl
+func getLoadBalancerNode(metalNodeList *metav1beta1.MetalNodeList, cluster *clusterv1.Cluster) (*metav1beta1.MetalNode, error) {
+	for i := range metalNodeList.Items {
+		metalNode := &metalNodeList.Items[i]
+		if !util.HasOwnerRef(metalNode.GetOwnerReferences(), clusterOwnerRef(cluster)) {
+			continue
+		}
+		if _, err := restoreMetalNodeStatus(metalNode); err != nil {
+			return nil, err
+		}
+		if metalNode.HasRole(constants.LoadBalancerRoleValue) {
+			return metalNode, nil
+		}
+	}
+	return nil, nil
+}
+
+// getLoadBalancerBackends returns the metal nodes of the bootstrapped control plane machines of the cluster. Until the
+// control plane is initialized the ones running their bootstrap data are returned as well, kubeadm reaches the API server
+// of the first control plane machine through the load balancer while it initializes the cluster.
+func getLoadBalancerBackends(metalNodeList *metav1beta1.MetalNodeList, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) []loadbalancer.Backend {
+	var backends []loadbalancer.Backend
+	for i := range metalNodeList.Items {
+		// look through a status not restored yet, or not reported again by the agent, the backends would flap otherwise
+		metalNode := metalNodeList.Items[i].DeepCopy()
+		if metalNodeStatusLost(metalNode) {
+			status, err := mirroredMetalNodeStatus(metalNode)
+			if err != nil {
+				log.WithError(err).Errorf("ignoring metal node %s for the load balancer", metalNode.Name)
+				continue
+			}
+			metalNode.Status = *status
+		}
+		if !metalNodeRefersTo(metalNode, cluster.Name, demoCluster.Name) || !metalNode.ContainRole(constants.ControlPlaneNodeRoleValue) {
+			continue
+		}
+		initializing := !conditions.IsTrue(cluster, clusterv1.ControlPlaneInitializedCondition) && metalNode.IsReady() && metalNode.Status.DataSecretName != ""
+		if !metalNode.Status.Bootstrapped && !initializing {
+			continue
+		}
+		address, err := endpointAddress(metalNode, demoCluster, cluster)
+		if err != nil {
+			log.WithError(err).Errorf("ignoring metal node %s for the load balancer", metalNode.Name)
+			continue
+		}
+		backends = append(backends, loadbalancer.Backend{
+			Name:    metalNode.Name,
+			Address: address,
+			Port:    apiServerPort(demoCluster),
+		})
+	}
+	return backends
+}
+
+// loadBalancerConfigRevision returns the revision of a HAProxy configuration, the metal node is asked to run
+func loadBalancerConfigRevision(config string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(config)))[:16]
+}
+
+// loadBalancerSecretName returns the name of the secret holding the bootstrap data of the load balancer
+func loadBalancerSecretName(demoCluster *infrav1.DemoCluster) string {
+	return fmt.Sprintf("%s-load-balancer", demoCluster.Name)
+}
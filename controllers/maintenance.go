@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// metalNodeCordoned returns true if the metal node is in maintenance, it is not claimed then
+func metalNodeCordoned(metalNode *metav1beta1.MetalNode) bool {
+	_, ok := metalNode.GetAnnotations()[infrav1.MetalNodeMaintenanceAnnotation]
+	return ok
+}
+
+// metalNodeEvacuated returns true if the machine hosted on the metal node is to be replaced on another metal node
+func metalNodeEvacuated(metalNode *metav1beta1.MetalNode) bool {
+	return infrav1.MaintenanceMode(metalNode.GetAnnotations()[infrav1.MetalNodeMaintenanceAnnotation]) == infrav1.EvacuateMaintenanceMode
+}
+
+// evacuateMachine deletes the worker Machine hosted on an evacuated metal node so that its MachineSet replaces it on
+// another metal node. A control plane Machine is not deleted from under its control plane, which would lose a member
+// of etcd without a replacement, it is marked to be deleted first by the next rollout or scale down of the control
+// plane instead. A Machine without a controller would not be replaced, it is left to be deleted by hand.
+func (r *DemoMachineReconciler) evacuateMachine(ctx context.Context, machine *clusterv1.Machine, demoMachine *infrav1.DemoMachine, metalNode *metav1beta1.MetalNode, l log.Logger) error {
+	owner := metav1.GetControllerOf(machine)
+	if owner == nil {
+		conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.MetalNodeEvacuatedReason, clusterv1.ConditionSeverityWarning,
+			"metal node %s is evacuated but the Machine has no controller to replace it, delete the Machine to release the metal node", metalNode.Name)
+		l.Warnf("metal node %s is evacuated but the machine has no controller to replace it", metalNode.Name)
+		return nil
+	}
+	if owner.Kind != "MachineSet" {
+		conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.MetalNodeEvacuatedReason, clusterv1.ConditionSeverityWarning,
+			"metal node %s is evacuated, the Machine is not deleted from under its %s %s but deleted first by its next rollout or scale down",
+			metalNode.Name, owner.Kind, owner.Name)
+		if _, ok := machine.GetAnnotations()[clusterv1.DeleteMachineAnnotation]; ok {
+			return nil
+		}
+		before := machine.DeepCopy()
+		annotations := machine.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[clusterv1.DeleteMachineAnnotation] = ""
+		machine.SetAnnotations(annotations)
+		if err := r.Client.Patch(ctx, machine, client.MergeFrom(before)); err != nil {
+			return errors.Wrapf(err, "failed to mark machine %s for deletion", machine.Name)
+		}
+		r.Recorder.Eventf(demoMachine, corev1.EventTypeWarning, constants.MetalNodeEvacuatedReason,
+			"Metal node %s is evacuated, marked the Machine to be deleted first by the next rollout or scale down of its %s %s", metalNode.Name, owner.Kind, owner.Name)
+		l.Infof("metal node %s is evacuated, marked the machine to be deleted first by its %s %s", metalNode.Name, owner.Kind, owner.Name)
+		return nil
+	}
+	conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.MetalNodeEvacuatedReason, clusterv1.ConditionSeverityWarning,
+		"metal node %s is evacuated, the Machine is replaced by its %s %s", metalNode.Name, owner.Kind, owner.Name)
+	if !machine.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	if err := r.Client.Delete(ctx, machine); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete machine %s", machine.Name)
+	}
+	l.Infof("metal node %s is evacuated, deleted the machine to be replaced by its %s %s", metalNode.Name, owner.Kind, owner.Name)
+	return nil
+}
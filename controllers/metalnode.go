@@ -0,0 +1,143 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/ipam"
+)
+
+// setMetalNodeStaticAddress hands the static address claimed by the demo machine bound to a metal node to its agent
+func setMetalNodeStaticAddress(metalNode *metav1beta1.MetalNode, address *ipam.Address) error {
+	if address == nil {
+		return nil
+	}
+	data, err := json.Marshal(infrav1.MetalNodeStaticAddress{
+		Address: address.Address,
+		Prefix:  address.Prefix,
+		Gateway: address.Gateway,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the static address")
+	}
+	annotations := metalNode.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[infrav1.MetalNodeStaticAddressAnnotation] = string(data)
+	metalNode.SetAnnotations(annotations)
+	return nil
+}
+
+// metalNodeClaimedBy returns the name of the demo machine a metal node is claimed by, empty if none is
+func metalNodeClaimedBy(metalNode *metav1beta1.MetalNode) string {
+	return metalNode.GetAnnotations()[infrav1.MetalNodeDemoMachineAnnotation]
+}
+
+// claimMetalNode binds a metal node to a demo machine, the claim is taken once the metal node is updated
+func claimMetalNode(metalNode *metav1beta1.MetalNode, demoMachine *infrav1.DemoMachine) {
+	annotations := metalNode.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[infrav1.MetalNodeDemoMachineAnnotation] = demoMachine.Name
+	// a metal node claimed again before it was powered off is no longer to be powered off
+	delete(annotations, infrav1.MetalNodePowerOffAnnotation)
+	metalNode.SetAnnotations(annotations)
+}
+
+// metalNodeRefersTo tells whether a metal node is claimed for a cluster. The metal nodes refer to the name of the Cluster,
+// the ones claimed by the demo machines before the name was normalized refer to the name of its DemoCluster.
+func metalNodeRefersTo(metalNode *metav1beta1.MetalNode, clusterName, demoClusterName string) bool {
+	refCluster := metalNode.GetRefCluster()
+	return refCluster != "" && (refCluster == clusterName || refCluster == demoClusterName)
+}
+
+// metalNodeStatusLost returns true if the metal node is claimed but lost its status, e.g. it was moved by clusterctl
+func metalNodeStatusLost(metalNode *metav1beta1.MetalNode) bool {
+	_, ok := metalNode.GetAnnotations()[infrav1.MetalNodeStatusAnnotation]
+	return ok && metalNode.GetRefCluster() == ""
+}
+
+// mirroredMetalNodeStatus returns the status of a metal node mirrored in its annotation
+func mirroredMetalNodeStatus(metalNode *metav1beta1.MetalNode) (*metav1beta1.MetalNodeStatus, error) {
+	status := &metav1beta1.MetalNodeStatus{}
+	if err := json.Unmarshal([]byte(metalNode.GetAnnotations()[infrav1.MetalNodeStatusAnnotation]), status); err != nil {
+		return nil, errors.Wrapf(err, "failed to read the status of metal node %s", metalNode.Name)
+	}
+	return status, nil
+}
+
+// restoreMetalNodeStatus restores the fields of the status of a metal node the provider sets from its annotation if the
+// status was lost, it returns true if the status was restored. Ready and Bootstrapped are left to the agent of the metal node.
+func restoreMetalNodeStatus(metalNode *metav1beta1.MetalNode) (bool, error) {
+	if !metalNodeStatusLost(metalNode) {
+		return false, nil
+	}
+	status, err := mirroredMetalNodeStatus(metalNode)
+	if err != nil {
+		return false, err
+	}
+	metalNode.Status.Role = status.Role
+	metalNode.Status.RefCluster = status.RefCluster
+	metalNode.Status.DataSecretName = status.DataSecretName
+	return true, nil
+}
+
+// requestBootstrapRerun asks the agent of a bootstrapped metal node to run a revision of its bootstrap data again
+func requestBootstrapRerun(metalNode *metav1beta1.MetalNode, revision string) {
+	annotations := metalNode.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[infrav1.MetalNodeRerunBootstrapAnnotation] = revision
+	metalNode.SetAnnotations(annotations)
+}
+
+// bootstrapRerunRequested tells whether the agent of a metal node is yet to run its bootstrap data again
+func bootstrapRerunRequested(metalNode *metav1beta1.MetalNode) bool {
+	_, ok := metalNode.GetAnnotations()[infrav1.MetalNodeRerunBootstrapAnnotation]
+	return ok
+}
+
+// clusterOwnerRef returns the owner reference a cluster sets on the metal node of its control plane endpoint
+func clusterOwnerRef(cluster *clusterv1.Cluster) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: clusterv1.GroupVersion.String(),
+		Kind:       "Cluster",
+		Name:       cluster.Name,
+		UID:        cluster.UID,
+	}
+}
+
+// demoMachineOwnerRef returns the owner reference the demo machines used to set on the metal node hosting them,
+// it is removed so that the metal node is not garbage collected along with the demo machine
+func demoMachineOwnerRef(demoMachine *infrav1.DemoMachine) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: infrav1.GroupVersion.String(),
+		Kind:       "DemoMachine",
+		Name:       demoMachine.Name,
+		UID:        demoMachine.UID,
+	}
+}
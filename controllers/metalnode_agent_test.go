@@ -0,0 +1,242 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/test/fakemetalnode"
+)
+
+// metalNodeProfile describes the timeline the fake metal node agent follows for a metal node
+type metalNodeProfile struct {
+	// ReadyAfter is the time it takes to initialize the metal node after its creation
+	ReadyAfter time.Duration
+	// BootstrapAfter is the time it takes to bootstrap the metal node once its bootstrap data is set
+	BootstrapAfter time.Duration
+	// FailInitialization never reports the metal node ready
+	FailInitialization bool
+	// FailBootstrap never reports the metal node bootstrapped
+	FailBootstrap bool
+	// FailReboot never reboots the metal node
+	FailReboot bool
+	// PowerState returns the power state of the host, On or Off, the metal node is reported not ready while it is Off.
+	// The hosts are always on when it is not set.
+	PowerState func() string
+}
+
+// fakeMetalNodeAgent stands in for the agent running on the bare metal hosts and for the metal node controller,
+// it initializes the metal nodes and bootstraps them once their bootstrap data is set.
+type fakeMetalNodeAgent struct {
+	client.Client
+
+	mu       sync.Mutex
+	profiles map[string]metalNodeProfile
+	// dataSeen records when the bootstrap data of a metal node was first seen
+	dataSeen map[string]time.Time
+	// reboots counts the reboots of each metal node
+	reboots map[string]int
+	// reinitializations counts the reinitializations of each metal node asked by the provider
+	reinitializations map[string]int
+	// bootstrapData records the bootstrap data each metal node was bootstrapped with
+	bootstrapData map[string]string
+}
+
+func newFakeMetalNodeAgent(c client.Client) *fakeMetalNodeAgent {
+	return &fakeMetalNodeAgent{
+		Client:            c,
+		profiles:          map[string]metalNodeProfile{},
+		dataSeen:          map[string]time.Time{},
+		reboots:           map[string]int{},
+		reinitializations: map[string]int{},
+		bootstrapData:     map[string]string{},
+	}
+}
+
+// SetProfile sets the timeline of a metal node, metal nodes without a profile become ready and bootstrapped immediately
+func (a *fakeMetalNodeAgent) SetProfile(name string, profile metalNodeProfile) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.profiles[name] = profile
+}
+
+func (a *fakeMetalNodeAgent) profile(name string) metalNodeProfile {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.profiles[name]
+}
+
+// bootstrapDataSeen returns when the bootstrap data of the metal node was first seen
+func (a *fakeMetalNodeAgent) bootstrapDataSeen(name string) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.dataSeen[name]; !ok {
+		a.dataSeen[name] = time.Now()
+	}
+	return a.dataSeen[name]
+}
+
+// Reboots returns the number of times the metal node was rebooted
+func (a *fakeMetalNodeAgent) Reboots(name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reboots[name]
+}
+
+func (a *fakeMetalNodeAgent) reboot(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reboots[name]++
+}
+
+// Reinitializations returns the number of times the metal node was initialized again
+func (a *fakeMetalNodeAgent) Reinitializations(name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reinitializations[name]
+}
+
+func (a *fakeMetalNodeAgent) reinitialize(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reinitializations[name]++
+}
+
+// BootstrapData returns the bootstrap data the metal node was last bootstrapped with
+func (a *fakeMetalNodeAgent) BootstrapData(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.bootstrapData[name]
+}
+
+func (a *fakeMetalNodeAgent) setBootstrapData(name, data string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.bootstrapData[name] = data
+}
+
+func (a *fakeMetalNodeAgent) forgetBootstrapData(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.dataSeen, name)
+}
+
+// SetupWithManager sets up the fake agent with the Manager.
+func (a *fakeMetalNodeAgent) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("fake-metalnode-agent").
+		For(&metav1beta1.MetalNode{}).
+		Complete(a)
+}
+
+// Reconcile moves a metal node along its timeline
+func (a *fakeMetalNodeAgent) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	metalNode := &metav1beta1.MetalNode{}
+	if err := a.Get(ctx, req.NamespacedName, metalNode); err != nil {
+		if apierrors.IsNotFound(err) {
+			a.forgetBootstrapData(req.Name)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	profile := a.profile(metalNode.Name)
+
+	// a powered off host runs no agent, the metal node controller reports it not ready until it is powered on
+	if profile.PowerState != nil && profile.PowerState() == "Off" {
+		if metalNode.IsReady() {
+			fakemetalnode.MarkNotReady(metalNode)
+			return ctrl.Result{}, a.Status().Update(ctx, metalNode)
+		}
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
+
+	// power-cycle the host, it comes back in the state it was in
+	if _, ok := metalNode.GetAnnotations()[infrav1.MetalNodeRebootAnnotation]; ok && !profile.FailReboot {
+		a.reboot(metalNode.Name)
+		delete(metalNode.Annotations, infrav1.MetalNodeRebootAnnotation)
+		return ctrl.Result{}, a.Update(ctx, metalNode)
+	}
+
+	// initialize a released metal node again, as the metal node controller does when asked to
+	if _, ok := metalNode.GetAnnotations()[infrav1.MetalNodeReinitializeAnnotation]; ok && !profile.FailInitialization {
+		a.reinitialize(metalNode.Name)
+		delete(metalNode.Annotations, infrav1.MetalNodeReinitializeAnnotation)
+		if err := a.Update(ctx, metalNode); err != nil {
+			return ctrl.Result{}, err
+		}
+		fakemetalnode.MarkReady(metalNode)
+		return ctrl.Result{}, a.Status().Update(ctx, metalNode)
+	}
+
+	if !metalNode.IsReady() {
+		if profile.FailInitialization {
+			return ctrl.Result{}, nil
+		}
+		if wait := time.Until(metalNode.CreationTimestamp.Add(profile.ReadyAfter)); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		// install the Kubernetes version requested for the metal node
+		if requested := metalNode.GetAnnotations()[infrav1.MetalNodeRequestedKubernetesVersionAnnotation]; requested != "" &&
+			metalNode.GetAnnotations()[infrav1.MetalNodeKubernetesVersionAnnotation] != requested {
+			metalNode.Annotations[infrav1.MetalNodeKubernetesVersionAnnotation] = requested
+			if err := a.Update(ctx, metalNode); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		fakemetalnode.MarkReady(metalNode)
+		return ctrl.Result{}, a.Status().Update(ctx, metalNode)
+	}
+
+	// run the bootstrap data of a bootstrapped metal node again
+	if _, ok := metalNode.GetAnnotations()[infrav1.MetalNodeRerunBootstrapAnnotation]; ok && metalNode.Status.Bootstrapped {
+		secret := &corev1.Secret{}
+		if err := a.Get(ctx, client.ObjectKey{Namespace: metalNode.Namespace, Name: metalNode.Status.DataSecretName}, secret); err != nil {
+			return ctrl.Result{}, err
+		}
+		a.setBootstrapData(metalNode.Name, string(secret.Data["value"]))
+		delete(metalNode.Annotations, infrav1.MetalNodeRerunBootstrapAnnotation)
+		return ctrl.Result{}, a.Update(ctx, metalNode)
+	}
+
+	// the metal node was released or is already bootstrapped
+	if metalNode.Status.DataSecretName == "" || metalNode.Status.Bootstrapped {
+		a.forgetBootstrapData(metalNode.Name)
+		return ctrl.Result{}, nil
+	}
+	if profile.FailBootstrap {
+		return ctrl.Result{}, nil
+	}
+	if wait := time.Until(a.bootstrapDataSeen(metalNode.Name).Add(profile.BootstrapAfter)); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	secret := &corev1.Secret{}
+	if err := a.Get(ctx, client.ObjectKey{Namespace: metalNode.Namespace, Name: metalNode.Status.DataSecretName}, secret); err != nil {
+		return ctrl.Result{}, err
+	}
+	a.setBootstrapData(metalNode.Name, string(secret.Data["value"]))
+	metalNode.Status.Bootstrapped = true
+	return ctrl.Result{}, a.Status().Update(ctx, metalNode)
+}
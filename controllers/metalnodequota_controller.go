@@ -0,0 +1,122 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/predicates"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// MetalNodeQuotaReconciler reconciles a MetalNodeQuota object, reporting the metal nodes claimed by its clusters.
+// The quota itself is enforced by the DemoMachineReconciler when it binds the machines to metal nodes.
+type MetalNodeQuotaReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// WatchFilterValue is the label value used to filter events and metal nodes prior to reconciliation.
+	WatchFilterValue string
+}
+
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=metalnodequotas,verbs=get;list;watch
+//+kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=metalnodequotas/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;list;watch
+//+kubebuilder:rbac:groups=bocloud.io,resources=metalnodes,verbs=get;list;watch
+
+// Reconcile reports the usage of the quota in its status
+func (r *MetalNodeQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	quota := &infrav1.MetalNodeQuota{}
+	if err := r.Client.Get(ctx, req.NamespacedName, quota); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	l := log.With("metalNodeQuota", quota.Name)
+
+	clusterList := &clusterv1.ClusterList{}
+	if err := r.Client.List(ctx, clusterList, client.InNamespace(quota.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := r.Client.List(ctx, metalNodeList, watchFilterListOptions(quota.Namespace, r.WatchFilterValue)...); err != nil {
+		return ctrl.Result{}, err
+	}
+	usage, err := newQuotaUsage(quota, clusterList.Items, metalNodeList.Items)
+	if err != nil {
+		l.WithError(err).Error("invalid quota")
+		return ctrl.Result{}, nil
+	}
+
+	status := infrav1.MetalNodeQuotaStatus{Used: usage.used, Free: usage.free, Reserved: usage.reserved()}
+	for cluster := range usage.clusters {
+		status.Clusters = append(status.Clusters, cluster)
+	}
+	sort.Strings(status.Clusters)
+	if reflect.DeepEqual(status, quota.Status) {
+		return ctrl.Result{}, nil
+	}
+	quota.Status = status
+	return ctrl.Result{}, r.Client.Status().Update(ctx, quota)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MetalNodeQuotaReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&infrav1.MetalNodeQuota{}, builder.WithPredicates(predicates.ResourceHasFilterLabel(mgr.GetLogger(), r.WatchFilterValue))).
+		WithOptions(options).
+		Watches(
+			&source.Kind{Type: &metav1beta1.MetalNode{}},
+			handler.EnqueueRequestsFromMapFunc(r.ToMetalNodeQuotas),
+		).
+		Watches(
+			&source.Kind{Type: &clusterv1.Cluster{}},
+			handler.EnqueueRequestsFromMapFunc(r.ToMetalNodeQuotas),
+		).
+		Complete(r)
+}
+
+// ToMetalNodeQuotas is a handler.MapFunc to be used to enqueue requests for reconciliation of the MetalNodeQuotas
+// of the namespace of a metal node or a Cluster.
+func (r *MetalNodeQuotaReconciler) ToMetalNodeQuotas(o client.Object) []reconcile.Request {
+	quotaList := &infrav1.MetalNodeQuotaList{}
+	if err := r.Client.List(context.TODO(), quotaList, client.InNamespace(o.GetNamespace())); err != nil {
+		log.WithError(err).Errorf("failed to list metalNodeQuotas in namespace %s", o.GetNamespace())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(quotaList.Items))
+	for i := range quotaList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&quotaList.Items[i])})
+	}
+	return requests
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+)
+
+var _ = Describe("MetalNodeQuotaReconciler", func() {
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace = createNamespace(ctx)
+	})
+
+	// setupCluster creates a cluster with the given labels whose control plane endpoint is set on a metal node on host
+	setupCluster := func(host string, clusterLabels map[string]string) *clusterv1.Cluster {
+		createMetalNode(ctx, namespace, host, metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace, func(cluster *clusterv1.Cluster, _ *infrav1.DemoCluster) {
+			cluster.Labels = clusterLabels
+		})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		return cluster
+	}
+
+	// metalNodeReadyReason returns the reason of the MetalNodeReady condition of the demoMachine
+	metalNodeReadyReason := func(demoMachine *infrav1.DemoMachine) func() string {
+		return func() string {
+			if err := get(ctx, demoMachine)(); err != nil {
+				return ""
+			}
+			return conditions.GetReason(demoMachine, constants.MetalNodeReadyCondition)
+		}
+	}
+
+	It("caps the metal nodes the clusters of the quota claim", func() {
+		cluster := setupCluster("10.0.3.1", nil)
+		max := int32(2)
+		quota := &infrav1.MetalNodeQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: namespace},
+			Spec:       infrav1.MetalNodeQuotaSpec{ClusterName: cluster.Name, Max: &max},
+		}
+		Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+		createMetalNode(ctx, namespace, "10.0.3.2", metalNodeProfile{})
+		createMetalNode(ctx, namespace, "10.0.3.3", metalNodeProfile{})
+
+		// the metal node of the control plane endpoint counts
+		_, first := createMachine(ctx, cluster, false)
+		Eventually(func() bool {
+			return get(ctx, first)() == nil && first.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		_, second := createMachine(ctx, cluster, false)
+		Eventually(metalNodeReadyReason(second), timeout, interval).Should(Equal(constants.QuotaExceededReason))
+		Expect(conditions.GetMessage(second, constants.MetalNodeReadyCondition)).To(Equal("the clusters of MetalNodeQuota quota claimed 2 metal nodes out of 2"))
+		Expect(second.GetLabels()).NotTo(HaveKey(infrav1.MetalNodeLabelName))
+
+		Eventually(func() infrav1.MetalNodeQuotaStatus {
+			Expect(get(ctx, quota)()).To(Succeed())
+			return quota.Status
+		}, timeout, interval).Should(Equal(infrav1.MetalNodeQuotaStatus{Clusters: []string{cluster.Name}, Used: 2, Free: 1}))
+
+		// raising the quota lets the waiting machine claim a metal node
+		max = 3
+		quota.Spec.Max = &max
+		Expect(k8sClient.Update(ctx, quota)).To(Succeed())
+		Eventually(func() bool {
+			return get(ctx, second)() == nil && second.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("admits the machines claiming metal nodes concurrently within the quota, whatever the name of the DemoCluster", func() {
+		createMetalNode(ctx, namespace, "10.0.3.20", metalNodeProfile{})
+		cluster, demoCluster := createCluster(ctx, namespace, func(cluster *clusterv1.Cluster, demoCluster *infrav1.DemoCluster) {
+			demoCluster.Name = cluster.Name + "-infra"
+			cluster.Spec.InfrastructureRef.Name = demoCluster.Name
+		})
+		Eventually(func() bool {
+			return get(ctx, demoCluster)() == nil && demoCluster.Status.Ready
+		}, timeout, interval).Should(BeTrue())
+		max := int32(2)
+		quota := &infrav1.MetalNodeQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: namespace},
+			Spec:       infrav1.MetalNodeQuotaSpec{ClusterName: cluster.Name, Max: &max},
+		}
+		Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+		for _, host := range []string{"10.0.3.21", "10.0.3.22", "10.0.3.23"} {
+			createMetalNode(ctx, namespace, host, metalNodeProfile{})
+		}
+
+		// the metal node of the control plane endpoint counts, a single machine claims the last one
+		var demoMachines []*infrav1.DemoMachine
+		for i := 0; i < 3; i++ {
+			_, demoMachine := createMachine(ctx, cluster, false)
+			demoMachines = append(demoMachines, demoMachine)
+		}
+		Eventually(func() []string {
+			var reasons []string
+			for _, demoMachine := range demoMachines {
+				if get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped {
+					reasons = append(reasons, "Bootstrapped")
+				} else {
+					reasons = append(reasons, conditions.GetReason(demoMachine, constants.MetalNodeReadyCondition))
+				}
+			}
+			return reasons
+		}, timeout, interval).Should(ConsistOf("Bootstrapped", constants.QuotaExceededReason, constants.QuotaExceededReason))
+		Eventually(func() infrav1.MetalNodeQuotaStatus {
+			Expect(get(ctx, quota)()).To(Succeed())
+			return quota.Status
+		}, timeout, interval).Should(Equal(infrav1.MetalNodeQuotaStatus{Clusters: []string{cluster.Name}, Used: 2, Free: 2}))
+		for _, demoMachine := range demoMachines {
+			if name := demoMachine.GetLabels()[infrav1.MetalNodeLabelName]; name != "" {
+				Expect(getMetalNode(ctx, namespace, name).GetRefCluster()).To(Equal(cluster.Name))
+			}
+		}
+	})
+
+	It("reserves metal nodes for the clusters of the quota", func() {
+		critical := setupCluster("10.0.3.10", map[string]string{"team": "payments"})
+		other := setupCluster("10.0.3.11", nil)
+		quota := &infrav1.MetalNodeQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: namespace},
+			Spec: infrav1.MetalNodeQuotaSpec{
+				ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+				Reserved:        2,
+			},
+		}
+		Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+		createMetalNode(ctx, namespace, "10.0.3.12", metalNodeProfile{})
+		createMetalNode(ctx, namespace, "10.0.3.13", metalNodeProfile{})
+		Eventually(func() infrav1.MetalNodeQuotaStatus {
+			Expect(get(ctx, quota)()).To(Succeed())
+			return quota.Status
+		}, timeout, interval).Should(Equal(infrav1.MetalNodeQuotaStatus{Clusters: []string{critical.Name}, Used: 1, Free: 2, Reserved: 1}))
+
+		// the other cluster claims the metal nodes the critical cluster does not need
+		_, first := createMachine(ctx, other, false)
+		Eventually(func() bool {
+			return get(ctx, first)() == nil && first.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		_, second := createMachine(ctx, other, false)
+		Eventually(metalNodeReadyReason(second), timeout, interval).Should(Equal(constants.QuotaExceededReason))
+		Expect(conditions.GetMessage(second, constants.MetalNodeReadyCondition)).To(Equal("the free metal nodes left are reserved by MetalNodeQuota payments"))
+
+		_, demoMachine := createMachine(ctx, critical, false)
+		Eventually(func() bool {
+			return get(ctx, demoMachine)() == nil && demoMachine.Status.Bootstrapped
+		}, timeout, interval).Should(BeTrue())
+		Eventually(func() infrav1.MetalNodeQuotaStatus {
+			Expect(get(ctx, quota)()).To(Succeed())
+			return quota.Status
+		}, timeout, interval).Should(Equal(infrav1.MetalNodeQuotaStatus{Clusters: []string{critical.Name}, Used: 2}))
+	})
+})
@@ -0,0 +1,99 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+var (
+	// metalNodeReprovisionings counts the reprovisionings of the released metal nodes by result, succeeded or failed
+	metalNodeReprovisionings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_metalnode_reprovisionings_total",
+		Help: "Number of the reprovisionings of the metal nodes released by the demo machines, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(metalNodeReprovisionings)
+}
+
+var (
+	provisioningQueueLengthDesc = prometheus.NewDesc("demo_provisioning_queue_length",
+		"Number of the demo machines waiting for a free metal node, by namespace.", []string{"namespace"}, nil)
+	provisioningQueueOldestWaitDesc = prometheus.NewDesc("demo_provisioning_queue_oldest_wait_seconds",
+		"Waiting time of the demo machine waiting the longest for a free metal node, by namespace.", []string{"namespace"}, nil)
+)
+
+// provisioningQueueCollector exposes the provisioning queues of the namespaces as metrics, read from the cache
+// of the manager when scraped
+type provisioningQueueCollector struct {
+	client           client.Client
+	watchFilterValue string
+}
+
+// Describe implements prometheus.Collector
+func (c *provisioningQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- provisioningQueueLengthDesc
+	ch <- provisioningQueueOldestWaitDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *provisioningQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	demoMachineList := &infrav1.DemoMachineList{}
+	if err := c.client.List(ctx, demoMachineList, watchFilterListOptions("", c.watchFilterValue)...); err != nil {
+		log.WithError(err).Error("failed to list demoMachines for the provisioning queue metrics")
+		return
+	}
+
+	lengths := map[string]int{}
+	oldest := map[string]time.Time{}
+	for i := range demoMachineList.Items {
+		demoMachine := &demoMachineList.Items[i]
+		if !queued(demoMachine) {
+			continue
+		}
+		lengths[demoMachine.Namespace]++
+		queuedAt := demoMachine.Status.Queue.QueuedAt.Time
+		if t, ok := oldest[demoMachine.Namespace]; !ok || queuedAt.Before(t) {
+			oldest[demoMachine.Namespace] = queuedAt
+		}
+	}
+	for namespace, length := range lengths {
+		ch <- prometheus.MustNewConstMetric(provisioningQueueLengthDesc, prometheus.GaugeValue, float64(length), namespace)
+		ch <- prometheus.MustNewConstMetric(provisioningQueueOldestWaitDesc, prometheus.GaugeValue, time.Since(oldest[namespace]).Seconds(), namespace)
+	}
+}
+
+// registerProvisioningQueueCollector exposes the provisioning queues as metrics, once for the first manager of the process
+func registerProvisioningQueueCollector(c client.Client, watchFilterValue string) error {
+	err := metrics.Registry.Register(&provisioningQueueCollector{client: c, watchFilterValue: watchFilterValue})
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
+}
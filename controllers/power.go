@@ -0,0 +1,152 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+	"github.com/git-czy/cluster-api-provider-demo/power"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// DefaultBootstrapTimeout is the time a metal node with a BMC is given to run the bootstrap data before it is power-cycled
+const DefaultBootstrapTimeout = 20 * time.Minute
+
+// reconcileBootstrapPower powers on the metal node handed the bootstrap data of the machine, and power-cycles it once
+// if it does not run the bootstrap data in time. It does nothing for the metal nodes without a BMC.
+func (r *DemoMachineReconciler) reconcileBootstrapPower(ctx context.Context, demoMachine *infrav1.DemoMachine, metalNode *metav1beta1.MetalNode, l log.Logger) error {
+	pm, err := getPowerManager(ctx, r.Client, metalNode)
+	if err != nil || pm == nil {
+		return err
+	}
+
+	condition := conditions.Get(demoMachine, constants.BootstrapSucceededCondition)
+	if condition == nil || condition.Status != corev1.ConditionFalse ||
+		(condition.Reason != constants.WaitingForMetalNodeBootstrapReason && condition.Reason != constants.MetalNodePowerCycledReason) {
+		// the bootstrap data is handed to the metal node, which may have been powered off when released
+		state, err := pm.Status(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the power state of metal node %s", metalNode.Name)
+		}
+		if state == power.Off {
+			l.Infof("powering on metal node %s", metalNode.Name)
+			return errors.Wrapf(pm.PowerOn(ctx), "failed to power on metal node %s", metalNode.Name)
+		}
+		return nil
+	}
+
+	timeout := r.BootstrapTimeout
+	if timeout == 0 {
+		timeout = DefaultBootstrapTimeout
+	}
+	// power-cycle once only, a metal node still stuck afterwards is left to the remediation
+	if condition.Reason == constants.MetalNodePowerCycledReason || time.Since(condition.LastTransitionTime.Time) < timeout {
+		return nil
+	}
+	if err := pm.Reboot(ctx); err != nil {
+		return errors.Wrapf(err, "failed to power-cycle metal node %s", metalNode.Name)
+	}
+	conditions.MarkFalse(demoMachine, constants.BootstrapSucceededCondition, constants.MetalNodePowerCycledReason, clusterv1.ConditionSeverityWarning,
+		"metal node %s did not run the bootstrap data in %s and was power-cycled", metalNode.Name, timeout)
+	l.Warnf("metal node %s did not run the bootstrap data in %s, power-cycled it", metalNode.Name, timeout)
+	return nil
+}
+
+// reconcilePowerOff powers off a released metal node asked to be powered off, so that it no longer runs what the machine
+// left on it, and marks it powered off so that it can be claimed while reported not ready. The metal node is marked
+// before it is powered off: the update fails if the metal node was claimed again meanwhile, and the machine claiming it
+// afterwards powers it on.
+func (r *DemoMachineReconciler) reconcilePowerOff(ctx context.Context, metalNode *metav1beta1.MetalNode) error {
+	pm, err := getPowerManager(ctx, r.Client, metalNode)
+	if err != nil {
+		return err
+	}
+	powerOff := pm != nil && metalNode.GetRefCluster() == "" && metalNodeClaimedBy(metalNode) == ""
+	delete(metalNode.Annotations, infrav1.MetalNodePowerOffAnnotation)
+	if powerOff {
+		metalNode.Annotations[infrav1.MetalNodePoweredOffAnnotation] = ""
+	}
+	if err := metalnode.Update(ctx, r.Client, metalNode); err != nil || !powerOff {
+		return err
+	}
+	if err := pm.PowerOff(ctx); err != nil {
+		return errors.Wrapf(err, "failed to power off metal node %s", metalNode.Name)
+	}
+	log.With("metalNode", metalNode.Name).Info("powered off the released metal node")
+	return nil
+}
+
+// powerOnMetalNode powers on a claimed metal node powered off when it was released, its agent reports it ready once it booted
+func powerOnMetalNode(ctx context.Context, c client.Client, metalNode *metav1beta1.MetalNode) error {
+	pm, err := getPowerManager(ctx, c, metalNode)
+	if err != nil {
+		return err
+	}
+	// the BMC may have been removed meanwhile, the metal node is then left to its administrator
+	if pm != nil {
+		if err := pm.PowerOn(ctx); err != nil {
+			return errors.Wrapf(err, "failed to power on metal node %s", metalNode.Name)
+		}
+	}
+	delete(metalNode.Annotations, infrav1.MetalNodePoweredOffAnnotation)
+	return nil
+}
+
+// metalNodePoweredOff tells whether a metal node was powered off when released, and is yet to be powered on
+func metalNodePoweredOff(metalNode *metav1beta1.MetalNode) bool {
+	_, ok := metalNode.GetAnnotations()[infrav1.MetalNodePoweredOffAnnotation]
+	return ok
+}
+
+// getPowerManager returns the power manager of the BMC of a metal node, nil if the metal node has no BMC
+func getPowerManager(ctx context.Context, c client.Client, metalNode *metav1beta1.MetalNode) (power.PowerManager, error) {
+	data, ok := metalNode.GetAnnotations()[infrav1.MetalNodeBMCAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	bmc := infrav1.MetalNodeBMC{}
+	if err := json.Unmarshal([]byte(data), &bmc); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the BMC of metal node %s", metalNode.Name)
+	}
+
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: metalNode.Namespace, Name: bmc.CredentialsName}
+	if err := c.Get(ctx, key, secret); err != nil {
+		return nil, errors.Wrapf(err, "failed to get the BMC credentials of metal node %s", metalNode.Name)
+	}
+	credentials := power.Credentials{
+		Username: string(secret.Data["username"]),
+		Password: string(secret.Data["password"]),
+	}
+	pm, err := power.New(bmc.Address, credentials, bmc.DisableCertificateVerification)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid BMC of metal node %s", metalNode.Name)
+	}
+	return pm, nil
+}
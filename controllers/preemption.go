@@ -0,0 +1,288 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// preemptionCandidate is a worker Machine of a lower priority cluster whose metal node can be freed for a demo machine
+type preemptionCandidate struct {
+	machine   *clusterv1.Machine
+	priority  int32
+	metalNode string
+	// owner is the MachineDeployment, or the MachineSet without one, replacing the machine once deleted
+	owner     client.Object
+	ownerKind string
+}
+
+// preemptionEnabled returns true if the machines of the cluster preempt the machines of lower priority clusters,
+// or record the ones they would preempt
+func preemptionEnabled(demoCluster *infrav1.DemoCluster) bool {
+	policy := demoCluster.Spec.PreemptionPolicy
+	return policy == infrav1.PreemptLowerPriorityPreemptionPolicy || policy == infrav1.DryRunPreemptionPolicy
+}
+
+// preemptionProtected returns true if the object keeps its worker Machines from being preempted
+func preemptionProtected(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[infrav1.PreemptionProtectedAnnotation]
+	return ok
+}
+
+// preemptMachine frees a metal node for a demo machine no metal node is left for, by deleting a worker Machine of a
+// lower priority cluster of the namespace. The replicas of its MachineSet or MachineDeployment are left as they are,
+// the Machine replacing it waits for a metal node behind the higher priority demo machines.
+// A single Machine is preempted at a time for a demo machine, which claims its metal node once released.
+// Every decision is recorded as an event on the demo machine.
+func (r *DemoMachineReconciler) preemptMachine(ctx context.Context, cluster *clusterv1.Cluster, demoMachine *infrav1.DemoMachine, demoCluster *infrav1.DemoCluster, metalNodes []metav1beta1.MetalNode, l log.Logger) error {
+	machineList := &clusterv1.MachineList{}
+	if err := r.Client.List(ctx, machineList, client.InNamespace(demoMachine.Namespace)); err != nil {
+		return err
+	}
+	for i := range machineList.Items {
+		machine := &machineList.Items[i]
+		if machine.GetAnnotations()[infrav1.PreemptedByAnnotation] == demoMachine.Name {
+			conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.PreemptingReason, clusterv1.ConditionSeverityInfo,
+				"waiting for Machine %s preempted from cluster %s to release its metal node", machine.Name, machine.Spec.ClusterName)
+			l.Infof("waiting for machine %s preempted from cluster %s to release its metal node", machine.Name, machine.Spec.ClusterName)
+			return nil
+		}
+	}
+
+	candidates, err := r.getPreemptionCandidates(ctx, cluster, demoCluster, machineList.Items, metalNodes)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the machines to preempt")
+	}
+	if len(candidates) == 0 {
+		if markNoMetalNodeFound(demoMachine, "no metal node found, and no Machine of a cluster of priority lower than %d can be preempted", demoCluster.Spec.Priority) {
+			r.Recorder.Eventf(demoMachine, corev1.EventTypeNormal, constants.NoPreemptionCandidateReason,
+				"No Machine of a cluster of priority lower than %d can be preempted", demoCluster.Spec.Priority)
+		}
+		l.Errorf("no metal node eligible for cluster %s, and no machine of a lower priority cluster can be preempted", demoCluster.Name)
+		return nil
+	}
+
+	candidate := candidates[0]
+	if demoCluster.Spec.PreemptionPolicy == infrav1.DryRunPreemptionPolicy {
+		if markNoMetalNodeFound(demoMachine, "no metal node found, Machine %s of cluster %s on metal node %s would be preempted",
+			candidate.machine.Name, candidate.machine.Spec.ClusterName, candidate.metalNode) {
+			r.Recorder.Eventf(demoMachine, corev1.EventTypeNormal, constants.PreemptionDryRunReason,
+				"Would preempt Machine %s of cluster %s with priority %d on metal node %s, replaced by %s %s once a metal node is free", candidate.machine.Name,
+				candidate.machine.Spec.ClusterName, candidate.priority, candidate.metalNode, candidate.ownerKind, candidate.owner.GetName())
+		}
+		l.Infof("dry run, would preempt machine %s of cluster %s on metal node %s", candidate.machine.Name, candidate.machine.Spec.ClusterName, candidate.metalNode)
+		return nil
+	}
+
+	if err := r.preempt(ctx, demoMachine, candidate); err != nil {
+		r.Recorder.Eventf(demoMachine, corev1.EventTypeWarning, constants.PreemptionFailedReason,
+			"Failed to preempt Machine %s of cluster %s: %v", candidate.machine.Name, candidate.machine.Spec.ClusterName, err)
+		return err
+	}
+	conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.PreemptingReason, clusterv1.ConditionSeverityInfo,
+		"waiting for Machine %s preempted from cluster %s to release its metal node", candidate.machine.Name, candidate.machine.Spec.ClusterName)
+	r.Recorder.Eventf(demoMachine, corev1.EventTypeNormal, constants.PreemptingReason,
+		"Preempted Machine %s of cluster %s with priority %d on metal node %s, replaced by %s %s once a metal node is free", candidate.machine.Name,
+		candidate.machine.Spec.ClusterName, candidate.priority, candidate.metalNode, candidate.ownerKind, candidate.owner.GetName())
+	r.Recorder.Eventf(candidate.machine, corev1.EventTypeNormal, constants.PreemptedReason,
+		"Preempted by DemoMachine %s of cluster %s with priority %d, replaced by %s %s once a metal node is free", demoMachine.Name, cluster.Name,
+		demoCluster.Spec.Priority, candidate.ownerKind, candidate.owner.GetName())
+	l.Infof("preempted machine %s of cluster %s on metal node %s, replaced by %s %s", candidate.machine.Name, candidate.machine.Spec.ClusterName,
+		candidate.metalNode, candidate.ownerKind, candidate.owner.GetName())
+	return nil
+}
+
+// markNoMetalNodeFound marks that no metal node is found for a demo machine, it returns false if the MetalNodeReady
+// condition already said so. The decisions are made again every time the demo machine is requeued, they are only
+// recorded as events when they change.
+func markNoMetalNodeFound(demoMachine *infrav1.DemoMachine, format string, args ...interface{}) bool {
+	message := fmt.Sprintf(format, args...)
+	if condition := conditions.Get(demoMachine, constants.MetalNodeReadyCondition); condition != nil &&
+		condition.Reason == constants.NoMetalNodeFoundReason && condition.Message == message {
+		return false
+	}
+	conditions.MarkFalse(demoMachine, constants.MetalNodeReadyCondition, constants.NoMetalNodeFoundReason, clusterv1.ConditionSeverityWarning, "%s", message)
+	return true
+}
+
+// preempt marks the Machine of the candidate as preempted by the demo machine and deletes it. Its MachineSet keeps
+// its replicas and creates another Machine, the preempted cluster gets its Machine back once a metal node is free.
+func (r *DemoMachineReconciler) preempt(ctx context.Context, demoMachine *infrav1.DemoMachine, candidate *preemptionCandidate) error {
+	machine := candidate.machine
+	before := machine.DeepCopy()
+	annotations.AddAnnotations(machine, map[string]string{infrav1.PreemptedByAnnotation: demoMachine.Name})
+	if err := r.Client.Patch(ctx, machine, client.MergeFrom(before)); err != nil {
+		return errors.Wrapf(err, "failed to mark machine %s as preempted", machine.Name)
+	}
+
+	if err := r.Client.Delete(ctx, machine); err != nil && !apierrors.IsNotFound(err) {
+		// the machine is left to its owner, another one is preempted next time
+		unmarked := machine.DeepCopy()
+		delete(unmarked.Annotations, infrav1.PreemptedByAnnotation)
+		if rerr := r.Client.Patch(ctx, unmarked, client.MergeFrom(machine)); rerr != nil {
+			log.WithError(rerr).Errorf("failed to unmark machine %s as preempted", machine.Name)
+		}
+		return errors.Wrapf(err, "failed to delete machine %s", machine.Name)
+	}
+	return nil
+}
+
+// getPreemptionCandidates returns the worker Machines bootstrapped on a metal node of the clusters of the namespace of a
+// priority lower than the priority of the demo cluster, the lowest priority and then the newest Machines first.
+// The Machines are protected from preemption by PreemptionProtectedAnnotation, and the Machines without a MachineSet,
+// of a paused or deleted cluster, or on a cordoned metal node are not preempted.
+func (r *DemoMachineReconciler) getPreemptionCandidates(ctx context.Context, cluster *clusterv1.Cluster, demoCluster *infrav1.DemoCluster, machines []clusterv1.Machine, metalNodes []metav1beta1.MetalNode) ([]*preemptionCandidate, error) {
+	// the worker metal nodes of the other clusters, by the name of the demo machine bound to them
+	metalNodeNames := map[string]string{}
+	for i := range metalNodes {
+		node := &metalNodes[i]
+		if node.GetRefCluster() == "" || metalNodeRefersTo(node, cluster.Name, demoCluster.Name) || !node.ContainRole(constants.WorkerNodeRoleValue) ||
+			!node.Status.Bootstrapped || metalNodeCordoned(node) {
+			continue
+		}
+		if name := metalNodeClaimedBy(node); name != "" {
+			metalNodeNames[name] = node.Name
+		}
+	}
+
+	// the priorities of the clusters whose machines can be preempted, by the name of the clusters
+	priorities := map[string]*int32{}
+	var candidates []*preemptionCandidate
+	for i := range machines {
+		machine := &machines[i]
+		metalNode, ok := metalNodeNames[machine.Spec.InfrastructureRef.Name]
+		if !ok || machine.Spec.InfrastructureRef.Kind != "DemoMachine" || util.IsControlPlaneMachine(machine) ||
+			!machine.DeletionTimestamp.IsZero() || preemptionProtected(machine) {
+			continue
+		}
+		if _, ok := machine.GetAnnotations()[infrav1.PreemptedByAnnotation]; ok {
+			continue
+		}
+
+		priority, ok := priorities[machine.Spec.ClusterName]
+		if !ok {
+			var err error
+			if priority, err = r.getPreemptiblePriority(ctx, machine.Namespace, machine.Spec.ClusterName); err != nil {
+				return nil, err
+			}
+			priorities[machine.Spec.ClusterName] = priority
+		}
+		if priority == nil || *priority >= demoCluster.Spec.Priority {
+			continue
+		}
+
+		candidate, err := r.getMachineOwner(ctx, machine)
+		if err != nil {
+			return nil, err
+		}
+		if candidate == nil {
+			continue
+		}
+		candidate.machine = machine
+		candidate.priority = *priority
+		candidate.metalNode = metalNode
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		if !a.machine.CreationTimestamp.Equal(&b.machine.CreationTimestamp) {
+			return b.machine.CreationTimestamp.Before(&a.machine.CreationTimestamp)
+		}
+		return a.machine.Name < b.machine.Name
+	})
+	return candidates, nil
+}
+
+// getPreemptiblePriority returns the priority of the DemoCluster of a cluster, nil if the machines of the cluster can not be preempted
+func (r *DemoMachineReconciler) getPreemptiblePriority(ctx context.Context, namespace, clusterName string) (*int32, error) {
+	cluster := &clusterv1.Cluster{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: clusterName}, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if cluster.Spec.InfrastructureRef == nil || cluster.Spec.InfrastructureRef.Kind != "DemoCluster" || cluster.Spec.Paused || annotations.HasPaused(cluster) ||
+		!cluster.DeletionTimestamp.IsZero() || preemptionProtected(cluster) {
+		return nil, nil
+	}
+	demoCluster := &infrav1.DemoCluster{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: cluster.Spec.InfrastructureRef.Name}, demoCluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	priority := demoCluster.Spec.Priority
+	return &priority, nil
+}
+
+// getMachineOwner returns a candidate holding the MachineDeployment of the MachineSet of a Machine, or the MachineSet
+// without one, nil if the Machine is not preempted
+func (r *DemoMachineReconciler) getMachineOwner(ctx context.Context, machine *clusterv1.Machine) (*preemptionCandidate, error) {
+	ref := metav1.GetControllerOf(machine)
+	if ref == nil || ref.Kind != "MachineSet" {
+		return nil, nil
+	}
+	machineSet := &clusterv1.MachineSet{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: ref.Name}, machineSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if preemptionProtected(machineSet) {
+		return nil, nil
+	}
+	candidate := &preemptionCandidate{owner: machineSet, ownerKind: "MachineSet"}
+
+	if ref := metav1.GetControllerOf(machineSet); ref != nil && ref.Kind == "MachineDeployment" {
+		machineDeployment := &clusterv1.MachineDeployment{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: ref.Name}, machineDeployment); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if preemptionProtected(machineDeployment) {
+			return nil, nil
+		}
+		candidate = &preemptionCandidate{owner: machineDeployment, ownerKind: "MachineDeployment"}
+	}
+	return candidate, nil
+}
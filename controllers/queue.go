@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// provisioningQueueRequeueInterval is the interval the queued demo machines are reconciled at, refreshing their position
+const provisioningQueueRequeueInterval = 30 * time.Second
+
+// queueEntry is a demo machine waiting in the provisioning queue of its namespace
+type queueEntry struct {
+	name     string
+	cluster  string
+	priority int32
+	queuedAt metav1.Time
+	// turn is the rank of the machine among the queued machines of its cluster
+	turn int
+}
+
+// provisioningQueue orders the demo machines of a namespace waiting for a free metal node: the machines of the higher
+// priority clusters first, then the clusters take turns, their machines first in first out
+type provisioningQueue []*queueEntry
+
+// queued returns true if the demo machine waits in the provisioning queue
+func queued(demoMachine *infrav1.DemoMachine) bool {
+	return demoMachine.Status.Queue != nil && demoMachine.GetLabels()[infrav1.MetalNodeLabelName] == "" && demoMachine.DeletionTimestamp.IsZero()
+}
+
+// getProvisioningQueue returns the provisioning queue of a namespace
+func getProvisioningQueue(ctx context.Context, c client.Client, namespace, watchFilterValue string) (provisioningQueue, error) {
+	demoMachineList := &infrav1.DemoMachineList{}
+	if err := c.List(ctx, demoMachineList, watchFilterListOptions(namespace, watchFilterValue)...); err != nil {
+		return nil, err
+	}
+	var queue provisioningQueue
+	for i := range demoMachineList.Items {
+		demoMachine := &demoMachineList.Items[i]
+		if queued(demoMachine) {
+			queue = append(queue, &queueEntry{
+				name:     demoMachine.Name,
+				cluster:  demoMachine.GetLabels()[clusterv1.ClusterLabelName],
+				queuedAt: demoMachine.Status.Queue.QueuedAt,
+			})
+		}
+	}
+	if len(queue) == 0 {
+		return nil, nil
+	}
+
+	// the priorities of the DemoClusters, by the name of their clusters
+	clusterList := &clusterv1.ClusterList{}
+	if err := c.List(ctx, clusterList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	demoClusterList := &infrav1.DemoClusterList{}
+	if err := c.List(ctx, demoClusterList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	demoClusterPriorities := map[string]int32{}
+	for _, demoCluster := range demoClusterList.Items {
+		demoClusterPriorities[demoCluster.Name] = demoCluster.Spec.Priority
+	}
+	priorities := map[string]int32{}
+	paused := map[string]bool{}
+	for _, cluster := range clusterList.Items {
+		if ref := cluster.Spec.InfrastructureRef; ref != nil && ref.Kind == "DemoCluster" {
+			priorities[cluster.Name] = demoClusterPriorities[ref.Name]
+		}
+		paused[cluster.Name] = cluster.Spec.Paused || annotations.HasPaused(&cluster)
+	}
+	// the machines of the paused clusters are not reconciled, they would hold their place in the queue
+	running := queue[:0]
+	for _, entry := range queue {
+		if !paused[entry.cluster] {
+			entry.priority = priorities[entry.cluster]
+			running = append(running, entry)
+		}
+	}
+
+	running.sort()
+	return running, nil
+}
+
+// add returns the queue along with the entry, in its place
+func (q provisioningQueue) add(entry *queueEntry) provisioningQueue {
+	for _, e := range q {
+		if e.name == entry.name {
+			return q
+		}
+	}
+	queue := append(q, entry)
+	queue.sort()
+	return queue
+}
+
+// position returns the position of a demo machine in the queue starting at 1, 0 if it is not queued
+func (q provisioningQueue) position(name string) int32 {
+	for i, entry := range q {
+		if entry.name == name {
+			return int32(i + 1)
+		}
+	}
+	return 0
+}
+
+// sort orders the queue
+func (q provisioningQueue) sort() {
+	firstIn := func(a, b *queueEntry) bool {
+		if !a.queuedAt.Equal(&b.queuedAt) {
+			return a.queuedAt.Before(&b.queuedAt)
+		}
+		return a.name < b.name
+	}
+
+	sort.SliceStable(q, func(i, j int) bool {
+		return firstIn(q[i], q[j])
+	})
+	turns := map[string]int{}
+	for _, entry := range q {
+		entry.turn = turns[entry.cluster]
+		turns[entry.cluster]++
+	}
+
+	sort.SliceStable(q, func(i, j int) bool {
+		a, b := q[i], q[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.turn != b.turn {
+			return a.turn < b.turn
+		}
+		return firstIn(a, b)
+	})
+}
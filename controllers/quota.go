@@ -0,0 +1,163 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// quotaUsage is the usage of a MetalNodeQuota by the clusters it applies to
+type quotaUsage struct {
+	quota *infrav1.MetalNodeQuota
+	// clusters holds the names of the clusters the quota applies to
+	clusters map[string]bool
+	// refClusters holds the names the metal nodes claimed by the clusters refer to, the names of the clusters and the
+	// ones of their DemoClusters the metal nodes claimed before the names were normalized refer to
+	refClusters       map[string]bool
+	metalNodeSelector labels.Selector
+	// used is the number of metal nodes counted by the quota claimed by the clusters
+	used int32
+	// free is the number of metal nodes counted by the quota free to be claimed
+	free int32
+}
+
+// newQuotaUsage returns the usage of a quota by the clusters of its namespace
+func newQuotaUsage(quota *infrav1.MetalNodeQuota, clusters []clusterv1.Cluster, metalNodes []metav1beta1.MetalNode) (*quotaUsage, error) {
+	clusterSelector := labels.Everything()
+	if quota.Spec.ClusterSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(quota.Spec.ClusterSelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid clusterSelector")
+		}
+		clusterSelector = selector
+	}
+	usage := &quotaUsage{quota: quota, clusters: map[string]bool{}, refClusters: map[string]bool{}, metalNodeSelector: labels.Everything()}
+	if quota.Spec.MetalNodeSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(quota.Spec.MetalNodeSelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid metalNodeSelector")
+		}
+		usage.metalNodeSelector = selector
+	}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+		ref := cluster.Spec.InfrastructureRef
+		if ref == nil || ref.Kind != "DemoCluster" || (quota.Spec.ClusterName != "" && quota.Spec.ClusterName != cluster.Name) ||
+			!clusterSelector.Matches(labels.Set(cluster.GetLabels())) {
+			continue
+		}
+		usage.clusters[cluster.Name] = true
+		usage.refClusters[cluster.Name] = true
+		usage.refClusters[ref.Name] = true
+	}
+	for i := range metalNodes {
+		metalNode := &metalNodes[i]
+		if !usage.metalNodeSelector.Matches(labels.Set(metalNode.GetLabels())) {
+			continue
+		}
+		if usage.refClusters[metalNode.GetRefCluster()] {
+			usage.used++
+		} else if metalNodeFree(metalNode) {
+			usage.free++
+		}
+	}
+	return usage, nil
+}
+
+// reserved returns the number of free metal nodes held back for the clusters of the quota
+func (u *quotaUsage) reserved() int32 {
+	reserved := u.quota.Spec.Reserved - u.used
+	if reserved < 0 {
+		return 0
+	}
+	if reserved > u.free {
+		return u.free
+	}
+	return reserved
+}
+
+// admits tells whether a cluster may claim a free metal node, the message tells why not
+func (u *quotaUsage) admits(clusterName string, metalNode *metav1beta1.MetalNode) (bool, string) {
+	if !u.metalNodeSelector.Matches(labels.Set(metalNode.GetLabels())) {
+		return true, ""
+	}
+	if u.clusters[clusterName] {
+		if max := u.quota.Spec.Max; max != nil && u.used >= *max {
+			return false, fmt.Sprintf("the clusters of MetalNodeQuota %s claimed %d metal nodes out of %d", u.quota.Name, u.used, *max)
+		}
+		return true, ""
+	}
+	// the other clusters leave the clusters of the quota enough free metal nodes to reach their reservation
+	if u.free <= u.reserved() {
+		return false, fmt.Sprintf("the free metal nodes left are reserved by MetalNodeQuota %s", u.quota.Name)
+	}
+	return true, ""
+}
+
+// getQuotaUsages returns the usage of the MetalNodeQuotas of a namespace, the quotas with invalid selectors are left out
+func getQuotaUsages(ctx context.Context, c client.Reader, namespace string, metalNodes []metav1beta1.MetalNode) ([]*quotaUsage, error) {
+	quotaList := &infrav1.MetalNodeQuotaList{}
+	if err := c.List(ctx, quotaList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(quotaList.Items) == 0 {
+		return nil, nil
+	}
+	clusterList := &clusterv1.ClusterList{}
+	if err := c.List(ctx, clusterList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	usages := make([]*quotaUsage, 0, len(quotaList.Items))
+	for i := range quotaList.Items {
+		usage, err := newQuotaUsage(&quotaList.Items[i], clusterList.Items, metalNodes)
+		if err != nil {
+			log.WithError(err).Warnf("ignoring MetalNodeQuota %s", quotaList.Items[i].Name)
+			continue
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// admitMetalNode tells whether the quotas let a cluster claim a free metal node, the message tells why not
+func admitMetalNode(usages []*quotaUsage, clusterName string, metalNode *metav1beta1.MetalNode) (bool, string) {
+	for _, usage := range usages {
+		if admitted, message := usage.admits(clusterName, metalNode); !admitted {
+			return false, message
+		}
+	}
+	return true, ""
+}
+
+// metalNodeFree returns true if the metal node can be claimed by a demo machine, including one powered off when released
+func metalNodeFree(metalNode *metav1beta1.MetalNode) bool {
+	return metalNode.GetRefCluster() == "" && metalNodeClaimedBy(metalNode) == "" && (metalNode.IsReady() || metalNodePoweredOff(metalNode)) && !metalNode.Status.Bootstrapped && !metalNodeStatusLost(metalNode) &&
+		!metalNodeReprovisioning(metalNode) && !metalNodeCordoned(metalNode)
+}
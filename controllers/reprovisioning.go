@@ -0,0 +1,195 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/metalnode"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// DefaultReprovisioningTimeout is the time a released metal node is given to be cleaned, and then to be reinitialized
+const DefaultReprovisioningTimeout = 30 * time.Minute
+
+// cleanupBootstrapData removes what a cluster left on a metal node, so that it can be initialized again: the Kubernetes node,
+// and the etcd member or the load balancer a DemoCluster ran on it
+const cleanupBootstrapData = `#cloud-config
+runcmd:
+- kubeadm reset --force
+- systemctl disable --now etcd haproxy || true
+- docker rm -f etcd || true
+- rm -f /etc/systemd/system/etcd.service /etc/haproxy/haproxy.cfg && systemctl daemon-reload
+- rm -rf /etc/kubernetes /var/lib/kubelet /var/lib/etcd /etc/etcd /etc/cni/net.d /var/lib/cni
+- iptables -F && iptables -t nat -F && iptables -t mangle -F && iptables -X
+- ipvsadm --clear || true
+- systemctl restart docker
+`
+
+// reconcileReprovisioning powers off a released metal node asked to be, or moves a reprovisioned one through the Cleaning
+// and Reinitializing states to the Available one, or to the Failed one when a step times out
+func (r *DemoMachineReconciler) reconcileReprovisioning(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	metalNode := &metav1beta1.MetalNode{}
+	if err := r.Client.Get(ctx, req.NamespacedName, metalNode); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	l := log.With("metalNode", metalNode.Name)
+
+	// the released metal nodes which are not reprovisioned are powered off instead
+	if metalnode.PowerOffRequested(metalNode) {
+		return ctrl.Result{}, r.reconcilePowerOff(ctx, metalNode)
+	}
+
+	reprovisioning, err := metalnode.GetReprovisioning(metalNode)
+	if err != nil {
+		l.WithError(err).Error("invalid reprovisioning annotation")
+		return ctrl.Result{}, nil
+	}
+	if reprovisioning == nil || metalNode.GetRefCluster() != "" ||
+		(reprovisioning.State != infrav1.CleaningState && reprovisioning.State != infrav1.ReinitializingState) {
+		return ctrl.Result{}, nil
+	}
+
+	timeout := r.ReprovisioningTimeout
+	if timeout == 0 {
+		timeout = DefaultReprovisioningTimeout
+	}
+	remaining := time.Until(reprovisioning.LastTransitionTime.Add(timeout))
+	if remaining <= 0 {
+		step := clusterv1.ConditionType(constants.MetalNodeCleanedCondition)
+		if reprovisioning.State == infrav1.ReinitializingState {
+			step = constants.MetalNodeReinitializedCondition
+		}
+		metalnode.SetReprovisioningCondition(reprovisioning, conditions.FalseCondition(step, constants.ReprovisioningTimedOutReason, clusterv1.ConditionSeverityError,
+			"%s for more than %s", reprovisioning.State, timeout))
+		metalnode.SetReprovisioningState(reprovisioning, infrav1.FailedState)
+		reprovisioning.Failed++
+		metalNodeReprovisionings.WithLabelValues("failed").Inc()
+		l.Warnf("the reprovisioning timed out, the metal node is kept out of the pool until the %s annotation is removed", infrav1.MetalNodeReprovisioningAnnotation)
+		if err := r.deleteCleanupSecret(ctx, metalNode); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.updateReprovisioning(ctx, metalNode, reprovisioning)
+	}
+
+	switch reprovisioning.State {
+	case infrav1.CleaningState:
+		secretName, err := r.reconcileCleanupSecret(ctx, metalNode)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if metalNode.Status.DataSecretName != secretName {
+			metalNode.Status.DataSecretName = secretName
+			metalNode.Status.Bootstrapped = false
+			l.Info("cleaning the metal node")
+			return ctrl.Result{RequeueAfter: remaining}, r.updateReprovisioning(ctx, metalNode, reprovisioning)
+		}
+		if !metalNode.Status.Bootstrapped {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+		if err := r.deleteCleanupSecret(ctx, metalNode); err != nil {
+			return ctrl.Result{}, err
+		}
+		metalNode.ResetMetalNode()
+		metalnode.RequestReinitialization(metalNode)
+		metalnode.SetReprovisioningCondition(reprovisioning, conditions.TrueCondition(constants.MetalNodeCleanedCondition))
+		metalnode.SetReprovisioningCondition(reprovisioning, conditions.FalseCondition(constants.MetalNodeReinitializedCondition, constants.WaitingForMetalNodeReadyReason, clusterv1.ConditionSeverityInfo, ""))
+		metalnode.SetReprovisioningState(reprovisioning, infrav1.ReinitializingState)
+		l.Info("cleaned the metal node, reinitializing it")
+		return ctrl.Result{RequeueAfter: timeout}, r.updateReprovisioning(ctx, metalNode, reprovisioning)
+
+	case infrav1.ReinitializingState:
+		if metalnode.ReinitializationRequested(metalNode) || !metalNode.IsReady() {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+		metalnode.SetReprovisioningCondition(reprovisioning, conditions.TrueCondition(constants.MetalNodeReinitializedCondition))
+		metalnode.SetReprovisioningState(reprovisioning, infrav1.AvailableState)
+		reprovisioning.Reprovisioned++
+		metalNodeReprovisionings.WithLabelValues("succeeded").Inc()
+		l.Info("reprovisioned the metal node")
+		return ctrl.Result{}, r.updateReprovisioning(ctx, metalNode, reprovisioning)
+	}
+	return ctrl.Result{}, nil
+}
+
+// updateReprovisioning persists the reprovisioning and the status of a metal node
+func (r *DemoMachineReconciler) updateReprovisioning(ctx context.Context, metalNode *metav1beta1.MetalNode, reprovisioning *infrav1.MetalNodeReprovisioning) error {
+	if err := metalnode.SetReprovisioning(metalNode, reprovisioning); err != nil {
+		return err
+	}
+	return metalnode.Update(ctx, r.Client, metalNode)
+}
+
+// reconcileCleanupSecret writes the cleanup bootstrap data of a metal node, owned by the metal node
+func (r *DemoMachineReconciler) reconcileCleanupSecret(ctx context.Context, metalNode *metav1beta1.MetalNode) (string, error) {
+	secret := &corev1.Secret{}
+	secret.Name = cleanupSecretName(metalNode)
+	secret.Namespace = metalNode.Namespace
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Type = clusterv1.ClusterSecretType
+		secret.Data = map[string][]byte{
+			"value":  []byte(cleanupBootstrapData),
+			"format": []byte("cloud-config"),
+		}
+		return controllerutil.SetControllerReference(metalNode, secret, r.Client.Scheme())
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to write the cleanup bootstrap data of metal node %s", metalNode.Name)
+	}
+	return secret.Name, nil
+}
+
+// deleteCleanupSecret deletes the cleanup bootstrap data of a metal node
+func (r *DemoMachineReconciler) deleteCleanupSecret(ctx context.Context, metalNode *metav1beta1.MetalNode) error {
+	secret := &corev1.Secret{}
+	secret.Name = cleanupSecretName(metalNode)
+	secret.Namespace = metalNode.Namespace
+	if err := r.Client.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// cleanupSecretName returns the name of the secret holding the cleanup bootstrap data of a metal node
+func cleanupSecretName(metalNode *metav1beta1.MetalNode) string {
+	return fmt.Sprintf("%s-cleanup", metalNode.Name)
+}
+
+// metalNodeReprovisioning returns true if the metal node is being reprovisioned or failed to, it is not claimed then
+func metalNodeReprovisioning(metalNode *metav1beta1.MetalNode) bool {
+	reprovisioning, err := metalnode.GetReprovisioning(metalNode)
+	if err != nil {
+		return true
+	}
+	return metalnode.ReinitializationRequested(metalNode) || (reprovisioning != nil && reprovisioning.State != infrav1.AvailableState)
+}
@@ -17,20 +17,31 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"fmt"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
 	infrastructurev1beta1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/feature"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -40,6 +51,8 @@ import (
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var metalNodeAgent *fakeMetalNodeAgent
+var cancel context.CancelFunc
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -54,16 +67,27 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "config", "crd", "bases"),
+			moduleCRDPath("sigs.k8s.io/cluster-api", "config", "crd", "bases"),
+			moduleCRDPath("sigs.k8s.io/cluster-api", "controlplane", "kubeadm", "config", "crd", "bases"),
+			moduleCRDPath("github.com/git-czy/cluster-api-metalnode", "config", "crd", "bases"),
+			filepath.Join("..", "config", "crd", "ipam"),
+		},
 		ErrorIfCRDPathMissing: true,
 	}
 
-	cfg, err := testEnv.Start()
+	var err error
+	cfg, err = testEnv.Start()
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
 	err = infrastructurev1beta1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = clusterv1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = metav1beta1.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
 
@@ -71,10 +95,74 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("starting the controllers and the fake metal node agent")
+	Expect(feature.MutableGates.Set(fmt.Sprintf("%s=true,%s=true", feature.IPAM, feature.MetalNodeReprovisioning))).To(Succeed())
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	// retry fast, the tests wait for the flows to converge
+	options := controller.Options{
+		MaxConcurrentReconciles: 5,
+		RateLimiter:             workqueue.NewItemExponentialFailureRateLimiter(10*time.Millisecond, time.Second),
+	}
+	err = (&DemoClusterReconciler{
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		EndpointProbeInterval: time.Second,
+	}).SetupWithManager(mgr, options)
+	Expect(err).NotTo(HaveOccurred())
+	err = (&DemoMachineReconciler{
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		ReprovisioningTimeout: 5 * time.Second,
+		BootstrapTimeout:      2 * time.Second,
+		Recorder:              mgr.GetEventRecorderFor("demomachine-controller"),
+		APIReader:             mgr.GetAPIReader(),
+	}).SetupWithManager(mgr, options)
+	Expect(err).NotTo(HaveOccurred())
+	err = (&DemoRemediationReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, options)
+	Expect(err).NotTo(HaveOccurred())
+	err = (&MetalNodeQuotaReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, options)
+	Expect(err).NotTo(HaveOccurred())
+	err = (&DemoIPPoolReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, options)
+	Expect(err).NotTo(HaveOccurred())
+
+	metalNodeAgent = newFakeMetalNodeAgent(mgr.GetClient())
+	err = metalNodeAgent.SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(ctrl.SetupSignalHandler())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	if cancel != nil {
+		cancel()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// moduleCRDPath returns a CRD directory of a module dependency, as downloaded in the module cache
+func moduleCRDPath(module string, elem ...string) string {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", module).Output()
+	Expect(err).NotTo(HaveOccurred(), "failed to locate module %s", module)
+	return filepath.Join(append([]string{strings.TrimSpace(string(out))}, elem...)...)
+}
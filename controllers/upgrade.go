@@ -0,0 +1,213 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+// sameKubernetesVersion tells whether two Kubernetes versions are the same, with or without their v prefix
+func sameKubernetesVersion(a, b string) bool {
+	va, errA := version.ParseMajorMinorPatchTolerant(a)
+	vb, errB := version.ParseMajorMinorPatchTolerant(b)
+	if errA != nil || errB != nil {
+		return strings.TrimPrefix(a, "v") == strings.TrimPrefix(b, "v")
+	}
+	return version.Compare(va, vb, version.WithBuildTags()) == 0
+}
+
+// newerKubernetesVersion tells whether the Kubernetes version a is newer than b, the versions that do not parse are never newer
+func newerKubernetesVersion(a, b string) bool {
+	va, err := version.ParseMajorMinorPatchTolerant(a)
+	if err != nil {
+		return false
+	}
+	vb, err := version.ParseMajorMinorPatchTolerant(b)
+	if err != nil {
+		return true
+	}
+	return version.Compare(va, vb, version.WithBuildTags()) > 0
+}
+
+// machineKubernetesVersion returns the Kubernetes version of a machine, empty when it has none
+func machineKubernetesVersion(machine *clusterv1.Machine) string {
+	if machine.Spec.Version == nil {
+		return ""
+	}
+	return *machine.Spec.Version
+}
+
+// metalNodeInitializedFor tells whether a metal node is initialized for a Kubernetes version, the metal nodes without
+// a version are taken for initialized for any
+func metalNodeInitializedFor(metalNode *metav1beta1.MetalNode, kubernetesVersion string) bool {
+	initialized := metalNode.GetAnnotations()[infrav1.MetalNodeKubernetesVersionAnnotation]
+	return kubernetesVersion == "" || initialized == "" || sameKubernetesVersion(initialized, kubernetesVersion)
+}
+
+// metalNodeReinitializing tells whether a metal node is still being reinitialized for the Kubernetes version requested for it
+func metalNodeReinitializing(metalNode *metav1beta1.MetalNode) bool {
+	requested := metalNode.GetAnnotations()[infrav1.MetalNodeRequestedKubernetesVersionAnnotation]
+	if requested == "" {
+		return false
+	}
+	initialized := metalNode.GetAnnotations()[infrav1.MetalNodeKubernetesVersionAnnotation]
+	return !metalNode.IsReady() || initialized == "" || !sameKubernetesVersion(initialized, requested)
+}
+
+// sortMetalNodesForVersion sorts the metal nodes so that the ones pre-staged for the Kubernetes version come first,
+// then the ones without a version, and last the ones initialized for another version
+func sortMetalNodesForVersion(metalNodes []metav1beta1.MetalNode, kubernetesVersion string) {
+	if kubernetesVersion == "" {
+		return
+	}
+	rank := func(metalNode *metav1beta1.MetalNode) int {
+		initialized := metalNode.GetAnnotations()[infrav1.MetalNodeKubernetesVersionAnnotation]
+		switch {
+		case initialized == "":
+			return 1
+		case sameKubernetesVersion(initialized, kubernetesVersion):
+			return 0
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(metalNodes, func(i, j int) bool {
+		return rank(&metalNodes[i]) < rank(&metalNodes[j])
+	})
+}
+
+// requestKubernetesVersion has a metal node reinitialized for a Kubernetes version, the metal node controller
+// initializes the metal nodes it does not report ready
+func requestKubernetesVersion(metalNode *metav1beta1.MetalNode, kubernetesVersion string) {
+	annotations := metalNode.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[infrav1.MetalNodeRequestedKubernetesVersionAnnotation] = kubernetesVersion
+	metalNode.SetAnnotations(annotations)
+	metalNode.Status.Ready = false
+}
+
+// reconcileUpgrade reports the progress of the Kubernetes version upgrade of the machines of the cluster, the target
+// version being the newest version of the machines. Nothing is reported until the machines are of different versions.
+func (r *DemoClusterReconciler) reconcileUpgrade(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) error {
+	machineList := &clusterv1.MachineList{}
+	if err := r.Client.List(ctx, machineList, client.InNamespace(cluster.Namespace), client.MatchingLabels{clusterv1.ClusterLabelName: cluster.Name}); err != nil {
+		return err
+	}
+	demoMachineList := &infrav1.DemoMachineList{}
+	if err := r.Client.List(ctx, demoMachineList, client.InNamespace(cluster.Namespace), client.MatchingLabels{clusterv1.ClusterLabelName: cluster.Name}); err != nil {
+		return err
+	}
+	bootstrapped := map[string]bool{}
+	for _, demoMachine := range demoMachineList.Items {
+		bootstrapped[demoMachine.Name] = demoMachine.Status.Bootstrapped
+	}
+
+	target := ""
+	for i := range machineList.Items {
+		if v := machineKubernetesVersion(&machineList.Items[i]); v != "" && (target == "" || newerKubernetesVersion(v, target)) {
+			target = v
+		}
+	}
+	if target == "" {
+		return nil
+	}
+
+	var updated, pending, outdated int32
+	for i := range machineList.Items {
+		machine := &machineList.Items[i]
+		v := machineKubernetesVersion(machine)
+		switch {
+		case v == "":
+		case !sameKubernetesVersion(v, target):
+			outdated++
+		case machine.Spec.InfrastructureRef.Kind == "DemoMachine" && bootstrapped[machine.Spec.InfrastructureRef.Name]:
+			updated++
+		default:
+			pending++
+		}
+	}
+
+	upgrade := demoCluster.Status.Upgrade
+	if upgrade == nil || !sameKubernetesVersion(upgrade.TargetVersion, target) {
+		// the machines of a new cluster are all of the same version
+		if upgrade == nil && outdated == 0 {
+			return nil
+		}
+		upgrade = &infrav1.DemoUpgradeStatus{TargetVersion: target, StartTime: metav1.Now()}
+		log.Infof("upgrading the machines of cluster %s to Kubernetes %s", cluster.Name, target)
+	}
+
+	metalNodeList := &metav1beta1.MetalNodeList{}
+	if err := r.Client.List(ctx, metalNodeList, watchFilterListOptions(demoCluster.Namespace, r.WatchFilterValue)...); err != nil {
+		return err
+	}
+	var reinitializing int32
+	for i := range metalNodeList.Items {
+		metalNode := &metalNodeList.Items[i]
+		if metalNodeRefersTo(metalNode, cluster.Name, demoCluster.Name) && metalNodeReinitializing(metalNode) &&
+			sameKubernetesVersion(metalNode.GetAnnotations()[infrav1.MetalNodeRequestedKubernetesVersionAnnotation], target) {
+			reinitializing++
+		}
+	}
+
+	upgrade.UpdatedMachines = updated
+	upgrade.PendingMachines = pending
+	upgrade.OutdatedMachines = outdated
+	upgrade.ReinitializingMetalNodes = reinitializing
+	switch {
+	case outdated > 0 || pending > 0:
+		upgrade.CompletionTime = nil
+	case upgrade.CompletionTime == nil:
+		now := metav1.Now()
+		upgrade.CompletionTime = &now
+		log.Infof("upgraded the machines of cluster %s to Kubernetes %s", cluster.Name, target)
+	}
+	demoCluster.Status.Upgrade = upgrade
+	return nil
+}
+
+// MachineToDemoCluster is a handler.MapFunc to be used to enqueue requests for reconciliation of the DemoCluster
+// of a Machine or a DemoMachine, so that the progress of its upgrade is reported
+func (r *DemoClusterReconciler) MachineToDemoCluster(o client.Object) []reconcile.Request {
+	clusterName := o.GetLabels()[clusterv1.ClusterLabelName]
+	if clusterName == "" {
+		return nil
+	}
+	cluster := &clusterv1.Cluster{}
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Namespace: o.GetNamespace(), Name: clusterName}, cluster); err != nil {
+		return nil
+	}
+	ref := cluster.Spec.InfrastructureRef
+	if ref == nil || ref.Kind != "DemoCluster" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: cluster.Namespace, Name: ref.Name}}}
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+	"github.com/git-czy/cluster-api-provider-demo/loadbalancer"
+	"github.com/git-czy/cluster-api-provider-demo/utils/log"
+)
+
+const (
+	// defaultVirtualRouterID is the VRRP virtual router id used when the DemoCluster does not set one
+	defaultVirtualRouterID = 51
+
+	// keepalived keys of the secret holding the keepalived configuration
+	keepalivedConfigKey   = "keepalived.conf"
+	keepalivedAuthPassKey = "auth-pass"
+)
+
+// reconcileVirtualIP points the control plane endpoint to the virtual IP, and injects keepalived into the
+// KubeadmControlPlane of the cluster before the demoCluster is ready, i.e. before it creates the control plane machines.
+// Once the demoCluster is ready it keeps the injected keepalived in line with the virtual IP settings, the address is
+// the immutable control plane endpoint and can not be changed.
+func (r *DemoClusterReconciler) reconcileVirtualIP(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster) (ctrl.Result, error) {
+	if !demoCluster.Spec.ControlPlaneEndpoint.IsValid() {
+		demoCluster.Spec.ControlPlaneEndpoint = clusterv1.APIEndpoint{
+			Host: demoCluster.Spec.VirtualIP.Address,
+			Port: apiServerPort(demoCluster),
+		}
+	}
+	if demoCluster.Spec.ControlPlaneEndpoint.Host != demoCluster.Spec.VirtualIP.Address {
+		conditions.MarkFalse(demoCluster, constants.VirtualIPInjectedCondition, constants.InvalidConfigurationReason, clusterv1.ConditionSeverityError,
+			"the virtual IP %s is not the control plane endpoint %s, which can not be changed", demoCluster.Spec.VirtualIP.Address, demoCluster.Spec.ControlPlaneEndpoint.Host)
+		log.Errorf("the virtual IP %s of demoCluster %s is not its control plane endpoint %s", demoCluster.Spec.VirtualIP.Address, demoCluster.Name, demoCluster.Spec.ControlPlaneEndpoint.Host)
+		return ctrl.Result{}, nil
+	}
+	conditions.MarkTrue(demoCluster, constants.ControlPlaneEndPointSetCondition)
+
+	// other control planes have to run keepalived on their own, the endpoint is still the virtual IP
+	ref := cluster.Spec.ControlPlaneRef
+	if ref == nil || ref.Kind != "KubeadmControlPlane" {
+		conditions.MarkFalse(demoCluster, constants.VirtualIPInjectedCondition, constants.UnsupportedControlPlaneReason, clusterv1.ConditionSeverityWarning,
+			"keepalived is only injected into a KubeadmControlPlane")
+		demoCluster.Status.Ready = true
+		return ctrl.Result{}, nil
+	}
+
+	kcp := &unstructured.Unstructured{}
+	kcp.SetAPIVersion(ref.APIVersion)
+	kcp.SetKind(ref.Kind)
+	key := client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}
+	if key.Namespace == "" {
+		key.Namespace = cluster.Namespace
+	}
+	if err := r.Client.Get(ctx, key, kcp); err != nil {
+		if apierrors.IsNotFound(err) {
+			conditions.MarkFalse(demoCluster, constants.VirtualIPInjectedCondition, constants.WaitingForControlPlaneReason, clusterv1.ConditionSeverityInfo, "")
+			log.Infof("waiting for the KubeadmControlPlane %s to inject keepalived", key.String())
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	vip := loadbalancer.VirtualIP{
+		Address:   demoCluster.Spec.VirtualIP.Address,
+		Interface: demoCluster.Spec.VirtualIP.Interface,
+		RouterID:  demoCluster.Spec.VirtualIP.VirtualRouterID,
+		Port:      demoCluster.Spec.ControlPlaneEndpoint.Port,
+	}
+	if vip.RouterID == 0 {
+		vip.RouterID = defaultVirtualRouterID
+	}
+	if err := r.reconcileKeepalivedSecret(ctx, demoCluster, cluster, &vip); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.injectKeepalived(ctx, demoCluster, kcp, vip); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	conditions.MarkTrue(demoCluster, constants.VirtualIPInjectedCondition)
+	demoCluster.Status.Ready = true
+	return ctrl.Result{}, nil
+}
+
+// reconcileKeepalivedSecret writes the keepalived configuration, it keeps the VRRP password of the secret
+// so that the machines already running keepalived keep talking to the new ones
+func (r *DemoClusterReconciler) reconcileKeepalivedSecret(ctx context.Context, demoCluster *infrav1.DemoCluster, cluster *clusterv1.Cluster, vip *loadbalancer.VirtualIP) error {
+	secret := &corev1.Secret{}
+	secret.Name = keepalivedSecretName(demoCluster)
+	secret.Namespace = demoCluster.Namespace
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		vip.AuthPass = string(secret.Data[keepalivedAuthPassKey])
+		if vip.AuthPass == "" {
+			vip.AuthPass = util.RandomString(8)
+		}
+		config, err := loadbalancer.KeepalivedConfig(*vip)
+		if err != nil {
+			return err
+		}
+		secret.Labels = map[string]string{clusterv1.ClusterLabelName: cluster.Name}
+		secret.Type = clusterv1.ClusterSecretType
+		secret.Data = map[string][]byte{
+			keepalivedConfigKey:   []byte(config),
+			keepalivedAuthPassKey: []byte(vip.AuthPass),
+		}
+		return controllerutil.SetControllerReference(demoCluster, secret, r.Client.Scheme())
+	})
+	return err
+}
+
+// injectKeepalived adds the keepalived files to the files of the KubeadmControlPlane, replacing the ones
+// injected before. The KubeadmControlPlane rolls its machines out when the files change.
+func (r *DemoClusterReconciler) injectKeepalived(ctx context.Context, demoCluster *infrav1.DemoCluster, kcp *unstructured.Unstructured, vip loadbalancer.VirtualIP) error {
+	keepalivedFiles, err := loadbalancer.KeepalivedFiles(vip)
+	if err != nil {
+		return err
+	}
+	injected := []interface{}{
+		map[string]interface{}{
+			"path":        loadbalancer.KeepalivedConfigPath,
+			"owner":       "root:root",
+			"permissions": "0600",
+			"contentFrom": map[string]interface{}{
+				"secret": map[string]interface{}{
+					"name": keepalivedSecretName(demoCluster),
+					"key":  keepalivedConfigKey,
+				},
+			},
+		},
+	}
+	for _, file := range keepalivedFiles {
+		injected = append(injected, map[string]interface{}{
+			"path":        file.Path,
+			"owner":       "root:root",
+			"permissions": file.Permissions,
+			"content":     file.Content,
+		})
+	}
+
+	files, _, err := unstructured.NestedSlice(kcp.Object, "spec", "kubeadmConfigSpec", "files")
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the files of KubeadmControlPlane %s", kcp.GetName())
+	}
+	paths := map[string]bool{}
+	for _, file := range injected {
+		paths[file.(map[string]interface{})["path"].(string)] = true
+	}
+	var desired []interface{}
+	for _, file := range files {
+		if f, ok := file.(map[string]interface{}); ok && paths[fmt.Sprint(f["path"])] {
+			continue
+		}
+		desired = append(desired, file)
+	}
+	desired = append(desired, injected...)
+	if reflect.DeepEqual(files, desired) {
+		return nil
+	}
+
+	patchBase := client.MergeFrom(kcp.DeepCopy())
+	if err := unstructured.SetNestedSlice(kcp.Object, desired, "spec", "kubeadmConfigSpec", "files"); err != nil {
+		return err
+	}
+	if err := r.Client.Patch(ctx, kcp, patchBase); err != nil {
+		return errors.Wrapf(err, "failed to inject keepalived into KubeadmControlPlane %s", kcp.GetName())
+	}
+	log.Infof("injected keepalived holding %s into KubeadmControlPlane %s", vip.Address, kcp.GetName())
+	return nil
+}
+
+// keepalivedSecretName returns the name of the secret holding the keepalived configuration
+func keepalivedSecretName(demoCluster *infrav1.DemoCluster) string {
+	return fmt.Sprintf("%s-keepalived", demoCluster.Name)
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/cluster-api/util/certs"
+)
+
+// ports of the etcd members
+const (
+	ClientPort = 2379
+	PeerPort   = 2380
+)
+
+// DefaultImage runs the etcd members, it is the etcd of kubeadm v1.23
+const DefaultImage = "registry.aliyuncs.com/google_containers/etcd:3.5.1-0"
+
+// paths of the etcd files on the metal nodes
+const (
+	PKIDir      = "/etc/etcd/pki"
+	DataDir     = "/var/lib/etcd"
+	ServicePath = "/etc/systemd/system/etcd.service"
+)
+
+// Member is an etcd member of the cluster, running on a metal node
+type Member struct {
+	// Name is the name of the member, e.g. the name of the metal node
+	Name string
+	// Address is the address the member listens on
+	Address string
+}
+
+// ClientURL returns the URL the clients reach the member at
+func (m Member) ClientURL() string {
+	return fmt.Sprintf("https://%s", net.JoinHostPort(m.Address, strconv.Itoa(ClientPort)))
+}
+
+// PeerURL returns the URL the peers reach the member at
+func (m Member) PeerURL() string {
+	return fmt.Sprintf("https://%s", net.JoinHostPort(m.Address, strconv.Itoa(PeerPort)))
+}
+
+// Endpoints returns the client URLs of the members, sorted by member name
+func Endpoints(members []Member) []string {
+	var endpoints []string
+	for _, member := range sortMembers(members) {
+		endpoints = append(endpoints, member.ClientURL())
+	}
+	return endpoints
+}
+
+// Config is the configuration of an etcd member
+type Config struct {
+	// Member is the member to run
+	Member Member
+	// Members are all the members of the cluster, including Member
+	Members []Member
+	// ClusterToken identifies the etcd cluster while its members bootstrap
+	ClusterToken string
+	// Image is the etcd container image, defaults to DefaultImage
+	Image string
+	// CACert is the PEM encoded certificate of the etcd certificate authority
+	CACert []byte
+	// KeyPair is the PEM encoded certificate and key of the member
+	KeyPair *certs.KeyPair
+}
+
+// the member runs in a container managed by systemd, the metal nodes come with docker for kubeadm
+const bootstrapTemplate = `#cloud-config
+write_files:
+- path: {{ .PKIDir }}/ca.crt
+  owner: root:root
+  permissions: '0644'
+  content: |
+{{ indent .CACert }}
+- path: {{ .PKIDir }}/member.crt
+  owner: root:root
+  permissions: '0644'
+  content: |
+{{ indent .Cert }}
+- path: {{ .PKIDir }}/member.key
+  owner: root:root
+  permissions: '0600'
+  content: |
+{{ indent .Key }}
+- path: {{ .ServicePath }}
+  owner: root:root
+  permissions: '0644'
+  content: |
+    [Unit]
+    Description=etcd member {{ .Member.Name }}
+    After=docker.service
+    Requires=docker.service
+
+    [Service]
+    ExecStartPre=-/usr/bin/docker rm -f etcd
+    ExecStart=/usr/bin/docker run --name etcd --net host \
+      -v {{ .DataDir }}:{{ .DataDir }} -v {{ .PKIDir }}:{{ .PKIDir }}:ro \
+      {{ .Image }} etcd \
+      --name={{ .Member.Name }} \
+      --data-dir={{ .DataDir }} \
+      --listen-client-urls={{ .Member.ClientURL }},https://127.0.0.1:{{ .ClientPort }} \
+      --advertise-client-urls={{ .Member.ClientURL }} \
+      --listen-peer-urls={{ .Member.PeerURL }} \
+      --initial-advertise-peer-urls={{ .Member.PeerURL }} \
+      --initial-cluster={{ .InitialCluster }} \
+      --initial-cluster-state=new \
+      --initial-cluster-token={{ .ClusterToken }} \
+      --client-cert-auth=true \
+      --trusted-ca-file={{ .PKIDir }}/ca.crt \
+      --cert-file={{ .PKIDir }}/member.crt \
+      --key-file={{ .PKIDir }}/member.key \
+      --peer-client-cert-auth=true \
+      --peer-trusted-ca-file={{ .PKIDir }}/ca.crt \
+      --peer-cert-file={{ .PKIDir }}/member.crt \
+      --peer-key-file={{ .PKIDir }}/member.key
+    ExecStop=/usr/bin/docker stop etcd
+    Restart=always
+    RestartSec=5
+
+    [Install]
+    WantedBy=multi-user.target
+runcmd:
+- mkdir -p {{ .DataDir }}
+- systemctl daemon-reload
+- systemctl enable etcd
+- systemctl restart etcd
+`
+
+var bootstrapTmpl = template.Must(template.New("bootstrap").Funcs(template.FuncMap{
+	"indent": func(data []byte) string {
+		lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		for i := range lines {
+			lines[i] = "    " + lines[i]
+		}
+		return strings.Join(lines, "\n")
+	},
+}).Parse(bootstrapTemplate))
+
+// BootstrapData returns the cloud-config running the etcd member on its metal node
+func BootstrapData(config *Config) ([]byte, error) {
+	if config.KeyPair == nil || !config.KeyPair.IsValid() {
+		return nil, errors.Errorf("missing the certificate of etcd member %s", config.Member.Name)
+	}
+	image := config.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	var initialCluster []string
+	for _, member := range sortMembers(config.Members) {
+		initialCluster = append(initialCluster, fmt.Sprintf("%s=%s", member.Name, member.PeerURL()))
+	}
+
+	var buf bytes.Buffer
+	if err := bootstrapTmpl.Execute(&buf, struct {
+		*Config
+		Image          string
+		InitialCluster string
+		Cert           []byte
+		Key            []byte
+		PKIDir         string
+		DataDir        string
+		ServicePath    string
+		ClientPort     int
+	}{config, image, strings.Join(initialCluster, ","), config.KeyPair.Cert, config.KeyPair.Key, PKIDir, DataDir, ServicePath, ClientPort}); err != nil {
+		return nil, errors.Wrapf(err, "failed to render the bootstrap data of etcd member %s", config.Member.Name)
+	}
+	return buf.Bytes(), nil
+}
+
+func sortMembers(members []Member) []Member {
+	sorted := append([]Member(nil), members...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/yaml"
+)
+
+func TestEndpoints(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Endpoints([]Member{
+		{Name: "metalnode-b", Address: "10.0.0.2"},
+		{Name: "metalnode-a", Address: "fd00::1"},
+	})).To(Equal([]string{"https://[fd00::1]:2379", "https://10.0.0.2:2379"}))
+	g.Expect(Endpoints(nil)).To(BeEmpty())
+}
+
+func TestBootstrapData(t *testing.T) {
+	g := NewWithT(t)
+
+	members := []Member{
+		{Name: "metalnode-b", Address: "10.0.0.2"},
+		{Name: "metalnode-a", Address: "10.0.0.1"},
+	}
+	keyPair := &certs.KeyPair{Cert: []byte("member-cert\n"), Key: []byte("member-key\n")}
+	data, err := BootstrapData(&Config{
+		Member:       members[0],
+		Members:      members,
+		ClusterToken: "demo-cluster",
+		CACert:       []byte("ca-cert\n"),
+		KeyPair:      keyPair,
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data)).To(HavePrefix("#cloud-config\n"))
+
+	cloudConfig := struct {
+		WriteFiles []struct {
+			Path        string `json:"path"`
+			Permissions string `json:"permissions"`
+			Content     string `json:"content"`
+		} `json:"write_files"`
+		RunCmd []string `json:"runcmd"`
+	}{}
+	g.Expect(yaml.Unmarshal(data, &cloudConfig)).To(Succeed())
+	g.Expect(cloudConfig.WriteFiles).To(HaveLen(4))
+	g.Expect(cloudConfig.WriteFiles[0].Content).To(Equal("ca-cert\n"))
+	g.Expect(cloudConfig.WriteFiles[1].Content).To(Equal("member-cert\n"))
+	g.Expect(cloudConfig.WriteFiles[2].Content).To(Equal("member-key\n"))
+	g.Expect(cloudConfig.WriteFiles[2].Permissions).To(Equal("0600"))
+
+	service := cloudConfig.WriteFiles[3]
+	g.Expect(service.Path).To(Equal(ServicePath))
+	g.Expect(service.Content).To(ContainSubstring(DefaultImage + " etcd"))
+	g.Expect(service.Content).To(ContainSubstring("--name=metalnode-b "))
+	g.Expect(service.Content).To(ContainSubstring("--listen-client-urls=https://10.0.0.2:2379,https://127.0.0.1:2379 "))
+	g.Expect(service.Content).To(ContainSubstring(
+		"--initial-cluster=metalnode-a=https://10.0.0.1:2380,metalnode-b=https://10.0.0.2:2380 "))
+	g.Expect(service.Content).To(ContainSubstring("--initial-cluster-token=demo-cluster "))
+	g.Expect(cloudConfig.RunCmd).To(ContainElement("systemctl restart etcd"))
+
+	_, err = BootstrapData(&Config{Member: members[0], Members: members})
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package etcd issues the certificates of the external etcd cluster of the control plane,
+// and renders the bootstrap data running an etcd member on a metal node.
+package etcd
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/cluster-api/util/certs"
+)
+
+// certificateDuration is the validity of the certificate authority, the certificates it signs
+// are not rotated so they last as long as it does
+const certificateDuration = time.Hour * 24 * 365 * 10
+
+// APIServerClientCommonName is the common name of the client certificate of the API servers
+const APIServerClientCommonName = "kube-apiserver-etcd-client"
+
+// CertificateAuthority signs the certificates of the etcd members and of their clients
+type CertificateAuthority struct {
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+}
+
+// NewCertificateAuthority returns a new self-signed certificate authority
+func NewCertificateAuthority() (*CertificateAuthority, error) {
+	key, err := certs.NewPrivateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the etcd CA private key")
+	}
+
+	now := time.Now().UTC()
+	tmpl := x509.Certificate{
+		SerialNumber:          new(big.Int).SetInt64(0),
+		Subject:               pkix.Name{CommonName: "etcd-ca"},
+		NotBefore:             now.Add(time.Minute * -5),
+		NotAfter:              now.Add(certificateDuration),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the etcd CA certificate")
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &CertificateAuthority{Cert: cert, Key: key}, nil
+}
+
+// ParseCertificateAuthority returns the certificate authority of a PEM encoded certificate and key
+func ParseCertificateAuthority(keyPair *certs.KeyPair) (*CertificateAuthority, error) {
+	cert, err := certs.DecodeCertPEM(keyPair.Cert)
+	if err != nil || cert == nil {
+		return nil, errors.Errorf("failed to decode the etcd CA certificate: %v", err)
+	}
+	signer, err := certs.DecodePrivateKeyPEM(keyPair.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the etcd CA private key")
+	}
+	key, ok := signer.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("expected a RSA etcd CA private key but got a %T", signer)
+	}
+	return &CertificateAuthority{Cert: cert, Key: key}, nil
+}
+
+// KeyPair returns the PEM encoded certificate and key of the certificate authority
+func (ca *CertificateAuthority) KeyPair() *certs.KeyPair {
+	return &certs.KeyPair{
+		Cert: certs.EncodeCertPEM(ca.Cert),
+		Key:  certs.EncodePrivateKeyPEM(ca.Key),
+	}
+}
+
+// NewMemberKeyPair returns the certificate an etcd member serves its clients and its peers with,
+// and authenticates to its peers with
+func (ca *CertificateAuthority) NewMemberKeyPair(name string, ip net.IP) (*certs.KeyPair, error) {
+	return ca.newKeyPair(&certs.Config{
+		CommonName: name,
+		AltNames: certs.AltNames{
+			DNSNames: []string{name, "localhost"},
+			IPs:      []net.IP{ip, net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		},
+		Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	})
+}
+
+// NewAPIServerClientKeyPair returns the certificate the API servers authenticate to etcd with
+func (ca *CertificateAuthority) NewAPIServerClientKeyPair() (*certs.KeyPair, error) {
+	return ca.newKeyPair(&certs.Config{
+		CommonName:   APIServerClientCommonName,
+		Organization: []string{"system:masters"},
+		Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+func (ca *CertificateAuthority) newKeyPair(cfg *certs.Config) (*certs.KeyPair, error) {
+	key, err := certs.NewPrivateKey()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create the private key of %s", cfg.CommonName)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate a certificate serial number")
+	}
+
+	tmpl := x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   cfg.CommonName,
+			Organization: cfg.Organization,
+		},
+		DNSNames:     cfg.AltNames.DNSNames,
+		IPAddresses:  cfg.AltNames.IPs,
+		SerialNumber: serial,
+		NotBefore:    ca.Cert.NotBefore,
+		NotAfter:     ca.Cert.NotAfter,
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  cfg.Usages,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, &tmpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create the certificate of %s", cfg.CommonName)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &certs.KeyPair{
+		Cert: certs.EncodeCertPEM(cert),
+		Key:  certs.EncodePrivateKeyPEM(key),
+	}, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/cluster-api/util/certs"
+)
+
+func TestCertificateAuthority(t *testing.T) {
+	g := NewWithT(t)
+
+	ca, err := NewCertificateAuthority()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(ca.Cert.IsCA).To(BeTrue())
+
+	// the certificate authority survives its round trip through a secret
+	parsed, err := ParseCertificateAuthority(ca.KeyPair())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(parsed.Cert.Equal(ca.Cert)).To(BeTrue())
+	g.Expect(parsed.Key.Equal(ca.Key)).To(BeTrue())
+
+	_, err = ParseCertificateAuthority(&certs.KeyPair{Cert: []byte("garbage"), Key: []byte("garbage")})
+	g.Expect(err).To(HaveOccurred())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+
+	member, err := parsed.NewMemberKeyPair("metalnode-a", net.ParseIP("10.0.0.1"))
+	g.Expect(err).NotTo(HaveOccurred())
+	memberCert, err := certs.DecodeCertPEM(member.Cert)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(memberCert.VerifyHostname("10.0.0.1")).To(Succeed())
+	g.Expect(memberCert.VerifyHostname("127.0.0.1")).To(Succeed())
+	g.Expect(memberCert.NotAfter).To(Equal(ca.Cert.NotAfter))
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		_, err = memberCert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}})
+		g.Expect(err).NotTo(HaveOccurred())
+	}
+
+	client, err := parsed.NewAPIServerClientKeyPair()
+	g.Expect(err).NotTo(HaveOccurred())
+	clientCert, err := certs.DecodeCertPEM(client.Cert)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(clientCert.Subject.CommonName).To(Equal(APIServerClientCommonName))
+	_, err = clientCert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = clientCert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	g.Expect(err).To(HaveOccurred())
+}
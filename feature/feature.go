@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package feature
+
+import (
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/component-base/featuregate"
+)
+
+const (
+	// Every feature gate should add method here following this template:
+	//
+	// // alpha: v0.X
+	// MyFeature featuregate.Feature = "MyFeature".
+
+	// HAControlPlaneEndpoint is a feature gate for highly available control plane endpoints,
+	// it allows a DemoCluster to use an externally provided endpoint and more than one control plane machine.
+	//
+	// alpha: v0.1
+	HAControlPlaneEndpoint featuregate.Feature = "HAControlPlaneEndpoint"
+
+	// AutoRemediation is a feature gate for reporting a DemoMachine as failed when its bootstrapped metal node is lost,
+	// so that a MachineHealthCheck can remediate it.
+	//
+	// alpha: v0.1
+	AutoRemediation featuregate.Feature = "AutoRemediation"
+
+	// IPAM is a feature gate for claiming the static addresses of the DemoMachines from IP pools through the
+	// Cluster API IPAM contract, and for the in-cluster DemoIPPool. It requires the IPAddressClaim and IPAddress CRDs.
+	//
+	// alpha: v0.1
+	IPAM featuregate.Feature = "IPAM"
+
+	// MetalNodeReprovisioning is a feature gate for cleaning and reinitializing the metal nodes released by the DemoMachines
+	// before they are claimed again, instead of handing them back as they are.
+	//
+	// alpha: v0.1
+	MetalNodeReprovisioning featuregate.Feature = "MetalNodeReprovisioning"
+)
+
+func init() {
+	runtime.Must(MutableGates.Add(defaultDemoProviderFeatureGates))
+}
+
+// defaultDemoProviderFeatureGates consists of all known demo provider feature keys.
+// To add a new feature, define a key for it above and add it here.
+var defaultDemoProviderFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	// Every feature should be initiated here:
+	HAControlPlaneEndpoint:  {Default: false, PreRelease: featuregate.Alpha},
+	AutoRemediation:         {Default: false, PreRelease: featuregate.Alpha},
+	IPAM:                    {Default: false, PreRelease: featuregate.Alpha},
+	MetalNodeReprovisioning: {Default: false, PreRelease: featuregate.Alpha},
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ipam implements the Cluster API IP address management contract, whose IPAddressClaim and IPAddress
+// objects are handled as unstructured since the provider does not depend on a Cluster API release serving them,
+// and the allocation of the addresses of the in-cluster pools.
+package ipam
+
+import (
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersion is the API group and version of the IPAddressClaim and IPAddress objects
+var GroupVersion = schema.GroupVersion{Group: "ipam.cluster.x-k8s.io", Version: "v1alpha1"}
+
+const (
+	// ClaimKind is the kind of the object claiming an address from a pool
+	ClaimKind = "IPAddressClaim"
+
+	// AddressKind is the kind of the object an address is allocated to a claim with
+	AddressKind = "IPAddress"
+)
+
+// Address is an address allocated from a pool
+type Address struct {
+	// Address is the IPv4 or IPv6 address
+	Address string
+	// Prefix is the length of the prefix of the network of the address
+	Prefix int32
+	// Gateway of the network of the address
+	Gateway string
+}
+
+// NewClaim returns an empty IPAddressClaim
+func NewClaim() *unstructured.Unstructured {
+	return newObject(ClaimKind)
+}
+
+// NewClaimList returns an empty list of IPAddressClaims
+func NewClaimList() *unstructured.UnstructuredList {
+	return newList(ClaimKind)
+}
+
+// NewAddress returns an empty IPAddress
+func NewAddress() *unstructured.Unstructured {
+	return newObject(AddressKind)
+}
+
+// NewAddressList returns an empty list of IPAddresses
+func NewAddressList() *unstructured.UnstructuredList {
+	return newList(AddressKind)
+}
+
+func newObject(kind string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(GroupVersion.WithKind(kind))
+	return obj
+}
+
+func newList(kind string) *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(GroupVersion.WithKind(kind + "List"))
+	return list
+}
+
+// GetPoolRef returns the pool an IPAddressClaim claims from or an IPAddress is allocated from
+func GetPoolRef(obj *unstructured.Unstructured) corev1.TypedLocalObjectReference {
+	ref := corev1.TypedLocalObjectReference{}
+	if apiGroup, ok, _ := unstructured.NestedString(obj.Object, "spec", "poolRef", "apiGroup"); ok {
+		ref.APIGroup = &apiGroup
+	}
+	ref.Kind, _, _ = unstructured.NestedString(obj.Object, "spec", "poolRef", "kind")
+	ref.Name, _, _ = unstructured.NestedString(obj.Object, "spec", "poolRef", "name")
+	return ref
+}
+
+// SetPoolRef sets the pool an IPAddressClaim claims from or an IPAddress is allocated from
+func SetPoolRef(obj *unstructured.Unstructured, ref corev1.TypedLocalObjectReference) error {
+	poolRef := map[string]interface{}{
+		"kind": ref.Kind,
+		"name": ref.Name,
+	}
+	if ref.APIGroup != nil {
+		poolRef["apiGroup"] = *ref.APIGroup
+	}
+	return unstructured.SetNestedMap(obj.Object, poolRef, "spec", "poolRef")
+}
+
+// IsPoolRef returns true if ref references the pool of the given API group, kind and name
+func IsPoolRef(ref corev1.TypedLocalObjectReference, apiGroup, kind, name string) bool {
+	return ref.APIGroup != nil && *ref.APIGroup == apiGroup && ref.Kind == kind && ref.Name == name
+}
+
+// GetAddressRef returns the name of the IPAddress allocated to an IPAddressClaim, empty while not allocated
+func GetAddressRef(claim *unstructured.Unstructured) string {
+	name, _, _ := unstructured.NestedString(claim.Object, "status", "addressRef", "name")
+	return name
+}
+
+// SetAddressRef sets the name of the IPAddress allocated to an IPAddressClaim
+func SetAddressRef(claim *unstructured.Unstructured, name string) error {
+	return unstructured.SetNestedField(claim.Object, name, "status", "addressRef", "name")
+}
+
+// GetClaimRef returns the name of the IPAddressClaim an IPAddress is allocated to
+func GetClaimRef(address *unstructured.Unstructured) string {
+	name, _, _ := unstructured.NestedString(address.Object, "spec", "claimRef", "name")
+	return name
+}
+
+// SetAddress sets the address an IPAddress allocates to the IPAddressClaim of the given name
+func SetAddress(obj *unstructured.Unstructured, claimName string, address Address) error {
+	if err := unstructured.SetNestedField(obj.Object, claimName, "spec", "claimRef", "name"); err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(obj.Object, address.Address, "spec", "address"); err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(obj.Object, int64(address.Prefix), "spec", "prefix"); err != nil {
+		return err
+	}
+	if address.Gateway == "" {
+		return nil
+	}
+	return unstructured.SetNestedField(obj.Object, address.Gateway, "spec", "gateway")
+}
+
+// GetAddress returns the address an IPAddress allocates
+func GetAddress(obj *unstructured.Unstructured) (Address, error) {
+	address := Address{}
+	var ok bool
+	var err error
+	if address.Address, ok, err = unstructured.NestedString(obj.Object, "spec", "address"); err != nil || !ok || address.Address == "" {
+		return Address{}, errors.Errorf("IPAddress %s has no address", obj.GetName())
+	}
+	prefix, _, err := unstructured.NestedInt64(obj.Object, "spec", "prefix")
+	if err != nil {
+		return Address{}, errors.Wrapf(err, "IPAddress %s has an invalid prefix", obj.GetName())
+	}
+	address.Prefix = int32(prefix)
+	address.Gateway, _, _ = unstructured.NestedString(obj.Object, "spec", "gateway")
+	return address, nil
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAddress(t *testing.T) {
+	g := NewWithT(t)
+
+	apiGroup := "infrastructure.cluster.x-k8s.io"
+	pool := corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: "DemoIPPool", Name: "pool"}
+
+	claim := NewClaim()
+	g.Expect(claim.GetAPIVersion()).To(Equal("ipam.cluster.x-k8s.io/v1alpha1"))
+	g.Expect(SetPoolRef(claim, pool)).To(Succeed())
+	g.Expect(IsPoolRef(GetPoolRef(claim), apiGroup, "DemoIPPool", "pool")).To(BeTrue())
+	g.Expect(IsPoolRef(GetPoolRef(claim), "", "DemoIPPool", "pool")).To(BeFalse())
+	g.Expect(GetAddressRef(claim)).To(BeEmpty())
+	g.Expect(SetAddressRef(claim, "machine")).To(Succeed())
+	g.Expect(GetAddressRef(claim)).To(Equal("machine"))
+
+	address := NewAddress()
+	address.SetName("machine")
+	_, err := GetAddress(address)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(SetAddress(address, "machine", Address{Address: "10.0.0.5", Prefix: 24, Gateway: "10.0.0.1"})).To(Succeed())
+	g.Expect(GetClaimRef(address)).To(Equal("machine"))
+	g.Expect(GetAddress(address)).To(Equal(Address{Address: "10.0.0.5", Prefix: 24, Gateway: "10.0.0.1"}))
+}
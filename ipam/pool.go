@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Pool is the ordered set of the addresses of an in-cluster pool
+type Pool struct {
+	ranges []ipRange
+}
+
+// ipRange is a range of addresses of the same family, both ends included
+type ipRange struct {
+	first, last net.IP
+}
+
+// NewPool returns the pool of the given addresses, each an IP address, a range of IP addresses like
+// 10.0.0.10-10.0.0.20, or a CIDR whose network and broadcast addresses are left out
+func NewPool(addresses []string) (*Pool, error) {
+	pool := &Pool{}
+	for _, address := range addresses {
+		r, err := parseRange(strings.TrimSpace(address))
+		if err != nil {
+			return nil, err
+		}
+		pool.ranges = append(pool.ranges, r)
+	}
+	return pool, nil
+}
+
+func parseRange(address string) (ipRange, error) {
+	if strings.Contains(address, "/") {
+		ip, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return ipRange{}, errors.Wrapf(err, "invalid CIDR %s", address)
+		}
+		first := normalize(network.IP)
+		last := make(net.IP, len(first))
+		for i := range first {
+			last[i] = first[i] | ^network.Mask[i]
+		}
+		// single addresses and point-to-point networks have no network and broadcast addresses
+		if ones, bits := network.Mask.Size(); bits-ones > 1 {
+			first = next(first)
+			if ip.To4() != nil {
+				last = prev(last)
+			}
+		}
+		return ipRange{first: first, last: last}, nil
+	}
+
+	first, last := address, address
+	if i := strings.Index(address, "-"); i >= 0 {
+		first, last = strings.TrimSpace(address[:i]), strings.TrimSpace(address[i+1:])
+	}
+	r := ipRange{first: normalize(net.ParseIP(first)), last: normalize(net.ParseIP(last))}
+	if r.first == nil || r.last == nil {
+		return ipRange{}, errors.Errorf("invalid address %s", address)
+	}
+	if len(r.first) != len(r.last) || bytes.Compare(r.first, r.last) > 0 {
+		return ipRange{}, errors.Errorf("invalid address range %s", address)
+	}
+	return r, nil
+}
+
+// normalize returns IPv4 addresses in their 4-byte form so that the families never compare equal
+func normalize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+func next(ip net.IP) net.IP {
+	n := make(net.IP, len(ip))
+	copy(n, ip)
+	for i := len(n) - 1; i >= 0; i-- {
+		if n[i]++; n[i] != 0 {
+			break
+		}
+	}
+	return n
+}
+
+func prev(ip net.IP) net.IP {
+	p := make(net.IP, len(ip))
+	copy(p, ip)
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i]--; p[i] != 0xff {
+			break
+		}
+	}
+	return p
+}
+
+// Contains returns true if address is in the pool
+func (p *Pool) Contains(address string) bool {
+	ip := normalize(net.ParseIP(address))
+	if ip == nil {
+		return false
+	}
+	for _, r := range p.ranges {
+		if len(ip) == len(r.first) && bytes.Compare(ip, r.first) >= 0 && bytes.Compare(ip, r.last) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the number of addresses in the pool, capped to math.MaxInt32 for the IPv6 networks
+func (p *Pool) Size() int32 {
+	size := new(big.Int)
+	for _, r := range p.ranges {
+		n := new(big.Int).Sub(new(big.Int).SetBytes(r.last), new(big.Int).SetBytes(r.first))
+		size.Add(size, n.Add(n, big.NewInt(1)))
+	}
+	if !size.IsInt64() || size.Int64() > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(size.Int64())
+}
+
+// Allocate returns the first address of the pool not in use, false if all of them are
+func (p *Pool) Allocate(used map[string]bool) (string, bool) {
+	for _, r := range p.ranges {
+		for ip := r.first; bytes.Compare(ip, r.last) <= 0; ip = next(ip) {
+			if !used[ip.String()] {
+				return ip.String(), true
+			}
+			// the last address of the family wraps around
+			if ip.Equal(r.last) {
+				break
+			}
+		}
+	}
+	return "", false
+}
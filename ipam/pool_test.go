@@ -0,0 +1,75 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPool(t *testing.T) {
+	g := NewWithT(t)
+
+	pool, err := NewPool([]string{"10.0.0.10-10.0.0.11", "192.168.0.0/30", "fd00::1"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pool.Size()).To(BeEquivalentTo(5))
+	g.Expect(pool.Contains("10.0.0.11")).To(BeTrue())
+	g.Expect(pool.Contains("192.168.0.0")).To(BeFalse())
+	g.Expect(pool.Contains("192.168.0.3")).To(BeFalse())
+	g.Expect(pool.Contains("::ffff:10.0.0.10")).To(BeTrue())
+
+	used := map[string]bool{}
+	var allocated []string
+	for {
+		address, ok := pool.Allocate(used)
+		if !ok {
+			break
+		}
+		used[address] = true
+		allocated = append(allocated, address)
+	}
+	g.Expect(allocated).To(Equal([]string{"10.0.0.10", "10.0.0.11", "192.168.0.1", "192.168.0.2", "fd00::1"}))
+
+	delete(used, "192.168.0.1")
+	address, ok := pool.Allocate(used)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(address).To(Equal("192.168.0.1"))
+}
+
+func TestPoolEdges(t *testing.T) {
+	g := NewWithT(t)
+
+	pool, err := NewPool([]string{"10.0.0.1/32", "10.0.1.0/31", "fd00::/64"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pool.Size()).To(BeEquivalentTo(math.MaxInt32))
+	g.Expect(pool.Contains("10.0.0.1")).To(BeTrue())
+	g.Expect(pool.Contains("10.0.1.0")).To(BeTrue())
+	g.Expect(pool.Contains("fd00::")).To(BeFalse())
+	g.Expect(pool.Contains("fd00::ffff:ffff:ffff:ffff")).To(BeTrue())
+
+	last, err := NewPool([]string{"255.255.255.255"})
+	g.Expect(err).NotTo(HaveOccurred())
+	_, ok := last.Allocate(map[string]bool{"255.255.255.255": true})
+	g.Expect(ok).To(BeFalse())
+
+	for _, invalid := range []string{"10.0.0.300", "10.0.0.2-10.0.0.1", "10.0.0.1-fd00::1", "10.0.0.0/33"} {
+		_, err := NewPool([]string{invalid})
+		g.Expect(err).To(HaveOccurred(), invalid)
+	}
+}
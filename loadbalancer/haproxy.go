@@ -0,0 +1,118 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loadbalancer renders the HAProxy configuration of the load balancer of the control plane,
+// and the bootstrap data delivering it to the metal node running HAProxy. It also renders the keepalived
+// static pod holding the virtual IP of the control plane on the control plane machines.
+package loadbalancer
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ConfigPath is where the bootstrap data writes the HAProxy configuration on the metal node
+const ConfigPath = "/etc/haproxy/haproxy.cfg"
+
+// Backend is a control plane machine HAProxy balances to
+type Backend struct {
+	// Name identifies the backend server, e.g. the name of the metal node
+	Name string
+	// Address is the address the API server of the machine listens on
+	Address string
+	// Port is the port the API server of the machine listens on
+	Port int32
+}
+
+const configTemplate = `# generated by cluster-api-provider-demo, do not edit
+global
+  log /dev/log local0
+  log /dev/log local1 notice
+  daemon
+
+defaults
+  log global
+  mode tcp
+  option dontlognull
+  timeout connect 5s
+  timeout client 50s
+  timeout server 50s
+
+frontend control-plane
+  bind :::{{ .Port }} v4v6
+  default_backend kube-apiservers
+
+backend kube-apiservers
+  option httpchk GET /healthz
+  http-check expect status 200
+{{- range .Backends }}
+  server {{ .Name }} {{ .Address }}:{{ .Port }} check check-ssl verify none
+{{- end }}
+`
+
+const bootstrapTemplate = `#cloud-config
+write_files:
+- path: {{ .Path }}
+  owner: root:root
+  permissions: '0644'
+  content: |
+{{ .Content }}
+runcmd:
+- systemctl enable haproxy
+- systemctl restart haproxy
+`
+
+var (
+	configTmpl    = template.Must(template.New("haproxy").Parse(configTemplate))
+	bootstrapTmpl = template.Must(template.New("bootstrap").Parse(bootstrapTemplate))
+)
+
+// Config returns the HAProxy configuration balancing the port to the backends,
+// the backends are sorted so that the configuration only changes when the backends do
+func Config(port int32, backends []Backend) (string, error) {
+	sorted := append([]Backend(nil), backends...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var buf bytes.Buffer
+	if err := configTmpl.Execute(&buf, struct {
+		Port     int32
+		Backends []Backend
+	}{port, sorted}); err != nil {
+		return "", errors.Wrap(err, "failed to render the HAProxy configuration")
+	}
+	return buf.String(), nil
+}
+
+// BootstrapData returns the cloud-config writing the HAProxy configuration and restarting HAProxy
+func BootstrapData(config string) ([]byte, error) {
+	lines := strings.Split(strings.TrimSuffix(config, "\n"), "\n")
+	for i := range lines {
+		lines[i] = "    " + lines[i]
+	}
+
+	var buf bytes.Buffer
+	if err := bootstrapTmpl.Execute(&buf, struct {
+		Path    string
+		Content string
+	}{ConfigPath, strings.Join(lines, "\n")}); err != nil {
+		return nil, errors.Wrap(err, "failed to render the load balancer bootstrap data")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+func TestConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	config, err := Config(6443, []Backend{
+		{Name: "metalnode-b", Address: "10.0.0.2", Port: 6443},
+		{Name: "metalnode-a", Address: "10.0.0.1", Port: 6443},
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(config).To(ContainSubstring("bind :::6443 v4v6\n"))
+	g.Expect(config).To(ContainSubstring(
+		"  server metalnode-a 10.0.0.1:6443 check check-ssl verify none\n" +
+			"  server metalnode-b 10.0.0.2:6443 check check-ssl verify none\n"))
+
+	empty, err := Config(8443, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(empty).To(ContainSubstring("bind :::8443 v4v6\n"))
+	g.Expect(empty).NotTo(ContainSubstring("check-ssl"))
+}
+
+func TestBootstrapData(t *testing.T) {
+	g := NewWithT(t)
+
+	config, err := Config(6443, []Backend{{Name: "metalnode-a", Address: "10.0.0.1", Port: 6443}})
+	g.Expect(err).NotTo(HaveOccurred())
+	data, err := BootstrapData(config)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(data)).To(HavePrefix("#cloud-config\n"))
+
+	// the configuration is written verbatim by cloud-init
+	cloudConfig := struct {
+		WriteFiles []struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+		} `json:"write_files"`
+		RunCmd []string `json:"runcmd"`
+	}{}
+	g.Expect(yaml.Unmarshal(data, &cloudConfig)).To(Succeed())
+	g.Expect(cloudConfig.WriteFiles).To(HaveLen(1))
+	g.Expect(cloudConfig.WriteFiles[0].Path).To(Equal(ConfigPath))
+	g.Expect(cloudConfig.WriteFiles[0].Content).To(Equal(config))
+	g.Expect(cloudConfig.RunCmd).To(ContainElement("systemctl restart haproxy"))
+}
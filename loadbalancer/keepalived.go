@@ -0,0 +1,174 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// paths of the keepalived files on the control plane machines
+const (
+	KeepalivedConfigPath      = "/etc/keepalived/keepalived.conf"
+	KeepalivedCheckScriptPath = "/etc/keepalived/check_apiserver.sh"
+	KeepalivedManifestPath    = "/etc/kubernetes/manifests/keepalived.yaml"
+)
+
+// KeepalivedImage runs keepalived in the static pod of the control plane machines
+const KeepalivedImage = "osixia/keepalived:2.0.17"
+
+// VirtualIP is the virtual IP the control plane machines elect a holder of with VRRP
+type VirtualIP struct {
+	// Address is the virtual IP
+	Address string
+	// Interface is the network interface holding the virtual IP
+	Interface string
+	// RouterID is the VRRP virtual router id, unique in the network
+	RouterID int32
+	// AuthPass authenticates the VRRP advertisements, at most 8 characters
+	AuthPass string
+	// Port is the port the API server listens on
+	Port int32
+}
+
+// File is a file written on the control plane machines before kubeadm runs
+type File struct {
+	Path        string
+	Permissions string
+	Content     string
+}
+
+// every machine starts as a backup with the same priority, the VRRP election picks the holder of the virtual IP
+// and moves it away from a machine failing the API server check
+const keepalivedConfigTemplate = `# generated by cluster-api-provider-demo, do not edit
+global_defs {
+  router_id LVS_DEVEL
+  enable_script_security
+  script_user root
+}
+
+vrrp_script check_apiserver {
+  script "{{ .CheckScriptPath }}"
+  interval 3
+  weight -2
+  fall 10
+  rise 2
+}
+
+vrrp_instance VI_1 {
+  state BACKUP
+  interface {{ .Interface }}
+  virtual_router_id {{ .RouterID }}
+  priority 100
+  authentication {
+    auth_type PASS
+    auth_pass {{ .AuthPass }}
+  }
+  virtual_ipaddress {
+    {{ .Address }}
+  }
+  track_script {
+    check_apiserver
+  }
+}
+`
+
+const keepalivedCheckScriptTemplate = `#!/bin/sh
+# generated by cluster-api-provider-demo, do not edit
+errorExit() {
+  echo "*** $*" 1>&2
+  exit 1
+}
+
+curl --silent --max-time 2 --insecure https://localhost:{{ .Port }}/ -o /dev/null || errorExit "Error GET https://localhost:{{ .Port }}/"
+if ip addr | grep -q {{ .Address }}; then
+  curl --silent --max-time 2 --insecure https://{{ .Address }}:{{ .Port }}/ -o /dev/null || errorExit "Error GET https://{{ .Address }}:{{ .Port }}/"
+fi
+`
+
+const keepalivedManifestTemplate = `apiVersion: v1
+kind: Pod
+metadata:
+  name: keepalived
+  namespace: kube-system
+spec:
+  containers:
+  - name: keepalived
+    image: {{ .Image }}
+    securityContext:
+      capabilities:
+        add:
+        - NET_ADMIN
+        - NET_BROADCAST
+        - NET_RAW
+    volumeMounts:
+    - mountPath: /usr/local/etc/keepalived/keepalived.conf
+      name: config
+    - mountPath: {{ .CheckScriptPath }}
+      name: check
+  hostNetwork: true
+  volumes:
+  - name: config
+    hostPath:
+      path: {{ .ConfigPath }}
+  - name: check
+    hostPath:
+      path: {{ .CheckScriptPath }}
+`
+
+var (
+	keepalivedConfigTmpl      = template.Must(template.New("keepalived").Parse(keepalivedConfigTemplate))
+	keepalivedCheckScriptTmpl = template.Must(template.New("check").Parse(keepalivedCheckScriptTemplate))
+	keepalivedManifestTmpl    = template.Must(template.New("manifest").Parse(keepalivedManifestTemplate))
+)
+
+// KeepalivedConfig returns the keepalived configuration holding the virtual IP, it carries the VRRP password
+func KeepalivedConfig(vip VirtualIP) (string, error) {
+	var buf bytes.Buffer
+	if err := keepalivedConfigTmpl.Execute(&buf, struct {
+		VirtualIP
+		CheckScriptPath string
+	}{vip, KeepalivedCheckScriptPath}); err != nil {
+		return "", errors.Wrap(err, "failed to render the keepalived configuration")
+	}
+	return buf.String(), nil
+}
+
+// KeepalivedFiles returns the keepalived static pod and the API server check it runs,
+// the keepalived configuration is written aside from them since it holds the VRRP password
+func KeepalivedFiles(vip VirtualIP) ([]File, error) {
+	var script bytes.Buffer
+	if err := keepalivedCheckScriptTmpl.Execute(&script, vip); err != nil {
+		return nil, errors.Wrap(err, "failed to render the keepalived check script")
+	}
+
+	var manifest bytes.Buffer
+	if err := keepalivedManifestTmpl.Execute(&manifest, struct {
+		Image           string
+		ConfigPath      string
+		CheckScriptPath string
+	}{KeepalivedImage, KeepalivedConfigPath, KeepalivedCheckScriptPath}); err != nil {
+		return nil, errors.Wrap(err, "failed to render the keepalived manifest")
+	}
+
+	return []File{
+		{Path: KeepalivedCheckScriptPath, Permissions: "0755", Content: script.String()},
+		{Path: KeepalivedManifestPath, Permissions: "0644", Content: manifest.String()},
+	}, nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadbalancer
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var testVirtualIP = VirtualIP{
+	Address:   "10.0.0.100",
+	Interface: "eth0",
+	RouterID:  51,
+	AuthPass:  "s3cr3t",
+	Port:      6443,
+}
+
+func TestKeepalivedConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	config, err := KeepalivedConfig(testVirtualIP)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(config).To(ContainSubstring("  interface eth0\n"))
+	g.Expect(config).To(ContainSubstring("  virtual_router_id 51\n"))
+	g.Expect(config).To(ContainSubstring("    auth_pass s3cr3t\n"))
+	g.Expect(config).To(ContainSubstring("  virtual_ipaddress {\n    10.0.0.100\n  }\n"))
+	g.Expect(config).To(ContainSubstring(`script "/etc/keepalived/check_apiserver.sh"`))
+}
+
+func TestKeepalivedFiles(t *testing.T) {
+	g := NewWithT(t)
+
+	files, err := KeepalivedFiles(testVirtualIP)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(files).To(HaveLen(2))
+
+	script := files[0]
+	g.Expect(script.Path).To(Equal(KeepalivedCheckScriptPath))
+	g.Expect(script.Permissions).To(Equal("0755"))
+	g.Expect(script.Content).To(HavePrefix("#!/bin/sh\n"))
+	g.Expect(script.Content).To(ContainSubstring("https://localhost:6443/"))
+	g.Expect(script.Content).To(ContainSubstring("grep -q 10.0.0.100;"))
+	g.Expect(script.Content).To(ContainSubstring("https://10.0.0.100:6443/"))
+	g.Expect(script.Content).NotTo(ContainSubstring("s3cr3t"))
+
+	// the manifest is a static pod the kubelet started by kubeadm runs
+	manifest := files[1]
+	g.Expect(manifest.Path).To(Equal(KeepalivedManifestPath))
+	g.Expect(manifest.Content).NotTo(ContainSubstring("s3cr3t"))
+	pod := &corev1.Pod{}
+	g.Expect(yaml.UnmarshalStrict([]byte(manifest.Content), pod)).To(Succeed())
+	g.Expect(pod.Name).To(Equal("keepalived"))
+	g.Expect(pod.Namespace).To(Equal("kube-system"))
+	g.Expect(pod.Spec.HostNetwork).To(BeTrue())
+	g.Expect(pod.Spec.Containers).To(HaveLen(1))
+	g.Expect(pod.Spec.Containers[0].Image).To(Equal(KeepalivedImage))
+	g.Expect(pod.Spec.Containers[0].SecurityContext.Capabilities.Add).To(ContainElement(corev1.Capability("NET_ADMIN")))
+	g.Expect(pod.Spec.Volumes).To(HaveLen(2))
+	g.Expect(pod.Spec.Volumes[0].HostPath.Path).To(Equal(KeepalivedConfigPath))
+	g.Expect(pod.Spec.Volumes[1].HostPath.Path).To(Equal(KeepalivedCheckScriptPath))
+}
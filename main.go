@@ -18,22 +18,32 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	"github.com/spf13/pflag"
+	"golang.org/x/time/rate"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
 	"os"
+	"strings"
+	"time"
+
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	clusterexpv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	infrastructurev1beta1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
 	"github.com/git-czy/cluster-api-provider-demo/controllers"
+	"github.com/git-czy/cluster-api-provider-demo/feature"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -55,46 +65,125 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var watchNamespaces string
+	var watchFilterValue string
+	var demoClusterConcurrency, demoMachineConcurrency int
+	var demoClusterRateLimiter, demoMachineRateLimiter rateLimiterOptions
+	var endpointProbeInterval, reprovisioningTimeout, bootstrapTimeout time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "namespaces", "",
+		"Comma-separated list of namespaces that the controller watches for cluster-api objects and metal nodes. "+
+			"If unspecified, the controller watches all namespaces.")
+	flag.StringVar(&watchFilterValue, "watch-filter", "",
+		fmt.Sprintf("Label value that the controller watches to reconcile cluster-api objects and metal nodes. "+
+			"Label key is always %s. If unspecified, the controller watches for all objects.", clusterv1.WatchLabel))
+	flag.IntVar(&demoClusterConcurrency, "democluster-concurrency", 1,
+		"Number of DemoClusters to process simultaneously.")
+	flag.IntVar(&demoMachineConcurrency, "demomachine-concurrency", 10,
+		"Number of DemoMachines to process simultaneously.")
+	flag.DurationVar(&endpointProbeInterval, "endpoint-probe-interval", controllers.DefaultEndpointProbeInterval,
+		"Interval at which the control plane endpoints of the ready DemoClusters are probed.")
+	flag.DurationVar(&reprovisioningTimeout, "reprovisioning-timeout", controllers.DefaultReprovisioningTimeout,
+		"Time a released metal node is given to be cleaned, and then to be reinitialized: the ones released by a DemoMachine "+
+			"when the MetalNodeReprovisioning feature gate is enabled, and the etcd and load balancer ones of a deleted DemoCluster.")
+	flag.DurationVar(&bootstrapTimeout, "bootstrap-timeout", controllers.DefaultBootstrapTimeout,
+		"Time a metal node with a BMC is given to run the bootstrap data of a DemoMachine before it is power-cycled.")
+	demoClusterRateLimiter.bindFlags(flag.CommandLine, "democluster")
+	demoMachineRateLimiter.bindFlags(flag.CommandLine, "demomachine")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
+	feature.MutableGates.AddFlag(pflag.CommandLine)
+	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+	pflag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "32e4cfc2.cluster.x-k8s.io",
-	})
+	}
+
+	// restrict the manager cache to the given namespaces, by default it watches all namespaces
+	namespaces := parseNamespaces(watchNamespaces)
+	switch len(namespaces) {
+	case 0:
+	case 1:
+		setupLog.Info("watching objects only in namespace for reconciliation", "namespace", namespaces[0])
+		options.Namespace = namespaces[0]
+	default:
+		setupLog.Info("watching objects only in namespaces for reconciliation", "namespaces", namespaces)
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.DemoClusterReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		WatchFilterValue:      watchFilterValue,
+		EndpointProbeInterval: endpointProbeInterval,
+	}).SetupWithManager(mgr, controller.Options{
+		MaxConcurrentReconciles: demoClusterConcurrency,
+		RateLimiter:             demoClusterRateLimiter.rateLimiter(),
+	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DemoCluster")
 		os.Exit(1)
 	}
 	if err = (&controllers.DemoMachineReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		WatchFilterValue:      watchFilterValue,
+		ReprovisioningTimeout: reprovisioningTimeout,
+		BootstrapTimeout:      bootstrapTimeout,
+		Recorder:              mgr.GetEventRecorderFor("demomachine-controller"),
+		APIReader:             mgr.GetAPIReader(),
+	}).SetupWithManager(mgr, controller.Options{
+		MaxConcurrentReconciles: demoMachineConcurrency,
+		RateLimiter:             demoMachineRateLimiter.rateLimiter(),
+	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DemoMachine")
 		os.Exit(1)
 	}
+	if err = (&controllers.DemoRemediationReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		WatchFilterValue: watchFilterValue,
+	}).SetupWithManager(mgr, controller.Options{}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DemoRemediation")
+		os.Exit(1)
+	}
+	if err = (&controllers.MetalNodeQuotaReconciler{
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		WatchFilterValue: watchFilterValue,
+	}).SetupWithManager(mgr, controller.Options{}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MetalNodeQuota")
+		os.Exit(1)
+	}
+	if feature.Gates.Enabled(feature.IPAM) {
+		if err = (&controllers.DemoIPPoolReconciler{
+			Client:           mgr.GetClient(),
+			Scheme:           mgr.GetScheme(),
+			WatchFilterValue: watchFilterValue,
+		}).SetupWithManager(mgr, controller.Options{}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "DemoIPPool")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -112,3 +201,43 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// parseNamespaces splits the comma-separated namespaces flag value, ignoring empty entries
+func parseNamespaces(value string) []string {
+	var namespaces []string
+	for _, ns := range strings.Split(value, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// rateLimiterOptions tunes the rate limiter of a controller work queue,
+// the defaults are the ones of workqueue.DefaultControllerRateLimiter
+type rateLimiterOptions struct {
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	qps       float64
+	burst     int
+}
+
+// bindFlags binds the rate limiter flags of the given controller
+func (o *rateLimiterOptions) bindFlags(fs *flag.FlagSet, controllerName string) {
+	fs.DurationVar(&o.baseDelay, controllerName+"-rate-limiter-base-delay", 5*time.Millisecond,
+		fmt.Sprintf("Base delay of the per item exponential backoff of the %s controller.", controllerName))
+	fs.DurationVar(&o.maxDelay, controllerName+"-rate-limiter-max-delay", 1000*time.Second,
+		fmt.Sprintf("Max delay of the per item exponential backoff of the %s controller.", controllerName))
+	fs.Float64Var(&o.qps, controllerName+"-rate-limiter-qps", 10,
+		fmt.Sprintf("Overall requeue rate per second of the %s controller.", controllerName))
+	fs.IntVar(&o.burst, controllerName+"-rate-limiter-burst", 100,
+		fmt.Sprintf("Overall requeue bucket size of the %s controller.", controllerName))
+}
+
+// rateLimiter returns the per item exponential backoff combined with the overall bucket rate limiter
+func (o *rateLimiterOptions) rateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(o.baseDelay, o.maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(o.qps), o.burst)},
+	)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metalnode persists and releases the metal nodes claimed by the provider. It is shared by the controllers and
+// the kubectl plugin, it only writes the metal nodes: the power of the released metal nodes is left to the controllers.
+package metalnode
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// Update persists the metadata and the status of a metal node.
+// The status of a claimed metal node is mirrored in an annotation, so that it survives a clusterctl move,
+// and the metal node is labeled to be moved by clusterctl.
+func Update(ctx context.Context, c client.Client, metalNode *metav1beta1.MetalNode) error {
+	annotations := metalNode.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	labels := metalNode.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if metalNode.GetRefCluster() != "" {
+		status, err := json.Marshal(metalNode.Status)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal the metal node status")
+		}
+		annotations[infrav1.MetalNodeStatusAnnotation] = string(status)
+		labels[infrav1.ClusterctlMoveLabelName] = ""
+	} else {
+		delete(annotations, infrav1.MetalNodeStatusAnnotation)
+		delete(annotations, infrav1.MetalNodeStaticAddressAnnotation)
+		delete(annotations, infrav1.MetalNodeDemoMachineAnnotation)
+		delete(annotations, infrav1.MetalNodeRerunBootstrapAnnotation)
+		delete(labels, infrav1.ClusterctlMoveLabelName)
+	}
+	metalNode.SetAnnotations(annotations)
+	metalNode.SetLabels(labels)
+
+	// the update returns the stored status, keep the one to write
+	status := metalNode.Status.DeepCopy()
+	if err := c.Update(ctx, metalNode); err != nil {
+		return err
+	}
+	metalNode.Status = *status
+	return c.Status().Update(ctx, metalNode)
+}
+
+// Release hands a metal node back to the pool and drops the owner references of what claimed it. A reprovisioned metal
+// node is cleaned and reinitialized before it is claimed again, the others are reset and asked to be powered off until
+// claimed again.
+func Release(ctx context.Context, c client.Client, metalNode *metav1beta1.MetalNode, reprovision bool, owners ...metav1.OwnerReference) error {
+	if reprovision {
+		if err := StartReprovisioning(metalNode); err != nil {
+			return err
+		}
+	} else {
+		metalNode.ResetMetalNode()
+		// the cleanup of a reprovisioned metal node runs on the host, the others are powered off until claimed again
+		RequestPowerOff(metalNode)
+	}
+	for _, owner := range owners {
+		metalNode.SetOwnerReferences(util.RemoveOwnerRef(metalNode.GetOwnerReferences(), owner))
+	}
+	return Update(ctx, c, metalNode)
+}
+
+// RequestPowerOff asks the controllers to power off a released metal node, it does nothing for the metal nodes without a BMC
+func RequestPowerOff(metalNode *metav1beta1.MetalNode) {
+	annotations := metalNode.GetAnnotations()
+	if _, ok := annotations[infrav1.MetalNodeBMCAnnotation]; !ok {
+		return
+	}
+	annotations[infrav1.MetalNodePowerOffAnnotation] = ""
+	metalNode.SetAnnotations(annotations)
+}
+
+// PowerOffRequested tells whether a released metal node is yet to be powered off
+func PowerOffRequested(metalNode *metav1beta1.MetalNode) bool {
+	_, ok := metalNode.GetAnnotations()[infrav1.MetalNodePowerOffAnnotation]
+	return ok
+}
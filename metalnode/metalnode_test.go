@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metalnode
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// newClaimedMetalNode returns a ready metal node claimed by the demo machine demo-0 of cluster demo
+func newClaimedMetalNode(bootstrapped bool) *metav1beta1.MetalNode {
+	return &metav1beta1.MetalNode{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "node",
+			Namespace:       "default",
+			Annotations:     map[string]string{infrav1.MetalNodeStatusAnnotation: "{}", infrav1.MetalNodeDemoMachineAnnotation: "demo-0"},
+			Labels:          map[string]string{infrav1.ClusterctlMoveLabelName: ""},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: infrav1.GroupVersion.String(), Kind: "DemoMachine", Name: "demo-0"}},
+		},
+		Status: metav1beta1.MetalNodeStatus{Ready: true, RefCluster: "demo", Bootstrapped: bootstrapped},
+	}
+}
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	_ = metav1beta1.AddToScheme(scheme)
+
+	t.Run("reprovisions a bootstrapped metal node", func(t *testing.T) {
+		g := NewWithT(t)
+		metalNode := newClaimedMetalNode(true)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(metalNode).Build()
+
+		g.Expect(Release(ctx, c, metalNode, true, metalNode.GetOwnerReferences()...)).To(Succeed())
+		g.Expect(c.Get(ctx, client.ObjectKeyFromObject(metalNode), metalNode)).To(Succeed())
+		reprovisioning, err := GetReprovisioning(metalNode)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(reprovisioning.State).To(Equal(infrav1.CleaningState))
+		g.Expect(PowerOffRequested(metalNode)).To(BeFalse())
+		g.Expect(metalNode.Status).To(Equal(metav1beta1.MetalNodeStatus{Ready: true}))
+		g.Expect(metalNode.GetOwnerReferences()).To(BeEmpty())
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeStatusAnnotation))
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeDemoMachineAnnotation))
+		g.Expect(metalNode.GetLabels()).NotTo(HaveKey(infrav1.ClusterctlMoveLabelName))
+	})
+
+	t.Run("asks to power off a metal node with a BMC", func(t *testing.T) {
+		g := NewWithT(t)
+		metalNode := newClaimedMetalNode(false)
+		metalNode.Annotations[infrav1.MetalNodeBMCAnnotation] = `{"address":"redfish://10.0.1.1","credentialsName":"bmc"}`
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(metalNode).Build()
+
+		g.Expect(Release(ctx, c, metalNode, false, metalNode.GetOwnerReferences()...)).To(Succeed())
+		g.Expect(c.Get(ctx, client.ObjectKeyFromObject(metalNode), metalNode)).To(Succeed())
+		g.Expect(PowerOffRequested(metalNode)).To(BeTrue())
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodeReprovisioningAnnotation))
+		g.Expect(metalNode.GetAnnotations()).NotTo(HaveKey(infrav1.MetalNodePoweredOffAnnotation))
+		g.Expect(metalNode.Status).To(Equal(metav1beta1.MetalNodeStatus{Ready: true}))
+	})
+
+	t.Run("leaves the power of a metal node without a BMC alone", func(t *testing.T) {
+		g := NewWithT(t)
+		metalNode := newClaimedMetalNode(false)
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(metalNode).Build()
+
+		g.Expect(Release(ctx, c, metalNode, false)).To(Succeed())
+		g.Expect(c.Get(ctx, client.ObjectKeyFromObject(metalNode), metalNode)).To(Succeed())
+		g.Expect(PowerOffRequested(metalNode)).To(BeFalse())
+		g.Expect(metalNode.GetOwnerReferences()).To(HaveLen(1))
+	})
+}
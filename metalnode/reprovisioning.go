@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metalnode
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/constants"
+)
+
+// StartReprovisioning releases a metal node into the Cleaning state, or straight into the Reinitializing one
+// when it is no longer ready to run the cleanup bootstrap data
+func StartReprovisioning(metalNode *metav1beta1.MetalNode) error {
+	reprovisioning, err := GetReprovisioning(metalNode)
+	if err != nil {
+		return err
+	}
+	if reprovisioning == nil {
+		reprovisioning = &infrav1.MetalNodeReprovisioning{}
+	}
+	reprovisioning.Conditions = nil
+
+	if metalNode.IsReady() {
+		metalNode.ResetMetalNode()
+		SetReprovisioningState(reprovisioning, infrav1.CleaningState)
+		SetReprovisioningCondition(reprovisioning, conditions.FalseCondition(constants.MetalNodeCleanedCondition, constants.WaitingForMetalNodeCleanupReason, clusterv1.ConditionSeverityInfo, ""))
+	} else {
+		metalNode.ResetMetalNode()
+		RequestReinitialization(metalNode)
+		SetReprovisioningState(reprovisioning, infrav1.ReinitializingState)
+		SetReprovisioningCondition(reprovisioning, conditions.FalseCondition(constants.MetalNodeReinitializedCondition, constants.WaitingForMetalNodeReadyReason, clusterv1.ConditionSeverityInfo, ""))
+	}
+	return SetReprovisioning(metalNode, reprovisioning)
+}
+
+// RequestReinitialization asks the metal node controller to initialize a metal node again
+func RequestReinitialization(metalNode *metav1beta1.MetalNode) {
+	annotations := metalNode.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[infrav1.MetalNodeReinitializeAnnotation] = ""
+	metalNode.SetAnnotations(annotations)
+}
+
+// ReinitializationRequested returns true until the metal node controller initialized a metal node again
+func ReinitializationRequested(metalNode *metav1beta1.MetalNode) bool {
+	_, ok := metalNode.GetAnnotations()[infrav1.MetalNodeReinitializeAnnotation]
+	return ok
+}
+
+// GetReprovisioning returns the reprovisioning of a metal node, nil if it never was
+func GetReprovisioning(metalNode *metav1beta1.MetalNode) (*infrav1.MetalNodeReprovisioning, error) {
+	data, ok := metalNode.GetAnnotations()[infrav1.MetalNodeReprovisioningAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	reprovisioning := &infrav1.MetalNodeReprovisioning{}
+	if err := json.Unmarshal([]byte(data), reprovisioning); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the reprovisioning of metal node %s", metalNode.Name)
+	}
+	return reprovisioning, nil
+}
+
+// SetReprovisioning records the reprovisioning of a metal node in its annotation
+func SetReprovisioning(metalNode *metav1beta1.MetalNode, reprovisioning *infrav1.MetalNodeReprovisioning) error {
+	data, err := json.Marshal(reprovisioning)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the reprovisioning")
+	}
+	annotations := metalNode.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[infrav1.MetalNodeReprovisioningAnnotation] = string(data)
+	metalNode.SetAnnotations(annotations)
+	return nil
+}
+
+// SetReprovisioningState moves a reprovisioning to a state
+func SetReprovisioningState(reprovisioning *infrav1.MetalNodeReprovisioning, state infrav1.ReprovisioningState) {
+	reprovisioning.State = state
+	reprovisioning.LastTransitionTime = metav1.Now()
+}
+
+// SetReprovisioningCondition sets a condition of a reprovisioning, replacing the one of the same type
+func SetReprovisioningCondition(reprovisioning *infrav1.MetalNodeReprovisioning, condition *clusterv1.Condition) {
+	condition.LastTransitionTime = metav1.Now()
+	for i := range reprovisioning.Conditions {
+		if reprovisioning.Conditions[i].Type == condition.Type {
+			reprovisioning.Conditions[i] = *condition
+			return
+		}
+	}
+	reprovisioning.Conditions = append(reprovisioning.Conditions, *condition)
+}
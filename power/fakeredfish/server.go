@@ -0,0 +1,212 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeredfish is an in-process Redfish BMC, so that the power actions can be tested offline.
+package fakeredfish
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// SystemPath is the path of the single system of the fake BMC
+const SystemPath = "/redfish/v1/Systems/1"
+
+// Server is a fake Redfish BMC managing a single computer system, powered on when the server starts
+type Server struct {
+	*httptest.Server
+
+	username string
+	password string
+
+	mu          sync.Mutex
+	powerState  string
+	bootTarget  string
+	bootEnabled string
+	resets      []string
+}
+
+// NewServer starts a fake Redfish BMC accepting the given credentials, it must be closed once done with
+func NewServer(username, password string) *Server {
+	s := &Server{
+		username:    username,
+		password:    password,
+		powerState:  "On",
+		bootTarget:  "None",
+		bootEnabled: "Disabled",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redfish/v1/Systems", s.handleSystems)
+	mux.HandleFunc(SystemPath, s.handleSystem)
+	mux.HandleFunc(SystemPath+"/Actions/ComputerSystem.Reset", s.handleReset)
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+// Address returns the BMC address of the system, as set on a metal node
+func (s *Server) Address() string {
+	return "redfish+http://" + strings.TrimPrefix(s.URL, "http://") + SystemPath
+}
+
+// PowerState returns the power state of the system, On or Off
+func (s *Server) PowerState() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.powerState
+}
+
+// SetPowerState sets the power state of the system, On or Off
+func (s *Server) SetPowerState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.powerState = state
+}
+
+// Resets returns the reset types the system was asked for, in order
+func (s *Server) Resets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.resets...)
+}
+
+// BootSourceOverride returns the boot source override target of the system and how it is enabled
+func (s *Server) BootSourceOverride() (target, enabled string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bootTarget, s.bootEnabled
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != s.username || password != s.password {
+			writeError(w, http.StatusUnauthorized, "Base.1.0.InsufficientPrivilege", "invalid credentials")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleSystems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Base.1.0.ActionNotSupported", r.Method)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"@odata.id":           "/redfish/v1/Systems",
+		"Members@odata.count": 1,
+		"Members":             []map[string]string{{"@odata.id": SystemPath}},
+	})
+}
+
+func (s *Server) handleSystem(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		system := map[string]interface{}{
+			"@odata.id":  SystemPath,
+			"Id":         "1",
+			"PowerState": s.powerState,
+			"Boot": map[string]string{
+				"BootSourceOverrideTarget":  s.bootTarget,
+				"BootSourceOverrideEnabled": s.bootEnabled,
+			},
+			"Actions": map[string]interface{}{
+				"#ComputerSystem.Reset": map[string]interface{}{
+					"target":                            SystemPath + "/Actions/ComputerSystem.Reset",
+					"ResetType@Redfish.AllowableValues": []string{"On", "ForceOff", "GracefulShutdown", "ForceRestart", "GracefulRestart"},
+				},
+			},
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, system)
+
+	case http.MethodPatch:
+		body := struct {
+			Boot struct {
+				BootSourceOverrideTarget  string
+				BootSourceOverrideEnabled string
+			}
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Base.1.0.MalformedJSON", err.Error())
+			return
+		}
+		s.mu.Lock()
+		if body.Boot.BootSourceOverrideTarget != "" {
+			s.bootTarget = body.Boot.BootSourceOverrideTarget
+		}
+		if body.Boot.BootSourceOverrideEnabled != "" {
+			s.bootEnabled = body.Boot.BootSourceOverrideEnabled
+		}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Base.1.0.ActionNotSupported", r.Method)
+	}
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Base.1.0.ActionNotSupported", r.Method)
+		return
+	}
+	body := struct{ ResetType string }{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Base.1.0.MalformedJSON", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch body.ResetType {
+	case "On":
+		s.powerState = "On"
+	case "ForceOff", "GracefulShutdown":
+		s.powerState = "Off"
+	case "ForceRestart", "GracefulRestart":
+		if s.powerState != "On" {
+			writeError(w, http.StatusConflict, "Base.1.0.ActionNotSupported", "the system is powered off")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "Base.1.0.ActionParameterValueNotInList", fmt.Sprintf("unsupported ResetType %q", body.ResetType))
+		return
+	}
+	s.resets = append(s.resets, body.ResetType)
+	// the one time boot source override is consumed by the boot
+	if s.powerState == "On" && s.bootEnabled == "Once" {
+		s.bootTarget, s.bootEnabled = "None", "Disabled"
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package power
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultIPMIPort is the port of IPMI over LAN
+const defaultIPMIPort = "623"
+
+// ipmiBootDevices maps the boot devices to the ipmitool boot devices
+var ipmiBootDevices = map[BootDevice]string{
+	DiskBootDevice:  "disk",
+	PXEBootDevice:   "pxe",
+	CDROMBootDevice: "cdrom",
+}
+
+// commandRunner runs a command with extra environment variables, returning its combined output
+type commandRunner func(ctx context.Context, env []string, name string, args ...string) ([]byte, error)
+
+// ipmi manages a host through ipmitool over the lanplus interface, which the manager image ships
+type ipmi struct {
+	host        string
+	port        string
+	credentials Credentials
+	run         commandRunner
+}
+
+func newIPMI(u *url.URL, credentials Credentials) *ipmi {
+	port := u.Port()
+	if port == "" {
+		port = defaultIPMIPort
+	}
+	return &ipmi{
+		host:        u.Hostname(),
+		port:        port,
+		credentials: credentials,
+		run:         runCommand,
+	}
+}
+
+func (i *ipmi) PowerOn(ctx context.Context) error {
+	state, err := i.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if state == On {
+		return nil
+	}
+	_, err = i.ipmitool(ctx, "chassis", "power", "on")
+	return err
+}
+
+func (i *ipmi) PowerOff(ctx context.Context) error {
+	state, err := i.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if state == Off {
+		return nil
+	}
+	_, err = i.ipmitool(ctx, "chassis", "power", "off")
+	return err
+}
+
+func (i *ipmi) Reboot(ctx context.Context) error {
+	state, err := i.Status(ctx)
+	if err != nil {
+		return err
+	}
+	// a power cycle is refused by the powered off hosts
+	if state == Off {
+		_, err = i.ipmitool(ctx, "chassis", "power", "on")
+		return err
+	}
+	_, err = i.ipmitool(ctx, "chassis", "power", "cycle")
+	return err
+}
+
+func (i *ipmi) Status(ctx context.Context) (State, error) {
+	out, err := i.ipmitool(ctx, "chassis", "power", "status")
+	if err != nil {
+		return Unknown, err
+	}
+	// Chassis Power is on
+	switch {
+	case strings.HasSuffix(strings.TrimSpace(string(out)), " on"):
+		return On, nil
+	case strings.HasSuffix(strings.TrimSpace(string(out)), " off"):
+		return Off, nil
+	default:
+		return Unknown, nil
+	}
+}
+
+func (i *ipmi) SetBootDevice(ctx context.Context, device BootDevice, persistent bool) error {
+	name, ok := ipmiBootDevices[device]
+	if !ok {
+		return errors.Errorf("unsupported boot device %q", device)
+	}
+	args := []string{"chassis", "bootdev", name}
+	if persistent {
+		args = append(args, "options=persistent")
+	}
+	_, err := i.ipmitool(ctx, args...)
+	return err
+}
+
+// ipmitool runs an ipmitool command against the BMC, the password is handed through the environment
+// so that it does not show in the process list
+func (i *ipmi) ipmitool(ctx context.Context, args ...string) ([]byte, error) {
+	command := strings.Join(args, " ")
+	args = append([]string{"-I", "lanplus", "-H", i.host, "-p", i.port, "-U", i.credentials.Username, "-E"}, args...)
+	out, err := i.run(ctx, []string{"IPMI_PASSWORD=" + i.credentials.Password}, "ipmitool", args...)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("ipmitool is not installed, the IPMI BMCs are managed through it")
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "ipmitool %s against %s failed: %s", command, i.host, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+func runCommand(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.Bytes(), err
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package power
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// fakeIPMITool answers the ipmitool commands of a host in the given power state, recording them
+type fakeIPMITool struct {
+	state    string
+	env      []string
+	commands []string
+}
+
+func (f *fakeIPMITool) run(_ context.Context, env []string, name string, args ...string) ([]byte, error) {
+	f.env = env
+	if name != "ipmitool" || len(args) < 9 || args[0] != "-I" || args[1] != "lanplus" {
+		return []byte("invalid command"), errors.New("exit status 1")
+	}
+	command := strings.Join(args[9:], " ")
+	f.commands = append(f.commands, command)
+	switch command {
+	case "chassis power status":
+		return []byte("Chassis Power is " + f.state + "\n"), nil
+	case "chassis power on":
+		f.state = "on"
+	case "chassis power off":
+		f.state = "off"
+	case "chassis power cycle":
+		if f.state == "off" {
+			return []byte("Set Chassis Power Control to Cycle failed: Command not supported in present state"), errors.New("exit status 1")
+		}
+	}
+	return []byte("ok\n"), nil
+}
+
+func TestIPMI(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	pm, err := New("ipmi://10.0.0.10", Credentials{Username: "admin", Password: "password"}, false)
+	g.Expect(err).NotTo(HaveOccurred())
+	i := pm.(*ipmi)
+	g.Expect(i.host).To(Equal("10.0.0.10"))
+	g.Expect(i.port).To(Equal("623"))
+	tool := &fakeIPMITool{state: "on"}
+	i.run = tool.run
+
+	g.Expect(pm.Status(ctx)).To(Equal(On))
+	g.Expect(tool.env).To(Equal([]string{"IPMI_PASSWORD=password"}))
+	g.Expect(pm.PowerOff(ctx)).To(Succeed())
+	g.Expect(pm.Status(ctx)).To(Equal(Off))
+	g.Expect(pm.Reboot(ctx)).To(Succeed())
+	g.Expect(pm.Reboot(ctx)).To(Succeed())
+	g.Expect(pm.SetBootDevice(ctx, PXEBootDevice, true)).To(Succeed())
+	g.Expect(pm.SetBootDevice(ctx, CDROMBootDevice, false)).To(Succeed())
+	g.Expect(tool.commands).To(Equal([]string{
+		"chassis power status",
+		"chassis power status", "chassis power off",
+		"chassis power status",
+		"chassis power status", "chassis power on",
+		"chassis power status", "chassis power cycle",
+		"chassis bootdev pxe options=persistent",
+		"chassis bootdev cdrom",
+	}))
+}
+
+func TestIPMIError(t *testing.T) {
+	g := NewWithT(t)
+
+	pm, err := New("ipmi://10.0.0.10:6230", Credentials{Username: "admin", Password: "password"}, false)
+	g.Expect(err).NotTo(HaveOccurred())
+	i := pm.(*ipmi)
+	g.Expect(i.port).To(Equal("6230"))
+	i.run = func(context.Context, []string, string, ...string) ([]byte, error) {
+		return []byte("Error: Unable to establish IPMI v2 / RMCP+ session\n"), errors.New("exit status 1")
+	}
+
+	_, err = pm.Status(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("ipmitool chassis power status against 10.0.0.10 failed: Error: Unable to establish IPMI v2 / RMCP+ session")))
+
+	i.run = func(context.Context, []string, string, ...string) ([]byte, error) {
+		return nil, &exec.Error{Name: "ipmitool", Err: exec.ErrNotFound}
+	}
+	_, err = pm.Status(context.Background())
+	g.Expect(err).To(MatchError("ipmitool is not installed, the IPMI BMCs are managed through it"))
+}
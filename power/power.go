@@ -0,0 +1,108 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package power manages the power of the bare metal hosts out of band, through the Redfish or IPMI interface
+// of their baseboard management controller (BMC).
+package power
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// State is the power state of a host
+type State string
+
+const (
+	// On is the state of a powered on host
+	On State = "On"
+
+	// Off is the state of a powered off host
+	Off State = "Off"
+
+	// Unknown is the state of a host powering on or off, or reporting a state it is not known by
+	Unknown State = "Unknown"
+)
+
+// BootDevice is a device a host boots from
+type BootDevice string
+
+const (
+	// DiskBootDevice boots the host from its local disk
+	DiskBootDevice BootDevice = "Disk"
+
+	// PXEBootDevice boots the host from the network
+	PXEBootDevice BootDevice = "PXE"
+
+	// CDROMBootDevice boots the host from its CD or virtual media
+	CDROMBootDevice BootDevice = "CDROM"
+)
+
+// PowerManager manages the power of a host through its BMC
+type PowerManager interface {
+	// PowerOn powers the host on, it does nothing if the host is on
+	PowerOn(ctx context.Context) error
+
+	// PowerOff powers the host off without waiting for its operating system to shut down
+	PowerOff(ctx context.Context) error
+
+	// Reboot power-cycles the host, or powers it on if it is off
+	Reboot(ctx context.Context) error
+
+	// Status returns the power state of the host
+	Status(ctx context.Context) (State, error)
+
+	// SetBootDevice sets the device the host boots from, on the next boot only unless persistent
+	SetBootDevice(ctx context.Context, device BootDevice, persistent bool) error
+}
+
+// Credentials authenticate to a BMC
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// New returns the PowerManager of the BMC at address, whose scheme selects the interface:
+//
+//   - redfish://host[:port][/redfish/v1/Systems/<id>], redfish+https:// or redfish+http:// for Redfish,
+//     the first system of the BMC is managed when the address has no path
+//   - ipmi://host[:port] for IPMI over LAN, the port defaults to 623
+func New(address string, credentials Credentials, disableCertificateVerification bool) (PowerManager, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid BMC address %q", address)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid BMC address %q: no host", address)
+	}
+	if credentials.Username == "" {
+		return nil, errors.New("the BMC credentials have no username")
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "redfish", "redfish+https":
+		return newRedfish("https", u, credentials, disableCertificateVerification), nil
+	case "redfish+http":
+		return newRedfish("http", u, credentials, disableCertificateVerification), nil
+	case "ipmi":
+		return newIPMI(u, credentials), nil
+	default:
+		return nil, errors.Errorf("unsupported BMC address %q, the scheme must be one of redfish, redfish+https, redfish+http or ipmi", address)
+	}
+}
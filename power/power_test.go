@@ -0,0 +1,49 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package power
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNew(t *testing.T) {
+	g := NewWithT(t)
+	credentials := Credentials{Username: "admin", Password: "password"}
+
+	pm, err := New("redfish://10.0.0.10/redfish/v1/Systems/1/", credentials, true)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pm.(*redfish).endpoint).To(Equal("https://10.0.0.10"))
+	g.Expect(pm.(*redfish).systemPath).To(Equal("/redfish/v1/Systems/1"))
+
+	pm, err = New("redfish+http://10.0.0.10:8000", credentials, false)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pm.(*redfish).endpoint).To(Equal("http://10.0.0.10:8000"))
+	g.Expect(pm.(*redfish).systemPath).To(BeEmpty())
+
+	pm, err = New("IPMI://[fd00::10]:623", credentials, false)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(pm.(*ipmi).host).To(Equal("fd00::10"))
+
+	_, err = New("idrac://10.0.0.10", credentials, false)
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported BMC address")))
+	_, err = New("10.0.0.10", credentials, false)
+	g.Expect(err).To(MatchError(ContainSubstring("no host")))
+	_, err = New("ipmi://10.0.0.10", Credentials{}, false)
+	g.Expect(err).To(MatchError(ContainSubstring("no username")))
+}
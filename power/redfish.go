@@ -0,0 +1,231 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package power
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// redfishSystemsPath is the collection of the systems of a Redfish service
+const redfishSystemsPath = "/redfish/v1/Systems"
+
+// redfishBootTargets maps the boot devices to the Redfish boot source override targets
+var redfishBootTargets = map[BootDevice]string{
+	DiskBootDevice:  "Hdd",
+	PXEBootDevice:   "Pxe",
+	CDROMBootDevice: "Cd",
+}
+
+// redfish manages a computer system of a Redfish service
+type redfish struct {
+	endpoint    string
+	systemPath  string
+	credentials Credentials
+	client      *http.Client
+}
+
+func newRedfish(scheme string, u *url.URL, credentials Credentials, disableCertificateVerification bool) *redfish {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if disableCertificateVerification {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	return &redfish{
+		endpoint:    fmt.Sprintf("%s://%s", scheme, u.Host),
+		systemPath:  strings.TrimSuffix(u.Path, "/"),
+		credentials: credentials,
+		client:      &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// redfishSystem is the part of a Redfish computer system the power manager reads
+type redfishSystem struct {
+	PowerState string `json:"PowerState"`
+	Actions    struct {
+		Reset struct {
+			Target string `json:"target"`
+		} `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+func (r *redfish) PowerOn(ctx context.Context) error {
+	state, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if state == On {
+		return nil
+	}
+	return r.reset(ctx, "On")
+}
+
+func (r *redfish) PowerOff(ctx context.Context) error {
+	state, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if state == Off {
+		return nil
+	}
+	return r.reset(ctx, "ForceOff")
+}
+
+func (r *redfish) Reboot(ctx context.Context) error {
+	state, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if state == Off {
+		return r.reset(ctx, "On")
+	}
+	return r.reset(ctx, "ForceRestart")
+}
+
+func (r *redfish) Status(ctx context.Context) (State, error) {
+	system, err := r.getSystem(ctx)
+	if err != nil {
+		return Unknown, err
+	}
+	switch system.PowerState {
+	case "On":
+		return On, nil
+	case "Off":
+		return Off, nil
+	default:
+		return Unknown, nil
+	}
+}
+
+func (r *redfish) SetBootDevice(ctx context.Context, device BootDevice, persistent bool) error {
+	target, ok := redfishBootTargets[device]
+	if !ok {
+		return errors.Errorf("unsupported boot device %q", device)
+	}
+	enabled := "Once"
+	if persistent {
+		enabled = "Continuous"
+	}
+	path, err := r.getSystemPath(ctx)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"Boot": map[string]string{
+			"BootSourceOverrideTarget":  target,
+			"BootSourceOverrideEnabled": enabled,
+		},
+	}
+	return r.do(ctx, http.MethodPatch, path, body, nil)
+}
+
+// reset runs the reset action of the system with the given reset type
+func (r *redfish) reset(ctx context.Context, resetType string) error {
+	system, err := r.getSystem(ctx)
+	if err != nil {
+		return err
+	}
+	target := system.Actions.Reset.Target
+	if target == "" {
+		path, err := r.getSystemPath(ctx)
+		if err != nil {
+			return err
+		}
+		target = path + "/Actions/ComputerSystem.Reset"
+	}
+	return r.do(ctx, http.MethodPost, target, map[string]string{"ResetType": resetType}, nil)
+}
+
+func (r *redfish) getSystem(ctx context.Context) (*redfishSystem, error) {
+	path, err := r.getSystemPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	system := &redfishSystem{}
+	if err := r.do(ctx, http.MethodGet, path, nil, system); err != nil {
+		return nil, err
+	}
+	return system, nil
+}
+
+// getSystemPath returns the path of the managed system, the first system of the service unless the address has one
+func (r *redfish) getSystemPath(ctx context.Context) (string, error) {
+	if r.systemPath != "" {
+		return r.systemPath, nil
+	}
+	systems := struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		} `json:"Members"`
+	}{}
+	if err := r.do(ctx, http.MethodGet, redfishSystemsPath, nil, &systems); err != nil {
+		return "", err
+	}
+	if len(systems.Members) == 0 {
+		return "", errors.Errorf("the Redfish service at %s has no system", r.endpoint)
+	}
+	r.systemPath = strings.TrimSuffix(systems.Members[0].ID, "/")
+	return r.systemPath, nil
+}
+
+// do sends a request to the Redfish service, decoding the response into out if it is not nil
+func (r *redfish) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(r.credentials.Username, r.credentials.Password)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to reach the Redfish service at %s", r.endpoint)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("%s %s: the Redfish service answered %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(data, out), "invalid answer to %s %s", method, path)
+}
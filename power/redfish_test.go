@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package power
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/git-czy/cluster-api-provider-demo/power/fakeredfish"
+)
+
+func TestRedfish(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	bmc := fakeredfish.NewServer("admin", "password")
+	defer bmc.Close()
+	pm, err := New(bmc.Address(), Credentials{Username: "admin", Password: "password"}, false)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(pm.Status(ctx)).To(Equal(On))
+	g.Expect(pm.PowerOn(ctx)).To(Succeed())
+	g.Expect(bmc.Resets()).To(BeEmpty())
+
+	g.Expect(pm.PowerOff(ctx)).To(Succeed())
+	g.Expect(pm.Status(ctx)).To(Equal(Off))
+	g.Expect(pm.PowerOff(ctx)).To(Succeed())
+
+	// a powered off host is powered on instead of restarted
+	g.Expect(pm.Reboot(ctx)).To(Succeed())
+	g.Expect(pm.Status(ctx)).To(Equal(On))
+	g.Expect(pm.Reboot(ctx)).To(Succeed())
+	g.Expect(bmc.Resets()).To(Equal([]string{"ForceOff", "On", "ForceRestart"}))
+
+	g.Expect(pm.SetBootDevice(ctx, PXEBootDevice, true)).To(Succeed())
+	target, enabled := bmc.BootSourceOverride()
+	g.Expect(target).To(Equal("Pxe"))
+	g.Expect(enabled).To(Equal("Continuous"))
+	g.Expect(pm.SetBootDevice(ctx, DiskBootDevice, false)).To(Succeed())
+	target, enabled = bmc.BootSourceOverride()
+	g.Expect(target).To(Equal("Hdd"))
+	g.Expect(enabled).To(Equal("Once"))
+	g.Expect(pm.SetBootDevice(ctx, "Floppy", false)).NotTo(Succeed())
+}
+
+func TestRedfishDiscoversTheSystem(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	bmc := fakeredfish.NewServer("admin", "password")
+	defer bmc.Close()
+	address := strings.TrimSuffix(bmc.Address(), fakeredfish.SystemPath)
+	pm, err := New(address, Credentials{Username: "admin", Password: "password"}, false)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(pm.PowerOff(ctx)).To(Succeed())
+	g.Expect(bmc.PowerState()).To(Equal("Off"))
+}
+
+func TestRedfishInvalidCredentials(t *testing.T) {
+	g := NewWithT(t)
+
+	bmc := fakeredfish.NewServer("admin", "password")
+	defer bmc.Close()
+	pm, err := New(bmc.Address(), Credentials{Username: "admin", Password: "wrong"}, false)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = pm.Status(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("401 Unauthorized")))
+}
@@ -0,0 +1,219 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	clusterctlclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
+	"sigs.k8s.io/cluster-api/test/framework"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// movedMachine is what a demo machine must keep across a clusterctl move
+type movedMachine struct {
+	providerID string
+	metalNode  string
+}
+
+var _ = Describe("Demo cluster clusterctl move", func() {
+	var (
+		ctx        context.Context
+		clusterKey client.ObjectKey
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+	})
+
+	AfterEach(func() {
+		cleanupTopology(ctx, managementClusterProxy.GetClient(), clusterKey)
+		cleanupTopology(ctx, targetClusterProxy.GetClient(), clusterKey)
+	})
+
+	It("moves a provisioned demo-cluster to another management cluster", func() {
+		source := managementClusterProxy.GetClient()
+		target := targetClusterProxy.GetClient()
+
+		By("provisioning the demo-cluster topology in the source management cluster")
+		clusterKey = applyTopology(ctx, managementClusterProxy)
+		cluster := framework.DiscoveryAndWaitForCluster(ctx, framework.DiscoveryAndWaitForClusterInput{
+			Getter:    source,
+			Namespace: clusterKey.Namespace,
+			Name:      clusterKey.Name,
+		}, intervals...)
+		framework.DiscoveryAndWaitForControlPlaneInitialized(ctx, framework.DiscoveryAndWaitForControlPlaneInitializedInput{
+			Lister:  source,
+			Cluster: cluster,
+		}, intervals...)
+		framework.DiscoveryAndWaitForMachineDeployments(ctx, framework.DiscoveryAndWaitForMachineDeploymentsInput{
+			Lister:  source,
+			Cluster: cluster,
+		}, intervals...)
+		Eventually(func() error {
+			return checkProvisioned(ctx, source, clusterKey)
+		}, intervals...).Should(Succeed())
+
+		machines := map[string]movedMachine{}
+		demoMachines := &infrav1.DemoMachineList{}
+		Expect(source.List(ctx, demoMachines, client.InNamespace(clusterKey.Namespace))).To(Succeed())
+		for _, demoMachine := range demoMachines.Items {
+			machines[demoMachine.Name] = movedMachine{
+				providerID: demoMachine.Spec.ProviderID,
+				metalNode:  demoMachine.GetLabels()[infrav1.MetalNodeLabelName],
+			}
+		}
+		Expect(machines).NotTo(BeEmpty())
+
+		By("labeling the CRDs the way clusterctl init does")
+		labelCRDsForMove(ctx, source)
+
+		By("moving the cluster to the target management cluster")
+		clusterctl, err := clusterctlclient.New("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(clusterctl.Move(clusterctlclient.MoveOptions{
+			FromKubeconfig: clusterctlclient.Kubeconfig{Path: managementClusterProxy.GetKubeconfigPath()},
+			ToKubeconfig:   clusterctlclient.Kubeconfig{Path: targetClusterProxy.GetKubeconfigPath()},
+			Namespace:      clusterKey.Namespace,
+		})).To(Succeed())
+
+		By("checking the source management cluster only kept the free metal nodes")
+		sourceDemoMachines := &infrav1.DemoMachineList{}
+		Expect(source.List(ctx, sourceDemoMachines, client.InNamespace(clusterKey.Namespace))).To(Succeed())
+		Expect(sourceDemoMachines.Items).To(BeEmpty())
+		sourceMetalNodes := &metav1beta1.MetalNodeList{}
+		Expect(source.List(ctx, sourceMetalNodes, client.InNamespace(clusterKey.Namespace))).To(Succeed())
+		Expect(sourceMetalNodes.Items).To(HaveLen(metalNodeCount - len(machines)))
+		for _, metalNode := range sourceMetalNodes.Items {
+			Expect(metalNode.GetRefCluster()).To(BeEmpty())
+		}
+
+		By("checking the machines resumed on their metal nodes in the target management cluster")
+		Eventually(func() error {
+			return checkProvisioned(ctx, target, clusterKey)
+		}, intervals...).Should(Succeed())
+		Eventually(func() error {
+			for name, moved := range machines {
+				demoMachine := &infrav1.DemoMachine{}
+				if err := target.Get(ctx, client.ObjectKey{Namespace: clusterKey.Namespace, Name: name}, demoMachine); err != nil {
+					return err
+				}
+				if !demoMachine.Status.Ready || !demoMachine.Status.Bootstrapped {
+					return fmt.Errorf("demo machine %s is not ready", name)
+				}
+				if demoMachine.Spec.ProviderID != moved.providerID {
+					return fmt.Errorf("demo machine %s has provider id %s, expected %s", name, demoMachine.Spec.ProviderID, moved.providerID)
+				}
+				metalNode := &metav1beta1.MetalNode{}
+				if err := target.Get(ctx, client.ObjectKey{Namespace: clusterKey.Namespace, Name: moved.metalNode}, metalNode); err != nil {
+					return err
+				}
+				if metalNode.GetRefCluster() != clusterKey.Name || !metalNode.Status.Bootstrapped {
+					return fmt.Errorf("the status of metal node %s was not restored", metalNode.Name)
+				}
+			}
+			return nil
+		}, intervals...).Should(Succeed())
+
+		By("deleting the cluster from the target management cluster")
+		movedCluster := &clusterv1.Cluster{}
+		Expect(target.Get(ctx, clusterKey, movedCluster)).To(Succeed())
+		framework.DeleteCluster(ctx, framework.DeleteClusterInput{
+			Deleter: target,
+			Cluster: movedCluster,
+		})
+		framework.WaitForClusterDeleted(ctx, framework.WaitForClusterDeletedInput{
+			Getter:  target,
+			Cluster: movedCluster,
+		}, intervals...)
+
+		By("checking the moved metal nodes were released")
+		Eventually(func() error {
+			metalNodes := &metav1beta1.MetalNodeList{}
+			if err := target.List(ctx, metalNodes, client.InNamespace(clusterKey.Namespace)); err != nil {
+				return err
+			}
+			if len(metalNodes.Items) != len(machines) {
+				return fmt.Errorf("expected %d metal nodes, found %d", len(machines), len(metalNodes.Items))
+			}
+			for _, metalNode := range metalNodes.Items {
+				if metalNode.GetRefCluster() != "" || metalNode.Status.Bootstrapped || len(metalNode.GetOwnerReferences()) > 0 {
+					return fmt.Errorf("metal node %s is still used by cluster %q", metalNode.Name, metalNode.GetRefCluster())
+				}
+			}
+			return nil
+		}, intervals...).Should(Succeed())
+	})
+})
+
+// checkProvisioned returns an error until clusterctl considers the cluster provisioned and ready to be moved
+func checkProvisioned(ctx context.Context, c client.Client, clusterKey client.ObjectKey) error {
+	cluster := &clusterv1.Cluster{}
+	if err := c.Get(ctx, clusterKey, cluster); err != nil {
+		return err
+	}
+	if !cluster.Status.InfrastructureReady || !cluster.Status.ControlPlaneReady {
+		return fmt.Errorf("cluster %s is not ready", clusterKey.Name)
+	}
+	machines := &clusterv1.MachineList{}
+	if err := c.List(ctx, machines, client.InNamespace(clusterKey.Namespace),
+		client.MatchingLabels{clusterv1.ClusterLabelName: clusterKey.Name}); err != nil {
+		return err
+	}
+	if len(machines.Items) == 0 {
+		return fmt.Errorf("cluster %s has no machines", clusterKey.Name)
+	}
+	for _, machine := range machines.Items {
+		if machine.Status.NodeRef == nil {
+			return fmt.Errorf("machine %s has no node", machine.Name)
+		}
+	}
+	return nil
+}
+
+// labelCRDsForMove labels the CRDs like clusterctl init labels the components of the providers it installs,
+// clusterctl move only discovers the objects of labeled CRDs. The metal node CRD is installed aside from clusterctl,
+// see the README, and the claimed metal nodes are labeled by the demo provider to be moved.
+func labelCRDsForMove(ctx context.Context, c client.Client) {
+	crds := &apiextensionsv1.CustomResourceDefinitionList{}
+	Expect(c.List(ctx, crds)).To(Succeed())
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		if crd.Spec.Group == clusterctlv1.GroupVersion.Group {
+			continue
+		}
+		labels := crd.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[clusterctlv1.ClusterctlLabelName] = ""
+		crd.SetLabels(labels)
+		Expect(c.Update(ctx, crd)).To(Succeed())
+	}
+}
@@ -0,0 +1,271 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/api/v1beta1/index"
+	bootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
+	kubeadmbootstrapcontrollers "sigs.k8s.io/cluster-api/bootstrap/kubeadm/controllers"
+	capicontrollers "sigs.k8s.io/cluster-api/controllers"
+	"sigs.k8s.io/cluster-api/controllers/remote"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+	kubeadmcontrolplanecontrollers "sigs.k8s.io/cluster-api/controlplane/kubeadm/controllers"
+	expv1 "sigs.k8s.io/cluster-api/exp/api/v1beta1"
+	"sigs.k8s.io/cluster-api/test/framework"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/controllers"
+	"github.com/git-czy/cluster-api-provider-demo/feature"
+)
+
+// The e2e suite runs without Docker nor kind: the management cluster is an envtest API server running
+// the Cluster API core, kubeadm bootstrap and kubeadm control plane controllers in process next to ours,
+// a second envtest API server stands in for the workload cluster and a fake metal node controller
+// stands in for the bare metal hosts, registering a Node in the workload cluster once a metal node is bootstrapped.
+// A third envtest API server, running the same controllers, is the target management cluster of clusterctl move.
+
+var (
+	// managementClusterProxy gives access to the management cluster to the Cluster API test framework
+	managementClusterProxy framework.ClusterProxy
+	// targetClusterProxy gives access to the management cluster the clusterctl move spec pivots the cluster to
+	targetClusterProxy framework.ClusterProxy
+	// workloadClient is a client of the API server standing in for the workload cluster
+	workloadClient client.Client
+	// workloadKubeconfig is the kubeconfig of the workload cluster, stored in the cluster kubeconfig secret
+	workloadKubeconfig []byte
+
+	managementClusters []*managementCluster
+	workloadEnv        *envtest.Environment
+	artifactsDir       string
+	cancel             context.CancelFunc
+)
+
+// managementCluster is an envtest API server running the Cluster API controllers and ours
+type managementCluster struct {
+	env   *envtest.Environment
+	proxy framework.ClusterProxy
+}
+
+func TestE2E(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecsWithDefaultAndCustomReporters(t,
+		"Demo Provider E2E Suite",
+		[]Reporter{printer.NewlineReporter{}})
+}
+
+var _ = BeforeSuite(func() {
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+
+	scheme := initScheme()
+
+	var err error
+	artifactsDir, err = os.MkdirTemp("", "demo-e2e-")
+	Expect(err).NotTo(HaveOccurred())
+
+	By("starting the workload cluster API server")
+	workloadEnv = &envtest.Environment{}
+	workloadConfig, err := workloadEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+	workloadClient, err = client.New(workloadConfig, client.Options{Scheme: scheme})
+	Expect(err).NotTo(HaveOccurred())
+	workloadKubeconfig = adminKubeconfig(workloadEnv, workloadConfig)
+
+	ctx, cancelFunc := context.WithCancel(ctrl.SetupSignalHandler())
+	cancel = cancelFunc
+
+	By("starting the management clusters")
+	managementClusterProxy = startManagementCluster(ctx, "management", scheme).proxy
+	targetClusterProxy = startManagementCluster(ctx, "target", scheme).proxy
+}, 180)
+
+var _ = AfterSuite(func() {
+	By("tearing down the test environments")
+	if cancel != nil {
+		cancel()
+	}
+	for _, mc := range managementClusters {
+		mc.proxy.Dispose(context.TODO())
+		Expect(mc.env.Stop()).To(Succeed())
+	}
+	if workloadEnv != nil {
+		Expect(workloadEnv.Stop()).To(Succeed())
+	}
+	Expect(os.RemoveAll(artifactsDir)).To(Succeed())
+})
+
+func initScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(clusterv1.AddToScheme(scheme)).To(Succeed())
+	Expect(expv1.AddToScheme(scheme)).To(Succeed())
+	Expect(bootstrapv1.AddToScheme(scheme)).To(Succeed())
+	Expect(controlplanev1.AddToScheme(scheme)).To(Succeed())
+	Expect(infrav1.AddToScheme(scheme)).To(Succeed())
+	Expect(metav1beta1.AddToScheme(scheme)).To(Succeed())
+	Expect(apiextensionsv1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+// startManagementCluster starts an envtest API server with the Cluster API, demo provider and metal node CRDs,
+// and runs the Cluster API controllers, the demo provider controllers and the fake metal node controller against it
+func startManagementCluster(ctx context.Context, name string, scheme *runtime.Scheme) *managementCluster {
+	env := &envtest.Environment{
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "config", "crd", "bases"),
+			moduleCRDPath("sigs.k8s.io/cluster-api", "config", "crd", "bases"),
+			moduleCRDPath("sigs.k8s.io/cluster-api", "bootstrap", "kubeadm", "config", "crd", "bases"),
+			moduleCRDPath("sigs.k8s.io/cluster-api", "controlplane", "kubeadm", "config", "crd", "bases"),
+			moduleCRDPath("github.com/git-czy/cluster-api-metalnode", "config", "crd", "bases"),
+		},
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := env.Start()
+	Expect(err).NotTo(HaveOccurred())
+
+	kubeconfigPath := filepath.Join(artifactsDir, name+".kubeconfig")
+	Expect(os.WriteFile(kubeconfigPath, adminKubeconfig(env, config), 0600)).To(Succeed())
+	mc := &managementCluster{
+		env:   env,
+		proxy: framework.NewClusterProxy(name, kubeconfigPath, scheme),
+	}
+	managementClusters = append(managementClusters, mc)
+
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Scheme:             scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+	setupControllers(ctx, mgr)
+
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+	return mc
+}
+
+// setupControllers registers the controllers the Cluster API providers would run in the management cluster
+func setupControllers(ctx context.Context, mgr ctrl.Manager) {
+	// the control plane endpoint is the workload cluster API server and a rolling upgrade of the control plane
+	// needs a second control plane machine, both need a highly available control plane endpoint
+	Expect(feature.MutableGates.Set(string(feature.HAControlPlaneEndpoint) + "=true")).To(Succeed())
+
+	options := controller.Options{
+		MaxConcurrentReconciles: 5,
+		RateLimiter:             workqueue.NewItemExponentialFailureRateLimiter(10*time.Millisecond, 5*time.Second),
+	}
+
+	Expect(index.AddDefaultIndexes(ctx, mgr)).To(Succeed())
+	trackerLog := ctrl.Log.WithName("remote").WithName("ClusterCacheTracker")
+	tracker, err := remote.NewClusterCacheTracker(mgr, remote.ClusterCacheTrackerOptions{
+		Log:     &trackerLog,
+		Indexes: remote.DefaultIndexes,
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect((&remote.ClusterCacheReconciler{
+		Client:  mgr.GetClient(),
+		Log:     ctrl.Log.WithName("remote").WithName("ClusterCacheReconciler"),
+		Tracker: tracker,
+	}).SetupWithManager(ctx, mgr, options)).To(Succeed())
+
+	Expect((&capicontrollers.ClusterReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(ctx, mgr, options)).To(Succeed())
+	Expect((&capicontrollers.MachineReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Tracker:   tracker,
+	}).SetupWithManager(ctx, mgr, options)).To(Succeed())
+	Expect((&capicontrollers.MachineSetReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Tracker:   tracker,
+	}).SetupWithManager(ctx, mgr, options)).To(Succeed())
+	Expect((&capicontrollers.MachineDeploymentReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(ctx, mgr, options)).To(Succeed())
+	Expect((&kubeadmbootstrapcontrollers.KubeadmConfigReconciler{
+		Client: mgr.GetClient(),
+	}).SetupWithManager(ctx, mgr, options)).To(Succeed())
+	Expect((&kubeadmcontrolplanecontrollers.KubeadmControlPlaneReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Tracker:   tracker,
+	}).SetupWithManager(ctx, mgr, options)).To(Succeed())
+
+	Expect((&controllers.DemoClusterReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, options)).To(Succeed())
+	Expect((&controllers.DemoMachineReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("demomachine-controller"),
+	}).SetupWithManager(mgr, options)).To(Succeed())
+
+	Expect((&fakeMetalNodeController{
+		Client:   mgr.GetClient(),
+		Workload: workloadClient,
+	}).SetupWithManager(mgr)).To(Succeed())
+}
+
+// adminKubeconfig returns a kubeconfig of a cluster admin of the envtest API server
+func adminKubeconfig(env *envtest.Environment, config *rest.Config) []byte {
+	user, err := env.AddUser(envtest.User{Name: "e2e-admin", Groups: []string{"system:masters"}}, config)
+	Expect(err).NotTo(HaveOccurred())
+	kubeconfig, err := user.KubeConfig()
+	Expect(err).NotTo(HaveOccurred())
+	return kubeconfig
+}
+
+// moduleCRDPath returns a directory of a module dependency, as downloaded in the module cache
+func moduleCRDPath(module string, elem ...string) string {
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", module).Output()
+	Expect(err).NotTo(HaveOccurred(), "failed to locate module %s", module)
+	return filepath.Join(append([]string{strings.TrimSpace(string(out))}, elem...)...)
+}
@@ -0,0 +1,207 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+	"github.com/git-czy/cluster-api-provider-demo/test/fakemetalnode"
+)
+
+// controlPlaneComponents are the static pods the kubeadm control plane controller checks on control plane nodes,
+// etcd is external
+var controlPlaneComponents = []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+
+// fakeMetalNodeController stands in for the agent running on the bare metal hosts,
+// it initializes the metal nodes and "runs" their bootstrap data by registering them in the workload cluster.
+type fakeMetalNodeController struct {
+	client.Client
+	// Workload is the client of the workload cluster the metal nodes join
+	Workload client.Client
+}
+
+// SetupWithManager sets up the fake controller with the Manager.
+func (r *fakeMetalNodeController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("fake-metalnode").
+		For(&metav1beta1.MetalNode{}).
+		Complete(r)
+}
+
+// Reconcile initializes, bootstraps and releases a metal node
+func (r *fakeMetalNodeController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	metalNode := &metav1beta1.MetalNode{}
+	if err := r.Get(ctx, req.NamespacedName, metalNode); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// the metal node was moved by clusterctl, the demo provider restores its status
+	if _, ok := metalNode.GetAnnotations()[infrav1.MetalNodeStatusAnnotation]; ok && metalNode.GetRefCluster() == "" {
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
+
+	// the metal node was cleaned, it is initialized again as asked
+	if _, ok := metalNode.GetAnnotations()[infrav1.MetalNodeReinitializeAnnotation]; ok {
+		delete(metalNode.Annotations, infrav1.MetalNodeReinitializeAnnotation)
+		return ctrl.Result{}, r.Update(ctx, metalNode)
+	}
+
+	if !metalNode.IsReady() {
+		fakemetalnode.MarkReady(metalNode)
+		return ctrl.Result{}, r.Status().Update(ctx, metalNode)
+	}
+
+	// the metal node was released, wipe what its bootstrap left in the workload cluster
+	if metalNode.Status.DataSecretName == "" {
+		return ctrl.Result{}, r.unregisterNode(ctx, metalNode.Name)
+	}
+	if metalNode.Status.Bootstrapped {
+		return ctrl.Result{}, nil
+	}
+
+	demoMachines := &infrav1.DemoMachineList{}
+	if err := r.List(ctx, demoMachines, client.InNamespace(metalNode.Namespace),
+		client.MatchingLabels{infrav1.MetalNodeLabelName: metalNode.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(demoMachines.Items) == 0 {
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
+	machine, err := util.GetOwnerMachine(ctx, r.Client, demoMachines.Items[0].ObjectMeta)
+	if err != nil || machine == nil || machine.Spec.Version == nil {
+		return ctrl.Result{RequeueAfter: time.Second}, err
+	}
+
+	version := *machine.Spec.Version
+	controlPlane := util.IsControlPlaneMachine(machine)
+	if controlPlane {
+		if err := r.initControlPlane(ctx, version); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.registerNode(ctx, metalNode, version, controlPlane); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	metalNode.Status.Bootstrapped = true
+	return ctrl.Result{}, r.Status().Update(ctx, metalNode)
+}
+
+// initControlPlane creates the configmaps kubeadm init uploads, the kubeadm control plane controller reads them
+func (r *fakeMetalNodeController) initControlPlane(ctx context.Context, version string) error {
+	kubeadmConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeadm-config", Namespace: metav1.NamespaceSystem},
+		Data: map[string]string{
+			"ClusterConfiguration": fmt.Sprintf("apiVersion: kubeadm.k8s.io/v1beta3\nkind: ClusterConfiguration\nkubernetesVersion: %s\n", version),
+		},
+	}
+	if err := r.Workload.Create(ctx, kubeadmConfig); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	kubeletConfig := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubelet-config-1.23", Namespace: metav1.NamespaceSystem},
+		Data: map[string]string{
+			"kubelet": "apiVersion: kubelet.config.k8s.io/v1beta1\nkind: KubeletConfiguration\ncgroupDriver: systemd\n",
+		},
+	}
+	if err := r.Workload.Create(ctx, kubeletConfig); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// registerNode creates the Node of the metal node, and the static pods of a control plane node, the way a kubelet would
+func (r *fakeMetalNodeController) registerNode(ctx context.Context, metalNode *metav1beta1.MetalNode, version string, controlPlane bool) error {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: metalNode.Name, Labels: map[string]string{}},
+		Spec:       corev1.NodeSpec{ProviderID: infrav1.ProviderIDPrefix + string(metalNode.UID)},
+	}
+	if controlPlane {
+		node.Labels["node-role.kubernetes.io/control-plane"] = ""
+		node.Labels["node-role.kubernetes.io/master"] = ""
+	}
+	if err := r.Workload.Create(ctx, node); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	node.Status = corev1.NodeStatus{
+		Conditions: []corev1.NodeCondition{{
+			Type:               corev1.NodeReady,
+			Status:             corev1.ConditionTrue,
+			LastHeartbeatTime:  metav1.Now(),
+			LastTransitionTime: metav1.Now(),
+		}},
+		NodeInfo: corev1.NodeSystemInfo{KubeletVersion: version},
+	}
+	if err := r.Workload.Status().Update(ctx, node); err != nil {
+		return err
+	}
+
+	if !controlPlane {
+		return nil
+	}
+	for _, component := range controlPlaneComponents {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-%s", component, node.Name),
+				Namespace:   metav1.NamespaceSystem,
+				Labels:      map[string]string{"component": component, "tier": "control-plane"},
+				Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "fake"},
+			},
+			Spec: corev1.PodSpec{
+				NodeName:   node.Name,
+				Containers: []corev1.Container{{Name: component, Image: fmt.Sprintf("k8s.gcr.io/%s:%s", component, version)}},
+			},
+		}
+		if err := r.Workload.Create(ctx, pod); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		pod.Status = corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		}
+		if err := r.Workload.Status().Update(ctx, pod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unregisterNode deletes the Node and the static pods of a released metal node
+func (r *fakeMetalNodeController) unregisterNode(ctx context.Context, name string) error {
+	for _, component := range controlPlaneComponents {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%s", component, name), Namespace: metav1.NamespaceSystem}}
+		if err := r.Workload.Delete(ctx, pod, client.GracePeriodSeconds(0)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	return client.IgnoreNotFound(r.Workload.Delete(ctx, node))
+}
@@ -0,0 +1,162 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/test/framework"
+	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+)
+
+const (
+	// metalNodeCount covers the machines of the topology scaled up, plus the surge of the rolling upgrades
+	metalNodeCount = 7
+	// workerReplicas is the size the worker MachineDeployment is scaled to
+	workerReplicas = 3
+	// upgradeVersion is the kubernetes version the cluster is upgraded to, the sample runs v1.23.6
+	upgradeVersion = "v1.23.7"
+)
+
+var intervals = []interface{}{5 * time.Minute, time.Second}
+
+var _ = Describe("Demo cluster lifecycle", func() {
+	var (
+		ctx        context.Context
+		namespace  string
+		clusterKey client.ObjectKey
+	)
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+	})
+
+	AfterEach(func() {
+		cleanupTopology(ctx, managementClusterProxy.GetClient(), clusterKey)
+	})
+
+	It("creates, scales, upgrades and deletes the demo-cluster topology without leaking metal nodes", func() {
+		c := managementClusterProxy.GetClient()
+
+		By("applying the demo-cluster topology on top of the metal nodes")
+		clusterKey = applyTopology(ctx, managementClusterProxy)
+		namespace = clusterKey.Namespace
+
+		cluster := framework.DiscoveryAndWaitForCluster(ctx, framework.DiscoveryAndWaitForClusterInput{
+			Getter:    c,
+			Namespace: clusterKey.Namespace,
+			Name:      clusterKey.Name,
+		}, intervals...)
+		controlPlane := framework.DiscoveryAndWaitForControlPlaneInitialized(ctx, framework.DiscoveryAndWaitForControlPlaneInitializedInput{
+			Lister:  c,
+			Cluster: cluster,
+		}, intervals...)
+		machineDeployments := framework.DiscoveryAndWaitForMachineDeployments(ctx, framework.DiscoveryAndWaitForMachineDeploymentsInput{
+			Lister:  c,
+			Cluster: cluster,
+		}, intervals...)
+
+		By("scaling the worker MachineDeployment")
+		for _, md := range machineDeployments {
+			framework.ScaleAndWaitMachineDeployment(ctx, framework.ScaleAndWaitMachineDeploymentInput{
+				ClusterProxy:              managementClusterProxy,
+				Cluster:                   cluster,
+				MachineDeployment:         md,
+				Replicas:                  workerReplicas,
+				WaitForMachineDeployments: intervals,
+			})
+		}
+
+		By("upgrading the control plane to " + upgradeVersion)
+		patchHelper, err := patch.NewHelper(controlPlane, c)
+		Expect(err).NotTo(HaveOccurred())
+		controlPlane.Spec.Version = upgradeVersion
+		Expect(patchHelper.Patch(ctx, controlPlane)).To(Succeed())
+		framework.WaitForControlPlaneMachinesToBeUpgraded(ctx, framework.WaitForControlPlaneMachinesToBeUpgradedInput{
+			Lister:                   c,
+			Cluster:                  cluster,
+			MachineCount:             int(*controlPlane.Spec.Replicas),
+			KubernetesUpgradeVersion: upgradeVersion,
+		}, intervals...)
+
+		By("upgrading the workers to " + upgradeVersion)
+		framework.UpgradeMachineDeploymentsAndWait(ctx, framework.UpgradeMachineDeploymentsAndWaitInput{
+			ClusterProxy:                managementClusterProxy,
+			Cluster:                     cluster,
+			UpgradeVersion:              upgradeVersion,
+			MachineDeployments:          machineDeployments,
+			WaitForMachinesToBeUpgraded: intervals,
+		})
+
+		By("deleting the cluster")
+		framework.DeleteCluster(ctx, framework.DeleteClusterInput{
+			Deleter: c,
+			Cluster: cluster,
+		})
+		framework.WaitForClusterDeleted(ctx, framework.WaitForClusterDeletedInput{
+			Getter:  c,
+			Cluster: cluster,
+		}, intervals...)
+
+		By("checking every metal node was released")
+		Eventually(func() error {
+			metalNodes := &metav1beta1.MetalNodeList{}
+			if err := c.List(ctx, metalNodes, client.InNamespace(namespace)); err != nil {
+				return err
+			}
+			if len(metalNodes.Items) != metalNodeCount {
+				return fmt.Errorf("expected %d metal nodes, found %d", metalNodeCount, len(metalNodes.Items))
+			}
+			for _, metalNode := range metalNodes.Items {
+				if metalNode.GetRefCluster() != "" || metalNode.Status.Bootstrapped || metalNode.Status.DataSecretName != "" {
+					return fmt.Errorf("metal node %s is still used by cluster %q", metalNode.Name, metalNode.GetRefCluster())
+				}
+			}
+			return nil
+		}, intervals...).Should(Succeed())
+
+		nodes := &corev1.NodeList{}
+		Expect(workloadClient.List(ctx, nodes)).To(Succeed())
+		Expect(nodes.Items).To(BeEmpty())
+	})
+})
+
+// createMetalNodes creates the pool of metal nodes the cluster machines are provisioned on
+func createMetalNodes(ctx context.Context, c client.Client, namespace string) {
+	for i := 0; i < metalNodeCount; i++ {
+		metalNode := &metav1beta1.MetalNode{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("metalnode-%d", i),
+				Namespace: namespace,
+			},
+		}
+		metalNode.Spec.NodeEndPoint.Host = fmt.Sprintf("10.0.0.%d", i+1)
+		Expect(c.Create(ctx, metalNode)).To(Succeed())
+	}
+}
@@ -0,0 +1,205 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	bootstrapv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
+	controlplanev1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+	"sigs.k8s.io/cluster-api/test/framework"
+	"sigs.k8s.io/cluster-api/util/certs"
+	"sigs.k8s.io/cluster-api/util/secret"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+	infrav1 "github.com/git-czy/cluster-api-provider-demo/api/v1beta1"
+)
+
+// loadTopology returns the objects of config/samples/demo-cluster.yaml, adapted to run against envtest:
+// the control plane endpoint is the workload cluster API server, etcd is external
+// and the addons and node draining needing a real workload cluster are skipped.
+func loadTopology(scheme *runtime.Scheme) []client.Object {
+	data, err := os.ReadFile(filepath.Join("..", "..", "config", "samples", "demo-cluster.yaml"))
+	Expect(err).NotTo(HaveOccurred())
+	objs, err := utilyaml.ToUnstructured(data)
+	Expect(err).NotTo(HaveOccurred())
+
+	endpoint := workloadEndpoint()
+	topology := make([]client.Object, 0, len(objs))
+	for i := range objs {
+		typed, err := scheme.New(objs[i].GroupVersionKind())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(objs[i].UnstructuredContent(), typed)).To(Succeed())
+
+		switch obj := typed.(type) {
+		case *infrav1.DemoCluster:
+			obj.Spec.ControlPlaneEndpoint = endpoint
+		case *controlplanev1.KubeadmControlPlane:
+			if obj.Spec.KubeadmConfigSpec.ClusterConfiguration == nil {
+				obj.Spec.KubeadmConfigSpec.ClusterConfiguration = &bootstrapv1.ClusterConfiguration{}
+			}
+			obj.Spec.KubeadmConfigSpec.ClusterConfiguration.Etcd.External = &bootstrapv1.ExternalEtcd{
+				Endpoints: []string{"https://127.0.0.1:2379"},
+				CAFile:    "/etc/kubernetes/pki/etcd/ca.crt",
+				CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+				KeyFile:   "/etc/kubernetes/pki/apiserver-etcd-client.key",
+			}
+			obj.SetAnnotations(map[string]string{
+				controlplanev1.SkipCoreDNSAnnotation:   "",
+				controlplanev1.SkipKubeProxyAnnotation: "",
+			})
+			obj.Spec.MachineTemplate.ObjectMeta.Annotations = map[string]string{clusterv1.ExcludeNodeDrainingAnnotation: ""}
+		case *clusterv1.MachineDeployment:
+			obj.Spec.Template.ObjectMeta.Annotations = map[string]string{clusterv1.ExcludeNodeDrainingAnnotation: ""}
+		}
+		// no webhooks are served, default the objects the way they would
+		if defaulter, ok := typed.(webhook.Defaulter); ok {
+			defaulter.Default()
+		}
+		topology = append(topology, typed.(client.Object))
+	}
+	return topology
+}
+
+// applyTopology creates the demo-cluster topology, the metal nodes it is provisioned on and the secrets of the cluster,
+// it returns the key of the Cluster
+func applyTopology(ctx context.Context, proxy framework.ClusterProxy) client.ObjectKey {
+	c := proxy.GetClient()
+	topology := loadTopology(proxy.GetScheme())
+	var clusterKey client.ObjectKey
+	for _, obj := range topology {
+		if _, ok := obj.(*clusterv1.Cluster); ok {
+			clusterKey = client.ObjectKeyFromObject(obj)
+		}
+	}
+	Expect(clusterKey.Name).NotTo(BeEmpty(), "the topology has no Cluster")
+
+	for _, obj := range topology {
+		if _, ok := obj.(*corev1.Namespace); ok {
+			// envtest runs no namespace controller, the namespace of a previous spec is never deleted
+			if err := c.Create(ctx, obj); !apierrors.IsAlreadyExists(err) {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			createMetalNodes(ctx, c, clusterKey.Namespace)
+			createClusterSecrets(ctx, c, clusterKey)
+			continue
+		}
+		Expect(c.Create(ctx, obj)).To(Succeed())
+	}
+	return clusterKey
+}
+
+// cleanupTopology deletes what a spec leaves behind once its cluster is deleted: the secrets of the cluster
+// and the metal nodes in the management cluster, and the configmaps uploaded by kubeadm in the workload cluster
+func cleanupTopology(ctx context.Context, c client.Client, clusterKey client.ObjectKey) {
+	if clusterKey.Name == "" {
+		return
+	}
+	for _, purpose := range []secret.Purpose{secret.Kubeconfig, secret.EtcdCA, secret.APIServerEtcdClient} {
+		s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: clusterKey.Namespace, Name: secret.Name(clusterKey.Name, purpose)}}
+		Expect(client.IgnoreNotFound(c.Delete(ctx, s))).To(Succeed())
+	}
+	Expect(c.DeleteAllOf(ctx, &metav1beta1.MetalNode{}, client.InNamespace(clusterKey.Namespace))).To(Succeed())
+
+	for _, name := range []string{"kubeadm-config", "kubelet-config-1.23"} {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceSystem, Name: name}}
+		Expect(client.IgnoreNotFound(workloadClient.Delete(ctx, cm))).To(Succeed())
+	}
+}
+
+// createClusterSecrets creates the secrets kubeadm would otherwise need a real control plane for:
+// the kubeconfig of the workload cluster and the certificates of the external etcd
+func createClusterSecrets(ctx context.Context, c client.Client, cluster client.ObjectKey) {
+	key, err := certs.NewPrivateKey()
+	Expect(err).NotTo(HaveOccurred())
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etcd-ca"},
+		NotBefore:             now,
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+
+	secrets := []*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: secret.Name(cluster.Name, secret.Kubeconfig)},
+			Data:       map[string][]byte{secret.KubeconfigDataName: workloadKubeconfig},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: secret.Name(cluster.Name, secret.EtcdCA)},
+			Data:       map[string][]byte{secret.TLSCrtDataName: certs.EncodeCertPEM(cert)},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: secret.Name(cluster.Name, secret.APIServerEtcdClient)},
+			Data: map[string][]byte{
+				secret.TLSCrtDataName: certs.EncodeCertPEM(cert),
+				secret.TLSKeyDataName: certs.EncodePrivateKeyPEM(key),
+			},
+		},
+	}
+	for _, s := range secrets {
+		s.Namespace = cluster.Namespace
+		s.Labels = map[string]string{clusterv1.ClusterLabelName: cluster.Name}
+		s.Type = clusterv1.ClusterSecretType
+		Expect(c.Create(ctx, s)).To(Succeed())
+	}
+}
+
+// workloadEndpoint returns the workload cluster API server address
+func workloadEndpoint() clusterv1.APIEndpoint {
+	config, err := clientcmd.Load(workloadKubeconfig)
+	Expect(err).NotTo(HaveOccurred())
+	current := config.Contexts[config.CurrentContext]
+	Expect(current).NotTo(BeNil())
+	server, err := url.Parse(config.Clusters[current.Cluster].Server)
+	Expect(err).NotTo(HaveOccurred())
+	host, port, err := net.SplitHostPort(server.Host)
+	Expect(err).NotTo(HaveOccurred())
+	p, err := strconv.Atoi(port)
+	Expect(err).NotTo(HaveOccurred())
+	return clusterv1.APIEndpoint{Host: host, Port: int32(p)}
+}
@@ -0,0 +1,33 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakemetalnode holds what the fake metal node agents of the controller tests and of the e2e tests share
+// with the metal node controller they stand in for.
+package fakemetalnode
+
+import (
+	metav1beta1 "github.com/git-czy/cluster-api-metalnode/api/v1beta1"
+)
+
+// MarkReady reports the metal node initialized the way the metal node controller does
+func MarkReady(metalNode *metav1beta1.MetalNode) {
+	metalNode.Status.Ready = true
+}
+
+// MarkNotReady reports the metal node not ready the way the metal node controller does, e.g. once its host is powered off
+func MarkNotReady(metalNode *metav1beta1.MetalNode) {
+	metalNode.Status.Ready = false
+}